@@ -0,0 +1,20 @@
+/*
+Optional ClickHouse table for the raw detections. Enable it by setting
+CLICKHOUSE_URL (e.g. http://localhost:8123). With DETECTION_SINK=clickhouse
+the detections are no longer written to the postgres detection table.
+*/
+
+CREATE TABLE IF NOT EXISTS detection (
+    event_id UInt32,
+    stream String,
+    label LowCardinality(String),
+    created DateTime('Europe/Helsinki'),
+    confidence Float32,
+    location_top Int32,
+    location_left Int32,
+    width Int32,
+    height Int32,
+    crop String
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(created)
+ORDER BY (stream, created);
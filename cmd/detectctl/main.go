@@ -0,0 +1,224 @@
+// detectctl controls a running detector through its rest api and the /ws
+// feed of the detections:
+//
+//	detectctl streams                      the streams with their health
+//	detectctl tail [-stream a] [-class b]  prints the detections as they happen
+//	detectctl pause <id>                   stops analyzing the frames of a stream
+//	detectctl resume <id>                  continues a paused stream
+//	detectctl test-alert <id>              sends a test notification to a subscription
+//
+// The address of the detector (HTTP_ADDR) and the api key are given with
+// -url and -key, or with DETECTCTL_URL and DETECTCTL_KEY. Pausing, resuming
+// and the test alerts need a key with the write scope.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type client struct {
+	base string
+	key  string
+	http *http.Client
+}
+
+// do calls the api and decodes the json response into out, if given
+func (c client) do(method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", c.key)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&failure)
+		if failure.Error == "" {
+			failure.Error = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, path, failure.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type stream struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Enabled bool   `json:"enabled"`
+	Paused  bool   `json:"paused"`
+}
+
+type streamStatus struct {
+	Address    string    `json:"address"`
+	Online     bool      `json:"online"`
+	LastFrame  time.Time `json:"last_frame"`
+	FPS        float64   `json:"fps"`
+	LastError  string    `json:"last_error"`
+	Reconnects int       `json:"reconnects"`
+}
+
+func (c client) streams() error {
+	var streams []stream
+	if err := c.do(http.MethodGet, "/api/streams", &streams); err != nil {
+		return err
+	}
+	var statuses []streamStatus
+	if err := c.do(http.MethodGet, "/api/status", &statuses); err != nil {
+		return err
+	}
+	health := make(map[string]streamStatus)
+	for _, s := range statuses {
+		health[s.Address] = s
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATE\tFPS\tLAST FRAME\tRECONNECTS\tLAST ERROR")
+	for _, s := range streams {
+		status, ok := health[s.Address]
+		state := "offline"
+		switch {
+		case !s.Enabled:
+			state = "disabled"
+		case s.Paused:
+			state = "paused"
+		case !ok:
+			state = "unknown"
+		case status.Online:
+			state = "online"
+		}
+		lastFrame := "-"
+		if !status.LastFrame.IsZero() {
+			lastFrame = time.Since(status.LastFrame).Round(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%.1f\t%s\t%d\t%s\n", s.ID, s.Name, state, status.FPS, lastFrame, status.Reconnects, status.LastError)
+	}
+	return w.Flush()
+}
+
+type event struct {
+	Stream     string  `json:"stream"`
+	StreamName string  `json:"stream_name"`
+	Class      string  `json:"class"`
+	Count      int     `json:"count"`
+	Confidence float32 `json:"confidence"`
+	Created    string  `json:"created"`
+	Snapshot   string  `json:"snapshot_url"`
+}
+
+// tail prints the detections of the /ws feed until the connection closes
+func (c client) tail(streams, classes []string) error {
+	u, err := url.Parse(c.base + "/ws")
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	query := url.Values{"stream": streams, "class": classes}
+	u.RawQuery = query.Encode()
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), http.Header{"X-API-Key": {c.key}})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	for {
+		var e event
+		if err := conn.ReadJSON(&e); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			return err
+		}
+		name := e.StreamName
+		if name == "" {
+			name = e.Stream
+		}
+		fmt.Printf("%s  %-20s  %d %s (%.0f%%)  %s\n", e.Created, name, e.Count, e.Class, e.Confidence*100, e.Snapshot)
+	}
+}
+
+// repeated is a flag that can be given several times
+type repeated []string
+
+func (r *repeated) String() string     { return strings.Join(*r, ",") }
+func (r *repeated) Set(v string) error { *r = append(*r, v); return nil }
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: detectctl [-url url] [-key key] streams|tail|pause <id>|resume <id>|test-alert <subscription>\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// id returns the single numeric argument of the command
+func id(args []string) int {
+	if len(args) != 1 {
+		usage()
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id <= 0 {
+		usage()
+	}
+	return id
+}
+
+func main() {
+	base := flag.String("url", os.Getenv("DETECTCTL_URL"), "address of the detector, e.g. http://localhost:8080")
+	key := flag.String("key", os.Getenv("DETECTCTL_KEY"), "api key")
+	flag.Usage = usage
+	flag.Parse()
+	if *base == "" || flag.NArg() == 0 {
+		usage()
+	}
+	c := client{base: strings.TrimSuffix(*base, "/"), key: *key, http: &http.Client{Timeout: 30 * time.Second}}
+
+	var err error
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "streams":
+		err = c.streams()
+	case "tail":
+		var streams, classes repeated
+		tail := flag.NewFlagSet("tail", flag.ExitOnError)
+		tail.Var(&streams, "stream", "address of a stream to follow, can be repeated")
+		tail.Var(&classes, "class", "class to follow, can be repeated")
+		tail.Parse(args)
+		err = c.tail(streams, classes)
+	case "pause", "resume":
+		err = c.do(http.MethodPost, fmt.Sprintf("/api/streams/%d/%s", id(args), flag.Arg(0)), nil)
+	case "test-alert":
+		err = c.do(http.MethodPost, fmt.Sprintf("/api/subscriptions/%d/test", id(args)), nil)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "detectctl: %v\n", err)
+		os.Exit(1)
+	}
+}
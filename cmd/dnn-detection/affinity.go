@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseCPUSets parses the space separated cpu sets of CPU_AFFINITY, e.g.
+// "0-1 2,3 4" is three sets
+func parseCPUSets(value string) ([][]int, error) {
+	var sets [][]int
+	for _, field := range strings.Fields(value) {
+		var set []int
+		for _, part := range strings.Split(field, ",") {
+			first, last, isRange := strings.Cut(part, "-")
+			from, err := strconv.Atoi(first)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu set %q", field)
+			}
+			to := from
+			if isRange {
+				if to, err = strconv.Atoi(last); err != nil || to < from {
+					return nil, fmt.Errorf("invalid cpu set %q", field)
+				}
+			}
+			for cpu := from; cpu <= to; cpu++ {
+				set = append(set, cpu)
+			}
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// cpuSet returns the cpus of the nth pipeline
+func cpuSet(n int) []int {
+	if len(cpuSets) == 0 {
+		return nil
+	}
+	return cpuSets[n%len(cpuSets)]
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpSink publishes the events to a RabbitMQ (AMQP 0-9-1) exchange. The
+// routing key may contain {stream} and {class} placeholders so that the
+// consumers can bind to the events they want, e.g. "detection.*.bird".
+// The publishes are confirmed by the broker, so an event that the broker
+// did not accept is reported as an error.
+type amqpSink struct {
+	url        string
+	exchange   string
+	routingKey string
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+func newAMQPSink(url, exchange, routingKey string) (*amqpSink, error) {
+	a := &amqpSink{url: url, exchange: exchange, routingKey: routingKey}
+	if err := a.connect(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// connect opens the connection and the confirming channel, and declares
+// the exchange. Must be called with the lock held (or before the sink is
+// shared).
+func (a *amqpSink) connect() error {
+	conn, err := amqp.DialConfig(a.url, amqp.Config{Properties: amqp.Table{"connection_name": "gocv-stream-events"}})
+	if err != nil {
+		return fmt.Errorf("amqp: %w", err)
+	}
+	channel, err := conn.Channel()
+	if err == nil && a.exchange != "" {
+		err = channel.ExchangeDeclare(a.exchange, "topic", true, false, false, false, nil)
+	}
+	if err == nil {
+		err = channel.Confirm(false)
+	}
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("amqp: %w", err)
+	}
+	a.conn = conn
+	a.channel = channel
+	return nil
+}
+
+func (a *amqpSink) writeEvent(event detectionEvent) error {
+	payload, err := json.Marshal(newEventPayload(event))
+	if err != nil {
+		return err
+	}
+	key := strings.NewReplacer("{stream}", mqttSlug(event.stream), "{class}", mqttSlug(event.label)).Replace(a.routingKey)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// reconnect after the broker has closed the connection
+	if a.conn.IsClosed() || a.channel.IsClosed() {
+		a.conn.Close()
+		if err := a.connect(); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	confirmation, err := a.channel.PublishWithDeferredConfirmWithContext(ctx, a.exchange, key, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    strconv.Itoa(event.id),
+		Timestamp:    time.Now(),
+		Type:         "detection",
+		Body:         payload,
+	})
+	if err != nil {
+		return fmt.Errorf("amqp: %w", err)
+	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("amqp: waiting for the confirmation of event %d: %w", event.id, err)
+	}
+	if !acked {
+		return fmt.Errorf("amqp: event %d was rejected by the broker", event.id)
+	}
+	return nil
+}
+
+func (a *amqpSink) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.conn.Close()
+}
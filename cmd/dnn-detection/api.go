@@ -0,0 +1,531 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// The REST API under /api/ exposes the events, streams, observers and
+// subscriptions as json:
+//
+//	GET    /api/events                   ?stream= &class= &status= &tag= &min_confidence= &from= &to= &order= &asc= &after= &limit=
+//	GET    /api/events/<id>              with the detections
+//	GET    /api/classes                  labels of the model
+//	GET    /api/hooks                    names of the HOOKS for on_detect
+//	GET    /api/streams                  POST to create
+//	GET    /api/streams/<id>             PUT to replace, DELETE to remove
+//	GET    /api/streams/<id>/settings    PUT to replace the detection thresholds
+//	GET    /api/streams/<id>/zones       PUT to replace the zones and masks
+//	GET    /api/streams/<id>/frame       jpeg to draw the zones on
+//	POST   /api/streams/<id>/pause       stops analyzing the frames, /resume continues
+//	GET    /api/status                   health of the streams
+//	GET    /api/observers                POST to create
+//	GET    /api/observers/<id>           PUT to replace, DELETE to purge
+//	GET    /api/subscriptions            ?observer=, POST to create
+//	GET    /api/subscriptions/<id>       PUT to replace, DELETE to remove
+//	POST   /api/subscriptions/<id>/test  sends a test notification
+//
+// The requests need an X-API-Key header (see pkg/store/apikeys.go) or an
+// OIDC session (see oidc.go) and are scoped to the organization of the key.
+// The requests other than GET need a write key.
+
+// apiEvent adds the public addresses of the images to the event
+type apiEvent struct {
+	store.Event
+	SnapshotURL string         `json:"snapshot_url,omitempty"`
+	Detections  []apiDetection `json:"detections,omitempty"`
+}
+
+type apiDetection struct {
+	store.Detection
+	CropURL string `json:"crop_url,omitempty"`
+}
+
+func newAPIEvent(e store.Event) apiEvent {
+	event := apiEvent{Event: e, SnapshotURL: notify.SnapshotLink(e.Snapshot)}
+	for _, d := range e.Detections {
+		event.Detections = append(event.Detections, apiDetection{d, notify.SnapshotLink(d.Crop)})
+	}
+	return event
+}
+
+// apiEventPage is a page of events, next is passed as after to get the
+// following page
+type apiEventPage struct {
+	Events []apiEvent `json:"events"`
+	Next   string     `json:"next,omitempty"`
+}
+
+// apiDatabase returns the database scoped to the organization of the request
+func apiDatabase(r *http.Request) (store.Database, error) {
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	return databaseForRequest(r, r.Header.Get("X-API-Key"), write)
+}
+
+// apiPath splits /api/<resource>/<id>/<sub>, id is zero for the collection
+func apiPath(path string) (resource string, id int, sub string, err error) {
+	resource, rest, _ := strings.Cut(strings.TrimPrefix(path, "/api/"), "/")
+	rest, sub, _ = strings.Cut(strings.Trim(rest, "/"), "/")
+	if rest != "" {
+		id, err = strconv.Atoi(rest)
+		if err != nil || id <= 0 {
+			return "", 0, "", fmt.Errorf("invalid id %q", rest)
+		}
+	}
+	return resource, id, sub, nil
+}
+
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	database, err := apiDatabase(r)
+	if err == sql.ErrNoRows {
+		apiError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	}
+	if err == store.ErrReadOnlyKey {
+		apiError(w, http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+	resource, id, sub, err := apiPath(r.URL.Path)
+	if err != nil {
+		apiError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if sub != "" && resource != "streams" && resource != "subscriptions" {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch resource {
+	case "events":
+		apiEvents(database, w, r, id)
+	case "classes":
+		if r.Method != http.MethodGet || id != 0 {
+			apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, classes)
+	case "hooks":
+		if r.Method != http.MethodGet || id != 0 {
+			apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, store.Hooks)
+	case "streams":
+		if sub != "" {
+			apiStreamConfig(database, w, r, id, sub)
+			return
+		}
+		apiStreams(database, w, r, id)
+	case "status":
+		if r.Method != http.MethodGet || id != 0 {
+			apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		statuses, err := database.StreamStatuses()
+		if statuses == nil {
+			statuses = []store.StreamStatus{}
+		}
+		respond(w, r, http.StatusOK, statuses, err)
+	case "observers":
+		apiObservers(database, w, r, id)
+	case "subscriptions":
+		if sub != "" {
+			apiSubscriptionTest(database, w, r, id, sub)
+			return
+		}
+		apiSubscriptions(database, w, r, id)
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func apiEvents(db store.Database, w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if id != 0 {
+		event, err := db.GetEvent(id)
+		if err != nil {
+			apiFailure(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAPIEvent(event))
+		return
+	}
+
+	filter, err := eventFilterFromQuery(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	events, err := db.ListEvents(filter)
+	if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+	page := apiEventPage{Events: []apiEvent{}}
+	for _, e := range events {
+		page.Events = append(page.Events, newAPIEvent(e))
+	}
+	if len(events) > 0 && len(events) == filter.Limit {
+		page.Next = encodeCursor(events[len(events)-1].Cursor())
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// eventFilterFromQuery reads the EventFilter from the query parameters
+func eventFilterFromQuery(r *http.Request) (store.EventFilter, error) {
+	q := r.URL.Query()
+	filter := store.EventFilter{
+		Stream:    q.Get("stream"),
+		Class:     q.Get("class"),
+		Status:    q.Get("status"),
+		Tags:      q["tag"],
+		OrderBy:   q.Get("order"),
+		Ascending: q.Get("asc") == "true",
+		Limit:     50,
+	}
+	switch filter.OrderBy {
+	case "", "created", "confidence":
+	default:
+		return filter, fmt.Errorf("cannot order events by %q", filter.OrderBy)
+	}
+	var err error
+	if value := q.Get("min_confidence"); value != "" {
+		if filter.MinConfidence, err = strconv.Atoi(value); err != nil {
+			return filter, fmt.Errorf("invalid min_confidence %q", value)
+		}
+	}
+	if value := q.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit <= 0 || filter.Limit > 500 {
+			return filter, fmt.Errorf("limit must be between 1 and 500")
+		}
+	}
+	for name, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := q.Get(name); value != "" {
+			if *t, err = time.Parse(time.RFC3339, value); err != nil {
+				return filter, fmt.Errorf("invalid %s %q, expected RFC 3339", name, value)
+			}
+		}
+	}
+	if value := q.Get("after"); value != "" {
+		if filter.After, err = decodeCursor(value); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// encodeCursor returns the cursor as an opaque page token
+func encodeCursor(cursor *store.EventCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (*store.EventCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	var cursor store.EventCursor
+	if err != nil || json.Unmarshal(data, &cursor) != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+func apiStreams(db store.Database, w http.ResponseWriter, r *http.Request, id int) {
+	switch {
+	case r.Method == http.MethodGet && id == 0:
+		streams, err := db.ListStreams()
+		if streams == nil {
+			streams = []store.Stream{}
+		}
+		respond(w, r, http.StatusOK, streams, err)
+	case r.Method == http.MethodGet:
+		stream, err := db.GetStream(id)
+		respond(w, r, http.StatusOK, stream, err)
+	case r.Method == http.MethodPost && id == 0, r.Method == http.MethodPut && id != 0:
+		stream := store.Stream{Enabled: true}
+		if !readJSON(w, r, &stream) {
+			return
+		}
+		if err := stream.Validate(db.Org()); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if id == 0 {
+			id, err := db.CreateStream(stream)
+			stream.ID = id
+			respond(w, r, http.StatusCreated, stream, err)
+			return
+		}
+		stream.ID = id
+		respond(w, r, http.StatusOK, stream, db.UpdateStream(stream))
+	case r.Method == http.MethodDelete && id != 0:
+		respond(w, r, http.StatusNoContent, nil, db.DeleteStream(id))
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// apiStreamConfig serves the per-stream configuration of the admin page
+func apiStreamConfig(db store.Database, w http.ResponseWriter, r *http.Request, id int, sub string) {
+	switch {
+	case id == 0:
+		apiError(w, http.StatusNotFound, "not found")
+	case sub == "settings" && r.Method == http.MethodGet:
+		settings, err := db.GetStreamSettings(id)
+		respond(w, r, http.StatusOK, settings, err)
+	case sub == "settings" && r.Method == http.MethodPut:
+		var settings store.StreamSettings
+		if !readJSON(w, r, &settings) {
+			return
+		}
+		if err := settings.Validate(); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		respond(w, r, http.StatusOK, settings, db.SetStreamSettings(id, settings))
+	case sub == "zones" && r.Method == http.MethodGet:
+		zones, err := db.StreamZones(id)
+		if zones == nil {
+			zones = []store.StreamZone{}
+		}
+		respond(w, r, http.StatusOK, zones, err)
+	case sub == "zones" && r.Method == http.MethodPut:
+		var zones []store.StreamZone
+		if !readJSON(w, r, &zones) {
+			return
+		}
+		names := map[string]bool{}
+		for _, z := range zones {
+			if err := z.Validate(); err != nil {
+				apiError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if names[z.Name] {
+				apiError(w, http.StatusBadRequest, fmt.Sprintf("duplicate zone %q", z.Name))
+				return
+			}
+			names[z.Name] = true
+		}
+		respond(w, r, http.StatusOK, zones, db.SetStreamZones(id, zones))
+	case sub == "frame" && r.Method == http.MethodGet:
+		apiStreamFrame(db, w, r, id)
+	case (sub == "pause" || sub == "resume") && r.Method == http.MethodPost:
+		address, err := db.PauseStream(id, sub == "pause")
+		if err == nil {
+			// the running stream reads its settings again before the next frame
+			detect.Pipelines.Reload(address)
+		}
+		respond(w, r, http.StatusNoContent, nil, err)
+	case sub == "settings", sub == "zones", sub == "frame", sub == "pause", sub == "resume":
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// how long to wait for a frame of a running stream
+const referenceFrameTimeout = 5 * time.Second
+
+// apiStreamFrame responds with the next frame of a running stream or with
+// the latest snapshot of the stream
+func apiStreamFrame(db store.Database, w http.ResponseWriter, r *http.Request, id int) {
+	stream, err := db.GetStream(id)
+	if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+
+	if previews.isRunning(stream.Address, db.Org()) {
+		ch := previews.watch(stream.Address)
+		defer previews.unwatch(stream.Address, ch)
+		select {
+		case frame, ok := <-ch:
+			if ok {
+				w.Header().Set("Content-Type", "image/jpeg")
+				w.Header().Set("Cache-Control", "no-store")
+				w.Write(frame)
+				return
+			}
+		case <-time.After(referenceFrameTimeout):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if notify.SnapshotDir != "" {
+		events, err := db.ListEvents(store.EventFilter{Stream: stream.Address, Limit: 1})
+		if err != nil {
+			apiFailure(w, r, err)
+			return
+		}
+		if len(events) > 0 && events[0].Snapshot != "" {
+			w.Header().Set("Cache-Control", "no-store")
+			http.ServeFile(w, r, filepath.Join(notify.SnapshotDir, events[0].Snapshot))
+			return
+		}
+	}
+	apiError(w, http.StatusNotFound, "no frame available")
+}
+
+func apiObservers(db store.Database, w http.ResponseWriter, r *http.Request, id int) {
+	switch {
+	case r.Method == http.MethodGet && id == 0:
+		observers, err := db.ListObservers()
+		if observers == nil {
+			observers = []store.Observer{}
+		}
+		respond(w, r, http.StatusOK, observers, err)
+	case r.Method == http.MethodGet:
+		observer, err := db.GetObserver(id)
+		respond(w, r, http.StatusOK, observer, err)
+	case r.Method == http.MethodPost && id == 0, r.Method == http.MethodPut && id != 0:
+		var observer store.Observer
+		if !readJSON(w, r, &observer) {
+			return
+		}
+		if err := observer.Validate(); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if id == 0 {
+			id, err := db.CreateObserver(observer)
+			observer.ID = id
+			respond(w, r, http.StatusCreated, observer, err)
+			return
+		}
+		observer.ID = id
+		respond(w, r, http.StatusOK, observer, db.UpdateObserver(observer))
+	case r.Method == http.MethodDelete && id != 0:
+		respond(w, r, http.StatusNoContent, nil, db.DeleteObserver(id))
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func apiSubscriptions(db store.Database, w http.ResponseWriter, r *http.Request, id int) {
+	switch {
+	case r.Method == http.MethodGet && id == 0:
+		observer, _ := strconv.Atoi(r.URL.Query().Get("observer"))
+		subscriptions, err := db.ListSubscriptions(observer)
+		if subscriptions == nil {
+			subscriptions = []store.Subscription{}
+		}
+		respond(w, r, http.StatusOK, subscriptions, err)
+	case r.Method == http.MethodGet:
+		subscription, err := db.GetSubscription(id)
+		respond(w, r, http.StatusOK, subscription, err)
+	case r.Method == http.MethodPost && id == 0, r.Method == http.MethodPut && id != 0:
+		// the defaults of the table for the omitted fields
+		subscription := store.Subscription{Channel: "email", Mode: store.SubscriptionEvent, QuietAction: store.QuietSuppress}
+		if !readJSON(w, r, &subscription) {
+			return
+		}
+		if err := subscription.Validate(); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if id == 0 {
+			id, err := db.CreateSubscription(subscription)
+			subscription.ID = id
+			respond(w, r, http.StatusCreated, subscription, err)
+			return
+		}
+		subscription.ID = id
+		respond(w, r, http.StatusOK, subscription, db.UpdateSubscription(subscription))
+	case r.Method == http.MethodDelete && id != 0:
+		respond(w, r, http.StatusNoContent, nil, db.DeleteSubscription(id))
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// apiSubscriptionTest sends a test notification to the subscription
+func apiSubscriptionTest(db store.Database, w http.ResponseWriter, r *http.Request, id int, sub string) {
+	switch {
+	case id == 0 || sub != "test":
+		apiError(w, http.StatusNotFound, "not found")
+	case r.Method != http.MethodPost:
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		if _, err := db.GetSubscription(id); err != nil {
+			apiFailure(w, r, err)
+			return
+		}
+		if err := db.SendTestNotification(strconv.Itoa(id)); err != nil {
+			// the error of the channel is what the caller wants to see
+			apiError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// readJSON decodes the request body, writing the error response on failure
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// respond writes the value, or the error response if err is not nil
+func respond(w http.ResponseWriter, r *http.Request, status int, v interface{}, err error) {
+	if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	writeJSON(w, status, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// apiFailure maps the database errors to the status codes
+func apiFailure(w http.ResponseWriter, r *http.Request, err error) {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		apiError(w, http.StatusNotFound, "not found")
+	case errors.As(err, &pqErr) && pqErr.Code.Class() == "23":
+		// integrity constraint, e.g. a stream that still has events
+		apiError(w, http.StatusConflict, pqErr.Message)
+	default:
+		logger("api").Error("Error serving api request", "method", r.Method, "path", r.URL.Path, "err", err)
+		apiError(w, http.StatusInternalServerError, "internal error")
+	}
+}
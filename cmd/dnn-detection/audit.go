@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/sources"
+)
+
+// resourceAudit samples the resources of the process every interval and
+// warns when one of them has grown in every sample of the window, the
+// early sign of a leak: Mats, goroutines, files or captures that are not
+// closed when a pipeline ends or restarts.
+type resourceAudit struct {
+	interval time.Duration
+	// samples in a row that must grow before a warning
+	window int
+	// channel:recipient of the warnings (e.g. slack:https://hooks...),
+	// empty logs them only
+	alert string
+
+	// the latest samples by the resource, at most window of them
+	samples map[string][]int
+	// the resources whose growth has been reported, until they shrink
+	reported map[string]bool
+}
+
+// newResourceAudit returns the audit of the AUDIT_* environment, nil
+// when it is disabled
+func newResourceAudit() *resourceAudit {
+	interval := envDuration("AUDIT_INTERVAL", time.Minute)
+	if interval <= 0 {
+		return nil
+	}
+	window := envInt("AUDIT_WINDOW", 10)
+	if window < 2 {
+		window = 2
+	}
+	return &resourceAudit{
+		interval: interval,
+		window:   window,
+		alert:    os.Getenv("AUDIT_ALERT"),
+		samples:  map[string][]int{},
+		reported: map[string]bool{},
+	}
+}
+
+// run samples the resources until ctx is done
+func (a *resourceAudit) run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, warning := range a.check(resourceCounts()) {
+			logger("audit").Warn("Resource audit", "warning", warning)
+			a.warn(ctx, warning)
+		}
+	}
+}
+
+// resourceCounts returns the current counts of the audited resources
+func resourceCounts() map[string]int {
+	counts := map[string]int{"goroutines": runtime.NumGoroutine()}
+	if n := openMats(); n != nil {
+		counts["open_mats"] = *n
+	}
+	if n := openFDs(); n != nil {
+		counts["open_fds"] = *n
+	}
+	captures := 0
+	for device, n := range sources.OpenCaptures() {
+		captures += n
+		counts["captures "+device] = n
+	}
+	counts["captures"] = captures
+	return counts
+}
+
+// check adds the sample and returns the warnings: the resources that grew
+// in every sample of the window, and the devices with more than one open
+// capture
+func (a *resourceAudit) check(counts map[string]int) []string {
+	var warnings []string
+	var summary []string
+	for resource, n := range counts {
+		if device, ok := strings.CutPrefix(resource, "captures "); ok {
+			if n > 1 && !a.reported[resource] {
+				warnings = append(warnings, fmt.Sprintf("%d captures open for %s", n, device))
+			}
+			a.reported[resource] = n > 1
+			continue
+		}
+		summary = append(summary, fmt.Sprintf("%s %d", resource, n))
+
+		samples := append(a.samples[resource], n)
+		if len(samples) > a.window {
+			samples = samples[1:]
+		}
+		a.samples[resource] = samples
+		if len(samples) > 1 && samples[len(samples)-1] < samples[len(samples)-2] {
+			a.reported[resource] = false
+		}
+		if len(samples) == a.window && growing(samples) && !a.reported[resource] {
+			warnings = append(warnings, fmt.Sprintf("%s grew from %d to %d in %v", resource, samples[0], n, time.Duration(a.window-1)*a.interval))
+			a.reported[resource] = true
+		}
+	}
+	// the captures of the ended pipelines
+	for resource := range a.reported {
+		if _, ok := counts[resource]; !ok && strings.HasPrefix(resource, "captures ") {
+			delete(a.reported, resource)
+		}
+	}
+	sort.Strings(summary)
+	logger("audit").Info("Resources", "counts", strings.Join(summary, ", "))
+	sort.Strings(warnings)
+	return warnings
+}
+
+// growing tells whether every sample is larger than the previous one
+func growing(samples []int) bool {
+	for i := 1; i < len(samples); i++ {
+		if samples[i] <= samples[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// warn sends the warning to AUDIT_ALERT
+func (a *resourceAudit) warn(ctx context.Context, warning string) {
+	channel, recipient, ok := strings.Cut(a.alert, ":")
+	if !ok {
+		return
+	}
+	host, _ := os.Hostname()
+	n := notify.Notification{
+		Subject: "Resource growth on " + host,
+		Body:    warning + "\n\nSee /debug/streams of the detector for the state of the pipelines.",
+	}
+	if err := notify.Send(ctx, channel, n, recipient); err != nil {
+		logger("audit").Error("Error sending resource audit warning", "err", err)
+	}
+}
+
+// openFDs returns the number of the open file descriptors of the process,
+// nil where /proc is not available
+func openFDs() *int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return nil
+	}
+	n := len(entries)
+	return &n
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+)
+
+// runBenchmark prints the speed of the model of the command line on the
+// video to stdout, e.g.
+//
+//	dnn-detection -benchmark sample.mp4 -m models/yolov4-tiny.weights -c models/yolov4-tiny.cfg -target cuda
+func runBenchmark(video string, frames int, backendName, targetName string) error {
+	detector, err := detect.NewDetector(model, config, backend, target, classes)
+	if err != nil {
+		return err
+	}
+	defer detector.Close()
+
+	result, err := detector.Benchmark(video, frames, detect.Settings{Confidence: confidenceTreshold, Intersection: intersectionTreshold, Enabled: true})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Model   string `json:"model"`
+		Config  string `json:"config"`
+		Backend string `json:"backend"`
+		Target  string `json:"target"`
+		detect.BenchmarkResult
+	}{model, config, backendName, targetName, result})
+}
@@ -0,0 +1,56 @@
+package main
+
+import "sync"
+
+// eventBroadcaster is the sink that fans the events out to the live
+// consumers of the apis, the WatchDetections stream of the grpc api and
+// the websocket feed.
+// A consumer that does not keep up misses events, the detection never
+// waits for them.
+type eventBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan detectionEvent]struct{}
+}
+
+var broadcaster = &eventBroadcaster{subscribers: map[chan detectionEvent]struct{}{}}
+
+// subscribe returns a channel receiving the events until unsubscribe
+func (b *eventBroadcaster) subscribe() chan detectionEvent {
+	ch := make(chan detectionEvent, 64)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBroadcaster) unsubscribe(ch chan detectionEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (b *eventBroadcaster) writeEvent(event detectionEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+	return nil
+}
+
+// Close ends the streams of the consumers
+func (b *eventBroadcaster) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// clickhouseSink writes the raw detections (one row per bounding box)
+// to ClickHouse using its HTTP interface. The table is created with
+// clickhouse.sql.
+type clickhouseSink struct {
+	endpoint string
+	user     string
+	password string
+	client   *http.Client
+}
+
+type clickhouseRow struct {
+	Event      int     `json:"event_id"`
+	Stream     string  `json:"stream"`
+	Label      string  `json:"label"`
+	Created    string  `json:"created"`
+	Confidence float32 `json:"confidence"`
+	Top        int     `json:"location_top"`
+	Left       int     `json:"location_left"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Crop       string  `json:"crop"`
+}
+
+func newClickhouseSink(address, user, password string) *clickhouseSink {
+	query := url.Values{}
+	query.Set("query", "INSERT INTO detection FORMAT JSONEachRow")
+	// created is in RFC3339 format which needs the best effort parser
+	query.Set("date_time_input_format", "best_effort")
+
+	return &clickhouseSink{
+		endpoint: address + "/?" + query.Encode(),
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *clickhouseSink) writeEvent(event detectionEvent) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, obj := range event.detections {
+		err := encoder.Encode(clickhouseRow{event.id, event.stream, event.label, event.created, obj.Confidence, obj.Top, obj.Left, obj.Width, obj.Height, obj.Crop})
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	if c.user != "" {
+		req.Header.Set("X-ClickHouse-User", c.user)
+		req.Header.Set("X-ClickHouse-Key", c.password)
+	}
+
+	return notify.DoRequest(c.client, req)
+}
+
+func (c *clickhouseSink) Close() error {
+	c.client.CloseIdleConnections()
+	return nil
+}
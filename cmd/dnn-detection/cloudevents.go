@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// The webhook, Kafka and NATS sinks can publish the events as CloudEvents
+// 1.0 in the structured json mode (WEBHOOK_FORMAT, KAFKA_FORMAT and
+// NATS_FORMAT=cloudevents), so that the event routers (Knative,
+// EventBridge...) can route them by the type and the subject. The data is
+// the same payload as in the default json format.
+
+// formats of the published events
+const (
+	formatJSON        = "json"
+	formatCloudEvents = "cloudevents"
+)
+
+const (
+	cloudEventsContentType = "application/cloudevents+json"
+	// types of the published cloudevents
+	cloudEventDetection    = "com.github.osmundi.gocv-stream-events.detection"
+	cloudEventDetectionBox = "com.github.osmundi.gocv-stream-events.detection.box"
+)
+
+// source of the cloudevents, CLOUDEVENTS_SOURCE
+var cloudEventsSource = "/gocv-stream-events"
+
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            string      `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// cloudEventSubject is the stream of the event, the name is preferred as
+// the address may contain credentials
+func cloudEventSubject(event detectionEvent) string {
+	if event.info.Name != "" {
+		return event.info.Name
+	}
+	return event.stream
+}
+
+func newCloudEvent(id, eventType string, event detectionEvent, data interface{}) cloudEvent {
+	return cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          cloudEventsSource,
+		Type:            eventType,
+		Subject:         cloudEventSubject(event),
+		Time:            event.created,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+}
+
+// encodeEvent returns the message of the event in the format and its
+// content type
+func encodeEvent(format string, event detectionEvent) ([]byte, string, error) {
+	payload := newEventPayload(event)
+	if format != formatCloudEvents {
+		body, err := json.Marshal(payload)
+		return body, "application/json", err
+	}
+	body, err := json.Marshal(newCloudEvent(strconv.Itoa(event.id), cloudEventDetection, event, payload))
+	return body, cloudEventsContentType, err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// The dashboard in web/ is a static page on top of the rest api, the /ws
+// feed and the previews.
+//
+//go:embed web
+var dashboardFiles embed.FS
+
+func dashboardHandler() http.Handler {
+	files, _ := fs.Sub(dashboardFiles, "web")
+	return http.FileServer(http.FS(files))
+}
+
+// snapshotHandler serves the snapshots and the crops of the events of the
+// organization of the caller for the dashboard when the snapshots have no
+// public address
+func snapshotHandler() http.Handler {
+	return protectedFiles("/snapshots/", notify.SnapshotDir, func(database store.Database, name string) (bool, error) {
+		return database.SnapshotVisible(name)
+	})
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+	"github.com/osmundi/gocv-stream-events/pkg/sources"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// With DEBUG_ENDPOINTS=true the http server has the pprof profiles under
+// /debug/pprof/ and the state of the stream pipelines on /debug/streams,
+// e.g. to find the capture that is stuck or the cause of memory growth:
+//
+//	go tool pprof -http=: 'http://localhost:8080/debug/pprof/heap?key=...'
+//
+// A build with -tags matprofile also counts the opencv Mats that have not
+// been closed, and lists where they were created on
+// /debug/pprof/gocv.io/x/gocv.Mat?debug=1.
+//
+// /debug/metrics has the latency histograms of the steps of the frames and
+// the frames dropped by the sources and the stages in the text format of
+// Prometheus, scraped with the key as a parameter:
+//
+//	params:
+//	  key: ['...']
+//
+// They need a write api key of all organizations (-create-api-key without
+// -org), since the profiles show the internals of every stream.
+
+// runtimeState is the summary of the process on /debug/streams
+type runtimeState struct {
+	Goroutines  int    `json:"goroutines"`
+	HeapAlloc   uint64 `json:"heap_alloc_bytes"`
+	HeapObjects uint64 `json:"heap_objects"`
+	Sys         uint64 `json:"sys_bytes"`
+	NumGC       uint32 `json:"num_gc"`
+	// the frames are in native memory of opencv, which is not in the heap
+	Previews int `json:"preview_viewers"`
+	// Mats that have not been closed, with -tags matprofile. It should stay
+	// around the size of the frame pools of the pipelines.
+	OpenMats *int `json:"open_mats,omitempty"`
+	// file descriptors of the process (linux only)
+	OpenFDs *int `json:"open_fds,omitempty"`
+	// open captures by the device, more than one for a device is a leak
+	Captures map[string]int `json:"captures"`
+}
+
+func debugStreamsHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	previews.mu.Lock()
+	viewers := 0
+	for _, v := range previews.viewers {
+		viewers += len(v)
+	}
+	previews.mu.Unlock()
+	// the leases of all the instances sharing the streams with -worker
+	var workers []store.Worker
+	if worker != nil {
+		var err error
+		if workers, err = db.Workers(); err != nil {
+			logger("api").Error("Error listing workers", "err", err)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, struct {
+		Runtime   runtimeState      `json:"runtime"`
+		Pipelines []detect.State    `json:"pipelines"`
+		GPUs      []detect.GPUState `json:"gpus"`
+		Workers   []store.Worker    `json:"workers,omitempty"`
+	}{
+		runtimeState{runtime.NumGoroutine(), mem.HeapAlloc, mem.HeapObjects, mem.Sys, mem.NumGC, viewers, openMats(), openFDs(), sources.OpenCaptures()},
+		detect.Pipelines.List(),
+		detect.GPUs.List(),
+		workers,
+	})
+}
+
+// debugHandler serves the debug endpoints to the admins
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/streams", debugStreamsHandler)
+	mux.HandleFunc("/debug/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		detect.Latencies.WritePrometheus(w)
+		detect.Pipelines.WritePrometheus(w)
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = r.URL.Query().Get("key")
+		}
+		database, err := databaseForRequest(r, key, true)
+		if err == sql.ErrNoRows || err == store.ErrReadOnlyKey || err == nil && database.Org() != 0 {
+			http.Error(w, "admin api key required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			logger("api").Error("Error serving debug request", "path", r.URL.Path, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// elasticsearchSink indexes every detection event as a document so the
+// sightings can be searched and visualized with Kibana. Works with
+// OpenSearch as well.
+type elasticsearchSink struct {
+	address  string
+	index    string
+	apiKey   string
+	user     string
+	password string
+	client   *http.Client
+}
+
+type elasticsearchDocument struct {
+	Event      int     `json:"event_id"`
+	Stream     string  `json:"stream"`
+	Class      string  `json:"class"`
+	Count      int     `json:"count"`
+	Confidence float32 `json:"confidence"`
+	Timestamp  string  `json:"@timestamp"`
+	StreamName string  `json:"stream_name,omitempty"`
+	Place      string  `json:"place,omitempty"`
+	Snapshot   string  `json:"snapshot_url,omitempty"`
+	// mapped as geo_point
+	Location *elasticsearchGeoPoint `json:"location,omitempty"`
+}
+
+type elasticsearchGeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func newElasticsearchSink(address, index string) *elasticsearchSink {
+	if index == "" {
+		index = "detections"
+	}
+	return &elasticsearchSink{
+		address: strings.TrimSuffix(address, "/"),
+		index:   index,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *elasticsearchSink) writeEvent(event detectionEvent) error {
+	doc := elasticsearchDocument{
+		Event:      event.id,
+		Stream:     event.stream,
+		Class:      event.label,
+		Count:      len(event.detections),
+		Timestamp:  event.created,
+		StreamName: event.info.Name,
+		Place:      event.info.Description,
+		Snapshot:   notify.SnapshotLink(event.snapshot),
+	}
+	if event.info.HasCoordinates {
+		doc.Location = &elasticsearchGeoPoint{event.info.Latitude, event.info.Longitude}
+	}
+	for _, obj := range event.detections {
+		if obj.Confidence > doc.Confidence {
+			doc.Confidence = obj.Confidence
+		}
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	// use the event id as document id so that resending an event doesn't duplicate it
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/%s/_doc/%d", e.address, e.index, event.id), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	} else if e.user != "" {
+		req.SetBasicAuth(e.user, e.password)
+	}
+
+	return notify.DoRequest(e.client, req)
+}
+
+func (e *elasticsearchSink) Close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
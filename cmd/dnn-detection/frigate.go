@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// The frigate layout mimics the topics of the Frigate NVR so that the
+// tools built around it (the Home Assistant integration, double-take etc.)
+// can consume the events as is:
+//
+//	<prefix>/available                 online/offline of the detector (retained)
+//	<prefix>/events                    new and end messages of every event
+//	<prefix>/<camera>/<object>         count of the objects, 0 after the timeout
+//	<prefix>/<camera>/all              count of all objects
+//	<prefix>/<camera>/<object>/snapshot jpeg of the latest event (retained)
+//	<prefix>/<camera>/detect/state     ON/OFF by the health of the stream
+//
+// The camera is the name of the stream, or the address when the stream
+// has no name.
+const mqttLayoutFrigate = "frigate"
+
+// frigateEvent is the subset of the frigate event fields that we can fill
+type frigateEvent struct {
+	ID            string   `json:"id"`
+	Camera        string   `json:"camera"`
+	FrameTime     float64  `json:"frame_time"`
+	SnapshotTime  float64  `json:"snapshot_time"`
+	Label         string   `json:"label"`
+	SubLabel      *string  `json:"sub_label"`
+	TopScore      float32  `json:"top_score"`
+	Score         float32  `json:"score"`
+	FalsePositive bool     `json:"false_positive"`
+	StartTime     float64  `json:"start_time"`
+	EndTime       *float64 `json:"end_time"`
+	Box           [4]int   `json:"box"`
+	Area          int      `json:"area"`
+	CurrentZones  []string `json:"current_zones"`
+	EnteredZones  []string `json:"entered_zones"`
+	HasSnapshot   bool     `json:"has_snapshot"`
+	HasClip       bool     `json:"has_clip"`
+	Stationary    bool     `json:"stationary"`
+}
+
+type frigateMessage struct {
+	Type   string       `json:"type"`
+	Before frigateEvent `json:"before"`
+	After  frigateEvent `json:"after"`
+}
+
+func frigateCamera(stream, name string) string {
+	if name != "" {
+		return mqttSlug(name)
+	}
+	return mqttSlug(stream)
+}
+
+func (m *mqttSink) writeFrigateEvent(event detectionEvent) error {
+	camera := frigateCamera(event.stream, event.info.Name)
+	topic := m.prefix + "/" + camera
+
+	created, err := time.Parse(time.RFC3339, event.created)
+	if err != nil {
+		return err
+	}
+	timestamp := float64(created.UnixMicro()) / 1e6
+
+	// the box of the most confident detection represents the event
+	fe := frigateEvent{
+		ID:           fmt.Sprintf("%.6f-%d", timestamp, event.id),
+		Camera:       camera,
+		FrameTime:    timestamp,
+		SnapshotTime: timestamp,
+		Label:        event.label,
+		StartTime:    timestamp,
+		CurrentZones: []string{},
+		EnteredZones: []string{},
+		HasSnapshot:  event.snapshot != "",
+	}
+	for _, obj := range event.detections {
+		if obj.Confidence > fe.TopScore {
+			fe.TopScore = obj.Confidence
+			fe.Score = obj.Confidence
+			fe.Box = [4]int{obj.Left, obj.Top, obj.Left + obj.Width, obj.Top + obj.Height}
+			fe.Area = obj.Width * obj.Height
+		}
+	}
+
+	if event.snapshot != "" && notify.SnapshotDir != "" {
+		data, err := os.ReadFile(filepath.Join(notify.SnapshotDir, event.snapshot))
+		if err != nil {
+			return err
+		}
+		if err := m.publish(topic+"/"+event.label+"/snapshot", true, data); err != nil {
+			return err
+		}
+	}
+
+	count := fmt.Sprint(len(event.detections))
+	if err := m.publish(topic+"/"+event.label, false, count); err != nil {
+		return err
+	}
+	if err := m.publish(topic+"/all", false, count); err != nil {
+		return err
+	}
+	// frigate resets the counts when the object leaves the frame
+	time.AfterFunc(mqttDetectedTimeout*time.Second, func() {
+		m.publish(topic+"/"+event.label, false, "0")
+		m.publish(topic+"/all", false, "0")
+	})
+
+	// the events are single frames so they end as soon as they start
+	if err := m.publishFrigateMessage("new", fe, fe); err != nil {
+		return err
+	}
+	ended := fe
+	ended.EndTime = &timestamp
+	return m.publishFrigateMessage("end", fe, ended)
+}
+
+func (m *mqttSink) publishFrigateMessage(kind string, before, after frigateEvent) error {
+	payload, err := json.Marshal(frigateMessage{Type: kind, Before: before, After: after})
+	if err != nil {
+		return err
+	}
+	return m.publish(m.prefix+"/events", false, payload)
+}
+
+func (m *mqttSink) writeFrigateHealth(stream string, online bool) error {
+	state := "OFF"
+	if online {
+		state = "ON"
+	}
+	return m.publish(m.prefix+"/"+frigateCamera(stream, m.streamName(stream))+"/detect/state", true, state)
+}
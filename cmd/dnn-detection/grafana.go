@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// grafanaSink posts every detection event as an annotation with the
+// Grafana http api, tagged with the stream and the class, so that the
+// sightings can be overlaid on the dashboards of the cameras. Without a
+// dashboard the annotations are organization wide and are shown with an
+// annotation query that filters by the tags.
+type grafanaSink struct {
+	endpoint string
+	token    string
+	// optional dashboard and panel of the annotations
+	dashboard string
+	panel     int
+	tags      []string
+	client    *http.Client
+}
+
+func newGrafanaSink(address, token, dashboard string, panel int, tags string) *grafanaSink {
+	g := &grafanaSink{
+		endpoint:  strings.TrimSuffix(address, "/") + "/api/annotations",
+		token:     token,
+		dashboard: dashboard,
+		panel:     panel,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			g.tags = append(g.tags, tag)
+		}
+	}
+	return g
+}
+
+type grafanaAnnotation struct {
+	DashboardUID string   `json:"dashboardUID,omitempty"`
+	PanelID      int      `json:"panelId,omitempty"`
+	Time         int64    `json:"time"`
+	Tags         []string `json:"tags"`
+	Text         string   `json:"text"`
+}
+
+func (g *grafanaSink) writeEvent(event detectionEvent) error {
+	created, err := time.Parse(time.RFC3339, event.created)
+	if err != nil {
+		return err
+	}
+	payload := newEventPayload(event)
+	stream := payload.StreamName
+	if stream == "" {
+		stream = payload.Stream
+	}
+
+	// the text is shown as html in the tooltip
+	text := fmt.Sprintf("%d × %s at %s (%d%%)", payload.Count, html.EscapeString(payload.Class), html.EscapeString(stream), int(100*payload.Confidence))
+	if payload.Snapshot != "" {
+		text += fmt.Sprintf(` <a href="%s">snapshot</a>`, html.EscapeString(payload.Snapshot))
+	}
+	annotation := grafanaAnnotation{
+		DashboardUID: g.dashboard,
+		PanelID:      g.panel,
+		Time:         created.UnixMilli(),
+		Tags:         append(append([]string{}, g.tags...), "stream:"+stream, "class:"+payload.Class),
+		Text:         text,
+	}
+	body, err := json.Marshal(annotation)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, g.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// a service account token
+	req.Header.Set("Authorization", "Bearer "+g.token)
+	return notify.DoRequest(g.client, req)
+}
+
+func (g *grafanaSink) Close() error {
+	g.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/osmundi/gocv-stream-events/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"gocv.io/x/gocv"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// grpcServer implements the Detections service of pb/detection.proto.
+// Like the rest api, the calls need the x-api-key metadata (or an OIDC id
+// token in the authorization metadata) and are scoped to the organization
+// of the key. The changes need a write key.
+type grpcServer struct {
+	pb.UnimplementedDetectionsServer
+}
+
+// serveGRPC serves the grpc api
+func serveGRPC(ctx context.Context, addr string) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger("api").Error("Error serving grpc", "err", err)
+		return
+	}
+	// room for the uncompressed frames of DetectFrame
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(maxUploadSize))
+	pb.RegisterDetectionsServer(server, grpcServer{})
+	go func() {
+		<-ctx.Done()
+		// the event subscriptions would keep GracefulStop waiting
+		server.Stop()
+	}()
+	logger("api").Info("Serving grpc", "addr", addr)
+	if err := server.Serve(listener); err != nil {
+		logger("api").Error("Error serving grpc", "err", err)
+	}
+}
+
+// grpcDatabase returns the database scoped to the organization of the call
+func grpcDatabase(ctx context.Context, write bool) (store.Database, error) {
+	var key string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get("x-api-key"); len(keys) > 0 {
+		key = keys[0]
+	}
+	var database store.Database
+	var err error
+	if bearer := md.Get("authorization"); key == "" && openID != nil && len(bearer) > 0 {
+		user, uerr := openID.bearerUser(ctx, strings.TrimPrefix(bearer[0], "Bearer "))
+		database, err = databaseForUser(user, uerr, write)
+	} else {
+		database, err = db.ForAPIKey(key, write)
+	}
+	if err == sql.ErrNoRows {
+		return database, status.Error(codes.Unauthenticated, "invalid api key")
+	}
+	if err == store.ErrReadOnlyKey {
+		return database, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return database, grpcError("api key", err)
+	}
+	return database, nil
+}
+
+// grpcError maps the database errors to the status codes
+func grpcError(method string, err error) error {
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "not found")
+	case errors.As(err, &pqErr) && pqErr.Code.Class() == "23":
+		return status.Error(codes.FailedPrecondition, pqErr.Message)
+	default:
+		logger("api").Error("Error serving grpc request", "method", method, "err", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func pbEvent(e store.Event) *pb.Event {
+	event := &pb.Event{
+		Id:          int64(e.ID),
+		Stream:      e.StreamAddress,
+		StreamName:  e.StreamName,
+		Class:       e.Class,
+		Count:       int32(e.Count),
+		Confidence:  int32(e.Confidence),
+		Created:     e.Created.Format(time.RFC3339),
+		SnapshotUrl: notify.SnapshotLink(e.Snapshot),
+		Status:      e.Status,
+		Tags:        e.Tags,
+	}
+	for _, d := range e.Detections {
+		event.Detections = append(event.Detections, &pb.Detection{
+			Confidence: int32(d.Confidence),
+			Top:        int32(d.Top),
+			Left:       int32(d.Left),
+			Width:      int32(d.Width),
+			Height:     int32(d.Height),
+			CropUrl:    notify.SnapshotLink(d.Crop),
+		})
+	}
+	return event
+}
+
+// pbDetectionEvent converts a live event, which has not been reviewed yet
+func pbDetectionEvent(e detectionEvent) *pb.Event {
+	event := &pb.Event{
+		Id:          int64(e.id),
+		Stream:      e.stream,
+		StreamName:  e.info.Name,
+		Class:       e.label,
+		Count:       int32(len(e.detections)),
+		Created:     e.created,
+		SnapshotUrl: notify.SnapshotLink(e.snapshot),
+		Status:      store.EventNew,
+	}
+	for _, obj := range e.detections {
+		confidence := int32(obj.Confidence * 100)
+		if confidence > event.Confidence {
+			event.Confidence = confidence
+		}
+		event.Detections = append(event.Detections, &pb.Detection{
+			Confidence: confidence,
+			Top:        int32(obj.Top),
+			Left:       int32(obj.Left),
+			Width:      int32(obj.Width),
+			Height:     int32(obj.Height),
+			CropUrl:    notify.SnapshotLink(obj.Crop),
+		})
+	}
+	return event
+}
+
+func pbStream(s store.Stream) *pb.Stream {
+	return &pb.Stream{
+		Id:             int64(s.ID),
+		Name:           s.Name,
+		Link:           s.Link,
+		Address:        s.Address,
+		Description:    s.Description,
+		Timezone:       s.Timezone,
+		HasCoordinates: s.HasCoordinates,
+		Latitude:       s.Latitude,
+		Longitude:      s.Longitude,
+		Disabled:       !s.Enabled,
+	}
+}
+
+func streamFromPB(s *pb.Stream) store.Stream {
+	return store.Stream{
+		ID:             int(s.Id),
+		Name:           s.Name,
+		Link:           s.Link,
+		Address:        s.Address,
+		Description:    s.Description,
+		Timezone:       s.Timezone,
+		HasCoordinates: s.HasCoordinates,
+		Latitude:       s.Latitude,
+		Longitude:      s.Longitude,
+		Enabled:        !s.Disabled,
+	}
+}
+
+func (grpcServer) GetEvents(ctx context.Context, req *pb.GetEventsRequest) (*pb.GetEventsResponse, error) {
+	database, err := grpcDatabase(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	filter := store.EventFilter{
+		Stream:        req.Stream,
+		Class:         req.Class,
+		Status:        req.Status,
+		MinConfidence: int(req.MinConfidence),
+		Limit:         int(req.PageSize),
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 50
+	}
+	for _, t := range []struct {
+		value string
+		time  *time.Time
+	}{{req.From, &filter.From}, {req.To, &filter.To}} {
+		if t.value == "" {
+			continue
+		}
+		if *t.time, err = time.Parse(time.RFC3339, t.value); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid time %q, expected RFC 3339", t.value)
+		}
+	}
+	if req.PageToken != "" {
+		if filter.After, err = decodeCursor(req.PageToken); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	events, err := database.ListEvents(filter)
+	if err != nil {
+		return nil, grpcError("GetEvents", err)
+	}
+	resp := &pb.GetEventsResponse{}
+	for _, e := range events {
+		resp.Events = append(resp.Events, pbEvent(e))
+	}
+	if len(events) == filter.Limit {
+		resp.NextPageToken = encodeCursor(events[len(events)-1].Cursor())
+	}
+	return resp, nil
+}
+
+func (grpcServer) GetEvent(ctx context.Context, req *pb.GetEventRequest) (*pb.Event, error) {
+	database, err := grpcDatabase(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	event, err := database.GetEvent(int(req.Id))
+	if err != nil {
+		return nil, grpcError("GetEvent", err)
+	}
+	return pbEvent(event), nil
+}
+
+func (grpcServer) ListStreams(ctx context.Context, req *pb.ListStreamsRequest) (*pb.ListStreamsResponse, error) {
+	database, err := grpcDatabase(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	streams, err := database.ListStreams()
+	if err != nil {
+		return nil, grpcError("ListStreams", err)
+	}
+	resp := &pb.ListStreamsResponse{}
+	for _, s := range streams {
+		resp.Streams = append(resp.Streams, pbStream(s))
+	}
+	return resp, nil
+}
+
+func (grpcServer) CreateStream(ctx context.Context, req *pb.Stream) (*pb.Stream, error) {
+	database, err := grpcDatabase(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	stream := streamFromPB(req)
+	if err := stream.Validate(database.Org()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if stream.ID, err = database.CreateStream(stream); err != nil {
+		return nil, grpcError("CreateStream", err)
+	}
+	return pbStream(stream), nil
+}
+
+func (grpcServer) UpdateStream(ctx context.Context, req *pb.Stream) (*pb.Stream, error) {
+	database, err := grpcDatabase(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	stream := streamFromPB(req)
+	if err := stream.Validate(database.Org()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := database.UpdateStream(stream); err != nil {
+		return nil, grpcError("UpdateStream", err)
+	}
+	return pbStream(stream), nil
+}
+
+func (grpcServer) DeleteStream(ctx context.Context, req *pb.DeleteStreamRequest) (*pb.DeleteStreamResponse, error) {
+	database, err := grpcDatabase(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.DeleteStream(int(req.Id)); err != nil {
+		return nil, grpcError("DeleteStream", err)
+	}
+	return &pb.DeleteStreamResponse{}, nil
+}
+
+func (grpcServer) WatchDetections(req *pb.WatchDetectionsRequest, stream pb.Detections_WatchDetectionsServer) error {
+	database, err := grpcDatabase(stream.Context(), false)
+	if err != nil {
+		return err
+	}
+	streams := stringSet(req.Streams)
+	classes := stringSet(req.Classes)
+
+	events := broadcaster.subscribe()
+	defer broadcaster.unsubscribe(events)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.Unavailable, "server shutting down")
+			}
+			if database.Org() != 0 && event.info.Org != database.Org() {
+				continue
+			}
+			if (len(streams) > 0 && !streams[event.stream]) || (len(classes) > 0 && !classes[event.label]) {
+				continue
+			}
+			if err := stream.Send(pbDetectionEvent(event)); err != nil {
+				return fmt.Errorf("watch detections: %w", err)
+			}
+		}
+	}
+}
+
+func (grpcServer) DetectFrame(ctx context.Context, req *pb.DetectFrameRequest) (*pb.DetectFrameResponse, error) {
+	if _, err := grpcDatabase(ctx, false); err != nil {
+		return nil, err
+	}
+	overrides := store.StreamSettings{Classes: req.Classes}
+	if req.Confidence != 0 {
+		confidence := float64(req.Confidence)
+		overrides.Confidence = &confidence
+	}
+	if req.Iou != 0 {
+		iou := float64(req.Iou)
+		overrides.IOU = &iou
+	}
+	settings, err := overrideSettings(overrides)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var img gocv.Mat
+	switch frame := req.Frame.(type) {
+	case *pb.DetectFrameRequest_Image:
+		img, err = gocv.IMDecode(frame.Image, gocv.IMReadColor)
+	case *pb.DetectFrameRequest_Raw:
+		if int(frame.Raw.Width)*int(frame.Raw.Height)*3 != len(frame.Raw.Pixels) {
+			return nil, status.Error(codes.InvalidArgument, "the size of the pixels does not match the width and height")
+		}
+		img, err = gocv.NewMatFromBytes(int(frame.Raw.Height), int(frame.Raw.Width), gocv.MatTypeCV8UC3, frame.Raw.Pixels)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "no frame")
+	}
+	if err != nil || img.Empty() {
+		return nil, status.Error(codes.InvalidArgument, "the frame is not an image")
+	}
+	defer img.Close()
+
+	detector, err := inferenceDetector()
+	if err != nil {
+		return nil, grpcError("DetectFrame", err)
+	}
+	resp := &pb.DetectFrameResponse{Width: int32(img.Cols()), Height: int32(img.Rows())}
+	for _, obj := range detector.Detect(img, settings) {
+		resp.Detections = append(resp.Detections, &pb.FrameDetection{
+			Class:      objectClass(obj),
+			Confidence: obj.Confidence,
+			Top:        int32(obj.Top),
+			Left:       int32(obj.Left),
+			Width:      int32(obj.Width),
+			Height:     int32(obj.Height),
+		})
+	}
+	return resp, nil
+}
+
+func stringSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, v := range values {
+		set[v] = true
+	}
+	return set
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// heartbeats pings the HEARTBEAT_* urls, nil if they are not set
+var heartbeats *heartbeat
+
+// heartbeat pings a dead man's switch (e.g. healthchecks.io) while the
+// detector runs, so that the service alerts when the pings stop: the
+// process has died, hangs or has lost its network, which the detector
+// cannot report itself
+type heartbeat struct {
+	// pinged every interval while the process runs
+	url string
+	// pinged with every health report of a stream that has frames,
+	// {stream} is replaced with the id of the stream
+	streamURL string
+	interval  time.Duration
+	client    *http.Client
+}
+
+// newHeartbeat returns the heartbeat of the HEARTBEAT_* environment, nil
+// when there is nothing to ping
+func newHeartbeat() *heartbeat {
+	h := &heartbeat{
+		url:       os.Getenv("HEARTBEAT_URL"),
+		streamURL: os.Getenv("HEARTBEAT_STREAM_URL"),
+		interval:  envDuration("HEARTBEAT_INTERVAL", time.Minute),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if h.url == "" && h.streamURL == "" {
+		return nil
+	}
+	return h
+}
+
+// run pings the url of the instance until ctx is done
+func (h *heartbeat) run(ctx context.Context) {
+	if h.url == "" || h.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		if err := h.ping(ctx, h.url); err != nil {
+			logger("worker").Warn("Error sending heartbeat", "err", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// streamAlive pings the url of the stream in the background. The streams
+// that are not in the database have no id and are not pinged.
+func (h *heartbeat) streamAlive(stream store.Stream) {
+	if h == nil || h.streamURL == "" || stream.ID == 0 {
+		return
+	}
+	url := strings.ReplaceAll(h.streamURL, "{stream}", strconv.Itoa(stream.ID))
+	go func() {
+		if err := h.ping(context.Background(), url); err != nil {
+			logger("worker").Warn("Error sending stream heartbeat", "stream_id", stream.ID, "err", err)
+		}
+	}()
+}
+
+// ping requests the url, any 2xx response is a success
+func (h *heartbeat) ping(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return notify.DoRequest(h.client, req)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// directory of the HLS playlists of the annotated streams, empty disables
+var hlsDir string
+
+// hlsOrgs are the organizations of the running HLS streams by the name of
+// their directory
+var hlsOrgs = struct {
+	sync.Mutex
+	names map[string]int
+}{names: map[string]int{}}
+
+// startHLS packages the annotated frames of the stream to HLS with ffmpeg
+// until the returned function is called. The playlist is served on
+// /hls/<name>/index.m3u8, where the name is the slug of the stream name
+// (or address), so the stream plays in a plain <video> tag (with hls.js
+// on the browsers without native HLS).
+func startHLS(stream store.Stream, address string) (stop func()) {
+	if hlsDir == "" {
+		return func() {}
+	}
+	name := restreamName(stream, address)
+	dir := filepath.Join(hlsDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger("preview").Error("HLS disabled", "device", address, "err", err)
+		return func() {}
+	}
+
+	// the frames are irregular, so they are timestamped on arrival and
+	// duplicated to a constant rate
+	cmd := exec.Command("ffmpeg", "-loglevel", "error",
+		"-use_wallclock_as_timestamps", "1", "-f", "mjpeg", "-i", "-",
+		"-r", "10", "-c:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency", "-pix_fmt", "yuv420p",
+		"-f", "hls", "-hls_time", "2", "-hls_list_size", "6", "-hls_flags", "delete_segments+omit_endlist",
+		filepath.Join(dir, "index.m3u8"))
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		logger("preview").Error("HLS disabled", "device", address, "err", err)
+		return func() {}
+	}
+	if err := cmd.Start(); err != nil {
+		logger("preview").Error("HLS disabled", "device", address, "err", err)
+		return func() {}
+	}
+
+	hlsOrgs.Lock()
+	hlsOrgs.names[name] = stream.Org
+	hlsOrgs.Unlock()
+	frames := previews.watch(address)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for frame := range frames {
+			if _, err := stdin.Write(frame); err != nil {
+				logger("preview").Error("HLS stopped", "device", address, "err", err)
+				// keep draining so that the preview never blocks
+				for range frames {
+				}
+				break
+			}
+		}
+		stdin.Close()
+		cmd.Wait()
+	}()
+	return func() {
+		hlsOrgs.Lock()
+		delete(hlsOrgs.names, name)
+		hlsOrgs.Unlock()
+		previews.unwatch(address, frames)
+		<-done
+	}
+}
+
+// hlsHandler serves the playlists and segments of hlsDir of the running
+// streams of the organization of the caller. The players must send the
+// key with every request (e.g. with xhrSetup of hls.js) or have a
+// session.
+func hlsHandler() http.Handler {
+	files := protectedFiles("/hls/", hlsDir, func(database store.Database, name string) (bool, error) {
+		stream, _, _ := strings.Cut(name, "/")
+		hlsOrgs.Lock()
+		org, ok := hlsOrgs.names[stream]
+		hlsOrgs.Unlock()
+		return ok && (database.Org() == 0 || org == database.Org()), nil
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the playlists change every segment
+		if filepath.Ext(r.URL.Path) == ".m3u8" {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
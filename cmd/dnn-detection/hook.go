@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// hookRunner runs the commands of the HOOKS on the events of the streams
+// that have chosen them with the on_detect setting, e.g. to turn on a
+// sprinkler. The command gets the event as GOCV_* environment variables
+// and as json in its stdin.
+type hookRunner struct {
+	commands map[string][]string
+	timeout  time.Duration
+	// a slot for each running command, events are skipped when all are taken
+	running chan struct{}
+}
+
+var hooks *hookRunner
+
+// newHookRunner returns the runner of the comma separated name=command
+// list, e.g. "sprinkler=/usr/local/bin/sprinkler --zone 2"
+func newHookRunner(config string, timeout time.Duration, concurrency int) *hookRunner {
+	h := &hookRunner{commands: map[string][]string{}, timeout: timeout, running: make(chan struct{}, concurrency)}
+	store.Hooks = []string{}
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, command, ok := strings.Cut(entry, "=")
+		if !ok || len(strings.Fields(command)) == 0 {
+			logger("sink").Error("Invalid hook, expected name=command", "hook", entry)
+			continue
+		}
+		h.commands[name] = strings.Fields(command)
+		store.Hooks = append(store.Hooks, name)
+	}
+	return h
+}
+
+// run starts the hook in the background
+func (h *hookRunner) run(name string, event detectionEvent) {
+	command, ok := h.commands[name]
+	if !ok {
+		eventLogger(event).Error("Hook is not configured", "hook", name)
+		return
+	}
+	select {
+	case h.running <- struct{}{}:
+	default:
+		eventLogger(event).Warn("Hook skipped, too many hooks running", "hook", name, "running", cap(h.running))
+		return
+	}
+
+	payload := newEventPayload(event)
+	input, err := json.Marshal(payload)
+	if err != nil {
+		<-h.running
+		eventLogger(event).Error("Error running hook", "hook", name, "err", err)
+		return
+	}
+	go func() {
+		defer func() { <-h.running }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Env = append(os.Environ(),
+			fmt.Sprintf("GOCV_EVENT_ID=%d", payload.Event),
+			"GOCV_STREAM="+payload.Stream,
+			"GOCV_STREAM_NAME="+payload.StreamName,
+			"GOCV_CLASS="+payload.Class,
+			fmt.Sprintf("GOCV_COUNT=%d", payload.Count),
+			fmt.Sprintf("GOCV_CONFIDENCE=%.2f", payload.Confidence),
+			"GOCV_CREATED="+payload.Created,
+			"GOCV_SNAPSHOT_URL="+payload.Snapshot,
+		)
+		cmd.Stdin = bytes.NewReader(input)
+		output, err := cmd.CombinedOutput()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %v", h.timeout)
+		}
+		if err != nil {
+			eventLogger(event).Error("Hook failed", "hook", name, "err", err, "output", string(bytes.TrimSpace(output)))
+		}
+	}()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// maxUploadSize limits the images of POST /detect
+const maxUploadSize = 20 << 20
+
+// the network of POST /detect and DetectFrame is read on the first request
+var (
+	detectorOnce sync.Once
+	detector     *detect.Detector
+	detectorErr  error
+)
+
+// inferenceDetection is an object found in an uploaded image
+type inferenceDetection struct {
+	Class      string  `json:"class"`
+	Confidence float32 `json:"confidence"`
+	Top        int     `json:"top"`
+	Left       int     `json:"left"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+}
+
+type inferenceResult struct {
+	Width      int                  `json:"width"`
+	Height     int                  `json:"height"`
+	Detections []inferenceDetection `json:"detections"`
+}
+
+// detectHandler runs the model on an uploaded image:
+//
+//	POST /detect   the image as the body or as the file "image" of a form,
+//	               ?confidence= &iou= &classes= &annotate=true
+//
+// The detections are returned as json, or with annotate=true as a jpeg with
+// the bounding boxes drawn. Any api key is accepted.
+func detectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if _, err := databaseForRequest(r, r.Header.Get("X-API-Key"), false); err == sql.ErrNoRows {
+		apiError(w, http.StatusUnauthorized, "invalid api key")
+		return
+	} else if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+
+	settings, err := inferenceSettings(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	upload, err := readUpload(w, r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	img, err := gocv.IMDecode(upload, gocv.IMReadColor)
+	if err != nil || img.Empty() {
+		apiError(w, http.StatusBadRequest, "the body is not an image")
+		return
+	}
+	defer img.Close()
+
+	detector, err := inferenceDetector()
+	if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+	detectedObjects := detector.Detect(img, settings)
+
+	if r.URL.Query().Get("annotate") == "true" {
+		detect.Annotate(&img, detectedObjects)
+		buf, err := gocv.IMEncode(gocv.JPEGFileExt, img)
+		if err != nil {
+			apiFailure(w, r, err)
+			return
+		}
+		defer buf.Close()
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(buf.GetBytes())
+		return
+	}
+
+	result := inferenceResult{Width: img.Cols(), Height: img.Rows(), Detections: []inferenceDetection{}}
+	for _, obj := range detectedObjects {
+		result.Detections = append(result.Detections, inferenceDetection{objectClass(obj), obj.Confidence, obj.Top, obj.Left, obj.Width, obj.Height})
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// inferenceDetector returns the shared network of the inference apis
+func inferenceDetector() (*detect.Detector, error) {
+	detectorOnce.Do(func() {
+		detector, detectorErr = detect.NewDetector(model, config, backend, target, classes)
+	})
+	return detector, detectorErr
+}
+
+// objectClass returns the class label of the object
+func objectClass(obj detect.Object) string {
+	class, _, _ := strings.Cut(obj.Label, " - ")
+	return class
+}
+
+// inferenceSettings returns the command line defaults with the overrides
+// of the query
+func inferenceSettings(r *http.Request) (detect.Settings, error) {
+	overrides := store.StreamSettings{}
+	query := r.URL.Query()
+	if value := query.Get("confidence"); value != "" {
+		confidence, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return detect.Settings{}, err
+		}
+		overrides.Confidence = &confidence
+	}
+	if value := query.Get("iou"); value != "" {
+		iou, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return detect.Settings{}, err
+		}
+		overrides.IOU = &iou
+	}
+	if value := query.Get("classes"); value != "" {
+		overrides.Classes = strings.Split(value, ",")
+	}
+	return overrideSettings(overrides)
+}
+
+// overrideSettings returns the command line defaults with the overrides
+func overrideSettings(overrides store.StreamSettings) (detect.Settings, error) {
+	settings := detect.Settings{Confidence: confidenceTreshold, Intersection: intersectionTreshold, Classes: overrides.Classes, Enabled: true}
+	if overrides.Confidence != nil {
+		settings.Confidence = float32(*overrides.Confidence)
+	}
+	if overrides.IOU != nil {
+		settings.Intersection = *overrides.IOU
+	}
+	return settings, overrides.Validate()
+}
+
+// readUpload returns the image of the body or of the multipart form
+func readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return io.ReadAll(r.Body)
+	}
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// influxSink writes detection counts and stream health as points in the
+// InfluxDB line protocol (v2 write api), so the activity can be graphed
+// next to other time-series data.
+type influxSink struct {
+	endpoint string
+	token    string
+	client   *http.Client
+}
+
+// escapes spaces, commas and equal signs in tag values
+var influxTagEscaper = strings.NewReplacer(" ", `\ `, ",", `\,`, "=", `\=`)
+
+func newInfluxSink(address, org, bucket, token string) *influxSink {
+	query := url.Values{}
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "s")
+
+	return &influxSink{
+		endpoint: strings.TrimSuffix(address, "/") + "/api/v2/write?" + query.Encode(),
+		token:    token,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (i *influxSink) writeEvent(event detectionEvent) error {
+	created, err := time.Parse(time.RFC3339, event.created)
+	if err != nil {
+		return err
+	}
+
+	var confidence float32
+	for _, obj := range event.detections {
+		confidence += obj.Confidence
+	}
+	confidence /= float32(len(event.detections))
+
+	return i.write(fmt.Sprintf("detections,stream=%s,class=%s count=%di,confidence=%.2f %d",
+		influxTagEscaper.Replace(event.stream), influxTagEscaper.Replace(event.label), len(event.detections), confidence, created.Unix()))
+}
+
+func (i *influxSink) writeHealth(stream string, online bool, fps float64) error {
+	return i.write(fmt.Sprintf("stream_health,stream=%s online=%t,fps=%.2f %d",
+		influxTagEscaper.Replace(stream), online, fps, time.Now().Unix()))
+}
+
+func (i *influxSink) write(line string) error {
+	req, err := http.NewRequest(http.MethodPost, i.endpoint, strings.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		req.Header.Set("Authorization", "Token "+i.token)
+	}
+	return notify.DoRequest(i.client, req)
+}
+
+func (i *influxSink) Close() error {
+	i.client.CloseIdleConnections()
+	return nil
+}
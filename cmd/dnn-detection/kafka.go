@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaSink produces the events to a Kafka topic, and optionally every
+// detection to a second topic. The messages are keyed by the stream so
+// the events of a stream stay in order within a partition.
+type kafkaSink struct {
+	writer *kafka.Writer
+	topic  string
+	// empty when the raw detections are not produced
+	detectionsTopic string
+	// format of the messages, json or cloudevents
+	format string
+}
+
+// kafkaDetection is the message of a single detection
+type kafkaDetection struct {
+	Event   int    `json:"event_id"`
+	Stream  string `json:"stream"`
+	Class   string `json:"class"`
+	Created string `json:"created"`
+	detectionPayload
+}
+
+func newKafkaSink(brokers, topic, detectionsTopic, format string) *kafkaSink {
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 100 * time.Millisecond,
+			// the detection must not wait for the brokers
+			Async: true,
+			Completion: func(messages []kafka.Message, err error) {
+				if err != nil {
+					logger("sink").Error("Error writing kafka messages", "messages", len(messages), "err", err)
+				}
+			},
+		},
+		topic:           topic,
+		detectionsTopic: detectionsTopic,
+		format:          format,
+	}
+}
+
+func (k *kafkaSink) writeEvent(event detectionEvent) error {
+	value, contentType, err := encodeEvent(k.format, event)
+	if err != nil {
+		return err
+	}
+	key := []byte(event.stream)
+	headers := []kafka.Header{{Key: "content-type", Value: []byte(contentType)}}
+	messages := []kafka.Message{{Topic: k.topic, Key: key, Value: value, Headers: headers}}
+
+	if k.detectionsTopic != "" {
+		for i, detection := range newEventPayload(event).Detections {
+			var message interface{} = kafkaDetection{event.id, event.stream, event.label, event.created, detection}
+			if k.format == formatCloudEvents {
+				message = newCloudEvent(fmt.Sprintf("%d-%d", event.id, i), cloudEventDetectionBox, event, message)
+			}
+			value, err := json.Marshal(message)
+			if err != nil {
+				return err
+			}
+			messages = append(messages, kafka.Message{Topic: k.detectionsTopic, Key: key, Value: value, Headers: headers})
+		}
+	}
+
+	return k.writer.WriteMessages(context.Background(), messages...)
+}
+
+func (k *kafkaSink) Close() error {
+	// flushes the pending messages
+	return k.writer.Close()
+}
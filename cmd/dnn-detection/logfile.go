@@ -0,0 +1,191 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the suffix of the rotated log files, sorts in the order of the rotations
+const logRotationLayout = "20060102T150405.000"
+
+// openLogOutput returns the output of the logs of the path of LOG_FILE:
+// the standard output for an empty path or "stdout" (e.g. in a container,
+// where the runtime collects the output), otherwise the rotating file
+func openLogOutput(path string) (io.WriteCloser, error) {
+	switch path {
+	case "", "stdout":
+		return nopCloser{os.Stdout}, nil
+	case "stderr":
+		return nopCloser{os.Stderr}, nil
+	default:
+		return openRotatingFile(path, int64(envInt("LOG_MAX_SIZE_MB", 100))<<20, envDuration("LOG_MAX_AGE", 0), envInt("LOG_MAX_BACKUPS", 7), os.Getenv("LOG_COMPRESS") == "true")
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// rotatingFile is a log file that is moved aside as path-<time> when it
+// would grow over maxSize or has been written for longer than maxAge. The
+// moved files are gzipped with compress, and the oldest are removed so
+// that at most backups of them are kept (0 keeps all).
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxAge   time.Duration
+	backups  int
+	compress bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	// the compression and the removal of the old files
+	cleanup sync.WaitGroup
+}
+
+// openRotatingFile appends to the file at path, a zero maxSize or maxAge
+// disables that rotation
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, backups int, compress bool) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, backups: backups, compress: compress}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	// the file of the previous run is already too old
+	if info, err := f.file.Stat(); err == nil && f.size > 0 && maxAge > 0 && time.Since(info.ModTime()) > maxAge {
+		f.mu.Lock()
+		err = f.rotate()
+		f.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// open opens the file at path for appending
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	full := f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize
+	old := f.maxAge > 0 && time.Since(f.opened) > f.maxAge
+	if f.size > 0 && (full || old) {
+		if err := f.rotate(); err != nil {
+			// the logs go on to the current file, the rotation is tried
+			// again after the next maxSize or maxAge
+			fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", f.path, err)
+			f.size, f.opened = 0, time.Now()
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside and opens a new one, f.mu is held
+func (f *rotatingFile) rotate() error {
+	rotated := f.path + "-" + time.Now().Format(logRotationLayout)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	previous := f.file
+	if err := f.open(); err != nil {
+		// the writes go on to the moved file
+		return err
+	}
+	previous.Close()
+
+	f.cleanup.Add(1)
+	go func() {
+		defer f.cleanup.Done()
+		if f.compress {
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(os.Stderr, "Error compressing log file %s: %v\n", rotated, err)
+			}
+		}
+		f.removeOld()
+	}()
+	return nil
+}
+
+// removeOld removes the oldest rotated files over the backups
+func (f *rotatingFile) removeOld() {
+	if f.backups <= 0 {
+		return
+	}
+	rotated, err := filepath.Glob(f.path + "-*")
+	if err != nil || len(rotated) <= f.backups {
+		return
+	}
+	sort.Strings(rotated)
+	for _, path := range rotated[:len(rotated)-f.backups] {
+		if err := os.Remove(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error removing log file %s: %v\n", path, err)
+		}
+	}
+}
+
+// Close closes the file after the rotated files have been compressed
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	file := f.file
+	f.file = nil
+	f.mu.Unlock()
+	f.cleanup.Wait()
+	if file == nil {
+		return nil
+	}
+	return file.Close()
+}
+
+// compressFile replaces the file with a gzipped path.gz
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
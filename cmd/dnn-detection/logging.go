@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// the minimum levels of the logs, set with LOG_LEVEL or -log-level
+var logLevels moduleLevels
+
+// openLog returns the handler of the logs to LOG_FILE in the format of
+// LOG_FORMAT: text (key=value, the default) or json, and the output to
+// close at the end. LOG_FILE is syslog (at SYSLOG_ADDR), journald, the
+// standard output or a file, see openLogOutput. The records are filtered
+// with logLevels.
+func openLog() (slog.Handler, io.Closer, error) {
+	format := os.Getenv("LOG_FORMAT")
+	if format != "" && format != "text" && format != "json" {
+		return nil, nil, fmt.Errorf("unknown LOG_FORMAT %q", format)
+	}
+	var handler slog.Handler
+	var out io.Closer
+	switch target := os.Getenv("LOG_FILE"); target {
+	case "syslog":
+		s, err := dialSyslog(os.Getenv("SYSLOG_ADDR"), os.Getenv("SYSLOG_FACILITY"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("syslog: %v", err)
+		}
+		handler, out = newSenderHandler(format, s.send), s
+	case "journald":
+		j, err := dialJournald()
+		if err != nil {
+			return nil, nil, fmt.Errorf("journald: %v", err)
+		}
+		handler, out = newSenderHandler(format, j.send), j
+	default:
+		w, err := openLogOutput(target)
+		if err != nil {
+			return nil, nil, err
+		}
+		handler, out = formatHandler(w, format, nil), w
+	}
+	return &levelHandler{Handler: handler, levels: &logLevels}, out, nil
+}
+
+// formatHandler returns the text or json handler of w, replace changes
+// the attributes as in slog.HandlerOptions
+func formatHandler(w io.Writer, format string, replace func(groups []string, a slog.Attr) slog.Attr) slog.Handler {
+	// the levels are checked by the levelHandler
+	opts := &slog.HandlerOptions{Level: slog.Level(math.MinInt), ReplaceAttr: replace}
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// moduleLevels are the minimum levels of the modules, "" for the
+// logs without a module or with a module that has no level of its own
+type moduleLevels struct {
+	levels atomic.Pointer[map[string]slog.Level]
+}
+
+// set parses the comma separated levels, a level without a module is the
+// default, e.g. "warn,capture=debug,store=info"
+func (l *moduleLevels) set(spec string) error {
+	levels := map[string]slog.Level{"": slog.LevelInfo}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		module, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			module, name = "", entry
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return fmt.Errorf("invalid log level %q: %v", entry, err)
+		}
+		levels[strings.TrimSpace(module)] = level
+	}
+	l.levels.Store(&levels)
+	return nil
+}
+
+// of returns the minimum level of the module
+func (l *moduleLevels) of(module string) slog.Level {
+	levels := l.levels.Load()
+	if levels == nil {
+		return slog.LevelInfo
+	}
+	if level, ok := (*levels)[module]; ok {
+		return level
+	}
+	return (*levels)[""]
+}
+
+// levelHandler drops the records below the level of the module of the
+// logger, the "module" attribute added with With (see logger)
+type levelHandler struct {
+	slog.Handler
+	levels *moduleLevels
+	module string
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.levels.of(h.module)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	for _, a := range attrs {
+		if a.Key == "module" {
+			c.module = a.Value.String()
+		}
+	}
+	c.Handler = h.Handler.WithAttrs(attrs)
+	return &c
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.Handler = h.Handler.WithGroup(name)
+	return &c
+}
+
+// logger returns the logger of the module, e.g. "api" or "worker"
+func logger(module string) *slog.Logger {
+	return slog.Default().With("module", module)
+}
+
+// streamLogger returns the logger of the pipeline of the device, with the
+// id of the stream for the devices in the database
+func streamLogger(deviceID string, stream store.Stream) *slog.Logger {
+	l := slog.Default().With("device", deviceID)
+	if stream.ID != 0 {
+		l = l.With("stream_id", stream.ID)
+	}
+	return l
+}
+
+// eventLogger returns the logger of the sinks of the event, with the
+// fields of the stream and the id of the event
+func eventLogger(e detectionEvent) *slog.Logger {
+	return streamLogger(e.stream, e.info).With("module", "sink", "event_id", e.id)
+}
@@ -0,0 +1,512 @@
+// What it does:
+//
+// This program uses a deep neural network to perform object detection.
+// Detected objects will be saved to a database and subscribed users
+// will be notified with an email.
+//
+// If environment variable RUN_ENV is not 'prod' then the detected objects
+// are not saved to a database but shown in a window for testing purposes.
+//
+//
+// How to run:
+//
+// 		go run ./cmd/dnn-detection [video/stream source] [modelfile] [configfile] ([backend] [confidence])
+//
+// Replace video source with '--' and the source(s) will be read from database
+//
+// It's possible to set multiple sources seperated with comma. The streams will
+// be processed in seperate go routines.
+//
+// Supported  sources:
+//   - images (*.png, *.jpg)
+//   - webcam (0)
+//   - video (*.mp4)
+//   - rtsp stream
+//
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+	"gocv.io/x/gocv"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/sources"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+var model string
+var config string
+var backend gocv.NetBackendType
+var target = gocv.NetTargetCPU
+
+// coco.names
+var classes []string
+
+// global database connection pool for ease of development
+var db *store.Database
+
+// saves the detection events in the background
+var writer *store.Writer
+
+// the threshold where the recognitions will be taken into consideration
+// use high enough value (e.g. over 0.95) in order to avoid false positives
+// (default for the streams that have no own settings in database)
+var confidenceTreshold float32
+
+// this value controls overlapping bounding boxes
+// default value 0.7 seems to recognize two overlapping objects
+// but dont draw duplicate bounding box from the same object
+var intersectionTreshold = 0.7
+
+// minimum time between two analyzed frames
+var sampleInterval time.Duration
+
+// analyzed frames per second at most per stream, 0 for no limit
+var maxFPS float64
+
+// frames with detections that may wait for the database and the sinks
+var sinkQueue int
+
+// how many frames may be skipped when the detection falls behind
+var maxFrameSkip int
+
+// frames wider than this are downscaled before the detection, 0 for no limit
+var maxWidth int
+
+// how often the position of a video file is saved, 0 for never
+var checkpointInterval time.Duration
+
+// cpus of the forward passes of the pipelines in turn, empty for any
+var cpuSets [][]int
+
+// the output of the logs, see openLog
+var logfile io.Closer
+
+func init() {
+	// the tests of the package run without the environment and the database
+	if testing.Testing() {
+		return
+	}
+	// get environment variables
+	err := godotenv.Load(".env")
+	if err != nil {
+		log.Fatalf("Error loading environment variables file")
+	}
+
+	// setup logging
+	var handler slog.Handler
+	handler, logfile, err = openLog()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the errors of the logs and the panics to Sentry
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		if reporter, err = newErrorReporter(dsn); err != nil {
+			log.Fatal(err)
+		}
+		handler = reporter.handler(handler)
+	}
+	// also the output of the log package
+	slog.SetDefault(slog.New(handler))
+	if err := logLevels.set(os.Getenv("LOG_LEVEL")); err != nil {
+		log.Fatal(err)
+	}
+
+	// init database connection
+	psqlconn := fmt.Sprintf("host=%s port=%d user=%s "+
+		"password=%s dbname=%s sslmode=disable",
+		os.Getenv("DB_HOST"), 5432, os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"))
+
+	poolConfig := store.PoolConfig{
+		MaxOpenConns:        envInt("DB_MAX_OPEN_CONNS", 0),
+		MaxIdleConns:        envInt("DB_MAX_IDLE_CONNS", 0),
+		ConnMaxLifetime:     envDuration("DB_CONN_MAX_LIFETIME", 0),
+		ConnMaxIdleTime:     envDuration("DB_CONN_MAX_IDLE_TIME", 0),
+		HealthCheckInterval: envDuration("DB_HEALTH_CHECK_INTERVAL", 30*time.Second),
+	}
+	db, err = store.NewDatabaseConnection(psqlconn, poolConfig)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// reporting queries can be directed to a read replica with the same credentials
+	if replicaHost := os.Getenv("DB_READ_HOST"); replicaHost != "" {
+		replicaconn := fmt.Sprintf("host=%s port=%d user=%s "+
+			"password=%s dbname=%s sslmode=disable",
+			replicaHost, 5432, os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"))
+		if err := db.ConnectReplica(replicaconn, poolConfig); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	notify.SnapshotDir = os.Getenv("SNAPSHOT_DIR")
+	notify.SnapshotURL = os.Getenv("SNAPSHOT_URL")
+	hlsDir = os.Getenv("HLS_DIR")
+	sinkQueue = envInt("PIPELINE_SINK_QUEUE", detect.DefaultSinkQueue)
+	maxFrameSkip = envInt("PIPELINE_MAX_FRAME_SKIP", 10)
+	maxWidth = envInt("PIPELINE_MAX_WIDTH", 0)
+	checkpointInterval = envDuration("VIDEO_CHECKPOINT_INTERVAL", detect.DefaultCheckpointInterval)
+	detect.LogLatency = os.Getenv("LOG_LATENCY") == "true"
+	detect.LimitInference(envInt("INFERENCE_CONCURRENCY", 0))
+	detect.LimitModelLoading(envInt("MODEL_LOAD_CONCURRENCY", 2))
+	detect.LimitMemory(int64(envInt("MEMORY_LIMIT_MB", 0))<<20, 5*time.Second)
+	// the default of opencv is a thread for every cpu in every forward pass
+	if threads := envInt("OPENCV_THREADS", 0); threads > 0 {
+		gocv.SetNumThreads(threads)
+	}
+	if cpuSets, err = parseCPUSets(os.Getenv("CPU_AFFINITY")); err != nil {
+		log.Fatal(err)
+	}
+	if restarts, err = newRestartPolicy(); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Threads", "gomaxprocs", runtime.GOMAXPROCS(0), "opencv", gocv.GetNumThreads())
+	if n, err := detect.ConfigureGPUs(os.Getenv("GPU_PLACEMENT")); err != nil {
+		log.Fatal(err)
+	} else if n > 0 {
+		slog.Info("CUDA devices", "devices", n)
+	}
+
+	// optional sinks for the detection events
+	initSinks()
+	// the hosts of the streams added by the organizations
+	for _, host := range strings.Split(os.Getenv("STREAM_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			store.StreamHosts = append(store.StreamHosts, host)
+		}
+	}
+	// commands the streams can run on their events
+	hooks = newHookRunner(os.Getenv("HOOKS"), envDuration("HOOK_TIMEOUT", 10*time.Second), envInt("HOOK_CONCURRENCY", 4))
+	if err := notify.Init(); err != nil {
+		log.Fatal(err)
+	}
+	// annotated rtsp restreams of the previews served by the http server
+	if api := os.Getenv("GO2RTC_URL"); api != "" {
+		previewURL := os.Getenv("RESTREAM_PREVIEW_URL")
+		if previewURL == "" {
+			previewURL = localURL(os.Getenv("HTTP_ADDR"))
+		}
+		if previewURL == "" {
+			slog.Warn("Restream disabled: HTTP_ADDR is not set")
+		} else {
+			restream = newGo2rtcClient(api, previewURL, os.Getenv("RESTREAM_API_KEY"), os.Getenv("GO2RTC_SOURCE"))
+		}
+	}
+	initOIDC()
+	// the detections would be stored nowhere without the clickhouse sink
+	if os.Getenv("DETECTION_SINK") == "clickhouse" {
+		if os.Getenv("CLICKHOUSE_URL") == "" {
+			log.Fatal("DETECTION_SINK=clickhouse needs CLICKHOUSE_URL")
+		}
+		db.SetStoreDetections(false)
+	}
+}
+
+func init() {
+	if testing.Testing() {
+		return
+	}
+	// initialize detectable classes to a variable
+	var err error
+	if classes, err = detect.ReadClasses("./models/coco.names.default"); err != nil {
+		log.Fatal(err)
+	}
+	store.Classes = classes
+}
+
+func main() {
+
+	defer db.Close()
+	defer logfile.Close()
+	defer closeSinks()
+	defer reporter.flush(5 * time.Second)
+	defer reporter.panicked(nil)
+
+	// read command line arguments
+	flag.StringVar(&model, "m", "models/default/yolov4.weights", "Object detection model")
+	flag.StringVar(&config, "c", "models/default/yolov4-custom.cfg", "Object detection model configurations")
+	confidence := flag.Int("confidence", 75, "How certain the model must be of detected objects in order to notice them")
+	selectedBackend := flag.String("backend", "opencv", "Detection nets backend (opencv/openvino)")
+	targetString := flag.String("target", "cpu", "Will the model be run on CPU or GPU (check gocv.ParseNetTarget for possible targets")
+	deviceIds := flag.String("d", "--", "List of devices seperated by comma")
+	flag.Float64Var(&intersectionTreshold, "iou", 0.7, "Overlap of bounding boxes after which they are considered the same object")
+	flag.DurationVar(&sampleInterval, "interval", 0, "Minimum time between analyzed frames (e.g. 500ms)")
+	flag.Float64Var(&maxFPS, "max-fps", 0, "Analyzed frames per second at most per stream (e.g. 2), 0 for no limit")
+	org := flag.Int("org", 0, "Only read the streams of this organization from database (0 = all)")
+	purgeObserver := flag.String("purge-observer", "", "Remove the observer with this email and all their data, then exit")
+	deadLetters := flag.Bool("dead-letters", false, "List the notifications that could not be delivered, then exit")
+	requeue := flag.Int("requeue", 0, "Move the dead letter with this id back to the outbox, then exit")
+	notifyTest := flag.String("notify-test", "", "Send a test notification to a subscription id or channel:recipient (e.g. email:me@example.com), then exit")
+	createAPIKey := flag.String("create-api-key", "", "Create an api key with this name for the -org, print it, then exit")
+	apiKeyScope := flag.String("api-key-scope", store.ScopeRead, "Scope of the created api key (read/write)")
+	apiKeys := flag.Bool("api-keys", false, "List the api keys of the -org, then exit")
+	revokeAPIKey := flag.Int("revoke-api-key", 0, "Revoke the api key with this id, then exit")
+	addUser := flag.String("add-user", "", "Let the OIDC user with this email sign in to the -org, then exit")
+	userScope := flag.String("user-scope", store.ScopeRead, "Scope of the added user (read/write)")
+	removeUser := flag.String("remove-user", "", "Remove the OIDC user with this email from the -org, then exit")
+	workerMode := flag.Bool("worker", false, "Share the streams of the database with the other -worker instances through leases")
+	benchmark := flag.String("benchmark", "", "Run the model on this video and print the speed as json, then exit")
+	benchmarkFrames := flag.Int("benchmark-frames", 200, "Frames analyzed with -benchmark at most")
+	shardFlag := flag.String("shard", "", "Only process the part i/n of the streams of the database (e.g. 0/3), the same n on every instance")
+	logLevel := flag.String("log-level", os.Getenv("LOG_LEVEL"), "Minimum level of the logs (debug/info/warn/error) with the levels of the modules, e.g. warn,capture=debug")
+
+	flag.Parse()
+
+	if err := logLevels.set(*logLevel); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	*db = db.ForOrg(*org)
+
+	if *purgeObserver != "" {
+		// printed to stdout because the log goes to the log file
+		if err := db.PurgeObserver(*purgeObserver); err != nil {
+			fmt.Printf("Error purging observer: %v\n", err)
+			return
+		}
+		fmt.Println("Observer purged")
+		return
+	}
+
+	if *deadLetters {
+		letters, err := db.DeadLetters(100)
+		if err != nil {
+			fmt.Printf("Error listing dead letters: %v\n", err)
+			return
+		}
+		for _, d := range letters {
+			fmt.Printf("%d\t%s\t%s\t%s\t%d attempts\t%s\n", d.ID, d.FailedAt.Format(time.RFC3339), d.Channel, d.Recipient, d.Attempts, d.LastError)
+		}
+		return
+	}
+
+	if *requeue != 0 {
+		if err := db.RequeueDeadLetter(*requeue); err != nil {
+			fmt.Printf("Error requeueing notification: %v\n", err)
+			return
+		}
+		fmt.Println("Notification requeued")
+		return
+	}
+
+	if *notifyTest != "" {
+		if err := db.SendTestNotification(*notifyTest); err != nil {
+			fmt.Printf("Error sending test notification: %v\n", err)
+			return
+		}
+		fmt.Println("Test notification sent")
+		return
+	}
+
+	if *createAPIKey != "" {
+		key, err := db.CreateAPIKey(*createAPIKey, *apiKeyScope)
+		if err != nil {
+			fmt.Printf("Error creating api key: %v\n", err)
+			return
+		}
+		// the key is not stored, so this is the only chance to see it
+		fmt.Println(key)
+		return
+	}
+
+	if *apiKeys {
+		keys, err := db.ListAPIKeys()
+		if err != nil {
+			fmt.Printf("Error listing api keys: %v\n", err)
+			return
+		}
+		for _, k := range keys {
+			state := "active"
+			if k.Revoked != nil {
+				state = "revoked " + k.Revoked.Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%s\torg %d\t%s\t%s\t%s\n", k.ID, k.Name, k.Org, k.Scope, k.Created.Format(time.RFC3339), state)
+		}
+		return
+	}
+
+	if *revokeAPIKey != 0 {
+		if err := db.RevokeAPIKey(*revokeAPIKey); err != nil {
+			fmt.Printf("Error revoking api key: %v\n", err)
+			return
+		}
+		fmt.Println("API key revoked")
+		return
+	}
+
+	if *addUser != "" {
+		if err := db.AddDashboardUser(*addUser, *userScope); err != nil {
+			fmt.Printf("Error adding user: %v\n", err)
+			return
+		}
+		fmt.Println("User added")
+		return
+	}
+
+	if *removeUser != "" {
+		if err := db.RemoveDashboardUser(*removeUser); err != nil {
+			fmt.Printf("Error removing user: %v\n", err)
+			return
+		}
+		fmt.Println("User removed")
+		return
+	}
+
+	if *confidence <= 100 && *confidence > 0 {
+		confidenceTreshold = float32(*confidence) / 100
+	} else {
+		fmt.Println("Confidence set to default (0.75) because provided input is too big or too low (use something between 0..100)")
+		confidenceTreshold = 0.75
+	}
+
+	// serialize command line arguments
+	backend = gocv.ParseNetBackend(*selectedBackend)
+	if backend == gocv.NetBackendOpenVINO {
+		// vpu available on 13th gen intel cpus
+		target = gocv.NetTargetVPU
+		target = gocv.NetTargetCPU
+	}
+
+	target = gocv.ParseNetTarget(*targetString)
+
+	if *benchmark != "" {
+		if err := runBenchmark(*benchmark, *benchmarkFrames, *selectedBackend, *targetString); err != nil {
+			fmt.Printf("Error running benchmark: %v\n", err)
+		}
+		return
+	}
+
+	if (*workerMode || *shardFlag != "") && *deviceIds != "--" {
+		fmt.Println("-worker and -shard read the streams from database, leave out -d")
+		return
+	}
+	if *workerMode && *shardFlag != "" {
+		fmt.Println("Use either -worker or -shard")
+		return
+	}
+
+	var deviceIdList []string
+	if *workerMode {
+		worker = newLeaseWorker()
+	} else if *shardFlag != "" {
+		s, err := parseShard(*shardFlag)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		addresses, err := db.StreamAddresses()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		deviceIdList = s.streams(addresses)
+	} else if *deviceIds == "--" {
+		addresses, err := db.StreamAddresses()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		deviceIdList = addresses
+	} else {
+		deviceIdList = strings.Split(*deviceIds, ",")
+	}
+
+	// the captures stop on a signal, and the events already captured are
+	// saved and published before the process exits
+	ctx, queries := shutdownContext(envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	*db = db.WithContext(queries)
+
+	// closed after the writer, which queues the saved events to it
+	publisher = newEventPublisher(envInt("PUBLISH_QUEUE", 256))
+	defer publisher.close()
+
+	var err error
+	writer, err = db.NewWriter(envInt("DB_WRITE_QUEUE", 256), envInt("DB_WRITE_BATCH", 32), envDuration("DB_WRITE_INTERVAL", 200*time.Millisecond), os.Getenv("DB_WRITE_OVERFLOW"))
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	// the queued events are saved before the database is closed
+	defer writer.Close()
+
+	slog.Info("Run main")
+	logConfigurations(map[string]string{"devices": *deviceIds, "shard": *shardFlag, "model": model, "config": config, "backend": *selectedBackend, "confidence": strconv.Itoa(*confidence)})
+	defer slog.Info("End run")
+
+	if audit := newResourceAudit(); audit != nil {
+		go audit.run(ctx)
+	}
+	if heartbeats = newHeartbeat(); heartbeats != nil {
+		go heartbeats.run(ctx)
+	}
+
+	if addr := os.Getenv("HTTP_ADDR"); addr != "" {
+		go serveHTTP(ctx, addr)
+	}
+	if addr := os.Getenv("GRPC_ADDR"); addr != "" {
+		go serveGRPC(ctx, addr)
+	}
+
+	// the workers elect the one that runs the jobs
+	if worker != nil {
+		worker.run(ctx)
+		return
+	}
+	startBackgroundJobs(ctx)
+
+	// its possible to read from multiple streams with this same program
+	var streams streamGroup
+	for i, deviceID := range deviceIdList {
+		if sources.DeviceType(deviceID) < 0 {
+			slog.Error("Unrecognized device", "device", deviceID)
+			continue
+		}
+
+		streams.goPipeline(ctx, deviceID, i, nil)
+	}
+	if err := streams.wait(ctx); err != nil {
+		slog.Error("Streams failed", "err", err)
+	}
+}
+
+// startBackgroundJobs starts the outbox delivery, the statistics, the
+// digests and the offline alerts, they stop when ctx is done
+func startBackgroundJobs(ctx context.Context) {
+	if os.Getenv("RUN_ENV") == "prod" {
+		go db.DispatchNotifications(ctx, 5*time.Second, envInt("NOTIFY_WORKERS", 4))
+		go db.RefreshStatisticsEvery(ctx, 5*time.Minute)
+		go db.SendDigests(ctx, 15*time.Minute)
+		if alerts := offlineAlerts(); alerts.Threshold > 0 {
+			go db.AlertOfflineStreams(ctx, time.Minute, alerts)
+		}
+	}
+}
+
+// offlineAlerts returns the alerts of the offline streams of the
+// OFFLINE_* environment
+func offlineAlerts() store.OfflineAlerts {
+	alerts := store.OfflineAlerts{
+		Threshold: envDuration("OFFLINE_THRESHOLD", 10*time.Minute),
+		Observers: os.Getenv("OFFLINE_ALERT_OBSERVERS") != "false",
+	}
+	alerts.Channel, alerts.Recipient, _ = strings.Cut(os.Getenv("OFFLINE_ALERT"), ":")
+	return alerts
+}
@@ -0,0 +1,12 @@
+//go:build matprofile
+
+package main
+
+import "gocv.io/x/gocv"
+
+// openMats returns the number of Mats that have not been closed, only
+// when built with -tags matprofile
+func openMats() *int {
+	n := gocv.MatProfile.Count()
+	return &n
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+)
+
+// mqttSink publishes the events and the stream state to an MQTT broker:
+//
+//	<prefix>/available                 online/offline of the detector (retained)
+//	<prefix>/<stream>/status           online/offline of the stream (retained)
+//	<prefix>/<stream>/fps              processed frames per second (retained)
+//	<prefix>/<stream>/event            json payload of every event
+//	<prefix>/<stream>/error            json of a panic of the pipeline
+//	<prefix>/<stream>/<class>/detected ON when the class is detected
+//	<prefix>/<stream>/<class>/count    count of the latest event (retained)
+//
+// With Home Assistant discovery enabled the configs of the entities are
+// published under the discovery prefix when a stream or class is first
+// seen, so every stream shows up as a device in Home Assistant.
+//
+// Alternatively the topics can follow the layout of Frigate, see frigate.go.
+type mqttSink struct {
+	client mqtt.Client
+	prefix string
+	layout string
+	// home assistant discovery prefix, empty when the discovery is disabled
+	discovery string
+
+	mu        sync.Mutex
+	announced map[string]bool
+	// names of the streams by the address
+	names map[string]string
+}
+
+// how long the detected binary sensor stays on in home assistant
+const mqttDetectedTimeout = 60
+
+var mqttSlugPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// mqttSlug turns a stream address into something usable as a topic level
+// and a home assistant object id
+func mqttSlug(s string) string {
+	return mqttSlugPattern.ReplaceAllString(s, "_")
+}
+
+func newMQTTSink(broker, user, password, prefix, layout, discovery string) (*mqttSink, error) {
+	if layout == mqttLayoutFrigate {
+		// frigate has its own home assistant integration
+		discovery = ""
+	}
+	m := &mqttSink{prefix: prefix, layout: layout, discovery: discovery, announced: map[string]bool{}, names: map[string]string{}}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("gocv-stream-events-"+mqttSlug(prefix)).
+		SetUsername(user).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetWill(prefix+"/available", "offline", 1, true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			client.Publish(prefix+"/available", 1, true, "online")
+			// the broker may have lost the retained configs
+			m.mu.Lock()
+			m.announced = map[string]bool{}
+			m.mu.Unlock()
+		})
+
+	m.client = mqtt.NewClient(opts)
+	token := m.client.Connect()
+	if !token.WaitTimeout(10 * time.Second) {
+		return nil, fmt.Errorf("mqtt: connection to %s timed out", broker)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	return m, nil
+}
+
+func (m *mqttSink) publish(topic string, retained bool, payload interface{}) error {
+	token := m.client.Publish(topic, 1, retained, payload)
+	if !token.WaitTimeout(5 * time.Second) {
+		return fmt.Errorf("mqtt: publish to %s timed out", topic)
+	}
+	return token.Error()
+}
+
+func (m *mqttSink) writeEvent(event detectionEvent) error {
+	if m.layout == mqttLayoutFrigate {
+		return m.writeFrigateEvent(event)
+	}
+
+	topic := m.prefix + "/" + mqttSlug(event.stream)
+	class := mqttSlug(event.label)
+	m.announceClass(event.stream, event.info.Name, event.label)
+
+	payload, err := json.Marshal(newEventPayload(event))
+	if err != nil {
+		return err
+	}
+	if err := m.publish(topic+"/event", false, payload); err != nil {
+		return err
+	}
+	if err := m.publish(topic+"/"+class+"/count", true, fmt.Sprint(len(event.detections))); err != nil {
+		return err
+	}
+	return m.publish(topic+"/"+class+"/detected", false, "ON")
+}
+
+func (m *mqttSink) writeHealth(stream string, online bool, fps float64) error {
+	if m.layout == mqttLayoutFrigate {
+		return m.writeFrigateHealth(stream, online)
+	}
+
+	topic := m.prefix + "/" + mqttSlug(stream)
+	m.announceStream(stream, m.streamName(stream))
+
+	status := "offline"
+	if online {
+		status = "online"
+	}
+	if err := m.publish(topic+"/status", true, status); err != nil {
+		return err
+	}
+	return m.publish(topic+"/fps", true, fmt.Sprintf("%.2f", fps))
+}
+
+// writePipelineError publishes the panic as a json message to the error
+// topic of the stream, frigate has no such topic
+func (m *mqttSink) writePipelineError(stream string, err *detect.PanicError) error {
+	if m.layout == mqttLayoutFrigate {
+		return nil
+	}
+	payload, jsonErr := json.Marshal(map[string]string{
+		"stage": err.Stage,
+		"error": err.Error(),
+		"time":  err.Time.Format(time.RFC3339),
+	})
+	if jsonErr != nil {
+		return jsonErr
+	}
+	return m.publish(m.prefix+"/"+mqttSlug(stream)+"/error", false, payload)
+}
+
+// streamName returns the name of the stream, which is cached as the
+// health is only reported with the address
+func (m *mqttSink) streamName(stream string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name, ok := m.names[stream]
+	if !ok {
+		if info, err := db.StreamByAddress(stream); err == nil {
+			name = info.Name
+			m.names[stream] = name
+		}
+	}
+	return name
+}
+
+// haDevice groups the entities of a stream into one device in home assistant
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// haConfig is the discovery config of a sensor or binary sensor
+type haConfig struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	StateTopic        string   `json:"state_topic"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	Icon              string   `json:"icon,omitempty"`
+	PayloadOn         string   `json:"payload_on,omitempty"`
+	PayloadOff        string   `json:"payload_off,omitempty"`
+	OffDelay          int      `json:"off_delay,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+func (m *mqttSink) device(stream, name string) haDevice {
+	if name == "" {
+		name = stream
+	}
+	return haDevice{
+		Identifiers:  []string{"gocv_" + mqttSlug(stream)},
+		Name:         name,
+		Manufacturer: "gocv-stream-events",
+		Model:        "Object detection",
+	}
+}
+
+// announce publishes the config once per connection, errors are only logged
+func (m *mqttSink) announce(component, objectID string, config haConfig) {
+	if m.discovery == "" {
+		return
+	}
+	key := component + "/" + objectID
+	m.mu.Lock()
+	done := m.announced[key]
+	m.announced[key] = true
+	m.mu.Unlock()
+	if done {
+		return
+	}
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		logger("sink").Error("Error publishing mqtt discovery", "err", err)
+		return
+	}
+	if err := m.publish(m.discovery+"/"+component+"/gocv/"+objectID+"/config", true, payload); err != nil {
+		logger("sink").Error("Error publishing mqtt discovery", "err", err)
+	}
+}
+
+func (m *mqttSink) announceStream(stream, name string) {
+	slug := mqttSlug(stream)
+	topic := m.prefix + "/" + slug
+	device := m.device(stream, name)
+
+	m.announce("binary_sensor", slug+"_status", haConfig{
+		Name:              device.Name + " status",
+		UniqueID:          "gocv_" + slug + "_status",
+		StateTopic:        topic + "/status",
+		AvailabilityTopic: m.prefix + "/available",
+		DeviceClass:       "connectivity",
+		PayloadOn:         "online",
+		PayloadOff:        "offline",
+		Device:            device,
+	})
+	m.announce("sensor", slug+"_fps", haConfig{
+		Name:              device.Name + " fps",
+		UniqueID:          "gocv_" + slug + "_fps",
+		StateTopic:        topic + "/fps",
+		AvailabilityTopic: m.prefix + "/available",
+		StateClass:        "measurement",
+		Unit:              "fps",
+		Icon:              "mdi:speedometer",
+		Device:            device,
+	})
+}
+
+func (m *mqttSink) announceClass(stream, name, class string) {
+	m.announceStream(stream, name)
+
+	slug := mqttSlug(stream) + "_" + mqttSlug(class)
+	topic := m.prefix + "/" + mqttSlug(stream) + "/" + mqttSlug(class)
+	device := m.device(stream, name)
+
+	m.announce("binary_sensor", slug, haConfig{
+		Name:              device.Name + " " + class + " detected",
+		UniqueID:          "gocv_" + slug,
+		StateTopic:        topic + "/detected",
+		AvailabilityTopic: m.prefix + "/available",
+		DeviceClass:       "occupancy",
+		PayloadOn:         "ON",
+		OffDelay:          mqttDetectedTimeout,
+		Device:            device,
+	})
+	m.announce("sensor", slug+"_count", haConfig{
+		Name:              device.Name + " " + class + " count",
+		UniqueID:          "gocv_" + slug + "_count",
+		StateTopic:        topic + "/count",
+		AvailabilityTopic: m.prefix + "/available",
+		StateClass:        "measurement",
+		Icon:              "mdi:counter",
+		Device:            device,
+	})
+}
+
+func (m *mqttSink) Close() error {
+	m.publish(m.prefix+"/available", true, "offline")
+	m.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsSink publishes the json payload of every event to a NATS subject.
+// The message id header lets a JetStream stream on the subject drop the
+// duplicates of a replayed event.
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+	// format of the messages, json or cloudevents
+	format string
+}
+
+func newNATSSink(address, subject, credentials, format string) (*natsSink, error) {
+	options := []nats.Option{nats.Name("gocv-stream-events"), nats.MaxReconnects(-1)}
+	if credentials != "" {
+		options = append(options, nats.UserCredentials(credentials))
+	}
+	conn, err := nats.Connect(address, options...)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	return &natsSink{conn: conn, subject: subject, format: format}, nil
+}
+
+func (n *natsSink) writeEvent(event detectionEvent) error {
+	payload, contentType, err := encodeEvent(n.format, event)
+	if err != nil {
+		return err
+	}
+	msg := nats.NewMsg(n.subject)
+	msg.Data = payload
+	msg.Header.Set("Content-Type", contentType)
+	msg.Header.Set(nats.MsgIdHdr, strconv.Itoa(event.id))
+	msg.Header.Set("Stream", event.stream)
+	msg.Header.Set("Class", event.label)
+	return n.conn.PublishMsg(msg)
+}
+
+func (n *natsSink) Close() error {
+	// flushes the pending messages
+	return n.conn.Drain()
+}
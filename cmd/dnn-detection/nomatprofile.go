@@ -0,0 +1,8 @@
+//go:build !matprofile
+
+package main
+
+// openMats is nil without the matprofile build tag
+func openMats() *int {
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// The dashboard and the api can be used with the accounts of an OpenID
+// Connect provider (Keycloak, Auth0, Google...) besides the api keys:
+//
+//	GET    /auth/login                   redirects to the provider
+//	GET    /auth/callback                the redirect url of the client
+//	POST   /auth/logout                  ends the session
+//	GET    /auth/me                      the signed in user, 401 without
+//
+// The users are mapped to the dashboard_user table by the subject of the
+// id token. A new user is bound to the row added with -add-user for the
+// email of the token, or created when OIDC_ORG_CLAIM names an existing
+// organization. OIDC_ROLES_CLAIM containing OIDC_WRITE_ROLE gives write
+// access. The session is a cookie signed with OIDC_SESSION_SECRET. The
+// api also accepts the id token as a bearer token.
+
+const (
+	sessionCookie = "session"
+	// state and nonce of the login in progress
+	loginCookie = "oidc_login"
+	sessionTTL  = 12 * time.Hour
+)
+
+// oidcAuth is the configured provider, nil if OIDC is not used
+type oidcAuth struct {
+	issuer    string
+	verifier  *oidc.IDTokenVerifier
+	config    oauth2.Config
+	secret    []byte
+	orgClaim  string
+	roleClaim string
+	writeRole string
+}
+
+var openID *oidcAuth
+
+// initOIDC discovers the provider of OIDC_ISSUER
+func initOIDC() {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return
+	}
+	secret := os.Getenv("OIDC_SESSION_SECRET")
+	if secret == "" {
+		logger("api").Warn("OIDC disabled: OIDC_SESSION_SECRET is not set")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		logger("api").Error("OIDC disabled", "err", err)
+		return
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	writeRole := os.Getenv("OIDC_WRITE_ROLE")
+	if writeRole == "" {
+		writeRole = "admin"
+	}
+	openID = &oidcAuth{
+		issuer:   issuer,
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		secret:    []byte(secret),
+		orgClaim:  os.Getenv("OIDC_ORG_CLAIM"),
+		roleClaim: os.Getenv("OIDC_ROLES_CLAIM"),
+		writeRole: writeRole,
+	}
+}
+
+// oidcClaims are the claims of the id token used for the mapping
+type oidcClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+	// the configured organization and role claims
+	extra map[string]interface{}
+}
+
+// claimStrings returns the claim as a list, the providers use both strings
+// and arrays
+func (c oidcClaims) claimStrings(name string) []string {
+	switch v := c.extra[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// mapUser returns the local user of the id token. The organization and
+// the scope are updated from the claims when they are configured.
+func (a *oidcAuth) mapUser(token *oidc.IDToken) (store.DashboardUser, error) {
+	var claims oidcClaims
+	if err := token.Claims(&claims); err != nil {
+		return store.DashboardUser{}, err
+	}
+	if err := token.Claims(&claims.extra); err != nil {
+		return store.DashboardUser{}, err
+	}
+
+	login := store.DashboardLogin{
+		Issuer:        a.issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}
+	if a.orgClaim != "" {
+		names := claims.claimStrings(a.orgClaim)
+		if len(names) == 0 {
+			return store.DashboardUser{}, store.ErrUnknownUser
+		}
+		login.Organization = &names[0]
+	}
+	if a.roleClaim != "" {
+		login.Scope = store.ScopeRead
+		for _, role := range claims.claimStrings(a.roleClaim) {
+			if role == a.writeRole {
+				login.Scope = store.ScopeWrite
+			}
+		}
+	}
+	return db.SignIn(login)
+}
+
+func (a *oidcAuth) sign(value string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(value))
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verify returns the signed value
+func (a *oidcAuth) verify(signed string) (string, bool) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", false
+	}
+	value := signed[:i]
+	return value, hmac.Equal([]byte(signed), []byte(a.sign(value)))
+}
+
+func (a *oidcAuth) setCookie(w http.ResponseWriter, r *http.Request, name, value string, ttl time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		// the changes of the api are not sent from other sites
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// sessionUser returns the user of the session cookie. The user is read
+// from the database, so removed users are signed out.
+func (a *oidcAuth) sessionUser(r *http.Request) (store.DashboardUser, error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return store.DashboardUser{}, sql.ErrNoRows
+	}
+	value, ok := a.verify(cookie.Value)
+	if !ok {
+		return store.DashboardUser{}, sql.ErrNoRows
+	}
+	id, expires, _ := strings.Cut(value, ":")
+	userID, err := strconv.Atoi(id)
+	expiry, err2 := strconv.ParseInt(expires, 10, 64)
+	if err != nil || err2 != nil || time.Now().Unix() > expiry {
+		return store.DashboardUser{}, sql.ErrNoRows
+	}
+	return db.DashboardUserByID(userID)
+}
+
+// bearerUser returns the user of an id token given as a bearer token
+func (a *oidcAuth) bearerUser(ctx context.Context, raw string) (store.DashboardUser, error) {
+	token, err := a.verifier.Verify(ctx, raw)
+	if err != nil {
+		return store.DashboardUser{}, sql.ErrNoRows
+	}
+	var claims oidcClaims
+	if err := token.Claims(&claims); err != nil {
+		return store.DashboardUser{}, sql.ErrNoRows
+	}
+	return db.DashboardUserBySubject(a.issuer, claims.Subject)
+}
+
+// databaseForUser returns the database scoped to the organization of the
+// user, like Database.ForAPIKey
+func databaseForUser(user store.DashboardUser, err error, write bool) (store.Database, error) {
+	if err != nil {
+		return store.Database{}, err
+	}
+	if write && user.Scope != store.ScopeWrite {
+		return store.Database{}, store.ErrReadOnlyKey
+	}
+	return db.ForOrg(user.Org), nil
+}
+
+// databaseForRequest returns the database of the api key, the bearer id
+// token or the session of the request
+func databaseForRequest(r *http.Request, key string, write bool) (store.Database, error) {
+	if key != "" || openID == nil {
+		return db.ForAPIKey(key, write)
+	}
+	if raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); raw != r.Header.Get("Authorization") {
+		user, err := openID.bearerUser(r.Context(), raw)
+		return databaseForUser(user, err, write)
+	}
+	user, err := openID.sessionUser(r)
+	return databaseForUser(user, err, write)
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func authHandler(w http.ResponseWriter, r *http.Request) {
+	if openID == nil {
+		apiError(w, http.StatusNotFound, "sign in is not enabled")
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/auth/") {
+	case "login":
+		openID.login(w, r)
+	case "callback":
+		openID.callback(w, r)
+	case "logout":
+		if r.Method != http.MethodPost {
+			apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		openID.setCookie(w, r, sessionCookie, "", -time.Second)
+		w.WriteHeader(http.StatusNoContent)
+	case "me":
+		user, err := openID.sessionUser(r)
+		if err == sql.ErrNoRows {
+			apiError(w, http.StatusUnauthorized, "not signed in")
+			return
+		}
+		respond(w, r, http.StatusOK, user, err)
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (a *oidcAuth) login(w http.ResponseWriter, r *http.Request) {
+	state, err := randomState()
+	if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+	nonce, err := randomState()
+	if err != nil {
+		apiFailure(w, r, err)
+		return
+	}
+	a.setCookie(w, r, loginCookie, a.sign(state+":"+nonce), 10*time.Minute)
+	http.Redirect(w, r, a.config.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+func (a *oidcAuth) callback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil {
+		http.Error(w, "sign in expired, try again", http.StatusBadRequest)
+		return
+	}
+	value, ok := a.verify(cookie.Value)
+	state, nonce, _ := strings.Cut(value, ":")
+	if !ok || r.URL.Query().Get("state") != state {
+		http.Error(w, "invalid sign in state", http.StatusBadRequest)
+		return
+	}
+	a.setCookie(w, r, loginCookie, "", -time.Second)
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, "sign in failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	token, err := a.config.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		logger("api").Error("Error exchanging oidc code", "err", err)
+		http.Error(w, "sign in failed", http.StatusUnauthorized)
+		return
+	}
+	raw, _ := token.Extra("id_token").(string)
+	idToken, err := a.verifier.Verify(r.Context(), raw)
+	if err != nil || idToken.Nonce != nonce {
+		logger("api").Error("Invalid oidc id token", "err", err)
+		http.Error(w, "sign in failed", http.StatusUnauthorized)
+		return
+	}
+	user, err := a.mapUser(idToken)
+	if err == store.ErrUnknownUser {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logger("api").Error("Error reading oidc user", "err", err)
+		http.Error(w, "sign in failed", http.StatusInternalServerError)
+		return
+	}
+	logger("api").Info("User signed in", "user", user.ID)
+	a.setCookie(w, r, sessionCookie, a.sign(fmt.Sprintf("%d:%d", user.ID, time.Now().Add(sessionTTL).Unix())), sessionTTL)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"image"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// runPipeline runs the detection of the device until the device is closed,
+// the stream is disabled or ctx is done, and returns why it ended (see
+// detect.Pipeline.Run)
+func runPipeline(ctx context.Context, deviceID string, captureId int) error {
+	// stream metadata (e.g. timezone) for the devices read from the database
+	stream, err := db.StreamByAddress(deviceID)
+	if err != nil && err != sql.ErrNoRows {
+		logger("pipeline").Error("Error reading stream from database", "device", deviceID, "err", err)
+	}
+	// the modules are added by the pipeline
+	streamLog := streamLogger(deviceID, stream)
+
+	// the gpu is chosen when the pipeline starts
+	var gpu *int
+	if c, err := db.DetectionConfig(deviceID); err == nil {
+		gpu = c.GPU
+	}
+
+	handler := &streamHandler{device: deviceID, captureId: captureId, stream: stream, logger: streamLog.With("module", "pipeline")}
+	defer handler.close()
+	pipeline := detect.Pipeline{
+		Device:   deviceID,
+		ID:       captureId,
+		Model:    model,
+		Config:   config,
+		Backend:  backend,
+		Target:   target,
+		Classes:  classes,
+		GPU:      gpu,
+		CPUs:     cpuSet(captureId),
+		Location: stream.Location(),
+		// save detections to database in production environment
+		Window:       os.Getenv("RUN_ENV") != "prod",
+		SinkQueue:    sinkQueue,
+		MaxFrameSkip: maxFrameSkip,
+		MaxWidth:     maxWidth,
+		Logger:       streamLog,
+		Handler:      handler,
+	}
+	if checkpointInterval > 0 {
+		pipeline.Checkpoints = db
+		pipeline.CheckpointInterval = checkpointInterval
+	}
+	return pipeline.Run(ctx)
+}
+
+// streamHandler connects the pipeline of a device to the database, the
+// sinks and the live views of the stream
+type streamHandler struct {
+	device    string
+	captureId int
+	// zero value if the device is not in the database
+	stream store.Stream
+	logger *slog.Logger
+
+	connected bool
+	stopHLS   func()
+	// hook run on the events, updated with the settings
+	mu       sync.Mutex
+	onDetect string
+}
+
+// Settings returns the settings of the stream in the database with the
+// command line defaults filled in for the values that are not set
+func (h *streamHandler) Settings() (detect.Settings, error) {
+	settings := detect.Settings{
+		Confidence:   confidenceTreshold,
+		Intersection: intersectionTreshold,
+		Interval:     sampleInterval,
+		MaxFPS:       maxFPS,
+		Enabled:      true,
+	}
+	c, err := db.DetectionConfig(h.device)
+	if err != nil {
+		return settings, err
+	}
+
+	settings.Enabled, settings.Paused = c.Enabled, c.Paused
+	// the stream has been given to another worker
+	if worker != nil && !worker.holds(h.device) {
+		settings.Enabled = false
+	}
+	if c.Confidence != nil {
+		settings.Confidence = float32(*c.Confidence)
+	}
+	if c.IOU != nil {
+		settings.Intersection = *c.IOU
+	}
+	if c.SampleIntervalMs != nil {
+		settings.Interval = time.Duration(*c.SampleIntervalMs) * time.Millisecond
+	}
+	if c.MaxFPS != nil {
+		settings.MaxFPS = *c.MaxFPS
+	}
+	settings.Classes = c.Classes
+	settings.Masks = c.Masks
+	settings.Priority = c.Priority
+	if len(c.Crop) == 4 {
+		settings.Crop = image.Rect(int(c.Crop[0]), int(c.Crop[1]), int(c.Crop[0]+c.Crop[2]), int(c.Crop[1]+c.Crop[3]))
+	}
+	h.mu.Lock()
+	h.onDetect = c.OnDetect
+	h.mu.Unlock()
+	return settings, nil
+}
+
+func (h *streamHandler) Connected() {
+	db.StreamConnected(h.device)
+	h.connected = true
+	restream.register(h.stream, h.device)
+	h.stopHLS = startHLS(h.stream, h.device)
+}
+
+func (h *streamHandler) Failed(reason string) {
+	db.StreamFailed(h.device, reason)
+}
+
+// Panicked marks the stream offline with the panic and publishes it to the
+// sinks as a pipeline error, the stream is then restarted by its
+// supervisor with the restart policy
+func (h *streamHandler) Panicked(err *detect.PanicError) {
+	db.StreamFailed(h.device, err.Error())
+	publishPipelineError(h.device, err)
+}
+
+func (h *streamHandler) Frame(img gocv.Mat, detectedObjects []detect.Object) {
+	previews.publish(h.device, h.stream.Org, img, detectedObjects)
+}
+
+func (h *streamHandler) Health(online bool, fps float64, lastFrame time.Time) {
+	publishHealth(h.device, online, fps)
+	if online {
+		db.StreamRunning(h.device, lastFrame, fps)
+		heartbeats.streamAlive(h.stream)
+	}
+}
+
+// Detected saves the snapshot and queues the event to the database
+// writer, the event is queued to the publisher once it has been saved
+func (h *streamHandler) Detected(img gocv.Mat, captured time.Time, detectedObjects []detect.Object, done func()) {
+	captureTime := captured.Format(time.RFC3339)
+	started := time.Now()
+
+	// all the labels are currently same (TODO: this must be updated if the model contains multiple classes)
+	label := strings.Split(detectedObjects[0].Label, " ")
+	classId, err := db.ClassID(label[0])
+	if err != nil {
+		// the other events of the stream may still be saved
+		h.logger.Error("Event dropped", "label", label[0], "err", err)
+		done()
+		return
+	}
+	snapshot := detect.SaveSnapshots(notify.SnapshotDir, img, h.captureId, captured, detectedObjects)
+	err = writer.Write(store.EventWrite{
+		Stream:     h.device,
+		Detections: storeDetections(detectedObjects),
+		Class:      classId,
+		Captured:   captureTime,
+		Snapshot:   snapshot,
+		Done: func(event int, err error) {
+			defer done()
+			detect.Latencies.Observe(h.device, detect.StepPersist, time.Since(started))
+			if err != nil {
+				h.logger.Error("Error saving event", "err", err)
+				return
+			}
+			if event == 0 {
+				// duplicate
+				return
+			}
+			publisher.publish(h, detectionEvent{id: event, stream: h.device, label: label[0], created: captureTime, detections: detectedObjects, snapshot: snapshot, info: h.stream})
+		},
+	})
+	if err != nil {
+		h.logger.Warn("Event dropped", "err", err)
+		done()
+	}
+}
+
+// published passes the saved event to the sinks and the hook of the stream
+func (h *streamHandler) published(e detectionEvent) {
+	started := time.Now()
+	defer func() { detect.Latencies.Observe(h.device, detect.StepNotify, time.Since(started)) }()
+	detect.Pipelines.Event(h.device)
+	publishEvent(e)
+	h.mu.Lock()
+	onDetect := h.onDetect
+	h.mu.Unlock()
+	if onDetect != "" {
+		hooks.run(onDetect, e)
+	}
+}
+
+// close stops the live views of the stream after the pipeline has ended
+func (h *streamHandler) close() {
+	if !h.connected {
+		return
+	}
+	if h.stopHLS != nil {
+		h.stopHLS()
+	}
+	restream.unregister(h.stream, h.device)
+	previews.stopped(h.device)
+}
+
+// storeDetections converts the objects to the detections of the database
+func storeDetections(detectedObjects []detect.Object) []store.Detection {
+	detections := make([]store.Detection, len(detectedObjects))
+	for i, obj := range detectedObjects {
+		detections[i] = store.Detection{
+			Confidence: int(obj.Confidence * 100),
+			Top:        obj.Top,
+			Left:       obj.Left,
+			Width:      obj.Width,
+			Height:     obj.Height,
+			Crop:       obj.Crop,
+		}
+	}
+	return detections
+}
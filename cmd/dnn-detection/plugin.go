@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+	"github.com/osmundi/gocv-stream-events/pkg/plugin"
+)
+
+// pluginSink sends the events to an external program, see package plugin.
+// The events are sent with the method "event", the status of the streams
+// with "health" and the panics of the pipelines with "error".
+type pluginSink struct {
+	plugin *plugin.Plugin
+	format string
+}
+
+// newPluginSinks returns the sinks of the comma separated command lines
+func newPluginSinks(config, format string, timeout time.Duration) []eventSink {
+	var plugins []eventSink
+	for _, command := range strings.Split(config, ",") {
+		if strings.TrimSpace(command) == "" {
+			continue
+		}
+		p, err := plugin.New("", command, timeout)
+		if err != nil {
+			logger("sink").Error("Sink plugin disabled", "err", err)
+			continue
+		}
+		plugins = append(plugins, &pluginSink{plugin: p, format: format})
+	}
+	return plugins
+}
+
+func (s *pluginSink) writeEvent(event detectionEvent) error {
+	payload, _, err := encodeEvent(s.format, event)
+	if err != nil {
+		return err
+	}
+	return s.plugin.Call("event", json.RawMessage(payload))
+}
+
+func (s *pluginSink) writeHealth(stream string, online bool, fps float64) error {
+	return s.plugin.Call("health", map[string]interface{}{
+		"stream": stream,
+		"online": online,
+		"fps":    fps,
+	})
+}
+
+func (s *pluginSink) writePipelineError(stream string, err *detect.PanicError) error {
+	return s.plugin.Call("error", map[string]interface{}{
+		"stream": stream,
+		"stage":  err.Stage,
+		"error":  err.Error(),
+		"time":   err.Time.Format(time.RFC3339),
+	})
+}
+
+func (s *pluginSink) Close() error {
+	return s.plugin.Close()
+}
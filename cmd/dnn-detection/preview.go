@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"sync"
+
+	"gocv.io/x/gocv"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+)
+
+// previewHub passes the annotated frames of the streams to the viewers of
+// the mjpeg preview. The frames are only encoded while someone watches.
+type previewHub struct {
+	mu      sync.Mutex
+	viewers map[string]map[chan []byte]struct{}
+	// organizations of the running streams by the address
+	streams map[string]int
+}
+
+var previews = &previewHub{viewers: map[string]map[chan []byte]struct{}{}, streams: map[string]int{}}
+
+// publish annotates a copy of the frame for the viewers of the stream
+func (p *previewHub) publish(stream string, org int, img gocv.Mat, detectedObjects []detect.Object) {
+	p.mu.Lock()
+	p.streams[stream] = org
+	watching := len(p.viewers[stream]) > 0
+	p.mu.Unlock()
+	if !watching {
+		return
+	}
+
+	// the frame is copied only to draw the detections on it
+	annotated := img
+	if len(detectedObjects) > 0 {
+		annotated = img.Clone()
+		defer annotated.Close()
+		detect.Annotate(&annotated, detectedObjects)
+	}
+	buf, err := gocv.IMEncode(gocv.JPEGFileExt, annotated)
+	if err != nil {
+		logger("preview").Error("Error encoding preview", "device", stream, "err", err)
+		return
+	}
+	// the native buffer is released before the viewers get the frame
+	frame := append([]byte(nil), buf.GetBytes()...)
+	buf.Close()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ch := range p.viewers[stream] {
+		// a slow viewer skips frames
+		select {
+		case ch <- frame:
+		default:
+		}
+	}
+}
+
+// stopped removes the stream from the previews and ends its viewers
+func (p *previewHub) stopped(stream string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.streams, stream)
+	for ch := range p.viewers[stream] {
+		close(ch)
+	}
+	delete(p.viewers, stream)
+}
+
+func (p *previewHub) watch(stream string) chan []byte {
+	ch := make(chan []byte, 1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.viewers[stream] == nil {
+		p.viewers[stream] = map[chan []byte]struct{}{}
+	}
+	p.viewers[stream][ch] = struct{}{}
+	return ch
+}
+
+func (p *previewHub) unwatch(stream string, ch chan []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.viewers[stream][ch]; ok {
+		delete(p.viewers[stream], ch)
+		close(ch)
+	}
+}
+
+// running returns the addresses of the running streams of the organization
+func (p *previewHub) running(org int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var streams []string
+	for stream, streamOrg := range p.streams {
+		if org == 0 || streamOrg == org {
+			streams = append(streams, stream)
+		}
+	}
+	sort.Strings(streams)
+	return streams
+}
+
+// isRunning tells if the stream of the organization is running
+func (p *previewHub) isRunning(stream string, org int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	streamOrg, ok := p.streams[stream]
+	return ok && (org == 0 || streamOrg == org)
+}
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+  {{range .Streams}}<h3>{{.}}</h3>
+  <p><img src="/preview?stream={{.}}{{if $.Key}}&key={{$.Key}}{{end}}" alt="{{.}}" style="max-width: 100%;"></p>
+  {{else}}<p>No streams running.</p>{{end}}
+</body>
+</html>
+`))
+
+// previewHandler serves the annotated frames of a stream as mjpeg on
+// /preview?stream=<address>, which the browsers show in an <img>. Without
+// the stream the page lists the previews of all running streams.
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	database, ok := browserDatabase(w, r)
+	if !ok {
+		return
+	}
+	stream := r.URL.Query().Get("stream")
+	if stream == "" {
+		previewPage.Execute(w, struct {
+			Streams []string
+			Key     string
+		}{previews.running(database.Org()), r.URL.Query().Get("key")})
+		return
+	}
+
+	if !previews.isRunning(stream, database.Org()) {
+		http.Error(w, "stream is not running", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	frames := previews.watch(stream)
+	defer previews.unwatch(stream, frames)
+	writer := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+writer.Boundary())
+	w.Header().Set("Cache-Control", "no-store")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-frames:
+			if !ok {
+				return
+			}
+			part, err := writer.CreatePart(textproto.MIMEHeader{
+				"Content-Type":   {"image/jpeg"},
+				"Content-Length": {fmt.Sprint(len(frame))},
+			})
+			if err != nil {
+				return
+			}
+			if _, err := part.Write(frame); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
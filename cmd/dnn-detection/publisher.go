@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+)
+
+// publishes the saved events to the sinks and the hooks
+var publisher *eventPublisher
+
+// eventPublisher passes the saved events to the sinks and the hooks of the
+// streams in its own goroutine. The events are handed to it by the
+// callbacks of the database writer, so a slow sink holds up only the
+// publishing and not the batches of the writer. When the queue is full the
+// new events are saved but not published.
+type eventPublisher struct {
+	events chan publication
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// publication is an event waiting in the queue of the publisher
+type publication struct {
+	handler *streamHandler
+	event   detectionEvent
+}
+
+// newEventPublisher starts a publisher with a queue of size events
+func newEventPublisher(size int) *eventPublisher {
+	p := &eventPublisher{events: make(chan publication, size), done: make(chan struct{})}
+	go p.run()
+	return p
+}
+
+// publish queues the event of the stream without waiting
+func (p *eventPublisher) publish(h *streamHandler, event detectionEvent) {
+	// the read lock keeps the queue open until the event is in it
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		eventLogger(event).Warn("Event not published, the publisher is closed")
+		return
+	}
+	select {
+	case p.events <- publication{h, event}:
+	default:
+		eventLogger(event).Warn("Event not published, the publish queue is full", "queue", cap(p.events))
+	}
+}
+
+// close publishes the queued events and stops the publisher
+func (p *eventPublisher) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.events)
+	}
+	p.mu.Unlock()
+	<-p.done
+}
+
+func (p *eventPublisher) run() {
+	defer close(p.done)
+	for e := range p.events {
+		e.handler.published(e.event)
+	}
+}
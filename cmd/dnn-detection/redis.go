@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisSink publishes the events to a Redis pub/sub channel for live
+// consumers and/or appends them to a Redis stream, which the consumers
+// can read from where they left off. The stream is trimmed to about
+// maxLen entries.
+type redisSink struct {
+	client  *redis.Client
+	channel string
+	stream  string
+	maxLen  int64
+}
+
+func newRedisSink(address, channel, stream string, maxLen int) (*redisSink, error) {
+	options, err := redis.ParseURL(address)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return &redisSink{client: redis.NewClient(options), channel: channel, stream: stream, maxLen: int64(maxLen)}, nil
+}
+
+func (r *redisSink) writeEvent(event detectionEvent) error {
+	payload, err := json.Marshal(newEventPayload(event))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pipe := r.client.Pipeline()
+	if r.channel != "" {
+		pipe.Publish(ctx, r.channel, payload)
+	}
+	if r.stream != "" {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.stream,
+			MaxLen: r.maxLen,
+			Approx: true,
+			Values: map[string]interface{}{
+				"event_id": event.id,
+				"stream":   event.stream,
+				"class":    event.label,
+				"payload":  payload,
+			},
+		})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("redis: %w", err)
+	}
+	return nil
+}
+
+func (r *redisSink) Close() error {
+	return r.client.Close()
+}
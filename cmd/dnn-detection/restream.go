@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// go2rtcClient republishes the annotated streams over RTSP with go2rtc
+// (https://github.com/AlexxIT/go2rtc). Every running stream is added to
+// go2rtc with the mjpeg preview as the source, so the NVRs can record
+// rtsp://<go2rtc>:8554/<name> where the name is the slug of the stream
+// name, or the address when the stream has no name. go2rtc connects to
+// the preview only while it has clients, so an unwatched restream costs
+// nothing.
+type go2rtcClient struct {
+	api string
+	// address of our http server as seen from go2rtc
+	previewURL string
+	// read api key for the previews
+	key string
+	// {url} is replaced with the preview url, the default transcodes the
+	// mjpeg to h264 which the NVRs expect
+	source string
+	client *http.Client
+}
+
+var restream *go2rtcClient
+
+func newGo2rtcClient(api, previewURL, key, source string) *go2rtcClient {
+	if source == "" {
+		source = "ffmpeg:{url}#video=h264"
+	}
+	return &go2rtcClient{
+		api:        strings.TrimSuffix(api, "/"),
+		previewURL: strings.TrimSuffix(previewURL, "/"),
+		key:        key,
+		source:     source,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// localURL returns the address of the http server listening on addr from
+// the same host, empty if addr is not set
+func localURL(addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	return "http://localhost:" + port
+}
+
+func restreamName(stream store.Stream, address string) string {
+	if stream.Name != "" {
+		return mqttSlug(stream.Name)
+	}
+	return mqttSlug(address)
+}
+
+// register adds the stream to go2rtc, errors are only logged as the
+// detection does not depend on the restream
+func (g *go2rtcClient) register(stream store.Stream, address string) {
+	if g == nil {
+		return
+	}
+	preview := g.previewURL + "/preview?" + url.Values{"stream": {address}, "key": {g.key}}.Encode()
+	query := url.Values{"name": {restreamName(stream, address)}, "src": {strings.ReplaceAll(g.source, "{url}", preview)}}
+	if err := g.call(http.MethodPut, query); err != nil {
+		logger("preview").Error("Error adding restream", "device", address, "stream_id", stream.ID, "err", err)
+	}
+}
+
+// unregister removes the stream from go2rtc
+func (g *go2rtcClient) unregister(stream store.Stream, address string) {
+	if g == nil {
+		return
+	}
+	if err := g.call(http.MethodDelete, url.Values{"src": {restreamName(stream, address)}}); err != nil {
+		logger("preview").Error("Error removing restream", "device", address, "stream_id", stream.ID, "err", err)
+	}
+}
+
+func (g *go2rtcClient) call(method string, query url.Values) error {
+	req, err := http.NewRequest(method, g.api+"/api/streams?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if err := notify.DoRequest(g.client, req); err != nil {
+		return fmt.Errorf("go2rtc: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// The observers manage their own subscriptions on the page in
+// web/subscriptions.html (SUBSCRIPTIONS_URL) with the endpoints under /me/:
+//
+//	POST   /me/login                     {"email": ...} mails the sign-in links
+//	GET    /me                           the observer
+//	GET    /me/streams                   streams of the organization with their zones
+//	GET    /me/channels                  configured notification channels
+//	GET    /me/classes                   labels of the model
+//	GET    /me/subscriptions             POST to create
+//	GET    /me/subscriptions/<id>        PUT to replace, DELETE to remove
+//
+// The sign-in links carry a token with the id of the observer signed with
+// SUBSCRIPTIONS_SECRET, which the page sends as a bearer token.
+
+// how long the sign-in links are valid
+const observerTokenTTL = 7 * 24 * time.Hour
+
+func observerTokenMAC(observer int, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SUBSCRIPTIONS_SECRET")))
+	fmt.Fprintf(mac, "observer:%d:%d", observer, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// observerToken signs the id of the observer until the expiry time
+func observerToken(observer int, expires time.Time) string {
+	return fmt.Sprintf("%d.%d.%s", observer, expires.Unix(), observerTokenMAC(observer, expires.Unix()))
+}
+
+// parseObserverToken returns the observer of a valid token
+func parseObserverToken(token string) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || os.Getenv("SUBSCRIPTIONS_SECRET") == "" {
+		return 0, fmt.Errorf("invalid token")
+	}
+	observer, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid token")
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !hmac.Equal([]byte(parts[2]), []byte(observerTokenMAC(observer, expires))) {
+		return 0, fmt.Errorf("invalid token")
+	}
+	if time.Now().Unix() > expires {
+		return 0, fmt.Errorf("token expired")
+	}
+	return observer, nil
+}
+
+// observerLink returns the sign-in link of the subscription page
+func observerLink(observer int) string {
+	query := url.Values{}
+	query.Set("token", observerToken(observer, time.Now().Add(observerTokenTTL)))
+	return os.Getenv("SUBSCRIPTIONS_URL") + "?" + query.Encode()
+}
+
+// sendObserverLinks mails the sign-in links to the observers with the
+// address. Unknown addresses are ignored.
+func sendObserverLinks(ctx context.Context, db store.Database, email string) error {
+	observers, err := db.ObserversByEmail(email)
+	if err != nil {
+		return err
+	}
+	for _, observer := range observers {
+		body := fmt.Sprintf("Manage your subscriptions at:\n\n%s\n\nThe link is valid for %d days. If you did not ask for it, you can ignore this message.\n",
+			observerLink(observer), int(observerTokenTTL/(24*time.Hour)))
+		n := notify.Notification{Subject: "Your subscriptions", Body: body}
+		if err := notify.Send(ctx, "email", n, email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observerDatabase returns the observer of the bearer token and the
+// database scoped to the organization of the observer
+func observerDatabase(r *http.Request) (store.Database, int, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	observer, err := parseObserverToken(token)
+	if err != nil {
+		return store.Database{}, 0, err
+	}
+	org, err := db.ObserverOrg(observer)
+	if err != nil {
+		return store.Database{}, 0, err
+	}
+	return db.ForOrg(org), observer, nil
+}
+
+// observerStream is a stream as shown to the observers, without the
+// address that may contain credentials
+type observerStream struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Zones       []string `json:"zones"`
+}
+
+func observerStreams(db store.Database) ([]observerStream, error) {
+	streams, err := db.ListStreams()
+	if err != nil {
+		return nil, err
+	}
+	result := []observerStream{}
+	for _, s := range streams {
+		if !s.Enabled {
+			continue
+		}
+		zones, err := db.StreamZones(s.ID)
+		if err != nil {
+			return nil, err
+		}
+		stream := observerStream{ID: s.ID, Name: s.Name, Description: s.Place(), Zones: []string{}}
+		if stream.Name == "" {
+			stream.Name = fmt.Sprintf("Stream %d", s.ID)
+		}
+		for _, z := range zones {
+			if !z.Mask {
+				stream.Zones = append(stream.Zones, z.Name)
+			}
+		}
+		result = append(result, stream)
+	}
+	return result, nil
+}
+
+func selfServiceHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/me"), "/")
+	if path == "login" {
+		selfServiceLogin(w, r)
+		return
+	}
+
+	database, observer, err := observerDatabase(r)
+	if err != nil {
+		apiError(w, http.StatusUnauthorized, "sign in again")
+		return
+	}
+	resource, rest, _ := strings.Cut(path, "/")
+	id := 0
+	if rest != "" {
+		id, err = strconv.Atoi(rest)
+		if err != nil || id <= 0 || resource != "subscriptions" {
+			apiError(w, http.StatusNotFound, "not found")
+			return
+		}
+	}
+	if resource != "subscriptions" && r.Method != http.MethodGet {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	switch resource {
+	case "":
+		o, err := database.GetObserver(observer)
+		respond(w, r, http.StatusOK, o, err)
+	case "streams":
+		streams, err := observerStreams(database)
+		respond(w, r, http.StatusOK, streams, err)
+	case "channels":
+		writeJSON(w, http.StatusOK, notify.Channels())
+	case "classes":
+		writeJSON(w, http.StatusOK, classes)
+	case "subscriptions":
+		selfServiceSubscriptions(database, w, r, observer, id)
+	default:
+		apiError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// selfServiceLogin mails the sign-in links. The response does not tell
+// whether the address belongs to an observer.
+func selfServiceLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if os.Getenv("SUBSCRIPTIONS_URL") == "" || os.Getenv("SUBSCRIPTIONS_SECRET") == "" {
+		apiError(w, http.StatusNotFound, "self-service is not enabled")
+		return
+	}
+	var login struct {
+		Email string `json:"email"`
+	}
+	if !readJSON(w, r, &login) {
+		return
+	}
+	if err := sendObserverLinks(r.Context(), *db, login.Email); err != nil {
+		logger("api").Error("Error sending sign-in links", "err", err)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// selfServiceSubscriptions is apiSubscriptions limited to the subscriptions
+// of the observer. The email subscriptions always go to the address of the
+// observer, so that nobody can subscribe others to the notifications.
+func selfServiceSubscriptions(db store.Database, w http.ResponseWriter, r *http.Request, observer, id int) {
+	if id != 0 {
+		s, err := db.GetSubscription(id)
+		if err == nil && s.Observer != observer {
+			err = sql.ErrNoRows
+		}
+		if err != nil {
+			apiFailure(w, r, err)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id == 0:
+		subscriptions, err := db.ListSubscriptions(observer)
+		if subscriptions == nil {
+			subscriptions = []store.Subscription{}
+		}
+		respond(w, r, http.StatusOK, subscriptions, err)
+	case r.Method == http.MethodGet:
+		subscription, err := db.GetSubscription(id)
+		respond(w, r, http.StatusOK, subscription, err)
+	case r.Method == http.MethodPost && id == 0, r.Method == http.MethodPut && id != 0:
+		subscription := store.Subscription{Alert: true, Channel: "email", Mode: store.SubscriptionEvent, QuietAction: store.QuietSuppress}
+		if !readJSON(w, r, &subscription) {
+			return
+		}
+		subscription.Observer = observer
+		if subscription.Channel == "email" {
+			subscription.Recipient = ""
+		}
+		if err := subscription.Validate(); err != nil {
+			apiError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if id == 0 {
+			id, err := db.CreateSubscription(subscription)
+			subscription.ID = id
+			respond(w, r, http.StatusCreated, subscription, err)
+			return
+		}
+		subscription.ID = id
+		respond(w, r, http.StatusOK, subscription, db.UpdateSubscription(subscription))
+	case r.Method == http.MethodDelete && id != 0:
+		respond(w, r, http.StatusNoContent, nil, db.DeleteSubscription(id))
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
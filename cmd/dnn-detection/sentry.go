@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// the same error is reported at most once in this time
+const reportInterval = time.Minute
+
+// the attributes of the logs that are tags of the reports, the others are
+// extra data
+var reportTags = map[string]bool{"module": true, "device": true, "stream_id": true, "event_id": true, "worker": true}
+
+// reporter sends the errors to SENTRY_DSN, nil if it is not set
+var reporter *errorReporter
+
+// errorReporter sends the errors of the logs and the panics to Sentry, or
+// to a service with the same api (e.g. GlitchTip). The reports are sent
+// in the background and dropped when the queue is full, so that the
+// logging never waits for the service.
+type errorReporter struct {
+	endpoint    string
+	auth        string
+	environment string
+	release     string
+	host        string
+	client      *http.Client
+	queue       chan sentryEvent
+
+	mu sync.Mutex
+	// when each error was last reported
+	reported map[string]time.Time
+	// reports queued or being sent
+	pending sync.WaitGroup
+}
+
+// sentryEvent is an event of the Sentry api
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+	Exception   []sentryException `json:"exception,omitempty"`
+}
+
+type sentryException struct {
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	Stacktrace sentryStacktrace `json:"stacktrace"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// newErrorReporter returns the reporter of the dsn, e.g.
+// https://key@o123.ingest.sentry.io/456
+func newErrorReporter(dsn string) (*errorReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SENTRY_DSN: %v", err)
+	}
+	slash := strings.LastIndex(u.Path, "/")
+	project := u.Path[slash+1:]
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("invalid SENTRY_DSN %q, expected scheme://key@host/project", u.Redacted())
+	}
+	host, _ := os.Hostname()
+	r := &errorReporter{
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, u.Path[:slash], project),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=gocv-stream-events/1.0, sentry_key=%s", u.User.Username()),
+		environment: os.Getenv("RUN_ENV"),
+		release:     os.Getenv("SENTRY_RELEASE"),
+		host:        host,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan sentryEvent, 100),
+		reported:    map[string]time.Time{},
+	}
+	go r.run()
+	return r, nil
+}
+
+// run sends the queued reports
+func (r *errorReporter) run() {
+	for e := range r.queue {
+		if err := r.send(e); err != nil {
+			// not to the logs, which would report it again
+			fmt.Fprintf(os.Stderr, "Error sending error report: %v\n", err)
+		}
+		r.pending.Done()
+	}
+}
+
+// send posts the event in an envelope
+func (r *errorReporter) send(e sentryEvent) error {
+	event, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `{"event_id":%q,"sent_at":%q}`+"\n", e.EventID, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`+"\n", len(event))
+	body.Write(event)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	return notify.DoRequest(r.client, req)
+}
+
+// report queues the event, unless the same error has been reported within
+// reportInterval or the queue is full
+func (r *errorReporter) report(e sentryEvent) {
+	key := e.Message + "|" + e.Tags["device"]
+	for _, ex := range e.Exception {
+		key += "|" + ex.Value
+	}
+	r.mu.Lock()
+	if time.Since(r.reported[key]) < reportInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.reported[key] = time.Now()
+	for k, t := range r.reported {
+		if time.Since(t) >= reportInterval {
+			delete(r.reported, k)
+		}
+	}
+	r.mu.Unlock()
+
+	e.EventID = newEventID()
+	e.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	e.Platform = "go"
+	e.ServerName, e.Environment, e.Release = r.host, r.environment, r.release
+	r.pending.Add(1)
+	select {
+	case r.queue <- e:
+	default:
+		r.pending.Done()
+	}
+}
+
+// flush waits for the queued reports to be sent, timeout at most
+func (r *errorReporter) flush(timeout time.Duration) {
+	if r == nil {
+		return
+	}
+	sent := make(chan struct{})
+	go func() {
+		r.pending.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(timeout):
+	}
+}
+
+// panicked reports a panic of the goroutine with the tags (e.g. the
+// device of a pipeline) and panics again. It must be deferred directly:
+//
+//	defer reporter.panicked(map[string]string{"device": deviceID})
+func (r *errorReporter) panicked(tags map[string]string) {
+	if r == nil {
+		return
+	}
+	v := recover()
+	if v == nil {
+		return
+	}
+	// the frames of the panic, the oldest first as in the api
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack []sentryFrame
+	for {
+		frame, more := frames.Next()
+		stack = append([]sentryFrame{{
+			Function: frame.Function,
+			Filename: frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.HasPrefix(frame.Function, "main.") || strings.Contains(frame.Function, "gocv-stream-events"),
+		}}, stack...)
+		if !more {
+			break
+		}
+	}
+	r.report(sentryEvent{
+		Level:     "fatal",
+		Message:   fmt.Sprintf("panic: %v", v),
+		Tags:      tags,
+		Exception: []sentryException{{Type: "panic", Value: fmt.Sprint(v), Stacktrace: sentryStacktrace{Frames: stack}}},
+	})
+	r.flush(5 * time.Second)
+	panic(v)
+}
+
+// handler returns the handler that reports the errors logged with next,
+// whatever the levels of next
+func (r *errorReporter) handler(next slog.Handler) slog.Handler {
+	return &reportingHandler{Handler: next, reporter: r}
+}
+
+// reportingHandler reports the records of the error level with the
+// attributes of the logger and the record
+type reportingHandler struct {
+	slog.Handler
+	reporter *errorReporter
+	attrs    []slog.Attr
+	group    string
+}
+
+func (h *reportingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelError || h.Handler.Enabled(ctx, level)
+}
+
+func (h *reportingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		e := sentryEvent{Level: "error", Message: r.Message, Tags: map[string]string{}, Extra: map[string]string{}}
+		attrs := h.attrs
+		r.Attrs(func(a slog.Attr) bool {
+			attrs = appendAttr(attrs, h.group, a)
+			return true
+		})
+		for _, a := range attrs {
+			if reportTags[a.Key] {
+				e.Tags[a.Key] = a.Value.String()
+			} else {
+				e.Extra[a.Key] = a.Value.String()
+			}
+		}
+		e.Logger = e.Tags["module"]
+		h.reporter.report(e)
+	}
+	if !h.Handler.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *reportingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.Handler = h.Handler.WithAttrs(attrs)
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		c.attrs = appendAttr(c.attrs, h.group, a)
+	}
+	return &c
+}
+
+func (h *reportingHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.Handler = h.Handler.WithGroup(name)
+	c.group = h.group + name + "."
+	return &c
+}
+
+// newEventID returns a random id of an event, 32 hex digits
+func newEventID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// serveHTTP serves the http endpoints of the detector until ctx is done
+func serveHTTP(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/unsubscribe", unsubscribeHandler)
+	mux.HandleFunc("/api/", apiHandler)
+	mux.HandleFunc("/auth/", authHandler)
+	mux.HandleFunc("/me", selfServiceHandler)
+	mux.HandleFunc("/me/", selfServiceHandler)
+	mux.HandleFunc("/ws", wsHandler)
+	mux.HandleFunc("/preview", previewHandler)
+	mux.HandleFunc("/detect", detectHandler)
+	if hlsDir != "" {
+		mux.Handle("/hls/", hlsHandler())
+	}
+	if notify.SnapshotDir != "" {
+		mux.Handle("/snapshots/", snapshotHandler())
+	}
+	if os.Getenv("DEBUG_ENDPOINTS") == "true" {
+		mux.Handle("/debug/", debugHandler())
+	}
+	mux.Handle("/", dashboardHandler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		// the requests in progress may finish, the websockets are cut
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+	logger("api").Info("Serving http", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger("api").Error("Error serving http", "err", err)
+	}
+}
+
+// protectedFiles serves the files of dir under prefix to the callers with
+// an api key or a session (see browserDatabase) when visible allows the
+// path relative to dir for their organization. The directories are not
+// listed.
+func protectedFiles(prefix, dir string, visible func(database store.Database, name string) (bool, error)) http.Handler {
+	files := http.StripPrefix(prefix, http.FileServer(http.Dir(dir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean(strings.TrimPrefix(r.URL.Path, prefix))
+		if strings.HasSuffix(r.URL.Path, "/") || name == "." || strings.HasPrefix(name, "..") {
+			http.NotFound(w, r)
+			return
+		}
+		database, ok := browserDatabase(w, r)
+		if !ok {
+			return
+		}
+		allowed, err := visible(database, name)
+		if err != nil {
+			logger("api").Error("Error checking file access", "path", r.URL.Path, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			http.NotFound(w, r)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
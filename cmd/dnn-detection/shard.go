@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// shard is the part of the streams of the database processed by this
+// instance with -shard i/n
+type shard struct {
+	index, count int
+}
+
+// parseShard parses i/n, where 0 <= i < n
+func parseShard(value string) (shard, error) {
+	i, n, ok := strings.Cut(value, "/")
+	index, err1 := strconv.Atoi(i)
+	count, err2 := strconv.Atoi(n)
+	if !ok || err1 != nil || err2 != nil || count < 1 || index < 0 || index >= count {
+		return shard{}, fmt.Errorf("invalid shard %q, expected i/n with 0 <= i < n", value)
+	}
+	return shard{index, count}, nil
+}
+
+// owns tells whether the stream belongs to the shard. The stream goes to
+// the shard with the highest hash of the address and the shard number
+// (rendezvous hashing), so when n grows by one only the streams of the new
+// shard move, and the rest stay where they were.
+func (s shard) owns(address string) bool {
+	owner, highest := 0, uint64(0)
+	for i := 0; i < s.count; i++ {
+		sum := sha256.Sum256([]byte(strconv.Itoa(i) + "/" + address))
+		if weight := binary.BigEndian.Uint64(sum[:8]); i == 0 || weight > highest {
+			owner, highest = i, weight
+		}
+	}
+	return owner == s.index
+}
+
+// streams returns the addresses of the shard
+func (s shard) streams(addresses []string) []string {
+	var owned []string
+	for _, address := range addresses {
+		if s.owns(address) {
+			owned = append(owned, address)
+		}
+	}
+	return owned
+}
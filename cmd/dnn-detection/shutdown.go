@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownContext returns a context that is done on SIGINT or SIGTERM,
+// and the context of the database queries, which is cancelled only when
+// the shutdown has taken longer than timeout. The captures stop on the
+// first one, the events already captured are still saved with the second.
+// A second signal, or the shutdown taking another timeout after the
+// queries have been cancelled, ends the process at once.
+func shutdownContext(timeout time.Duration) (ctx, queries context.Context) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	queries, cancelQueries := context.WithCancel(context.Background())
+	go func() {
+		<-ctx.Done()
+		// the default handling of the signals again
+		stop()
+		slog.Info("Shutting down", "timeout", timeout)
+		time.Sleep(timeout)
+		slog.Warn("Shutdown timed out, cancelling the queries")
+		cancelQueries()
+		time.Sleep(timeout)
+		slog.Error("Shutdown timed out")
+		os.Exit(1)
+	}()
+	return ctx, queries
+}
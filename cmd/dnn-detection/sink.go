@@ -0,0 +1,220 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// detectionEvent is a single frame with at least one detected object
+// that has been saved to the database
+type detectionEvent struct {
+	id         int
+	stream     string
+	label      string
+	created    string
+	detections []detect.Object
+	// path of the frame relative to the snapshot directory
+	snapshot string
+	// zero value if the device is not in the database
+	info store.Stream
+}
+
+// eventSink receives every detection event after it has been saved
+// to the database. Sinks are optional and configured with environment
+// variables.
+type eventSink interface {
+	writeEvent(event detectionEvent) error
+	Close() error
+}
+
+// eventPayload is the json representation of a detection event shared by
+// the sinks that publish the events as messages
+type eventPayload struct {
+	Event      int                `json:"event_id"`
+	Stream     string             `json:"stream"`
+	StreamName string             `json:"stream_name,omitempty"`
+	Class      string             `json:"class"`
+	Count      int                `json:"count"`
+	Confidence float32            `json:"confidence"`
+	Created    string             `json:"created"`
+	Snapshot   string             `json:"snapshot_url,omitempty"`
+	Detections []detectionPayload `json:"detections"`
+}
+
+type detectionPayload struct {
+	Confidence float32 `json:"confidence"`
+	Top        int     `json:"top"`
+	Left       int     `json:"left"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Crop       string  `json:"crop_url,omitempty"`
+}
+
+func newEventPayload(event detectionEvent) eventPayload {
+	payload := eventPayload{
+		Event:      event.id,
+		Stream:     event.stream,
+		StreamName: event.info.Name,
+		Class:      event.label,
+		Count:      len(event.detections),
+		Created:    event.created,
+		Snapshot:   notify.SnapshotLink(event.snapshot),
+	}
+	for _, obj := range event.detections {
+		if obj.Confidence > payload.Confidence {
+			payload.Confidence = obj.Confidence
+		}
+		payload.Detections = append(payload.Detections, detectionPayload{obj.Confidence, obj.Top, obj.Left, obj.Width, obj.Height, notify.SnapshotLink(obj.Crop)})
+	}
+	return payload
+}
+
+// healthSink is implemented by the sinks that also want to receive
+// periodic status of the streams
+type healthSink interface {
+	writeHealth(stream string, online bool, fps float64) error
+}
+
+// errorSink is implemented by the sinks that also want to receive the
+// panics of the pipelines
+type errorSink interface {
+	writePipelineError(stream string, err *detect.PanicError) error
+}
+
+var sinks []eventSink
+
+func initSinks() {
+	// the live consumers of the apis
+	sinks = append(sinks, broadcaster)
+	if os.Getenv("CLICKHOUSE_URL") != "" {
+		sinks = append(sinks, newClickhouseSink(os.Getenv("CLICKHOUSE_URL"), os.Getenv("CLICKHOUSE_USER"), os.Getenv("CLICKHOUSE_PASSWORD")))
+	}
+	if os.Getenv("ELASTICSEARCH_URL") != "" {
+		es := newElasticsearchSink(os.Getenv("ELASTICSEARCH_URL"), os.Getenv("ELASTICSEARCH_INDEX"))
+		es.apiKey = os.Getenv("ELASTICSEARCH_API_KEY")
+		es.user = os.Getenv("ELASTICSEARCH_USER")
+		es.password = os.Getenv("ELASTICSEARCH_PASSWORD")
+		sinks = append(sinks, es)
+	}
+	if source := os.Getenv("CLOUDEVENTS_SOURCE"); source != "" {
+		cloudEventsSource = source
+	}
+	if os.Getenv("WEBHOOK_URLS") != "" {
+		sinks = append(sinks, newWebhookSink(os.Getenv("WEBHOOK_SECRET"), strings.Split(os.Getenv("WEBHOOK_URLS"), ","), os.Getenv("WEBHOOK_FORMAT"), envInt("WEBHOOK_WORKERS", 4), envInt("WEBHOOK_QUEUE", 100)))
+	}
+	if os.Getenv("MQTT_BROKER") != "" {
+		layout := os.Getenv("MQTT_TOPIC_LAYOUT")
+		prefix := os.Getenv("MQTT_TOPIC_PREFIX")
+		if prefix == "" && layout == mqttLayoutFrigate {
+			prefix = "frigate"
+		} else if prefix == "" {
+			prefix = "gocv"
+		}
+		var discovery string
+		if os.Getenv("MQTT_HA_DISCOVERY") == "true" {
+			discovery = "homeassistant"
+		}
+		m, err := newMQTTSink(os.Getenv("MQTT_BROKER"), os.Getenv("MQTT_USER"), os.Getenv("MQTT_PASSWORD"), prefix, layout, discovery)
+		if err != nil {
+			logger("sink").Error("MQTT sink disabled", "err", err)
+		} else {
+			sinks = append(sinks, m)
+		}
+	}
+	if os.Getenv("NATS_URL") != "" {
+		subject := os.Getenv("NATS_SUBJECT")
+		if subject == "" {
+			subject = "gocv.events"
+		}
+		n, err := newNATSSink(os.Getenv("NATS_URL"), subject, os.Getenv("NATS_CREDENTIALS"), os.Getenv("NATS_FORMAT"))
+		if err != nil {
+			logger("sink").Error("NATS sink disabled", "err", err)
+		} else {
+			sinks = append(sinks, n)
+		}
+	}
+	if os.Getenv("KAFKA_BROKERS") != "" {
+		topic := os.Getenv("KAFKA_TOPIC")
+		if topic == "" {
+			topic = "detection-events"
+		}
+		sinks = append(sinks, newKafkaSink(os.Getenv("KAFKA_BROKERS"), topic, os.Getenv("KAFKA_DETECTIONS_TOPIC"), os.Getenv("KAFKA_FORMAT")))
+	}
+	if os.Getenv("AMQP_URL") != "" {
+		routingKey := os.Getenv("AMQP_ROUTING_KEY")
+		if routingKey == "" {
+			routingKey = "detection.{stream}.{class}"
+		}
+		a, err := newAMQPSink(os.Getenv("AMQP_URL"), os.Getenv("AMQP_EXCHANGE"), routingKey)
+		if err != nil {
+			logger("sink").Error("AMQP sink disabled", "err", err)
+		} else {
+			sinks = append(sinks, a)
+		}
+	}
+	if os.Getenv("REDIS_URL") != "" {
+		r, err := newRedisSink(os.Getenv("REDIS_URL"), os.Getenv("REDIS_CHANNEL"), os.Getenv("REDIS_STREAM"), envInt("REDIS_STREAM_MAXLEN", 10000))
+		if err != nil {
+			logger("sink").Error("Redis sink disabled", "err", err)
+		} else {
+			sinks = append(sinks, r)
+		}
+	}
+	if os.Getenv("INFLUX_URL") != "" {
+		sinks = append(sinks, newInfluxSink(os.Getenv("INFLUX_URL"), os.Getenv("INFLUX_ORG"), os.Getenv("INFLUX_BUCKET"), os.Getenv("INFLUX_TOKEN")))
+	}
+	if os.Getenv("GRAFANA_URL") != "" {
+		tags := os.Getenv("GRAFANA_TAGS")
+		if tags == "" {
+			tags = "detection"
+		}
+		sinks = append(sinks, newGrafanaSink(os.Getenv("GRAFANA_URL"), os.Getenv("GRAFANA_TOKEN"), os.Getenv("GRAFANA_DASHBOARD_UID"), envInt("GRAFANA_PANEL_ID", 0), tags))
+	}
+	if os.Getenv("SINK_PLUGINS") != "" {
+		sinks = append(sinks, newPluginSinks(os.Getenv("SINK_PLUGINS"), os.Getenv("SINK_PLUGIN_FORMAT"), envDuration("PLUGIN_TIMEOUT", 30*time.Second))...)
+	}
+}
+
+// publishEvent forwards the event to all configured sinks. A failing
+// sink is logged but does not stop the detection.
+func publishEvent(event detectionEvent) {
+	for _, sink := range sinks {
+		if err := sink.writeEvent(event); err != nil {
+			eventLogger(event).Error("Error publishing event", "err", err)
+		}
+	}
+}
+
+// publishHealth forwards the stream status to the sinks that support it
+func publishHealth(stream string, online bool, fps float64) {
+	for _, sink := range sinks {
+		if hs, ok := sink.(healthSink); ok {
+			if err := hs.writeHealth(stream, online, fps); err != nil {
+				logger("sink").Error("Error publishing health", "device", stream, "err", err)
+			}
+		}
+	}
+}
+
+// publishPipelineError forwards the panic of the pipeline of the stream to
+// the sinks that support it
+func publishPipelineError(stream string, panicked *detect.PanicError) {
+	for _, sink := range sinks {
+		if es, ok := sink.(errorSink); ok {
+			if err := es.writePipelineError(stream, panicked); err != nil {
+				logger("sink").Error("Error publishing pipeline error", "device", stream, "err", err)
+			}
+		}
+	}
+}
+
+func closeSinks() {
+	for _, sink := range sinks {
+		sink.Close()
+	}
+}
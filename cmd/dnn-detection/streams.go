@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+)
+
+// restart policies of the pipelines (STREAM_RESTART)
+const (
+	restartNever = "never"
+	// after the failures of the device or the network
+	restartOnFailure = "on-failure"
+	// also after a video file has ended
+	restartAlways = "always"
+)
+
+// the wait before a restart is doubled up to this
+const maxRestartBackoff = 5 * time.Minute
+
+// restartPolicy tells when the pipeline of a stream is started again after
+// it has ended. A disabled stream or a shutdown is never restarted.
+type restartPolicy struct {
+	mode string
+	// restarts of a stream at most within the window, after that the
+	// stream is given up
+	max    int
+	window time.Duration
+	// wait before the first restart, doubled after every restart without
+	// any frame in between
+	backoff time.Duration
+}
+
+var restarts restartPolicy
+
+// newRestartPolicy returns the policy of the STREAM_RESTART* environment
+func newRestartPolicy() (restartPolicy, error) {
+	policy := restartPolicy{
+		mode:    os.Getenv("STREAM_RESTART"),
+		max:     envInt("STREAM_MAX_RESTARTS", 5),
+		window:  envDuration("STREAM_RESTART_WINDOW", 10*time.Minute),
+		backoff: envDuration("STREAM_RESTART_BACKOFF", 5*time.Second),
+	}
+	switch policy.mode {
+	case "":
+		policy.mode = restartOnFailure
+	case restartNever, restartOnFailure, restartAlways:
+	default:
+		return policy, fmt.Errorf("unknown STREAM_RESTART %q", policy.mode)
+	}
+	return policy, nil
+}
+
+// allows tells whether the pipeline that ended with err is started again
+func (r restartPolicy) allows(ctx context.Context, err error) bool {
+	switch {
+	case ctx.Err() != nil, errors.Is(err, detect.ErrStreamDisabled), errors.Is(err, detect.ErrWindowClosed):
+		return false
+	case errors.Is(err, detect.ErrSourceEnded):
+		return r.mode == restartAlways
+	}
+	return r.mode != restartNever
+}
+
+// streamGroup runs the pipelines of the streams and keeps why each of them
+// ended. A failing stream does not stop the others, and the ended
+// pipelines are restarted with the restart policy.
+type streamGroup struct {
+	group errgroup.Group
+	// runs one pipeline of a stream, runPipeline when nil
+	run func(ctx context.Context, deviceID string, captureId int) error
+
+	mu sync.Mutex
+	// the error returned by the last pipeline of the stream
+	exits map[string]error
+}
+
+// goPipeline runs the pipeline of the stream in its own goroutine, done is
+// called with its result after it has ended and will not be restarted
+func (g *streamGroup) goPipeline(ctx context.Context, deviceID string, captureId int, done func(error)) {
+	g.group.Go(func() error {
+		defer reporter.panicked(map[string]string{"device": deviceID})
+		err := g.supervise(ctx, deviceID, captureId)
+		if done != nil {
+			done(err)
+		}
+		return nil
+	})
+}
+
+// supervise runs the pipeline of the stream again after it has ended, as
+// long as the restart policy allows, and returns why the last run ended
+func (g *streamGroup) supervise(ctx context.Context, deviceID string, captureId int) error {
+	run := g.run
+	if run == nil {
+		run = runPipeline
+	}
+	backoff := restarts.backoff
+	// the restarts within the window
+	var recent []time.Time
+	for {
+		started := time.Now()
+		err := run(ctx, deviceID, captureId)
+		g.mu.Lock()
+		if g.exits == nil {
+			g.exits = map[string]error{}
+		}
+		g.exits[deviceID] = err
+		g.mu.Unlock()
+		if err != nil && !stoppedCleanly(ctx, err) {
+			logger("pipeline").Error("Stream failed", "device", deviceID, "err", err)
+		}
+		if !restarts.allows(ctx, err) {
+			return err
+		}
+
+		now := time.Now()
+		for len(recent) > 0 && now.Sub(recent[0]) > restarts.window {
+			recent = recent[1:]
+		}
+		if len(recent) >= restarts.max {
+			logger("pipeline").Error("Stream given up", "device", deviceID, "restarts", len(recent), "window", restarts.window)
+			return err
+		}
+		recent = append(recent, now)
+		// a run that analyzed frames was a transient failure, the backoff
+		// starts over
+		if detect.Pipelines.LastFrame(deviceID).After(started) {
+			backoff = restarts.backoff
+		}
+		detect.Pipelines.Restarting(deviceID)
+		logger("pipeline").Info("Restarting stream", "device", deviceID, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		if backoff *= 2; backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+	}
+}
+
+// wait waits for the pipelines and returns the failures of the streams
+// joined, nil if every pipeline stopped cleanly
+func (g *streamGroup) wait(ctx context.Context) error {
+	g.group.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	streams := make([]string, 0, len(g.exits))
+	for stream := range g.exits {
+		streams = append(streams, stream)
+	}
+	sort.Strings(streams)
+	var failures []error
+	for _, stream := range streams {
+		if err := g.exits[stream]; err != nil && !stoppedCleanly(ctx, err) {
+			failures = append(failures, fmt.Errorf("%s: %w", stream, err))
+		}
+	}
+	return errors.Join(failures...)
+}
+
+// stoppedCleanly tells whether the pipeline ended without a failure: the
+// stream was disabled, the window was closed or the process shuts down
+func stoppedCleanly(ctx context.Context, err error) bool {
+	return err == nil || errors.Is(err, detect.ErrStreamDisabled) || errors.Is(err, detect.ErrWindowClosed) || ctx.Err() != nil && errors.Is(err, ctx.Err())
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+)
+
+func TestRestartPolicyAllows(t *testing.T) {
+	failed := errors.New("connection refused")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		mode string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{restartOnFailure, context.Background(), failed, true},
+		{restartOnFailure, context.Background(), nil, true},
+		{restartOnFailure, context.Background(), detect.ErrSourceEnded, false},
+		{restartOnFailure, context.Background(), fmt.Errorf("capture: %w", detect.ErrStreamDisabled), false},
+		{restartOnFailure, context.Background(), detect.ErrWindowClosed, false},
+		{restartOnFailure, cancelled, failed, false},
+		{restartAlways, context.Background(), detect.ErrSourceEnded, true},
+		{restartAlways, context.Background(), detect.ErrStreamDisabled, false},
+		{restartAlways, cancelled, detect.ErrSourceEnded, false},
+		{restartNever, context.Background(), failed, false},
+		{restartNever, context.Background(), detect.ErrSourceEnded, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %v", tt.mode, tt.err), func(t *testing.T) {
+			r := restartPolicy{mode: tt.mode}
+			if got := r.allows(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("allows(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	failed := errors.New("connection refused")
+	tests := []struct {
+		name string
+		// errors of the runs, the last one repeats
+		errs []error
+		// runs until supervise returns
+		runs int
+		want error
+	}{
+		{"given up after the restarts", []error{failed}, 3, failed},
+		{"file ended", []error{detect.ErrSourceEnded}, 1, detect.ErrSourceEnded},
+		{"disabled after a failure", []error{failed, detect.ErrStreamDisabled}, 2, detect.ErrStreamDisabled},
+	}
+	defer func(policy restartPolicy) { restarts = policy }(restarts)
+	restarts = restartPolicy{mode: restartOnFailure, max: 2, window: time.Minute, backoff: time.Millisecond}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runs := 0
+			g := &streamGroup{run: func(context.Context, string, int) error {
+				err := tt.errs[min(runs, len(tt.errs)-1)]
+				runs++
+				return err
+			}}
+			err := g.supervise(context.Background(), "supervise-test", 0)
+			if err != tt.want || runs != tt.runs {
+				t.Errorf("supervise = %v after %d runs, want %v after %d", err, runs, tt.want, tt.runs)
+			}
+			if g.exits["supervise-test"] != tt.want {
+				t.Errorf("exit of the stream %v, want %v", g.exits["supervise-test"], tt.want)
+			}
+		})
+	}
+}
+
+func TestSuperviseCancelled(t *testing.T) {
+	defer func(policy restartPolicy) { restarts = policy }(restarts)
+	restarts = restartPolicy{mode: restartOnFailure, max: 5, window: time.Minute, backoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := errors.New("connection refused")
+	runs := 0
+	g := &streamGroup{run: func(context.Context, string, int) error {
+		runs++
+		// the shutdown comes during the backoff
+		time.AfterFunc(10*time.Millisecond, cancel)
+		return failed
+	}}
+	if err := g.supervise(ctx, "supervise-cancel-test", 0); err != failed || runs != 1 {
+		t.Errorf("supervise = %v after %d runs, want %v after 1", err, runs, failed)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the socket of the native protocol of systemd-journald
+const journaldSocket = "/run/systemd/journal/socket"
+
+// syslog facilities by name
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// recordSender sends one formatted record to syslog or journald. attrs
+// are the attributes of the logger and the record, with the names of
+// their groups.
+type recordSender func(r slog.Record, message []byte, attrs []slog.Attr) error
+
+// senderHandler formats the records with the text or json handler and
+// passes them to a recordSender one at a time, for the outputs that take
+// the level of every message. The time and the level are left out of the
+// formatted message, the outputs have their own.
+type senderHandler struct {
+	formatter slog.Handler
+	out       *senderOutput
+	attrs     []slog.Attr
+	// prefix of the groups, e.g. "request."
+	group string
+}
+
+type senderOutput struct {
+	mu   sync.Mutex
+	buf  bytes.Buffer
+	send recordSender
+}
+
+func newSenderHandler(format string, send recordSender) *senderHandler {
+	out := &senderOutput{send: send}
+	omitTimeAndLevel := func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+	return &senderHandler{formatter: formatHandler(&out.buf, format, omitTimeAndLevel), out: out}
+}
+
+func (h *senderHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.formatter.Enabled(ctx, level)
+}
+
+func (h *senderHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := h.attrs
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = appendAttr(attrs, h.group, a)
+		return true
+	})
+
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.buf.Reset()
+	if err := h.formatter.Handle(ctx, r); err != nil {
+		return err
+	}
+	message := bytes.TrimSuffix(h.out.buf.Bytes(), []byte("\n"))
+	if err := h.out.send(r, message, attrs); err != nil {
+		// not lost, e.g. systemd keeps the standard error too
+		fmt.Fprintf(os.Stderr, "%s level=%s %s\n", r.Time.Format(time.RFC3339), r.Level, message)
+	}
+	return nil
+}
+
+func (h *senderHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.formatter = h.formatter.WithAttrs(attrs)
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		c.attrs = appendAttr(c.attrs, h.group, a)
+	}
+	return &c
+}
+
+func (h *senderHandler) WithGroup(name string) slog.Handler {
+	c := *h
+	c.formatter = h.formatter.WithGroup(name)
+	c.group = h.group + name + "."
+	return &c
+}
+
+// appendAttr appends the attribute with the prefix of its groups, the
+// attributes of a group one by one
+func appendAttr(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	value := a.Value.Resolve()
+	if value.Kind() == slog.KindGroup {
+		for _, member := range value.Group() {
+			attrs = appendAttr(attrs, prefix+a.Key+".", member)
+		}
+		return attrs
+	}
+	if a.Key == "" {
+		return attrs
+	}
+	return append(attrs, slog.Attr{Key: prefix + a.Key, Value: value})
+}
+
+// syslogWriter sends the logs to a syslog server in the format of RFC 5424
+type syslogWriter struct {
+	network, addr string
+	facility      int
+	hostname, app string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// dialSyslog connects to the server at addr, e.g. udp://logs:514,
+// tcp://logs:601 or unix:///dev/log, the local /dev/log if empty
+func dialSyslog(addr, facility string) (*syslogWriter, error) {
+	s := &syslogWriter{network: "unixgram", addr: "/dev/log", facility: syslogFacilities["daemon"], app: filepath.Base(os.Args[0])}
+	if addr != "" {
+		network, address, ok := strings.Cut(addr, "://")
+		if !ok {
+			return nil, fmt.Errorf("invalid SYSLOG_ADDR %q, expected network://address", addr)
+		}
+		s.network, s.addr = network, address
+	}
+	if facility != "" {
+		n, ok := syslogFacilities[facility]
+		if !ok {
+			return nil, fmt.Errorf("unknown SYSLOG_FACILITY %q", facility)
+		}
+		s.facility = n
+	}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect opens the connection, a unix socket as a datagram or a stream
+// socket whichever the server has
+func (s *syslogWriter) connect() error {
+	conn, err := net.Dial(s.network, s.addr)
+	if err != nil && s.network == "unixgram" {
+		s.network = "unix"
+		conn, err = net.Dial(s.network, s.addr)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// send writes the message, connecting again once if the server has gone
+// away. The messages of a stream connection are framed with their length
+// (RFC 6587).
+func (s *syslogWriter) send(r slog.Record, message []byte, _ []slog.Attr) error {
+	priority := s.facility*8 + syslogSeverity(r.Level)
+	line := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", priority, r.Time.Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.app, os.Getpid(), message)
+	if s.network == "tcp" || s.network == "unix" {
+		line = fmt.Sprintf("%d %s", len(line), line)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		if _, err := s.conn.Write([]byte(line)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write([]byte(line))
+	return err
+}
+
+func (s *syslogWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// syslogSeverity returns the severity of the level, also the PRIORITY of
+// journald
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// journaldWriter sends the logs to systemd-journald with the attributes
+// as fields of their own, so that e.g. journalctl DEVICE=rtsp://... shows
+// the logs of one stream
+type journaldWriter struct {
+	conn *net.UnixConn
+	app  string
+}
+
+func dialJournald() (*journaldWriter, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{conn: conn, app: filepath.Base(os.Args[0])}, nil
+}
+
+// send writes the fields of the record to the journal. The messages must
+// fit in one datagram, the larger ones go to the standard error.
+func (j *journaldWriter) send(r slog.Record, message []byte, attrs []slog.Attr) error {
+	var b bytes.Buffer
+	journaldField(&b, "MESSAGE", string(message))
+	journaldField(&b, "PRIORITY", fmt.Sprint(syslogSeverity(r.Level)))
+	journaldField(&b, "SYSLOG_IDENTIFIER", j.app)
+	for _, a := range attrs {
+		journaldField(&b, journaldName(a.Key), a.Value.String())
+	}
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+func (j *journaldWriter) Close() error {
+	return j.conn.Close()
+}
+
+// journaldField writes the field in the native protocol, the values with
+// newlines with their length
+func journaldField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journaldName returns the name of the field of the attribute: upper case
+// letters, digits and underscores, not starting with an underscore, which
+// are the fields of journald itself
+func journaldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] == '_' || name[0] >= '0' && name[0] <= '9' {
+		return "X_" + string(name)
+	}
+	return string(name)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+  {{if .Done}}<p>You have been unsubscribed and will not receive these notifications anymore.</p>
+  {{else}}<form method="post">
+    <p>Do you want to stop receiving the notifications of this subscription?</p>
+    <button type="submit">Unsubscribe</button>
+  </form>{{end}}
+</body>
+</html>
+`))
+
+// unsubscribeHandler shows a confirmation page on GET, so that the link
+// scanners of mail servers do not unsubscribe anyone, and unsubscribes
+// on POST (the form or the one-click request of the mail client)
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, err := strconv.Atoi(r.URL.Query().Get("s"))
+	token := r.URL.Query().Get("t")
+	if err != nil || !notify.ValidUnsubscribeToken(subscription, token) {
+		http.Error(w, "invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		unsubscribePage.Execute(w, struct{ Done bool }{false})
+	case http.MethodPost:
+		if err := db.Unsubscribe(subscription); err != nil {
+			logger("api").Error("Error unsubscribing", "subscription", subscription, "err", err)
+			http.Error(w, "unsubscribing failed", http.StatusInternalServerError)
+			return
+		}
+		logger("api").Info("Unsubscribed", "subscription", subscription)
+		unsubscribePage.Execute(w, struct{ Done bool }{true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
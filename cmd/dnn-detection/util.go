@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+func logConfigurations(configs map[string]string) {
+	for k, v := range configs {
+		slog.Info("Configuration", "name", k, "value", v)
+	}
+}
+
+// envInt returns the integer value of the environment variable or the
+// default if it is not set
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", name, err)
+	}
+	return n
+}
+
+// envDuration is like envInt for durations (e.g. 30s, 5m)
+func envDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", name, err)
+	}
+	return d
+}
@@ -0,0 +1,29 @@
+// Sign in with OIDC when it is enabled on the server: shows the signed in
+// user or a sign in link in #account. The api key fields are not needed
+// with a session.
+"use strict";
+
+(async () => {
+  const account = document.getElementById("account");
+  const resp = await fetch("/auth/me");
+  if (resp.status === 404) return;
+  if (resp.status === 401) {
+    const link = document.createElement("a");
+    link.href = "/auth/login";
+    link.textContent = "Sign in";
+    account.append(link);
+    return;
+  }
+  if (!resp.ok) return;
+  const user = await resp.json();
+  const logout = document.createElement("button");
+  logout.textContent = "Sign out";
+  logout.onclick = async () => {
+    await fetch("/auth/logout", { method: "POST" });
+    location.reload();
+  };
+  account.append(`${user.name || user.email} (${user.scope}) `, logout);
+  for (const key of document.querySelectorAll("input[name=key]")) {
+    key.closest("label").hidden = true;
+  }
+})();
@@ -0,0 +1,278 @@
+// Admin page of the streams: edit the streams, their detection thresholds
+// and draw the zones and masks on a reference frame of the stream.
+"use strict";
+
+const auth = document.getElementById("auth");
+const list = document.getElementById("streams");
+const editor = document.getElementById("editor");
+const streamForm = document.getElementById("stream");
+const settingsForm = document.getElementById("settings");
+const canvas = document.getElementById("frame");
+const statusLine = document.getElementById("status");
+let current = null;
+let zones = [];
+let frame = null;
+
+async function api(method, path, body) {
+  const headers = auth.key.value ? { "X-API-Key": auth.key.value } : {};
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+  const resp = await fetch("/api/" + path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (!resp.ok) {
+    throw new Error((await resp.json()).error || resp.statusText);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+function report(message) {
+  statusLine.textContent = message;
+}
+
+// run reports the errors of the api calls
+async function run(action, done) {
+  try {
+    await action();
+    if (done) report(done);
+  } catch (err) {
+    report("Error: " + err.message);
+  }
+}
+
+async function loadStreams(select) {
+  const streams = await api("GET", "streams");
+  list.replaceChildren();
+  for (const s of streams) {
+    const li = document.createElement("li");
+    li.textContent = s.name || s.address;
+    if (!s.enabled) li.classList.add("disabled");
+    li.onclick = () => run(() => edit(s));
+    list.append(li);
+    if (s.id === select) edit(s);
+  }
+}
+
+function number(value) {
+  return value === "" ? null : Number(value);
+}
+
+function fill(form, values) {
+  for (const el of form.elements) {
+    if (!el.name || !(el.name in values)) continue;
+    if (el.type === "checkbox") el.checked = values[el.name];
+    else if (el.multiple) {
+      for (const o of el.options) o.selected = (values[el.name] || []).includes(o.value);
+    } else el.value = values[el.name] ?? "";
+  }
+}
+
+async function edit(stream) {
+  current = stream;
+  editor.hidden = false;
+  report("");
+  fill(streamForm, stream);
+  if (!stream.has_coordinates) {
+    streamForm.latitude.value = "";
+    streamForm.longitude.value = "";
+  }
+  document.getElementById("delete").hidden = !stream.id;
+  document.getElementById("config").hidden = !stream.id;
+  zones = [];
+  frame = null;
+  draw();
+  if (!stream.id) return;
+
+  const [settings, streamZones] = await Promise.all([
+    api("GET", `streams/${stream.id}/settings`),
+    api("GET", `streams/${stream.id}/zones`),
+  ]);
+  fill(settingsForm, settings);
+  zones = streamZones;
+  renderZones();
+  loadFrame(stream.id);
+}
+
+// the frame is fetched with the api key, so it cannot be an img src
+async function loadFrame(id) {
+  const headers = auth.key.value ? { "X-API-Key": auth.key.value } : {};
+  const resp = await fetch(`/api/streams/${id}/frame`, { headers });
+  if (!resp.ok || current.id !== id) {
+    draw();
+    return;
+  }
+  const img = new Image();
+  img.onload = () => {
+    if (current.id !== id) return;
+    frame = img;
+    canvas.width = img.naturalWidth;
+    canvas.height = img.naturalHeight;
+    draw();
+  };
+  img.src = URL.createObjectURL(await resp.blob());
+}
+
+function draw(pending) {
+  const ctx = canvas.getContext("2d");
+  if (!frame) {
+    canvas.width = 640;
+    canvas.height = 360;
+    ctx.fillStyle = "#ccc";
+    ctx.fillRect(0, 0, canvas.width, canvas.height);
+    ctx.fillStyle = "#555";
+    ctx.font = "16px sans-serif";
+    ctx.fillText("No frame available", 20, 30);
+  } else {
+    ctx.drawImage(frame, 0, 0);
+  }
+  ctx.lineWidth = Math.max(2, canvas.width / 400);
+  ctx.font = `${Math.max(14, canvas.width / 60)}px sans-serif`;
+  for (const z of pending ? zones.concat(pending) : zones) {
+    ctx.strokeStyle = z.mask ? "#d33" : "#3a3";
+    ctx.fillStyle = z.mask ? "rgba(200, 50, 50, 0.3)" : "rgba(50, 160, 50, 0.15)";
+    ctx.fillRect(z.x, z.y, z.width, z.height);
+    ctx.strokeRect(z.x, z.y, z.width, z.height);
+    ctx.fillStyle = ctx.strokeStyle;
+    ctx.fillText(z.name, z.x + 4, z.y + parseInt(ctx.font, 10) + 2);
+  }
+}
+
+function renderZones() {
+  const body = document.querySelector("#zones tbody");
+  body.replaceChildren();
+  zones.forEach((z, i) => {
+    const tr = document.createElement("tr");
+    const name = document.createElement("input");
+    name.value = z.name;
+    name.onchange = () => { z.name = name.value; draw(); };
+    const mask = document.createElement("input");
+    mask.type = "checkbox";
+    mask.checked = z.mask;
+    mask.onchange = () => { z.mask = mask.checked; draw(); };
+    const remove = document.createElement("button");
+    remove.textContent = "Remove";
+    remove.onclick = () => { zones.splice(i, 1); renderZones(); };
+    const cells = [name, mask, `${z.x},${z.y} ${z.width}×${z.height}`, remove];
+    for (const c of cells) {
+      const td = document.createElement("td");
+      td.append(c);
+      tr.append(td);
+    }
+    body.append(tr);
+  });
+  draw();
+}
+
+// position of the pointer in the pixels of the frame
+function point(e) {
+  const r = canvas.getBoundingClientRect();
+  return {
+    x: Math.round((e.clientX - r.left) * canvas.width / r.width),
+    y: Math.round((e.clientY - r.top) * canvas.height / r.height),
+  };
+}
+
+let start = null;
+
+function area(a, b) {
+  return {
+    x: Math.max(0, Math.min(a.x, b.x)),
+    y: Math.max(0, Math.min(a.y, b.y)),
+    width: Math.abs(a.x - b.x),
+    height: Math.abs(a.y - b.y),
+  };
+}
+
+canvas.onpointerdown = (e) => {
+  if (!frame) return;
+  start = point(e);
+  canvas.setPointerCapture(e.pointerId);
+};
+canvas.onpointermove = (e) => {
+  if (start) draw({ name: "", mask: false, ...area(start, point(e)) });
+};
+canvas.onpointerup = (e) => {
+  if (!start) return;
+  const a = area(start, point(e));
+  start = null;
+  if (a.width < 5 || a.height < 5) {
+    draw();
+    return;
+  }
+  zones.push({ name: `zone ${zones.length + 1}`, mask: false, ...a });
+  renderZones();
+};
+
+streamForm.onsubmit = (e) => {
+  e.preventDefault();
+  const f = streamForm;
+  const stream = {
+    name: f.name.value,
+    address: f.address.value,
+    link: f.link.value,
+    description: f.description.value,
+    timezone: f.timezone.value,
+    has_coordinates: f.latitude.value !== "" && f.longitude.value !== "",
+    latitude: number(f.latitude.value) || 0,
+    longitude: number(f.longitude.value) || 0,
+    enabled: f.enabled.checked,
+  };
+  run(async () => {
+    const saved = current.id
+      ? await api("PUT", `streams/${current.id}`, stream)
+      : await api("POST", "streams", stream);
+    await loadStreams(saved.id);
+  }, "Stream saved.");
+};
+
+document.getElementById("delete").onclick = () => {
+  if (!confirm(`Delete stream ${current.name || current.address}?`)) return;
+  run(async () => {
+    await api("DELETE", `streams/${current.id}`);
+    editor.hidden = true;
+    await loadStreams();
+  }, "Stream deleted.");
+};
+
+settingsForm.onsubmit = (e) => {
+  e.preventDefault();
+  const f = settingsForm;
+  const settings = {
+    confidence: number(f.confidence.value),
+    iou: number(f.iou.value),
+    sample_interval_ms: number(f.sample_interval_ms.value),
+    max_fps: number(f.max_fps.value),
+    gpu: number(f.gpu.value),
+    priority: number(f.priority.value) || 0,
+    crop: f.crop.value.trim() ? f.crop.value.split(",").map(Number) : [],
+    classes: [...f.classes.selectedOptions].map((o) => o.value),
+    on_detect: f.on_detect.value,
+  };
+  run(() => api("PUT", `streams/${current.id}/settings`, settings), "Thresholds saved.");
+};
+
+document.getElementById("save-zones").onclick = () => {
+  run(() => api("PUT", `streams/${current.id}/zones`, zones), "Zones saved.");
+};
+
+document.getElementById("add").onclick = () => {
+  run(() => edit({ address: "", enabled: true, has_coordinates: false }));
+};
+
+async function init() {
+  const classes = await api("GET", "classes");
+  settingsForm.classes.replaceChildren(...classes.map((c) => new Option(c, c)));
+  const hooks = await api("GET", "hooks");
+  settingsForm.on_detect.append(...hooks.map((h) => new Option(h, h)));
+  await loadStreams();
+}
+
+auth.onsubmit = (e) => {
+  e.preventDefault();
+  localStorage.setItem("apiKey", auth.key.value);
+  editor.hidden = true;
+  run(init);
+};
+auth.key.value = localStorage.getItem("apiKey") || "";
+run(init);
@@ -0,0 +1,176 @@
+// Dashboard of the detector: a timeline of the events grouped by day with
+// the snapshots, filters, live updates over /ws and the mjpeg preview.
+"use strict";
+
+const filters = document.getElementById("filters");
+const timeline = document.getElementById("timeline");
+const more = document.getElementById("more");
+let next = "";
+let socket = null;
+
+function key() {
+  return filters.key.value;
+}
+
+async function api(path) {
+  const headers = key() ? { "X-API-Key": key() } : {};
+  const resp = await fetch("/api/" + path, { headers });
+  if (!resp.ok) {
+    throw new Error((await resp.json()).error || resp.statusText);
+  }
+  return resp.json();
+}
+
+// snapshots are served from /snapshots when SNAPSHOT_URL is not set, an
+// <img> cannot send the key in a header
+function imageURL(url, path) {
+  if (url || !path) return url || "";
+  return "/snapshots/" + path + (key() ? "?key=" + encodeURIComponent(key()) : "");
+}
+
+function query() {
+  const q = new URLSearchParams();
+  for (const name of ["stream", "class"]) {
+    if (filters[name].value) q.set(name, filters[name].value);
+  }
+  if (filters.from.value) q.set("from", new Date(filters.from.value + "T00:00:00").toISOString());
+  if (filters.to.value) {
+    const to = new Date(filters.to.value + "T00:00:00");
+    to.setDate(to.getDate() + 1);
+    q.set("to", to.toISOString());
+  }
+  return q;
+}
+
+function dayOf(event) {
+  return new Date(event.created).toLocaleDateString();
+}
+
+function dayList(day, prepend) {
+  let section = timeline.querySelector(`[data-day="${CSS.escape(day)}"]`);
+  if (!section) {
+    section = document.createElement("div");
+    section.className = "day";
+    section.dataset.day = day;
+    section.innerHTML = "<h3></h3><div class=gallery></div>";
+    section.querySelector("h3").textContent = day;
+    prepend ? timeline.prepend(section) : timeline.append(section);
+  }
+  return section.querySelector(".gallery");
+}
+
+function card(event) {
+  const div = document.createElement("div");
+  div.className = "event";
+  const img = document.createElement("img");
+  img.loading = "lazy";
+  img.src = imageURL(event.snapshot_url, event.snapshot);
+  const p = document.createElement("p");
+  const time = new Date(event.created).toLocaleTimeString();
+  p.textContent = `${time} ${event.count} × ${event.class} (${event.confidence}%) ${event.stream_name || event.stream_address || event.stream}`;
+  div.append(img, p);
+  div.onclick = () => {
+    const dialog = document.getElementById("detail");
+    dialog.querySelector("img").src = img.src;
+    dialog.querySelector("p").textContent = p.textContent;
+    dialog.showModal();
+  };
+  return div;
+}
+
+async function load(reset) {
+  if (reset) {
+    timeline.replaceChildren();
+    next = "";
+  }
+  const q = query();
+  if (next) q.set("after", next);
+  try {
+    const page = await api("events?" + q);
+    for (const event of page.events) {
+      dayList(dayOf(event), false).append(card(event));
+    }
+    next = page.next || "";
+  } catch (err) {
+    timeline.textContent = "Error: " + err.message;
+    next = "";
+  }
+  more.hidden = !next;
+  document.getElementById("empty").hidden = timeline.childElementCount > 0;
+}
+
+function showPreview() {
+  const section = document.getElementById("preview");
+  const stream = filters.stream.value;
+  section.hidden = !stream;
+  const img = section.querySelector("img");
+  if (!stream) {
+    img.removeAttribute("src");
+    return;
+  }
+  const q = new URLSearchParams({ stream });
+  if (key()) q.set("key", key());
+  img.src = "/preview?" + q;
+}
+
+// live events matching the filters are added to the top of the timeline
+function connect() {
+  if (socket) socket.close();
+  const q = query();
+  q.delete("from");
+  q.delete("to");
+  if (key()) q.set("key", key());
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  socket = new WebSocket(`${scheme}//${location.host}/ws?${q}`);
+  const live = document.getElementById("live");
+  socket.onopen = () => live.classList.add("on");
+  socket.onclose = (e) => {
+    live.classList.remove("on");
+    if (e.target === socket) setTimeout(connect, 5000);
+  };
+  socket.onmessage = (msg) => {
+    if (filters.to.value) return;
+    const e = JSON.parse(msg.data);
+    const event = {
+      created: e.created,
+      count: e.count,
+      class: e.class,
+      confidence: Math.round(e.confidence * 100),
+      stream_name: e.stream_name,
+      stream: e.stream,
+      snapshot_url: e.snapshot_url,
+    };
+    const c = card(event);
+    c.classList.add("new");
+    dayList(dayOf(event), true).prepend(c);
+    document.getElementById("empty").hidden = true;
+  };
+}
+
+async function init() {
+  filters.key.value = localStorage.getItem("apiKey") || "";
+  try {
+    const [streams, classes] = await Promise.all([api("streams"), api("classes")]);
+    for (const s of streams) {
+      filters.stream.add(new Option(s.name || s.address, s.address));
+    }
+    for (const c of classes) {
+      filters.class.add(new Option(c, c));
+    }
+  } catch (err) {
+    timeline.textContent = "Error: " + err.message;
+  }
+  load(true);
+  showPreview();
+  connect();
+}
+
+filters.onsubmit = (e) => {
+  e.preventDefault();
+  localStorage.setItem("apiKey", key());
+  load(true);
+  showPreview();
+  connect();
+};
+more.onclick = () => load(false);
+init();
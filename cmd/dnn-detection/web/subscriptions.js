@@ -0,0 +1,178 @@
+// Self-service page of the observers: sign in with an emailed link and
+// manage the own subscriptions through the /me endpoints.
+"use strict";
+
+const form = document.getElementById("subscription");
+const statusLine = document.getElementById("status");
+let token = "";
+let streams = [];
+let current = null;
+
+async function me(method, path, body) {
+  const headers = { Authorization: "Bearer " + token };
+  if (body !== undefined) headers["Content-Type"] = "application/json";
+  const resp = await fetch("/me" + path, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (resp.status === 401) {
+    sessionStorage.removeItem("observerToken");
+    showLogin();
+    throw new Error("The sign-in link has expired, request a new one.");
+  }
+  if (!resp.ok) {
+    throw new Error((await resp.json()).error || resp.statusText);
+  }
+  return resp.status === 204 ? null : resp.json();
+}
+
+async function run(action, done) {
+  try {
+    await action();
+    statusLine.textContent = done || "";
+  } catch (err) {
+    statusLine.textContent = err.message;
+  }
+}
+
+function showLogin() {
+  document.getElementById("login").hidden = false;
+  document.getElementById("manage").hidden = true;
+}
+
+function streamName(id) {
+  const s = streams.find((s) => s.id === id);
+  return s ? s.name : `Stream ${id}`;
+}
+
+async function loadSubscriptions() {
+  const subscriptions = await me("GET", "/subscriptions");
+  const body = document.querySelector("#subscriptions tbody");
+  body.replaceChildren();
+  for (const s of subscriptions) {
+    const tr = document.createElement("tr");
+    const edit = document.createElement("button");
+    edit.textContent = "Edit";
+    edit.onclick = () => openForm(s);
+    const remove = document.createElement("button");
+    remove.textContent = "Remove";
+    remove.onclick = () => {
+      if (!confirm(`Remove the subscription of ${streamName(s.stream_id)}?`)) return;
+      run(async () => {
+        await me("DELETE", `/subscriptions/${s.id}`);
+        await loadSubscriptions();
+      }, "Subscription removed.");
+    };
+    for (const c of [streamName(s.stream_id), s.channel, s.mode, s.alert ? "on" : "off"]) {
+      const td = document.createElement("td");
+      td.textContent = c;
+      tr.append(td);
+    }
+    const td = document.createElement("td");
+    td.append(edit, " ", remove);
+    tr.append(td);
+    body.append(tr);
+  }
+  document.getElementById("none").hidden = subscriptions.length > 0;
+}
+
+function selectZones(stream, selected) {
+  const s = streams.find((s) => s.id === Number(stream));
+  form.zones.replaceChildren(...(s ? s.zones : []).map((z) => new Option(z, z, false, selected.includes(z))));
+  form.zones.parentElement.hidden = form.zones.options.length === 0;
+}
+
+function openForm(s) {
+  current = s;
+  form.hidden = false;
+  form.stream_id.value = s.stream_id || (streams[0] && streams[0].id) || "";
+  form.alert.checked = s.alert;
+  for (const name of ["channel", "recipient", "mode", "alert_interval", "burst_window", "quiet_start", "quiet_end", "quiet_action"]) {
+    form[name].value = s[name] || "";
+  }
+  for (const o of form.classes.options) o.selected = (s.classes || []).includes(o.value);
+  selectZones(form.stream_id.value, s.zones || []);
+  form.channel.onchange();
+  form.scrollIntoView();
+}
+
+function selected(select) {
+  return [...select.selectedOptions].map((o) => o.value);
+}
+
+form.stream_id.onchange = () => selectZones(form.stream_id.value, []);
+form.channel.onchange = () => {
+  form.recipient.parentElement.hidden = form.channel.value === "email";
+};
+
+form.onsubmit = (e) => {
+  e.preventDefault();
+  const s = {
+    stream_id: Number(form.stream_id.value),
+    alert: form.alert.checked,
+    // not on the form, kept as set by the operators
+    confidence: current.confidence || 0,
+    channel: form.channel.value,
+    recipient: form.channel.value === "email" ? "" : form.recipient.value,
+    mode: form.mode.value,
+    alert_interval: form.alert_interval.value,
+    burst_window: form.burst_window.value,
+    classes: selected(form.classes),
+    zones: selected(form.zones),
+    quiet_start: form.quiet_start.value,
+    quiet_end: form.quiet_end.value,
+    quiet_action: form.quiet_action.value,
+  };
+  run(async () => {
+    if (current.id) await me("PUT", `/subscriptions/${current.id}`, s);
+    else await me("POST", "/subscriptions", s);
+    form.hidden = true;
+    await loadSubscriptions();
+  }, "Subscription saved.");
+};
+
+document.getElementById("cancel").onclick = () => {
+  form.hidden = true;
+};
+document.getElementById("add").onclick = () => {
+  openForm({ alert: true, channel: "email", mode: "event", quiet_action: "suppress" });
+};
+
+document.getElementById("login-form").onsubmit = (e) => {
+  e.preventDefault();
+  const email = e.target.email.value;
+  run(async () => {
+    const resp = await fetch("/me/login", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ email }),
+    });
+    if (!resp.ok) throw new Error((await resp.json()).error || resp.statusText);
+  }, `If ${email} has subscriptions, a sign-in link has been sent to it.`);
+};
+
+async function init() {
+  // the token of the sign-in link is kept for the session and removed
+  // from the address bar
+  const params = new URLSearchParams(location.search);
+  if (params.has("token")) {
+    sessionStorage.setItem("observerToken", params.get("token"));
+    history.replaceState(null, "", location.pathname);
+  }
+  token = sessionStorage.getItem("observerToken") || "";
+  if (!token) {
+    showLogin();
+    return;
+  }
+  const [observer, channels, classes] = await Promise.all([me("GET", ""), me("GET", "/channels"), me("GET", "/classes")]);
+  streams = await me("GET", "/streams");
+  document.getElementById("observer").textContent = observer.email;
+  form.stream_id.replaceChildren(...streams.map((s) => new Option(s.name, s.id)));
+  form.channel.replaceChildren(...channels.map((c) => new Option(c, c)));
+  form.classes.replaceChildren(...classes.map((c) => new Option(c, c)));
+  document.getElementById("manage").hidden = false;
+  await loadSubscriptions();
+}
+
+run(init);
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// the posts still being retried this long after Close are cancelled
+const webhookDrainTimeout = 10 * time.Second
+
+// webhookSink posts every event to the WEBHOOK_URLS, signed the same way
+// as the notifications of the webhook subscriptions. The posts are sent
+// by a fixed number of workers so that the retries do not block the
+// publishing, and dropped when the queue is full.
+type webhookSink struct {
+	client *notify.WebhookClient
+	urls   []string
+	// format of the events, json or cloudevents
+	format string
+	posts  chan webhookPost
+	// cancels the retries of the posts left when the drain times out
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.RWMutex
+	closed  bool
+	workers sync.WaitGroup
+}
+
+// webhookPost is an event waiting to be posted to a url
+type webhookPost struct {
+	event       detectionEvent
+	url         string
+	payload     []byte
+	contentType string
+}
+
+func newWebhookSink(secret string, urls []string, format string, workers, queue int) *webhookSink {
+	for i := range urls {
+		urls[i] = strings.TrimSpace(urls[i])
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &webhookSink{client: notify.NewWebhookClient(secret), urls: urls, format: format, posts: make(chan webhookPost, queue), ctx: ctx, cancel: cancel}
+	w.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go w.work()
+	}
+	return w
+}
+
+func (w *webhookSink) writeEvent(event detectionEvent) error {
+	payload, contentType, err := encodeEvent(w.format, event)
+	if err != nil {
+		return err
+	}
+	// the read lock keeps the queue open until the posts are in it
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return nil
+	}
+	for _, url := range w.urls {
+		select {
+		case w.posts <- webhookPost{event, url, payload, contentType}:
+		default:
+			eventLogger(event).Warn("Webhook dropped, the queue is full", "url", url, "queue", cap(w.posts))
+		}
+	}
+	return nil
+}
+
+// work posts the queued events until the queue is closed
+func (w *webhookSink) work() {
+	defer w.workers.Done()
+	for p := range w.posts {
+		if err := w.client.PostWithRetry(w.ctx, p.url, p.payload, p.contentType); err != nil {
+			eventLogger(p.event).Error("Error posting webhook", "url", p.url, "err", err)
+		}
+	}
+}
+
+// Close posts the queued events, the retries still running after
+// webhookDrainTimeout are cancelled
+func (w *webhookSink) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.posts)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(webhookDrainTimeout):
+		logger("sink").Warn("Webhooks still being sent, cancelling them", "timeout", webhookDrainTimeout)
+		w.cancel()
+		<-done
+	}
+	w.cancel()
+	return w.client.Close()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/osmundi/gocv-stream-events/pkg/store"
+)
+
+// browserDatabase returns the database scoped by the api key of the
+// request, writing the error response on failure. The browsers cannot set
+// the headers of websocket or <img> requests, so the key can also be given
+// with ?key=.
+func browserDatabase(w http.ResponseWriter, r *http.Request) (store.Database, bool) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	database, err := databaseForRequest(r, key, false)
+	if err == sql.ErrNoRows {
+		http.Error(w, "invalid api key", http.StatusUnauthorized)
+		return database, false
+	}
+	if err != nil {
+		logger("api").Error("Error serving websocket", "path", r.URL.Path, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return database, false
+	}
+	return database, true
+}
+
+// same origin only, like the default of the browsers for fetch
+var wsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+// wsHandler streams the detection events to the websocket clients of /ws
+// as they happen, in the json format of the other sinks (eventPayload).
+// The ?stream= and ?class= parameters, which can be repeated, limit the
+// events.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	database, ok := browserDatabase(w, r)
+	if !ok {
+		return
+	}
+	streams := stringSet(r.URL.Query()["stream"])
+	classes := stringSet(r.URL.Query()["class"])
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has responded with the error
+		return
+	}
+	defer conn.Close()
+
+	// the client only sends control messages, reading detects the close
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	events := broadcaster.subscribe()
+	defer broadcaster.unsubscribe(events)
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+				return
+			}
+			if database.Org() != 0 && event.info.Org != database.Org() {
+				continue
+			}
+			if (len(streams) > 0 && !streams[event.stream]) || (len(classes) > 0 && !classes[event.label]) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(newEventPayload(event)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/detect"
+)
+
+// leaseWorker spreads the streams of the database on several instances
+// started with -worker. Every heartbeat (a third of the lease ttl) the
+// worker renews the leases of its streams and claims unleased streams up
+// to its share, an even split of the enabled streams on the live workers.
+// A worker with more than its share gives up one stream per heartbeat, so
+// the streams move to a new worker gradually. The streams of a worker that
+// stops are claimed by the others after their leases have expired.
+//
+// All the workers write to the same database, and the outbox is shared,
+// but the oldest live worker alone runs the background jobs (e.g. the
+// digests). A leader that cannot confirm its place for half the ttl stops
+// the jobs before another worker takes over.
+type leaseWorker struct {
+	id       string
+	host     string
+	capacity int
+	ttl      time.Duration
+
+	mu sync.Mutex
+	// streams whose lease the worker holds, and the ones with a pipeline
+	held    map[string]bool
+	running map[string]bool
+	// capture id of the next pipeline
+	nextID int
+	// stops the background jobs, nil when the worker is not the leader
+	stopJobs context.CancelFunc
+	// when the worker was last seen as the leader after a heartbeat
+	confirmed time.Time
+	// the pipelines of the leased streams
+	pipelines streamGroup
+}
+
+// worker is nil unless the streams are shared with -worker
+var worker *leaseWorker
+
+// newLeaseWorker returns the worker of the WORKER_* environment, the id
+// is the host and the pid by default
+func newLeaseWorker() *leaseWorker {
+	host, _ := os.Hostname()
+	id := os.Getenv("WORKER_ID")
+	if id == "" {
+		id = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return &leaseWorker{
+		id:       id,
+		host:     host,
+		capacity: envInt("WORKER_CAPACITY", 0),
+		ttl:      envDuration("WORKER_LEASE_TTL", 30*time.Second),
+		held:     map[string]bool{},
+		running:  map[string]bool{},
+	}
+}
+
+// run keeps the leases of the worker until ctx is done, and then waits
+// for the pipelines, which release their leases when they end
+func (w *leaseWorker) run(ctx context.Context) {
+	logger("worker").Info("Worker started", "worker", w.id, "ttl", w.ttl)
+	for ctx.Err() == nil {
+		w.lead(ctx, w.heartbeat(ctx))
+		select {
+		case <-time.After(w.ttl / 3):
+		case <-ctx.Done():
+		}
+	}
+	if err := w.pipelines.wait(ctx); err != nil {
+		logger("worker").Error("Streams failed", "err", err)
+	}
+}
+
+// heartbeat renews the worker and its leases and balances the streams,
+// returns false if the worker could not renew itself
+func (w *leaseWorker) heartbeat(ctx context.Context) bool {
+	if err := db.WorkerHeartbeat(w.id, w.host, w.capacity); err != nil {
+		logger("worker").Error("Error sending worker heartbeat", "worker", w.id, "err", err)
+		return false
+	}
+	held, err := db.RenewLeases(w.id, w.ttl)
+	if err != nil {
+		logger("worker").Error("Error renewing leases", "worker", w.id, "err", err)
+		return true
+	}
+
+	w.mu.Lock()
+	w.held = map[string]bool{}
+	for _, address := range held {
+		w.held[address] = true
+	}
+	for address := range w.running {
+		if !w.held[address] {
+			logger("worker").Warn("Lease lost", "worker", w.id, "device", address)
+			detect.Pipelines.Reload(address)
+		}
+	}
+	w.mu.Unlock()
+
+	share, err := db.StreamShare(w.ttl)
+	if err != nil {
+		logger("worker").Error("Error reading stream share", "worker", w.id, "err", err)
+		return true
+	}
+	if w.capacity > 0 && share > w.capacity {
+		share = w.capacity
+	}
+	switch {
+	case len(held) > share:
+		w.release(held[len(held)-1])
+	case len(held) < share:
+		claimed, err := db.ClaimStreams(w.id, share-len(held), w.ttl)
+		if err != nil {
+			logger("worker").Error("Error claiming streams", "worker", w.id, "err", err)
+		}
+		for _, address := range claimed {
+			w.start(ctx, address)
+		}
+	}
+	return true
+}
+
+// start runs the pipeline of a claimed stream. The lease is released when
+// the pipeline ends, e.g. when the device closes, so that the stream is
+// claimed again on a later heartbeat, by this or another worker.
+func (w *leaseWorker) start(ctx context.Context, address string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.held[address] = true
+	if w.running[address] {
+		// the pipeline of a lost lease has not stopped yet
+		return
+	}
+	w.running[address] = true
+	captureId := w.nextID
+	w.nextID++
+	logger("worker").Info("Lease claimed", "worker", w.id, "device", address)
+
+	w.pipelines.goPipeline(ctx, address, captureId, func(error) {
+		w.mu.Lock()
+		delete(w.running, address)
+		delete(w.held, address)
+		w.mu.Unlock()
+		if err := db.ReleaseLease(w.id, address); err != nil {
+			logger("worker").Error("Error releasing lease", "worker", w.id, "device", address, "err", err)
+		}
+	})
+}
+
+// release gives the stream to the other workers and stops its pipeline.
+// The new worker may start reading the stream before the pipeline here
+// has noticed the stop.
+func (w *leaseWorker) release(address string) {
+	logger("worker").Info("Lease released", "worker", w.id, "device", address)
+	if err := db.ReleaseLease(w.id, address); err != nil {
+		logger("worker").Error("Error releasing lease", "worker", w.id, "device", address, "err", err)
+		return
+	}
+	w.mu.Lock()
+	delete(w.held, address)
+	w.mu.Unlock()
+	detect.Pipelines.Reload(address)
+}
+
+// holds tells whether the worker still has the lease of the stream
+func (w *leaseWorker) holds(address string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.held[address]
+}
+
+// lead starts the background jobs when the worker becomes the oldest live
+// worker, alive is whether its heartbeat succeeded. The jobs are stopped
+// when another worker leads, or when the leadership has not been
+// confirmed for half the ttl, before the others see the worker as dead.
+func (w *leaseWorker) lead(ctx context.Context, alive bool) {
+	if alive {
+		leader, err := db.LeadingWorker(w.ttl)
+		if err != nil && err != sql.ErrNoRows {
+			logger("worker").Error("Error reading leading worker", "worker", w.id, "err", err)
+		} else if leader == w.id {
+			w.confirmed = time.Now()
+		} else {
+			w.confirmed = time.Time{}
+		}
+	}
+	leading := time.Since(w.confirmed) < w.ttl/2
+	switch {
+	case leading && w.stopJobs == nil:
+		logger("worker").Info("Worker runs the background jobs", "worker", w.id)
+		var jobs context.Context
+		jobs, w.stopJobs = context.WithCancel(ctx)
+		startBackgroundJobs(jobs)
+	case !leading && w.stopJobs != nil:
+		logger("worker").Warn("Worker stopped the background jobs, it no longer leads", "worker", w.id)
+		w.stopJobs()
+		w.stopJobs = nil
+	}
+}
@@ -0,0 +1,419 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: pb/detection.proto
+
+// gRPC API of the detector. Regenerate the Go code with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/detection.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Detections_GetEvents_FullMethodName       = "/gocv.detection.v1.Detections/GetEvents"
+	Detections_GetEvent_FullMethodName        = "/gocv.detection.v1.Detections/GetEvent"
+	Detections_ListStreams_FullMethodName     = "/gocv.detection.v1.Detections/ListStreams"
+	Detections_CreateStream_FullMethodName    = "/gocv.detection.v1.Detections/CreateStream"
+	Detections_UpdateStream_FullMethodName    = "/gocv.detection.v1.Detections/UpdateStream"
+	Detections_DeleteStream_FullMethodName    = "/gocv.detection.v1.Detections/DeleteStream"
+	Detections_WatchDetections_FullMethodName = "/gocv.detection.v1.Detections/WatchDetections"
+	Detections_DetectFrame_FullMethodName     = "/gocv.detection.v1.Detections/DetectFrame"
+)
+
+// DetectionsClient is the client API for Detections service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DetectionsClient interface {
+	// GetEvents returns a page of the saved events, newest first
+	GetEvents(ctx context.Context, in *GetEventsRequest, opts ...grpc.CallOption) (*GetEventsResponse, error)
+	// GetEvent returns a single event with its detections
+	GetEvent(ctx context.Context, in *GetEventRequest, opts ...grpc.CallOption) (*Event, error)
+	ListStreams(ctx context.Context, in *ListStreamsRequest, opts ...grpc.CallOption) (*ListStreamsResponse, error)
+	CreateStream(ctx context.Context, in *Stream, opts ...grpc.CallOption) (*Stream, error)
+	// UpdateStream replaces the stream with the id
+	UpdateStream(ctx context.Context, in *Stream, opts ...grpc.CallOption) (*Stream, error)
+	// DeleteStream fails for streams that have events or subscriptions
+	DeleteStream(ctx context.Context, in *DeleteStreamRequest, opts ...grpc.CallOption) (*DeleteStreamResponse, error)
+	// WatchDetections pushes the events as they are detected. A consumer
+	// that falls behind misses events instead of slowing the detection.
+	WatchDetections(ctx context.Context, in *WatchDetectionsRequest, opts ...grpc.CallOption) (Detections_WatchDetectionsClient, error)
+	// DetectFrame runs the model of the detector on a single frame, e.g. for
+	// capture agents that cannot run the network themselves. Nothing is
+	// saved.
+	DetectFrame(ctx context.Context, in *DetectFrameRequest, opts ...grpc.CallOption) (*DetectFrameResponse, error)
+}
+
+type detectionsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDetectionsClient(cc grpc.ClientConnInterface) DetectionsClient {
+	return &detectionsClient{cc}
+}
+
+func (c *detectionsClient) GetEvents(ctx context.Context, in *GetEventsRequest, opts ...grpc.CallOption) (*GetEventsResponse, error) {
+	out := new(GetEventsResponse)
+	err := c.cc.Invoke(ctx, Detections_GetEvents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectionsClient) GetEvent(ctx context.Context, in *GetEventRequest, opts ...grpc.CallOption) (*Event, error) {
+	out := new(Event)
+	err := c.cc.Invoke(ctx, Detections_GetEvent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectionsClient) ListStreams(ctx context.Context, in *ListStreamsRequest, opts ...grpc.CallOption) (*ListStreamsResponse, error) {
+	out := new(ListStreamsResponse)
+	err := c.cc.Invoke(ctx, Detections_ListStreams_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectionsClient) CreateStream(ctx context.Context, in *Stream, opts ...grpc.CallOption) (*Stream, error) {
+	out := new(Stream)
+	err := c.cc.Invoke(ctx, Detections_CreateStream_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectionsClient) UpdateStream(ctx context.Context, in *Stream, opts ...grpc.CallOption) (*Stream, error) {
+	out := new(Stream)
+	err := c.cc.Invoke(ctx, Detections_UpdateStream_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectionsClient) DeleteStream(ctx context.Context, in *DeleteStreamRequest, opts ...grpc.CallOption) (*DeleteStreamResponse, error) {
+	out := new(DeleteStreamResponse)
+	err := c.cc.Invoke(ctx, Detections_DeleteStream_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *detectionsClient) WatchDetections(ctx context.Context, in *WatchDetectionsRequest, opts ...grpc.CallOption) (Detections_WatchDetectionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Detections_ServiceDesc.Streams[0], Detections_WatchDetections_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &detectionsWatchDetectionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Detections_WatchDetectionsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type detectionsWatchDetectionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *detectionsWatchDetectionsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *detectionsClient) DetectFrame(ctx context.Context, in *DetectFrameRequest, opts ...grpc.CallOption) (*DetectFrameResponse, error) {
+	out := new(DetectFrameResponse)
+	err := c.cc.Invoke(ctx, Detections_DetectFrame_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DetectionsServer is the server API for Detections service.
+// All implementations must embed UnimplementedDetectionsServer
+// for forward compatibility
+type DetectionsServer interface {
+	// GetEvents returns a page of the saved events, newest first
+	GetEvents(context.Context, *GetEventsRequest) (*GetEventsResponse, error)
+	// GetEvent returns a single event with its detections
+	GetEvent(context.Context, *GetEventRequest) (*Event, error)
+	ListStreams(context.Context, *ListStreamsRequest) (*ListStreamsResponse, error)
+	CreateStream(context.Context, *Stream) (*Stream, error)
+	// UpdateStream replaces the stream with the id
+	UpdateStream(context.Context, *Stream) (*Stream, error)
+	// DeleteStream fails for streams that have events or subscriptions
+	DeleteStream(context.Context, *DeleteStreamRequest) (*DeleteStreamResponse, error)
+	// WatchDetections pushes the events as they are detected. A consumer
+	// that falls behind misses events instead of slowing the detection.
+	WatchDetections(*WatchDetectionsRequest, Detections_WatchDetectionsServer) error
+	// DetectFrame runs the model of the detector on a single frame, e.g. for
+	// capture agents that cannot run the network themselves. Nothing is
+	// saved.
+	DetectFrame(context.Context, *DetectFrameRequest) (*DetectFrameResponse, error)
+	mustEmbedUnimplementedDetectionsServer()
+}
+
+// UnimplementedDetectionsServer must be embedded to have forward compatible implementations.
+type UnimplementedDetectionsServer struct {
+}
+
+func (UnimplementedDetectionsServer) GetEvents(context.Context, *GetEventsRequest) (*GetEventsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEvents not implemented")
+}
+func (UnimplementedDetectionsServer) GetEvent(context.Context, *GetEventRequest) (*Event, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEvent not implemented")
+}
+func (UnimplementedDetectionsServer) ListStreams(context.Context, *ListStreamsRequest) (*ListStreamsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListStreams not implemented")
+}
+func (UnimplementedDetectionsServer) CreateStream(context.Context, *Stream) (*Stream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateStream not implemented")
+}
+func (UnimplementedDetectionsServer) UpdateStream(context.Context, *Stream) (*Stream, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateStream not implemented")
+}
+func (UnimplementedDetectionsServer) DeleteStream(context.Context, *DeleteStreamRequest) (*DeleteStreamResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteStream not implemented")
+}
+func (UnimplementedDetectionsServer) WatchDetections(*WatchDetectionsRequest, Detections_WatchDetectionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchDetections not implemented")
+}
+func (UnimplementedDetectionsServer) DetectFrame(context.Context, *DetectFrameRequest) (*DetectFrameResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DetectFrame not implemented")
+}
+func (UnimplementedDetectionsServer) mustEmbedUnimplementedDetectionsServer() {}
+
+// UnsafeDetectionsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DetectionsServer will
+// result in compilation errors.
+type UnsafeDetectionsServer interface {
+	mustEmbedUnimplementedDetectionsServer()
+}
+
+func RegisterDetectionsServer(s grpc.ServiceRegistrar, srv DetectionsServer) {
+	s.RegisterService(&Detections_ServiceDesc, srv)
+}
+
+func _Detections_GetEvents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectionsServer).GetEvents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detections_GetEvents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectionsServer).GetEvents(ctx, req.(*GetEventsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detections_GetEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectionsServer).GetEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detections_GetEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectionsServer).GetEvent(ctx, req.(*GetEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detections_ListStreams_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListStreamsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectionsServer).ListStreams(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detections_ListStreams_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectionsServer).ListStreams(ctx, req.(*ListStreamsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detections_CreateStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Stream)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectionsServer).CreateStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detections_CreateStream_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectionsServer).CreateStream(ctx, req.(*Stream))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detections_UpdateStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Stream)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectionsServer).UpdateStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detections_UpdateStream_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectionsServer).UpdateStream(ctx, req.(*Stream))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detections_DeleteStream_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteStreamRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectionsServer).DeleteStream(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detections_DeleteStream_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectionsServer).DeleteStream(ctx, req.(*DeleteStreamRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Detections_WatchDetections_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchDetectionsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DetectionsServer).WatchDetections(m, &detectionsWatchDetectionsServer{stream})
+}
+
+type Detections_WatchDetectionsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type detectionsWatchDetectionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *detectionsWatchDetectionsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Detections_DetectFrame_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DetectFrameRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DetectionsServer).DetectFrame(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Detections_DetectFrame_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DetectionsServer).DetectFrame(ctx, req.(*DetectFrameRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Detections_ServiceDesc is the grpc.ServiceDesc for Detections service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Detections_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gocv.detection.v1.Detections",
+	HandlerType: (*DetectionsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetEvents",
+			Handler:    _Detections_GetEvents_Handler,
+		},
+		{
+			MethodName: "GetEvent",
+			Handler:    _Detections_GetEvent_Handler,
+		},
+		{
+			MethodName: "ListStreams",
+			Handler:    _Detections_ListStreams_Handler,
+		},
+		{
+			MethodName: "CreateStream",
+			Handler:    _Detections_CreateStream_Handler,
+		},
+		{
+			MethodName: "UpdateStream",
+			Handler:    _Detections_UpdateStream_Handler,
+		},
+		{
+			MethodName: "DeleteStream",
+			Handler:    _Detections_DeleteStream_Handler,
+		},
+		{
+			MethodName: "DetectFrame",
+			Handler:    _Detections_DetectFrame_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchDetections",
+			Handler:       _Detections_WatchDetections_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pb/detection.proto",
+}
@@ -0,0 +1,12 @@
+package detect
+
+import "golang.org/x/sys/unix"
+
+// pinThread restricts the current os thread to the cpus
+func pinThread(cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	return unix.SchedSetaffinity(0, &set)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package detect
+
+import "errors"
+
+// pinThread is only supported on linux
+func pinThread(cpus []int) error {
+	return errors.New("cpu affinity is only supported on linux")
+}
@@ -0,0 +1,103 @@
+package detect
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+
+	"gocv.io/x/gocv"
+
+	"github.com/osmundi/gocv-stream-events/pkg/sources"
+)
+
+// BenchmarkResult is the speed of a model on the frames of a source, e.g.
+// to choose between the full and the tiny model of a host
+type BenchmarkResult struct {
+	Source string `json:"source"`
+	Frames int    `json:"frames"`
+	// frames per second through the whole detection, without the capture
+	FPS     float64 `json:"fps"`
+	Seconds float64 `json:"seconds"`
+	// the first forward pass, which initializes the network, is not in
+	// the percentiles
+	WarmupMs       float64 `json:"warmup_ms"`
+	InferenceP50Ms float64 `json:"inference_p50_ms"`
+	InferenceP95Ms float64 `json:"inference_p95_ms"`
+	InferenceMaxMs float64 `json:"inference_max_ms"`
+	// go heap allocations per frame, with the capture
+	AllocsPerFrame float64 `json:"allocs_per_frame"`
+	// cpu time of the process per second, over 1 when several cores are
+	// used (linux only)
+	CPUUtilization float64 `json:"cpu_utilization"`
+	// share of the time spent in the forward passes, with a cuda target
+	GPUUtilization *float64 `json:"gpu_utilization,omitempty"`
+	// peak resident memory of the process, with the native memory of
+	// opencv (linux only)
+	MaxRSSBytes   int64  `json:"max_rss_bytes"`
+	HeapBytes     uint64 `json:"heap_bytes"`
+	OpenCVThreads int    `json:"opencv_threads"`
+}
+
+// Benchmark runs the detector on the frames of the source one at a time
+// until the source ends or n frames have been analyzed
+func (d *Detector) Benchmark(source string, n int, settings Settings) (BenchmarkResult, error) {
+	result := BenchmarkResult{Source: source, OpenCVThreads: gocv.GetNumThreads()}
+	webcam, err := sources.Open(source)
+	if err != nil {
+		return result, err
+	}
+	defer webcam.Close()
+
+	img := gocv.NewMat()
+	defer img.Close()
+	if !webcam.Read(&img) || img.Empty() {
+		return result, fmt.Errorf("no frames in %s", source)
+	}
+	_, warmup := d.detect(img, settings)
+	result.WarmupMs = milliseconds(warmup)
+
+	var passes []time.Duration
+	if n > 0 {
+		// allocated before the measured frames
+		passes = make([]time.Duration, 0, n)
+	}
+	var inference time.Duration
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	mallocs := mem.Mallocs
+	cpuStart, _ := processUsage()
+	start := time.Now()
+	for len(passes) < n && webcam.Read(&img) && !img.Empty() {
+		_, took := d.detect(img, settings)
+		passes = append(passes, took)
+		inference += took
+	}
+	elapsed := time.Since(start)
+	cpuEnd, maxRSS := processUsage()
+	runtime.ReadMemStats(&mem)
+	if len(passes) == 0 {
+		return result, fmt.Errorf("no frames after the first one in %s", source)
+	}
+
+	sort.Slice(passes, func(i, j int) bool { return passes[i] < passes[j] })
+	result.Frames = len(passes)
+	result.Seconds = elapsed.Seconds()
+	result.FPS = float64(len(passes)) / elapsed.Seconds()
+	result.InferenceP50Ms = milliseconds(passes[(len(passes)-1)*50/100])
+	result.InferenceP95Ms = milliseconds(passes[(len(passes)-1)*95/100])
+	result.InferenceMaxMs = milliseconds(passes[len(passes)-1])
+	result.CPUUtilization = (cpuEnd - cpuStart).Seconds() / elapsed.Seconds()
+	if d.target == gocv.NetTargetCUDA || d.target == gocv.NetTargetCUDAFP16 {
+		gpu := inference.Seconds() / elapsed.Seconds()
+		result.GPUUtilization = &gpu
+	}
+	result.AllocsPerFrame = float64(mem.Mallocs-mallocs) / float64(len(passes))
+	result.MaxRSSBytes = maxRSS
+	result.HeapBytes = mem.HeapAlloc
+	return result, nil
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,133 @@
+package detect
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/sources"
+)
+
+// DefaultCheckpointInterval is how often the position of a video file is
+// saved when the pipeline does not set it
+const DefaultCheckpointInterval = 10 * time.Second
+
+// Checkpoints keeps the positions of the video files, e.g. in the database
+type Checkpoints interface {
+	// VideoCheckpoint returns the frame to resume the file from, 0 for the
+	// start
+	VideoCheckpoint(source string) (int64, error)
+	SaveVideoCheckpoint(source string, frame int64) error
+}
+
+// checkpoint tracks the position of the video file of a pipeline. The
+// saved position is after the last frame that has gone through all the
+// stages with all the frames before it, so a resumed pipeline neither
+// skips the frames that were still being analyzed nor repeats their
+// events. A frame with detections is finished once its event has been
+// saved, a dropped frame when it is dropped.
+type checkpoint struct {
+	source   string
+	store    Checkpoints
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu sync.Mutex
+	// the position after the last finished frame
+	done int64
+	// the positions of the frames in the stages in the order of the
+	// capture, and the ones of them that have finished out of order
+	pending   []int64
+	completed map[int64]bool
+	saved     int64
+	savedAt   time.Time
+}
+
+// newCheckpoint returns the checkpoint of the pipeline, nil for a live
+// source or without Checkpoints
+func (p Pipeline) newCheckpoint(webcam *sources.Capture) *checkpoint {
+	if p.Checkpoints == nil || webcam.Live() {
+		return nil
+	}
+	interval := p.CheckpointInterval
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	return &checkpoint{source: p.Device, store: p.Checkpoints, interval: interval, logger: p.log(logCapture), completed: map[int64]bool{}, savedAt: time.Now()}
+}
+
+// resume moves the file to the saved position
+func (c *checkpoint) resume(webcam *sources.Capture) {
+	if c == nil {
+		return
+	}
+	frame, err := c.store.VideoCheckpoint(c.source)
+	if err != nil {
+		c.logger.Error("Error reading checkpoint", "err", err)
+		return
+	}
+	if frame > 0 {
+		c.logger.Info("Resuming from checkpoint", "frame", frame)
+		webcam.SeekFrame(frame)
+	}
+	c.done, c.saved = frame, frame
+}
+
+// captured records that the frame at the position has entered the stages
+func (c *checkpoint) captured(position int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(c.pending, position)
+}
+
+// finished records that the frame at the position has gone through the
+// stages. The position is saved only after every frame captured before
+// it has finished too.
+func (c *checkpoint) finished(position int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed[position] = true
+	for len(c.pending) > 0 && c.completed[c.pending[0]] {
+		delete(c.completed, c.pending[0])
+		c.done = c.pending[0]
+		c.pending = c.pending[1:]
+	}
+}
+
+// save saves the position if the interval has passed since the previous
+// save, or at once with force
+func (c *checkpoint) save(force bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	done, saved := c.done, c.saved
+	due := force || time.Since(c.savedAt) >= c.interval
+	c.mu.Unlock()
+	if !due || done == saved {
+		return
+	}
+	if err := c.store.SaveVideoCheckpoint(c.source, done); err != nil {
+		c.logger.Error("Error saving checkpoint", "err", err)
+		return
+	}
+	c.mu.Lock()
+	c.saved, c.savedAt = done, time.Now()
+	c.mu.Unlock()
+}
+
+// reset starts the file over on the next run, after it has ended
+func (c *checkpoint) reset() {
+	if c == nil {
+		return
+	}
+	if err := c.store.SaveVideoCheckpoint(c.source, 0); err != nil {
+		c.logger.Error("Error resetting checkpoint", "err", err)
+	}
+}
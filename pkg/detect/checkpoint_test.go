@@ -0,0 +1,78 @@
+package detect
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// memoryCheckpoints keeps the checkpoints of the test
+type memoryCheckpoints map[string]int64
+
+func (m memoryCheckpoints) VideoCheckpoint(source string) (int64, error) {
+	return m[source], nil
+}
+
+func (m memoryCheckpoints) SaveVideoCheckpoint(source string, frame int64) error {
+	m[source] = frame
+	return nil
+}
+
+func TestCheckpointFinishedInOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		captured []int64
+		finished []int64
+		want     int64
+	}{
+		{"nothing finished", []int64{1, 2, 3}, nil, 0},
+		{"in order", []int64{1, 2, 3}, []int64{1, 2, 3}, 3},
+		{"later frame first", []int64{1, 2, 3}, []int64{2, 3}, 0},
+		{"gap filled", []int64{1, 2, 3}, []int64{3, 1, 2}, 3},
+		{"prefix only", []int64{1, 2, 3, 4}, []int64{1, 3, 4}, 1},
+		// the frames skipped by the capture never enter the stages
+		{"skipped positions", []int64{5, 10, 15}, []int64{10, 5}, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := memoryCheckpoints{}
+			c := &checkpoint{source: "video.mp4", store: store, interval: time.Hour, logger: slog.Default(), completed: map[int64]bool{}}
+			for _, position := range tt.captured {
+				c.captured(position)
+			}
+			for _, position := range tt.finished {
+				c.finished(position)
+			}
+			c.save(true)
+			if got := store["video.mp4"]; got != tt.want {
+				t.Errorf("saved position = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckpointDroppedFrame(t *testing.T) {
+	store := memoryCheckpoints{}
+	c := &checkpoint{source: "video.mp4", store: store, interval: time.Hour, logger: slog.Default(), completed: map[int64]bool{}}
+	pool := newMatPool(2)
+	defer pool.close()
+	q := newQueue("video.mp4", StageSink, 1, DropNewest)
+
+	// the second frame does not fit in the queue and is dropped, the first
+	// one is still waiting for the sink
+	for _, position := range []int64{1, 2} {
+		c.captured(position)
+		q.put(&frame{img: pool.get(), pool: pool, position: position, checkpoint: c})
+	}
+	c.save(true)
+	if got := store["video.mp4"]; got != 0 {
+		t.Fatalf("saved position = %d before the waiting frame finished", got)
+	}
+	f := <-q.frames
+	c.finished(f.position)
+	f.release()
+	c.save(true)
+	if got := store["video.mp4"]; got != 2 {
+		t.Errorf("saved position = %d, want 2", got)
+	}
+}
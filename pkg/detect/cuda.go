@@ -0,0 +1,16 @@
+//go:build cuda
+
+package detect
+
+import "gocv.io/x/gocv/cuda"
+
+// cudaDevices returns the number of the cuda devices
+func cudaDevices() int {
+	return cuda.GetCudaEnabledDeviceCount()
+}
+
+// useCUDADevice makes the device current for the os thread, the networks
+// initialized in the thread afterwards are placed on it
+func useCUDADevice(device int) {
+	cuda.SetDevice(device)
+}
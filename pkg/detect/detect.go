@@ -0,0 +1,181 @@
+// Package detect runs a deep neural network (yolo) on the frames of the
+// video sources and reports the detected objects.
+package detect
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+
+	"gocv.io/x/gocv"
+)
+
+// Object is a detected object in the pixel coordinates of the frame
+type Object struct {
+	Confidence               float32
+	Top, Left, Width, Height int
+	// class and confidence, e.g. "bird - 97%"
+	Label string
+	// path of the cropped image relative to the snapshot directory
+	Crop string
+}
+
+// blobMean is subtracted from the pixels of the blobs, i.e. nothing
+var blobMean = gocv.NewScalar(0, 0, 0, 0)
+
+var blue = color.RGBA{0, 0, 255, 0}
+var yellow = color.RGBA{0, 255, 0, 0}
+
+// ReadClasses reads the labels of the model, one per line (e.g. coco.names)
+func ReadClasses(path string) ([]string, error) {
+	var classes []string
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		classes = append(classes, scanner.Text())
+	}
+
+	return classes, scanner.Err()
+}
+
+// outputLayers returns the names of the output layers of the network,
+// the layers whose outputs are read after the forward pass
+func outputLayers(net gocv.Net) []string {
+	names := net.GetLayerNames()
+	var outputs []string
+	for _, l := range net.GetUnconnectedOutLayers() {
+		outputs = append(outputs, names[l-1])
+	}
+	return outputs
+}
+
+func drawBoundingBoxes(img gocv.Mat, detectedObjects []Object, window *gocv.Window) {
+	Annotate(&img, detectedObjects)
+	window.ResizeWindow(1200, 720)
+	window.IMShow(img)
+}
+
+// Annotate draws the bounding boxes and labels of the objects on the image
+func Annotate(img *gocv.Mat, detectedObjects []Object) {
+	for _, obj := range detectedObjects {
+		gocv.Rectangle(img, image.Rect(obj.Left, obj.Top, obj.Left+obj.Width, obj.Top+obj.Height), yellow, 2)
+		gocv.PutText(img, obj.Label, image.Pt(obj.Left, obj.Top), gocv.FontHersheyPlain, 2.2, blue, 2)
+	}
+}
+
+func bbIntersectionOverUnion(a, b Object) float64 {
+
+	boxA := []int{a.Left, a.Top, a.Left + a.Width, a.Top + a.Height}
+	boxB := []int{b.Left, b.Top, b.Left + b.Width, b.Top + b.Height}
+	// determine the (x, y)-coordinates of the intersection rectangle
+	xA := math.Max(float64(boxA[0]), float64(boxB[0]))
+	yA := math.Max(float64(boxA[1]), float64(boxB[1]))
+	xB := math.Min(float64(boxA[2]), float64(boxB[2]))
+	yB := math.Min(float64(boxA[3]), float64(boxB[3]))
+
+	// compute the area of intersection rectangle
+	interArea := math.Max(0, xB-xA+1) * math.Max(0, yB-yA+1)
+
+	// compute the area of both the prediction and ground-truth rectangles
+	boxAArea := float64(boxA[2]-boxA[0]+1) * float64(boxA[3]-boxA[1]+1)
+	boxBArea := float64(boxB[2]-boxB[0]+1) * float64(boxB[3]-boxB[1]+1)
+
+	// compute the intersection over union by taking the intersection area and dividing it by the sum of prediction + ground-truth areas - the intersection area
+	iou := interArea / (boxAArea + boxBArea - interArea)
+
+	// return the intersection over union value
+	return iou
+}
+
+// performDetection analyzes the results from the detector network,
+// which produces an output blob with a shape 1x1xNx7
+// where N is the number of detections, and each detection
+// is a vector of float values
+// [batchId, classId, confidence, left, top, right, bottom]
+func performDetection(frame *gocv.Mat, results []gocv.Mat, settings Settings, classes []string) []Object {
+
+	detectedObjects := []Object{}
+	var currentlyDetectedObject Object
+
+	for _, output := range results {
+		data, err := output.DataPtrFloat32()
+		if err != nil {
+			logger(logPipeline).Error("Error reading the output of the network", "err", err)
+		}
+
+		if output.Cols() < 0 {
+			logger(logPipeline).Error("Invalid output of the network", "row", data[0:10])
+			break
+		}
+
+		for j := 0; j < output.Total(); j += output.Cols() {
+			row := data[j : j+output.Cols()]
+			scores := row[5:]
+			classID, confidence := getClassIDAndConfidence(scores)
+
+			if confidence > settings.Confidence && settings.AllowsClass(classes[classID]) {
+				centerX := int(row[0] * float32(frame.Cols()))
+				centerY := int(row[1] * float32(frame.Rows()))
+				width := int(row[2] * float32(frame.Cols()))
+				height := int(row[3] * float32(frame.Rows()))
+				if settings.Masked(image.Pt(centerX, centerY)) {
+					continue
+				}
+
+				currentlyDetectedObject = Object{
+					Confidence: confidence,
+					Top:        centerY - height/2,
+					Left:       centerX - width/2,
+					Width:      width,
+					Height:     height,
+					Label:      fmt.Sprintf("%s - %d%%", classes[classID], int(100*confidence)),
+				}
+
+				if len(detectedObjects) == 0 {
+					detectedObjects = append(detectedObjects, currentlyDetectedObject)
+					continue
+				}
+
+				newObject := true
+				for i, obj := range detectedObjects {
+					intersection := bbIntersectionOverUnion(currentlyDetectedObject, obj)
+					if intersection > settings.Intersection {
+						newObject = false
+
+						if currentlyDetectedObject.Confidence > obj.Confidence {
+							detectedObjects[i] = currentlyDetectedObject
+						}
+					}
+				}
+
+				if newObject {
+					detectedObjects = append(detectedObjects, currentlyDetectedObject)
+				}
+			}
+		}
+	}
+
+	return detectedObjects
+}
+
+// getClassID retrieve class id from given row.
+func getClassIDAndConfidence(x []float32) (int, float32) {
+	res := 0
+	max := float32(0.0)
+	for i, y := range x {
+		if y > max {
+			max = y
+			res = i
+		}
+	}
+	return res, max
+}
@@ -0,0 +1,96 @@
+package detect
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestBBIntersectionOverUnion(t *testing.T) {
+	// the boxes include their last pixel, a box of width 9 is 10 pixels wide
+	box := Object{Left: 0, Top: 0, Width: 9, Height: 9}
+	tests := []struct {
+		name string
+		a, b Object
+		want float64
+	}{
+		{"same box", box, box, 1},
+		{"half overlap", box, Object{Left: 5, Top: 0, Width: 9, Height: 9}, 1.0 / 3},
+		{"inside", box, Object{Left: 0, Top: 0, Width: 4, Height: 9}, 0.5},
+		{"adjacent", box, Object{Left: 10, Top: 0, Width: 9, Height: 9}, 0},
+		{"apart", box, Object{Left: 100, Top: 100, Width: 9, Height: 9}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bbIntersectionOverUnion(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("bbIntersectionOverUnion() = %v, want %v", got, tt.want)
+			}
+			if got := bbIntersectionOverUnion(tt.b, tt.a); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("bbIntersectionOverUnion() swapped = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetClassIDAndConfidence(t *testing.T) {
+	tests := []struct {
+		name       string
+		scores     []float32
+		class      int
+		confidence float32
+	}{
+		{"no scores", nil, 0, 0},
+		{"all zero", []float32{0, 0, 0}, 0, 0},
+		{"highest", []float32{0.1, 0.8, 0.3}, 1, 0.8},
+		{"first of equal", []float32{0.5, 0.5}, 0, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, confidence := getClassIDAndConfidence(tt.scores)
+			if class != tt.class || confidence != tt.confidence {
+				t.Errorf("getClassIDAndConfidence() = %d, %v, want %d, %v", class, confidence, tt.class, tt.confidence)
+			}
+		})
+	}
+}
+
+func TestSettingsAllowsClass(t *testing.T) {
+	tests := []struct {
+		name    string
+		classes []string
+		label   string
+		want    bool
+	}{
+		{"all classes", nil, "bird", true},
+		{"listed", []string{"cat", "bird"}, "bird", true},
+		{"not listed", []string{"cat"}, "bird", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Settings{Classes: tt.classes}).AllowsClass(tt.label); got != tt.want {
+				t.Errorf("AllowsClass(%q) = %v, want %v", tt.label, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettingsMasked(t *testing.T) {
+	settings := Settings{Masks: []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(50, 50, 60, 60)}}
+	tests := []struct {
+		name  string
+		point image.Point
+		want  bool
+	}{
+		{"first mask", image.Pt(5, 5), true},
+		{"second mask", image.Pt(55, 59), true},
+		{"edge is outside", image.Pt(10, 5), false},
+		{"between", image.Pt(30, 30), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := settings.Masked(tt.point); got != tt.want {
+				t.Errorf("Masked(%v) = %v, want %v", tt.point, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package detect
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// Detector runs the network on single images, e.g. the uploads of an
+// api. The network is shared by the callers, one image at a time.
+type Detector struct {
+	mu      sync.Mutex
+	net     gocv.Net
+	target  gocv.NetTargetType
+	classes []string
+	// output layers of the network
+	outputs []string
+	// reused for the images, under mu
+	blob   gocv.Mat
+	images []gocv.Mat
+}
+
+// NewDetector reads the network of the model
+func NewDetector(model, config string, backend gocv.NetBackendType, target gocv.NetTargetType, classes []string) (*Detector, error) {
+	net := gocv.ReadNet(model, config)
+	if net.Empty() {
+		return nil, fmt.Errorf("error reading network model from: %v %v", model, config)
+	}
+	net.SetPreferableBackend(backend)
+	net.SetPreferableTarget(target)
+	return &Detector{net: net, target: target, classes: classes, outputs: outputLayers(net), blob: gocv.NewMat(), images: make([]gocv.Mat, 1)}, nil
+}
+
+// Detect returns the objects of the image that pass the settings
+func (d *Detector) Detect(img gocv.Mat, settings Settings) []Object {
+	objects, _ := d.detect(img, settings)
+	return objects
+}
+
+// detect is Detect that also returns the duration of the forward pass
+func (d *Detector) detect(img gocv.Mat, settings Settings) ([]Object, time.Duration) {
+	d.mu.Lock()
+	d.images[0] = img
+	gocv.BlobFromImages(d.images, &d.blob, 1.0/255.0, image.Pt(416, 416), blobMean, true, false, gocv.MatTypeCV32F)
+	d.images[0] = gocv.Mat{}
+	d.net.SetInput(d.blob, "")
+	acquireInference(1)
+	start := time.Now()
+	prob := d.net.ForwardLayers(d.outputs)
+	took := time.Since(start)
+	releaseInference(1)
+	d.mu.Unlock()
+
+	defer func() {
+		for i := range prob {
+			prob[i].Close()
+		}
+	}()
+	return performDetection(&img, prob, settings, d.classes), took
+}
+
+// Close releases the network
+func (d *Detector) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.blob.Close()
+	return d.net.Close()
+}
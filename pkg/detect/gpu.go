@@ -0,0 +1,154 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// placement policies of the streams on the gpus
+const (
+	PlacementRoundRobin  = "round-robin"
+	PlacementLeastLoaded = "least-loaded"
+)
+
+// GPUState is the load of a gpu
+type GPUState struct {
+	Device  int      `json:"device"`
+	Streams []string `json:"streams"`
+	// share of the time the forward passes ran on the device during the
+	// last utilizationWindow, over 1 when passes ran in parallel
+	Utilization float64 `json:"utilization"`
+	Passes      int64   `json:"passes"`
+}
+
+// utilizationWindow is the period of GPUState.Utilization
+const utilizationWindow = time.Minute
+
+type gpu struct {
+	streams map[string]bool
+	passes  int64
+	// busy time of the current and the previous window
+	busy, previousBusy time.Duration
+	windowStarted      time.Time
+}
+
+// GPUSet places the pipelines on the cuda devices
+type GPUSet struct {
+	mu      sync.Mutex
+	policy  string
+	devices []*gpu
+	next    int
+}
+
+// GPUs are the cuda devices of the process, empty until ConfigureGPUs
+var GPUs = &GPUSet{}
+
+// ConfigureGPUs spreads the pipelines with a cuda target on the cuda
+// devices with the policy. Returns the number of the devices, zero when
+// the build has no cuda support.
+func ConfigureGPUs(policy string) (int, error) {
+	switch policy {
+	case "":
+		policy = PlacementRoundRobin
+	case PlacementRoundRobin, PlacementLeastLoaded:
+	default:
+		return 0, fmt.Errorf("unknown gpu placement %q", policy)
+	}
+	n := cudaDevices()
+	GPUs.mu.Lock()
+	defer GPUs.mu.Unlock()
+	GPUs.policy = policy
+	GPUs.devices = nil
+	for i := 0; i < n; i++ {
+		GPUs.devices = append(GPUs.devices, &gpu{streams: map[string]bool{}, windowStarted: time.Now()})
+	}
+	return n, nil
+}
+
+// assign picks the device of the stream, the explicit device if it is
+// valid. Returns -1 when there are no devices.
+func (g *GPUSet) assign(stream string, explicit int) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.devices) == 0 {
+		return -1
+	}
+	device := explicit
+	if device < 0 || device >= len(g.devices) {
+		switch g.policy {
+		case PlacementLeastLoaded:
+			device = 0
+			for i, d := range g.devices {
+				least := g.devices[device]
+				if len(d.streams) < len(least.streams) || len(d.streams) == len(least.streams) && d.load() < least.load() {
+					device = i
+				}
+			}
+		default:
+			device = g.next % len(g.devices)
+			g.next++
+		}
+	}
+	g.devices[device].streams[stream] = true
+	return device
+}
+
+// release frees the place of the stream on the device
+func (g *GPUSet) release(stream string, device int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if device >= 0 && device < len(g.devices) {
+		delete(g.devices[device].streams, stream)
+	}
+}
+
+// pass records a forward pass on the device
+func (g *GPUSet) pass(device int, took time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if device < 0 || device >= len(g.devices) {
+		return
+	}
+	d := g.devices[device]
+	d.rotate()
+	d.passes++
+	d.busy += took
+}
+
+// rotate starts a new window when the current one is over
+func (d *gpu) rotate() {
+	if time.Since(d.windowStarted) < utilizationWindow {
+		return
+	}
+	d.previousBusy = d.busy
+	// a window without any passes
+	if time.Since(d.windowStarted) > 2*utilizationWindow {
+		d.previousBusy = 0
+	}
+	d.busy = 0
+	d.windowStarted = time.Now()
+}
+
+// load is the utilization of the last full window
+func (d *gpu) load() float64 {
+	d.rotate()
+	return d.previousBusy.Seconds() / utilizationWindow.Seconds()
+}
+
+// List returns the state of the devices
+func (g *GPUSet) List() []GPUState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	states := []GPUState{}
+	for i, d := range g.devices {
+		state := GPUState{Device: i, Streams: []string{}, Utilization: d.load(), Passes: d.passes}
+		for stream := range d.streams {
+			state.Streams = append(state.Streams, stream)
+		}
+		sort.Strings(state.Streams)
+		states = append(states, state)
+	}
+	return states
+}
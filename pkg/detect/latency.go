@@ -0,0 +1,122 @@
+package detect
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// steps of a frame whose latency is measured
+const (
+	StepDecode      = "decode"
+	StepPreprocess  = "preprocess"
+	StepForward     = "forward"
+	StepPostprocess = "postprocess"
+	// saving the event, from the snapshot until the database writer is done
+	StepPersist = "persist"
+	// publishing the saved event to the sinks and the hooks
+	StepNotify = "notify"
+)
+
+var steps = []string{StepDecode, StepPreprocess, StepForward, StepPostprocess, StepPersist, StepNotify}
+
+// latencyBuckets are the upper bounds of the histograms in seconds
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// LogLatency logs the mean latencies of the steps of every stream with
+// the health reports of the pipelines
+var LogLatency bool
+
+type histogram struct {
+	// observations in each bucket, the last one is over the largest bound
+	counts []uint64
+	count  uint64
+	sum    float64
+	// at the previous summary
+	loggedCount uint64
+	loggedSum   float64
+}
+
+type latencyKey struct {
+	stream, step string
+}
+
+// LatencyRecorder keeps a histogram of the latency of each step of each
+// stream
+type LatencyRecorder struct {
+	mu         sync.Mutex
+	histograms map[latencyKey]*histogram
+}
+
+// Latencies are the latencies of the pipelines of the process
+var Latencies = &LatencyRecorder{histograms: map[latencyKey]*histogram{}}
+
+// Observe records the duration of the step of a frame of the stream
+func (l *LatencyRecorder) Observe(stream, step string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := latencyKey{stream, step}
+	h, ok := l.histograms[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		l.histograms[key] = h
+	}
+	seconds := d.Seconds()
+	h.counts[sort.SearchFloat64s(latencyBuckets, seconds)]++
+	h.count++
+	h.sum += seconds
+}
+
+// summary returns the mean latency of each step of the stream since the
+// previous summary, e.g. "decode 4ms, forward 85ms"
+func (l *LatencyRecorder) summary(stream string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var parts []string
+	for _, step := range steps {
+		h, ok := l.histograms[latencyKey{stream, step}]
+		if !ok || h.count == h.loggedCount {
+			continue
+		}
+		mean := (h.sum - h.loggedSum) / float64(h.count-h.loggedCount)
+		parts = append(parts, fmt.Sprintf("%s %v", step, time.Duration(mean*float64(time.Second)).Round(100*time.Microsecond)))
+		h.loggedCount, h.loggedSum = h.count, h.sum
+	}
+	return strings.Join(parts, ", ")
+}
+
+// WritePrometheus writes the histograms in the text format of Prometheus
+func (l *LatencyRecorder) WritePrometheus(w io.Writer) error {
+	l.mu.Lock()
+	keys := make([]latencyKey, 0, len(l.histograms))
+	for key := range l.histograms {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].step != keys[j].step {
+			return keys[i].step < keys[j].step
+		}
+		return keys[i].stream < keys[j].stream
+	})
+	var b strings.Builder
+	b.WriteString("# HELP gocv_step_latency_seconds Latency of the steps of the frames by stream.\n")
+	b.WriteString("# TYPE gocv_step_latency_seconds histogram\n")
+	for _, key := range keys {
+		h := l.histograms[key]
+		labels := fmt.Sprintf("stream=%q,step=%q", streamLabel(key.stream), key.step)
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "gocv_step_latency_seconds_bucket{%s,le=\"%g\"} %d\n", labels, bound, cumulative)
+		}
+		fmt.Fprintf(&b, "gocv_step_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(&b, "gocv_step_latency_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(&b, "gocv_step_latency_seconds_count{%s} %d\n", labels, h.count)
+	}
+	l.mu.Unlock()
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,42 @@
+package detect
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// inferenceSlots limits the forward passes that run at the same time, nil
+// when unlimited
+var inferenceSlots *semaphore.Weighted
+
+// LimitInference allows at most n forward passes of the networks of the
+// process at the same time, zero for no limit. It must be called before
+// the pipelines are started.
+//
+// The waiting passes get the slots in the order they started to wait.
+// Every pipeline waits with at most one frame, so the streams take turns
+// and a busy stream cannot starve the others.
+func LimitInference(n int) {
+	if n <= 0 {
+		inferenceSlots = nil
+		return
+	}
+	inferenceSlots = semaphore.NewWeighted(int64(n))
+}
+
+// acquireInference waits for a slot with the weight of the pass, e.g. the
+// number of images in it
+func acquireInference(weight int64) {
+	if inferenceSlots != nil {
+		// cannot fail without a deadline
+		inferenceSlots.Acquire(context.Background(), weight)
+	}
+}
+
+// releaseInference frees the slot taken with acquireInference
+func releaseInference(weight int64) {
+	if inferenceSlots != nil {
+		inferenceSlots.Release(weight)
+	}
+}
@@ -0,0 +1,55 @@
+package detect
+
+import (
+	"context"
+	"image"
+
+	"gocv.io/x/gocv"
+	"golang.org/x/sync/semaphore"
+)
+
+// loadSlots limits the networks that are loaded at the same time, nil
+// when unlimited
+var loadSlots *semaphore.Weighted
+
+// LimitModelLoading lets at most n pipelines load and warm up their
+// networks at the same time, zero for no limit. It must be called before
+// the pipelines are started. With many streams, loading all the networks
+// at once thrashes the disk and the cpu, and delays the first detection
+// of every stream instead of the last ones.
+func LimitModelLoading(n int) {
+	if n <= 0 {
+		loadSlots = nil
+		return
+	}
+	loadSlots = semaphore.NewWeighted(int64(n))
+}
+
+// acquireModelLoad waits for a slot, it fails only when ctx is done
+func acquireModelLoad(ctx context.Context) error {
+	if loadSlots != nil {
+		return loadSlots.Acquire(ctx, 1)
+	}
+	return nil
+}
+
+func releaseModelLoad() {
+	if loadSlots != nil {
+		loadSlots.Release(1)
+	}
+}
+
+// warmup runs a forward pass on a blank frame, so that the lazy
+// initialization of the network (e.g. the cuda kernels) is done before the
+// first real frame
+func warmup(net gocv.Net, outputs []string) {
+	img := gocv.NewMatWithSize(416, 416, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	blob := gocv.BlobFromImage(img, 1.0/255.0, image.Pt(416, 416), blobMean, true, false)
+	defer blob.Close()
+
+	net.SetInput(blob, "")
+	for _, prob := range net.ForwardLayers(outputs) {
+		prob.Close()
+	}
+}
@@ -0,0 +1,86 @@
+//go:build matprofile
+
+package detect
+
+import (
+	"image"
+	"log/slog"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// leakHandler is the handler of the leak test, it keeps nothing
+type leakHandler struct{}
+
+func (leakHandler) Settings() (Settings, error)                               { return Settings{}, nil }
+func (leakHandler) Connected()                                                {}
+func (leakHandler) Failed(string)                                             {}
+func (leakHandler) Frame(gocv.Mat, []Object)                                  {}
+func (leakHandler) Health(bool, float64, time.Time)                           {}
+func (leakHandler) Detected(_ gocv.Mat, _ time.Time, _ []Object, done func()) { done() }
+func (leakHandler) Panicked(*PanicError)                                      {}
+
+// TestStagesCloseMats runs frames through the pools and the stages of a
+// pipeline, with the inference replaced by outputs made in the test, and
+// checks that every Mat they opened is closed in the end. Run with
+// go test -tags matprofile ./pkg/detect
+func TestStagesCloseMats(t *testing.T) {
+	const frames = 200
+	baseline := gocv.MatProfile.Count()
+
+	p := Pipeline{Device: "matprofile-test", MaxWidth: 320, Classes: []string{"person"}, Logger: slog.Default(), Handler: leakHandler{}}
+	images := newMatPool(4)
+	blobs := newMatPool(4)
+	captured := newQueue(p.Device, StagePreprocess, 1, DropOldest)
+	inputs := newQueue(p.Device, StageInference, 1, Block)
+	results := newQueue(p.Device, StagePostprocess, 1, Block)
+	detections := newQueue(p.Device, StageSink, 2, DropNewest)
+
+	go func() {
+		defer inputs.close()
+		p.preprocess(captured, inputs, blobs)
+	}()
+	go func() {
+		defer results.close()
+		for i := 0; ; i++ {
+			f, ok := <-inputs.frames
+			if !ok {
+				return
+			}
+			blobs.put(f.blob)
+			// every other frame has a detection
+			f.prob = []gocv.Mat{yoloOutput(1, len(p.Classes), (i+1)%2)}
+			inputs.done()
+			results.put(f)
+		}
+	}()
+	go func() {
+		defer detections.close()
+		p.postprocess(results, detections, newFrameSkipper(p.Device, 0), nil, func() {})
+	}()
+	sunk := make(chan struct{})
+	go func() {
+		defer close(sunk)
+		p.sink(detections, nil)
+	}()
+
+	source := gocv.NewMatWithSize(480, 640, gocv.MatTypeCV8UC3)
+	// every other frame is cropped, the others are only resized
+	crops := []image.Rectangle{{}, image.Rect(0, 0, 600, 400)}
+	for i := 0; i < frames; i++ {
+		img := images.get()
+		source.CopyTo(&img)
+		captured.put(&frame{img: img, pool: images, captured: time.Now(), settings: Settings{Confidence: 0.5, Crop: crops[i%2]}})
+	}
+	captured.close()
+	<-sunk
+
+	source.Close()
+	images.close()
+	blobs.close()
+	if open := gocv.MatProfile.Count(); open != baseline {
+		t.Errorf("%d Mats open after %d frames, expected %d", open, frames, baseline)
+	}
+}
@@ -0,0 +1,91 @@
+package detect
+
+import (
+	"sync"
+	"time"
+)
+
+// pressure levels of the memory budget
+const (
+	memoryNormal = iota
+	// the frame rates are halved and the buffers are trimmed
+	memoryHigh
+	// in addition, the streams with the lowest priority are paused one by
+	// one
+	memoryCritical
+)
+
+// shares of the budget where the levels start, and below which the
+// paused streams are resumed one by one
+const (
+	memoryHighShare     = 0.8
+	memoryCriticalShare = 0.95
+	memoryResumeShare   = 0.7
+)
+
+// memoryBudget degrades the pipelines gradually when the resident memory
+// of the process approaches the limit, instead of letting the kernel kill
+// the process
+type memoryBudget struct {
+	mu    sync.Mutex
+	limit int64
+	level int
+}
+
+var budget = &memoryBudget{}
+
+// LimitMemory checks the resident memory of the process against the limit
+// in bytes every interval (linux only). The frames of opencv are outside
+// the go heap, so GOMEMLIMIT alone does not keep them in check.
+func LimitMemory(limit int64, interval time.Duration) {
+	if limit <= 0 {
+		return
+	}
+	budget.mu.Lock()
+	budget.limit = limit
+	budget.mu.Unlock()
+	go func() {
+		for range time.Tick(interval) {
+			budget.check(residentMemory())
+		}
+	}()
+}
+
+// check moves the budget to the level of the resident memory
+func (b *memoryBudget) check(rss int64) {
+	if rss <= 0 {
+		return
+	}
+	b.mu.Lock()
+	share := float64(rss) / float64(b.limit)
+	level := memoryNormal
+	switch {
+	case share >= memoryCriticalShare:
+		level = memoryCritical
+	case share >= memoryHighShare:
+		level = memoryHigh
+	}
+	if level != b.level {
+		logger(logPipeline).Warn("Memory pressure changed", "budget_pct", int(share*100), "rss_mb", rss>>20, "level", level)
+	}
+	b.level = level
+	b.mu.Unlock()
+
+	switch {
+	case level == memoryCritical:
+		if stream := Pipelines.pauseLowest(); stream != "" {
+			logger(logPipeline).Warn("Stream paused for memory", "device", stream)
+		}
+	case share < memoryResumeShare:
+		if stream := Pipelines.resumeHighest(); stream != "" {
+			logger(logPipeline).Info("Stream resumed", "device", stream)
+		}
+	}
+}
+
+// memoryPressure returns the current level of the budget
+func memoryPressure() int {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+	return budget.level
+}
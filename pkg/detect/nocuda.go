@@ -0,0 +1,10 @@
+//go:build !cuda
+
+package detect
+
+// cudaDevices is zero without the cuda build tag
+func cudaDevices() int {
+	return 0
+}
+
+func useCUDADevice(device int) {}
@@ -0,0 +1,598 @@
+package detect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/sources"
+	"gocv.io/x/gocv"
+)
+
+// Handler connects a pipeline to the rest of the application: where the
+// settings come from and where the detections and the health go.
+//
+// The methods are called from the goroutines of the stages: Settings,
+// Connected and Failed from the capture, Frame and Health from the
+// postprocessing and Detected from the sink, so a slow Detected never
+// delays the capture.
+type Handler interface {
+	// Settings returns the current settings of the stream. It is called
+	// when the pipeline starts, every SettingsRefreshInterval and after
+	// Pipelines.Reload.
+	Settings() (Settings, error)
+	// Connected is called after the device has been opened
+	Connected()
+	// Failed is called when the device cannot be opened or is closed
+	Failed(reason string)
+	// Frame is called with every analyzed frame
+	Frame(img gocv.Mat, detectedObjects []Object)
+	// Health is called about once a minute with the frame rate, and with
+	// online false when the pipeline stops
+	Health(online bool, fps float64, lastFrame time.Time)
+	// Detected is called with the frames that have detections. done must
+	// be called once the event has been saved or dropped, a resumed video
+	// file starts after it.
+	Detected(img gocv.Mat, captured time.Time, detectedObjects []Object, done func())
+	// Panicked is called when a stage has panicked, Run then returns the
+	// error
+	Panicked(err *PanicError)
+}
+
+// Pipeline reads the frames of a device and runs the detection on them.
+// The capture, preprocessing, inference, postprocessing and the handler
+// run in their own goroutines: the capture always passes on the latest
+// frame, and the frames with detections are dropped if the handler falls
+// more than SinkQueue frames behind.
+type Pipeline struct {
+	// address of the device, the key of the pipeline in Pipelines
+	Device string
+	// number of the pipeline in the process, used in the window titles
+	// and the snapshot names
+	ID int
+
+	// the network and its labels
+	Model   string
+	Config  string
+	Backend gocv.NetBackendType
+	Target  gocv.NetTargetType
+	Classes []string
+	// cuda device of the network with a cuda target, nil to place it with
+	// the policy of GPUs
+	GPU *int
+	// cpus of the thread of the forward passes, empty for any. The worker
+	// threads of opencv (SetNumThreads) are not pinned.
+	CPUs []int
+
+	// timezone of the capture times
+	Location *time.Location
+	// show the detections in a window instead of passing them to the
+	// handler, for testing
+	Window bool
+	// frames waiting for Handler.Detected, DefaultSinkQueue if zero
+	SinkQueue int
+	// at most every MaxFrameSkip:th frame is analyzed when the detection
+	// cannot keep up with the capture, 0 or 1 analyzes every frame
+	MaxFrameSkip int
+	// frames wider than this are downscaled before the preprocessing, so
+	// that the later stages, the snapshots and the previews handle smaller
+	// frames, 0 keeps the size
+	MaxWidth int
+	// keeps the position of a video file, so that a restarted pipeline
+	// resumes after the frames already analyzed, nil for none
+	Checkpoints Checkpoints
+	// how often the position is saved, DefaultCheckpointInterval if zero
+	CheckpointInterval time.Duration
+	// logger of the pipeline with the fields of the stream, slog.Default()
+	// with the device if nil
+	Logger *slog.Logger
+
+	Handler Handler
+}
+
+// modules of the logs of the package, the "module" attribute of the
+// loggers
+const (
+	// the source and the capture stage
+	logCapture = "capture"
+	// the other stages and the resources shared by the pipelines
+	logPipeline = "pipeline"
+)
+
+// log returns the Logger of the pipeline for the module
+func (p Pipeline) log(module string) *slog.Logger {
+	return p.Logger.With("module", module)
+}
+
+// logger returns the logger of the module for the logs that are not of
+// one pipeline
+func logger(module string) *slog.Logger {
+	return slog.Default().With("module", module)
+}
+
+// reasons a pipeline ends without a failure
+var (
+	ErrStreamDisabled = errors.New("stream disabled")
+	ErrWindowClosed   = errors.New("window closed")
+	// the last frame of a video file has been read
+	ErrSourceEnded = errors.New("source ended")
+)
+
+// failures of the source
+var (
+	errNoFrames     = errors.New("no frames")
+	errDeviceClosed = errors.New("device closed")
+)
+
+// empty frames read in a row before the device is reconnected
+const maxEmptyFrames = 10
+
+// Run reads the device until it is closed, the stream is disabled or ctx
+// is done. After ctx is done the frames already captured go through the
+// stages and the handler before Run returns.
+//
+// Run returns why the pipeline ended: ErrStreamDisabled, ErrWindowClosed,
+// ErrSourceEnded, the error of ctx or the failure of the device or the
+// network.
+func (p Pipeline) Run(ctx context.Context) (err error) {
+	deviceID := p.Device
+	h := p.Handler
+	if p.Logger == nil {
+		p.Logger = slog.Default().With("device", deviceID)
+	}
+	Pipelines.Started(deviceID)
+	defer func() { Pipelines.Stopped(deviceID, err) }()
+	// a panic outside of the stages, e.g. while opening the device
+	defer func() {
+		if v := recover(); v != nil {
+			panicked := p.panicked(StageCapture, v)
+			p.failed(panicked)
+			err = panicked
+		}
+	}()
+
+	webcam, err := sources.Open(deviceID)
+	if err != nil {
+		p.log(logCapture).Error("Error opening device", "err", err)
+		Pipelines.Failed(deviceID, err.Error())
+		h.Failed(err.Error())
+		return err
+	}
+	defer webcam.Close()
+	checkpoint := p.newCheckpoint(webcam)
+	checkpoint.resume(webcam)
+
+	// the network is loaded only for a source that delivers frames, a
+	// camera that is offline does not hold the memory of a network
+	first := gocv.NewMat()
+	ok := webcam.ReadContext(ctx, &first) && !first.Empty()
+	first.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !ok {
+		p.log(logCapture).Error("No frames from device")
+		Pipelines.Failed(deviceID, errNoFrames.Error())
+		h.Failed(errNoFrames.Error())
+		return errNoFrames
+	}
+	p.log(logCapture).Info("Start reading device", "type", webcam.Type)
+	h.Connected()
+
+	// open DNN object tracking model, the slot is released after the
+	// warmup by the inference
+	Pipelines.Stage(deviceID, StageLoading)
+	loading := time.Now()
+	if err := acquireModelLoad(ctx); err != nil {
+		return err
+	}
+	net := gocv.ReadNet(p.Model, p.Config)
+
+	if net.Empty() {
+		releaseModelLoad()
+		p.log(logPipeline).Error("Error reading network model", "model", p.Model, "config", p.Config)
+		return fmt.Errorf("error reading network model from: %v %v", p.Model, p.Config)
+	}
+	defer net.Close()
+	net.SetPreferableBackend(gocv.NetBackendType(p.Backend))
+	net.SetPreferableTarget(gocv.NetTargetType(p.Target))
+
+	device := -1
+	if p.Target == gocv.NetTargetCUDA || p.Target == gocv.NetTargetCUDAFP16 {
+		explicit := -1
+		if p.GPU != nil {
+			explicit = *p.GPU
+		}
+		device = GPUs.assign(deviceID, explicit)
+		defer GPUs.release(deviceID, device)
+		Pipelines.gpu(deviceID, device)
+	}
+
+	sinkQueue := p.SinkQueue
+	if sinkQueue <= 0 {
+		sinkQueue = DefaultSinkQueue
+	}
+	captured := newQueue(deviceID, StagePreprocess, 1, DropOldest)
+	inputs := newQueue(deviceID, StageInference, 1, Block)
+	results := newQueue(deviceID, StagePostprocess, 1, Block)
+	detections := newQueue(deviceID, StageSink, sinkQueue, DropNewest)
+	// a frame for every place in the queues and the stages
+	images := newMatPool(sinkQueue + 8)
+	defer images.close()
+	blobs := newMatPool(3)
+	defer blobs.close()
+
+	skipper := newFrameSkipper(deviceID, p.MaxFrameSkip)
+
+	// closed to stop the capture, e.g. when the window is closed
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	stopCapture := func() { stopOnce.Do(func() { close(stop) }) }
+
+	// a panic of a stage stops the capture, and the frames left for the
+	// stage are discarded
+	var failure stageFailure
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		defer inputs.close()
+		defer captured.discard(nil)
+		defer p.recovered(StagePreprocess, &failure, stopCapture)
+		p.preprocess(captured, inputs, blobs)
+	}()
+	go func() {
+		defer wg.Done()
+		defer results.close()
+		defer inputs.discard(blobs)
+		defer p.recovered(StageInference, &failure, stopCapture)
+		p.inference(net, device, loading, inputs, results, blobs)
+	}()
+	go func() {
+		defer wg.Done()
+		defer detections.close()
+		defer results.discard(nil)
+		defer p.recovered(StagePostprocess, &failure, stopCapture)
+		p.postprocess(results, detections, skipper, checkpoint, stopCapture)
+	}()
+	go func() {
+		defer wg.Done()
+		defer detections.discard(nil)
+		defer p.recovered(StageSink, &failure, stopCapture)
+		p.sink(detections, checkpoint)
+	}()
+
+	err = func() error {
+		defer p.recovered(StageCapture, &failure, stopCapture)
+		return p.capture(ctx, webcam, images, skipper, captured, checkpoint, stop)
+	}()
+	captured.close()
+	wg.Wait()
+	if panicked := failure.get(); panicked != nil {
+		p.failed(panicked)
+		err = panicked
+	}
+	if err == ErrSourceEnded {
+		checkpoint.reset()
+	} else {
+		checkpoint.save(true)
+	}
+
+	h.Health(false, 0, time.Time{})
+	if err == errDeviceClosed {
+		Pipelines.Failed(deviceID, err.Error())
+		h.Failed(err.Error())
+	}
+	return err
+}
+
+// capture reads the frames of the device with the interval of the
+// settings until the device is closed, the stream is disabled, stop is
+// closed or ctx is done. Returns why the capture ended.
+func (p Pipeline) capture(ctx context.Context, webcam *sources.Capture, images *matPool, skipper *frameSkipper, out *queue, checkpoint *checkpoint, stop <-chan struct{}) error {
+	deviceID := p.Device
+	h := p.Handler
+	loc := p.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	settings, err := h.Settings()
+	if err != nil {
+		p.log(logCapture).Error("Error reading detection settings", "err", err)
+	}
+	Pipelines.priority(deviceID, settings.Priority)
+	settingsLoaded := time.Now()
+	var lastFrame time.Time
+	emptyFrames := 0
+
+	for {
+		select {
+		case <-stop:
+			return ErrWindowClosed
+		case <-ctx.Done():
+			p.log(logCapture).Info("Stopping capture")
+			return ctx.Err()
+		default:
+		}
+		if time.Since(settingsLoaded) > SettingsRefreshInterval || Pipelines.ReloadRequested(deviceID) {
+			if s, err := h.Settings(); err == nil {
+				p.log(logCapture).Debug("Settings reloaded", "enabled", s.Enabled, "paused", s.Paused, "interval", s.Interval, "max_fps", s.MaxFPS)
+				settings = s
+				Pipelines.priority(deviceID, settings.Priority)
+			} else {
+				p.log(logCapture).Error("Error reading detection settings", "err", err)
+			}
+			settingsLoaded = time.Now()
+		}
+		checkpoint.save(false)
+		if !settings.Enabled {
+			p.log(logCapture).Info("Stream disabled")
+			return ErrStreamDisabled
+		}
+		if settings.Paused || Pipelines.memoryPaused(deviceID) {
+			Pipelines.Stage(deviceID, StagePaused)
+			// a paused stream still stops without waiting for the resume
+			select {
+			case <-time.After(time.Second):
+			case <-stop:
+				return ErrWindowClosed
+			case <-ctx.Done():
+				p.log(logCapture).Info("Stopping capture")
+				return ctx.Err()
+			}
+			continue
+		}
+
+		// sample frames with the configured interval
+		if wait := settings.Interval - time.Since(lastFrame); wait > 0 {
+			Pipelines.Stage(deviceID, StageWaiting)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				continue
+			}
+		}
+		lastFrame = time.Now()
+
+		// capture image from video/stream
+		Pipelines.Stage(deviceID, StageCapturing)
+		img := images.get()
+		if ok := webcam.ReadContext(ctx, &img); !ok {
+			images.put(img)
+			if ctx.Err() != nil {
+				p.log(logCapture).Info("Stopping capture")
+				return ctx.Err()
+			}
+			if !webcam.Live() {
+				p.log(logCapture).Info("Source ended")
+				return ErrSourceEnded
+			}
+			p.log(logCapture).Error("Device closed")
+			return errDeviceClosed
+		}
+		if img.Empty() {
+			images.put(img)
+			if emptyFrames++; emptyFrames >= maxEmptyFrames {
+				p.log(logCapture).Error("Cannot read image from device", "empty_frames", emptyFrames)
+				return errDeviceClosed
+			}
+			continue
+		}
+		emptyFrames = 0
+		Pipelines.sourceDropped(deviceID, webcam.Dropped())
+		Latencies.Observe(deviceID, StepDecode, webcam.Decoded())
+		if !skipper.read() {
+			images.put(img)
+			continue
+		}
+
+		// try to get capture time as real as possible (this why called straight after webcam read)
+		f := &frame{img: img, pool: images, captured: time.Now().In(loc), position: webcam.Position(), settings: settings, checkpoint: checkpoint}
+		checkpoint.captured(f.position)
+		out.put(f)
+		Pipelines.processed(deviceID, StageCapture, 0)
+	}
+}
+
+// preprocess converts the frames to the blobs that the network can
+// analyze. With the MaxFPS of the settings it waits before it takes the
+// next frame, which is then the latest one captured.
+//
+// The frames of a stream come swapped out of the ring of the source
+// instead of copied. The preprocessing itself runs on the cpu: gocv has
+// no binding for UMat, and -target opencl moves only the network.
+func (p Pipeline) preprocess(in, out *queue, blobs *matPool) {
+	ratio := 1.0 / 255.0
+	// reused for the frames, the blob is made from one image at a time
+	images := make([]gocv.Mat, 1)
+	// earliest time of the next frame
+	var next time.Time
+	for {
+		time.Sleep(time.Until(next))
+		f, ok := <-in.frames
+		if !ok {
+			return
+		}
+		if f.settings.MaxFPS > 0 {
+			next = time.Now().Add(time.Duration(float64(time.Second) / f.settings.MaxFPS))
+		}
+
+		started := time.Now()
+		p.downscale(f)
+
+		// convert image Mat to 416x416 blob that the object detector can analyze,
+		// the resize, the scaling and the BGR to RGB swap are done in one pass
+		// to the reused blob
+		f.blob = blobs.get()
+		images[0] = f.img
+		gocv.BlobFromImages(images, &f.blob, ratio, image.Pt(416, 416), blobMean, true, false, gocv.MatTypeCV32F)
+		images[0] = gocv.Mat{}
+		Latencies.Observe(p.Device, StepPreprocess, time.Since(started))
+		in.done()
+		out.put(f)
+	}
+}
+
+// downscale crops the frame to the Crop of its settings and shrinks it to
+// MaxWidth. The detections, the masks and the zones are in the pixels of
+// the resulting frame.
+func (p Pipeline) downscale(f *frame) {
+	src := f.img
+	crop := f.settings.Crop.Intersect(image.Rect(0, 0, src.Cols(), src.Rows()))
+	cropped := !crop.Empty() && crop != image.Rect(0, 0, src.Cols(), src.Rows())
+	if cropped {
+		// shares the data of the frame
+		src = f.img.Region(crop)
+		defer src.Close()
+	}
+	resized := p.MaxWidth > 0 && src.Cols() > p.MaxWidth
+	if !cropped && !resized {
+		return
+	}
+
+	dst := f.pool.get()
+	if resized {
+		size := image.Pt(p.MaxWidth, src.Rows()*p.MaxWidth/src.Cols())
+		gocv.Resize(src, &dst, size, 0, 0, gocv.InterpolationArea)
+	} else {
+		src.CopyTo(&dst)
+	}
+	f.pool.put(f.img)
+	f.img = dst
+}
+
+// inference warms up the network and runs it on the blobs, on the cuda
+// device if it is not -1. The pipeline is ready after the warmup, loading
+// is when the network started to load.
+func (p Pipeline) inference(net gocv.Net, device int, loading time.Time, in, out *queue, blobs *matPool) {
+	if device >= 0 || len(p.CPUs) > 0 {
+		runtime.LockOSThread()
+		// a pinned thread ends with the goroutine instead of going back to
+		// the other goroutines
+		if len(p.CPUs) == 0 {
+			defer runtime.UnlockOSThread()
+		}
+	}
+	if device >= 0 {
+		// the network is initialized on the current device of the thread
+		// on the first forward pass
+		useCUDADevice(device)
+	}
+	if len(p.CPUs) > 0 {
+		if err := pinThread(p.CPUs); err != nil {
+			p.log(logPipeline).Error("Error pinning the inference", "cpus", p.CPUs, "err", err)
+		}
+	}
+	// the slot of the loading is released also if the warmup panics
+	warming := true
+	defer func() {
+		if warming {
+			releaseModelLoad()
+		}
+	}()
+	// the output layers are the same for every frame
+	outputs := outputLayers(net)
+	warmup(net, outputs)
+	warming = false
+	releaseModelLoad()
+	Pipelines.ready(p.Device, time.Since(loading))
+	p.log(logPipeline).Info("Network ready", "took", time.Since(loading).Round(time.Millisecond))
+
+	for f := range in.frames {
+		// feed the blob into the detector
+		net.SetInput(f.blob, "")
+
+		// run a forward pass thru the network, the slot is released also
+		// if it panics
+		func() {
+			acquireInference(1)
+			defer releaseInference(1)
+			started := time.Now()
+			f.prob = net.ForwardLayers(outputs)
+			took := time.Since(started)
+			GPUs.pass(device, took)
+			Latencies.Observe(p.Device, StepForward, took)
+		}()
+		blobs.put(f.blob)
+		in.done()
+		out.put(f)
+	}
+}
+
+// postprocess picks the detections from the outputs of the network and
+// passes the frames with detections to the sink
+func (p Pipeline) postprocess(in, out *queue, skipper *frameSkipper, checkpoint *checkpoint, stop func()) {
+	deviceID := p.Device
+	h := p.Handler
+
+	var window *gocv.Window
+	if p.Window {
+		window = gocv.NewWindow(fmt.Sprintf("DNN Detection - %d", p.ID))
+		defer window.Close()
+	}
+
+	// processed frames since the last health report
+	frames := 0
+	healthReported := time.Now()
+
+	for f := range in.frames {
+		started := time.Now()
+		f.objects = performDetection(&f.img, f.prob, f.settings, p.Classes)
+		for i := range f.prob {
+			f.prob[i].Close()
+		}
+		f.prob = nil
+		Latencies.Observe(deviceID, StepPostprocess, time.Since(started))
+		if len(f.objects) > 0 {
+			p.log(logPipeline).Info("Detected", "objects", len(f.objects), "label", f.objects[0].Label)
+		}
+		h.Frame(f.img, f.objects)
+		Pipelines.Frame(deviceID, len(f.objects))
+		in.done()
+		skipper.processed(time.Since(f.captured))
+
+		frames++
+		if elapsed := time.Since(healthReported); elapsed > time.Minute {
+			h.Health(true, float64(frames)/elapsed.Seconds(), f.captured)
+			if LogLatency {
+				p.log(logPipeline).Info("Latency", "steps", Latencies.summary(deviceID))
+			}
+			frames = 0
+			healthReported = time.Now()
+		}
+
+		if window != nil {
+			// show bounding box in own window when in test environment
+			drawBoundingBoxes(f.img, f.objects, window)
+			checkpoint.finished(f.position)
+			f.release()
+			if window.WaitKey(1) >= 0 {
+				stop()
+			}
+			continue
+		}
+		// pass the detections on, e.g. to be saved to the database
+		if len(f.objects) == 0 {
+			checkpoint.finished(f.position)
+			f.release()
+			continue
+		}
+		out.put(f)
+	}
+}
+
+// sink passes the frames with detections to the handler
+func (p Pipeline) sink(in *queue, checkpoint *checkpoint) {
+	for f := range in.frames {
+		position := f.position
+		p.Handler.Detected(f.img, f.captured, f.objects, func() { checkpoint.finished(position) })
+		f.release()
+		in.done()
+	}
+}
@@ -0,0 +1,78 @@
+package detect
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// BenchmarkPreprocess measures the time and the allocations of a 1080p
+// frame through the preprocess stage, which reuses the blobs and the image
+// slice
+func BenchmarkPreprocess(b *testing.B) {
+	p := Pipeline{Device: "benchmark"}
+	images := newMatPool(2)
+	blobs := newMatPool(2)
+	defer images.close()
+	defer blobs.close()
+	in := newQueue(p.Device, StagePreprocess, 1, Block)
+	out := newQueue(p.Device, StageInference, 1, Block)
+	go func() {
+		defer out.close()
+		p.preprocess(in, out, blobs)
+	}()
+
+	source := gocv.NewMatWithSize(1080, 1920, gocv.MatTypeCV8UC3)
+	defer source.Close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		img := images.get()
+		source.CopyTo(&img)
+		in.put(&frame{img: img, pool: images, captured: time.Now()})
+		f := <-out.frames
+		blobs.put(f.blob)
+		f.release()
+		out.done()
+	}
+	b.StopTimer()
+	in.close()
+	for range out.frames {
+	}
+}
+
+// BenchmarkPostprocess measures the allocations of picking the detections
+// from an output of the size of the coarsest yolo layer
+func BenchmarkPostprocess(b *testing.B) {
+	classes := make([]string, 80)
+	for i := range classes {
+		classes[i] = fmt.Sprint("class", i)
+	}
+	results := []gocv.Mat{yoloOutput(13*13*3, len(classes), 5)}
+	defer results[0].Close()
+	img := gocv.NewMatWithSize(416, 416, gocv.MatTypeCV8UC3)
+	defer img.Close()
+	settings := Settings{Confidence: 0.5, Intersection: 0.7}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		performDetection(&img, results, settings, classes)
+	}
+}
+
+// yoloOutput returns an output of the network with rows of the classes,
+// of which the first detected rows are separate detections
+func yoloOutput(rows, classes, detected int) gocv.Mat {
+	m := gocv.NewMatWithSize(rows, 5+classes, gocv.MatTypeCV32F)
+	for r := 0; r < detected && r < rows; r++ {
+		center := float32(r+1) / float32(detected+1)
+		for i, v := range []float32{center, center, 0.1, 0.1, 0.9} {
+			m.SetFloatAt(r, i, v)
+		}
+		m.SetFloatAt(r, 5+r%classes, 0.9)
+	}
+	return m
+}
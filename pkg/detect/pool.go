@@ -0,0 +1,57 @@
+package detect
+
+import (
+	"gocv.io/x/gocv"
+)
+
+// matPool keeps the Mats of the frames and the blobs of a pipeline for
+// reuse, so that the native buffers are not allocated for every frame.
+//
+// A sync.Pool is not used because it drops the items without a way to
+// close them: the memory of a Mat is not managed by the garbage collector
+// and would leak. The free list is bounded instead, and the Mats that do
+// not fit are closed.
+type matPool struct {
+	free chan gocv.Mat
+}
+
+func newMatPool(size int) *matPool {
+	return &matPool{free: make(chan gocv.Mat, size)}
+}
+
+// get returns a free Mat, or a new one if there is none
+func (p *matPool) get() gocv.Mat {
+	select {
+	case m := <-p.free:
+		return m
+	default:
+		return gocv.NewMat()
+	}
+}
+
+// put returns the Mat to the pool. It must not be used after this. When
+// the memory runs low, the free Mats are closed instead.
+func (p *matPool) put(m gocv.Mat) {
+	if memoryPressure() >= memoryHigh {
+		m.Close()
+		p.close()
+		return
+	}
+	select {
+	case p.free <- m:
+	default:
+		m.Close()
+	}
+}
+
+// close closes the free Mats, the pool can still be used after this
+func (p *matPool) close() {
+	for {
+		select {
+		case m := <-p.free:
+			m.Close()
+		default:
+			return
+		}
+	}
+}
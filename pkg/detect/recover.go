@@ -0,0 +1,84 @@
+package detect
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// PanicError is returned by Run when a stage of the pipeline has panicked,
+// e.g. on a frame that opencv cannot handle. The other stages have been
+// stopped and the resources released, so the pipeline can be started
+// again.
+type PanicError struct {
+	// the stage that panicked, e.g. StageInference
+	Stage string
+	Value interface{}
+	Stack []byte
+	Time  time.Time
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", e.Stage, e.Value)
+}
+
+// stageFailure keeps the first panic of the stages of a pipeline
+type stageFailure struct {
+	mu  sync.Mutex
+	err *PanicError
+}
+
+func (f *stageFailure) set(err *PanicError) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *stageFailure) get() *PanicError {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// recovered is deferred by the goroutines of the stages. A panic is logged
+// with its stack and kept as the failure of the pipeline, and stop ends
+// the capture so that the other stages end too.
+func (p Pipeline) recovered(stage string, failure *stageFailure, stop func()) {
+	if v := recover(); v != nil {
+		failure.set(p.panicked(stage, v))
+		stop()
+	}
+}
+
+// panicked logs the panic of the stage, it is called in the deferred
+// function that recovered it so that the stack is the one of the panic
+func (p Pipeline) panicked(stage string, v interface{}) *PanicError {
+	err := &PanicError{Stage: stage, Value: v, Stack: debug.Stack(), Time: time.Now()}
+	p.log(logPipeline).Error("Stage panicked", "stage", stage, "panic", fmt.Sprint(v), "stack", string(err.Stack))
+	return err
+}
+
+// failed marks the stream degraded and passes the panic to the handler
+func (p Pipeline) failed(err *PanicError) {
+	Pipelines.panicked(p.Device, err)
+	p.Handler.Panicked(err)
+}
+
+// discard releases the frames left in the queue after the stage reading it
+// has panicked, until the stage before it closes the queue, so that the
+// stages before it do not block. blobs gets back the blobs of the frames
+// of the inference.
+func (q *queue) discard(blobs *matPool) {
+	for f := range q.frames {
+		if blobs != nil {
+			blobs.put(f.blob)
+		}
+		for i := range f.prob {
+			f.prob[i].Close()
+		}
+		f.release()
+	}
+}
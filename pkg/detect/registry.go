@@ -0,0 +1,405 @@
+package detect
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// what the capture of a pipeline is doing
+const (
+	StageConnecting = "connecting"
+	StageLoading    = "loading"
+	StageWaiting    = "waiting"
+	StageCapturing  = "capturing"
+	StagePaused     = "paused"
+	StageStopped    = "stopped"
+	// waiting to be started again after the pipeline ended
+	StageRestarting = "restarting"
+)
+
+// StageStats are the counters of a stage of a pipeline
+type StageStats struct {
+	Processed int64 `json:"processed"`
+	// frames dropped because the stage was busy
+	Dropped int64 `json:"dropped"`
+	// frames waiting for the stage
+	Queued int `json:"queued"`
+}
+
+// State is what a pipeline is doing
+type State struct {
+	Stream string    `json:"stream"`
+	Stage  string    `json:"stage"`
+	Since  time.Time `json:"since"`
+	// seconds in the current stage, a large value while capturing is a
+	// stuck capture
+	StageSeconds float64   `json:"stage_seconds"`
+	Started      time.Time `json:"started"`
+	Frames       int64     `json:"frames"`
+	LastFrame    time.Time `json:"last_frame,omitempty"`
+	Detections   int64     `json:"detections"`
+	Events       int64     `json:"events"`
+	LastError    string    `json:"last_error,omitempty"`
+	// by the stage of the pipeline, e.g. StageInference
+	Stages map[string]StageStats `json:"stages,omitempty"`
+	// frames of the stream replaced by newer ones before the capture
+	SourceDropped int64 `json:"source_dropped"`
+	// cuda device of the network
+	GPU *int `json:"gpu,omitempty"`
+	// every FrameSkip:th captured frame is analyzed, more when the
+	// detection falls behind
+	FrameSkip int `json:"frame_skip"`
+	// of the settings, the streams with the lowest priority are paused
+	// first when the memory runs low
+	Priority int `json:"priority"`
+	// the network has been loaded and warmed up, and the frames are
+	// analyzed
+	Ready bool `json:"ready"`
+	// seconds from the start of the loading of the network to the end of
+	// its warmup
+	LoadSeconds float64 `json:"load_seconds,omitempty"`
+	// paused to stay within the memory budget
+	MemoryPaused bool `json:"memory_paused,omitempty"`
+	// why the pipeline ended, e.g. "stream disabled", empty while it runs
+	Exit string `json:"exit,omitempty"`
+	// times the pipeline has been started again after it ended
+	Restarts int `json:"restarts"`
+	// panics of the stages over all the runs
+	Panics int `json:"panics,omitempty"`
+	// a stage has panicked and no frame has been analyzed since
+	Degraded bool `json:"degraded,omitempty"`
+	// the settings are read again before the next frame
+	reloadRequested bool
+	stages          map[string]*StageStats
+}
+
+// Registry tracks the state of the pipelines by the device
+type Registry struct {
+	mu      sync.Mutex
+	streams map[string]*State
+}
+
+// Pipelines is the state of the pipelines of the process
+var Pipelines = &Registry{streams: map[string]*State{}}
+
+func (p *Registry) get(stream string) *State {
+	s, ok := p.streams[stream]
+	if !ok {
+		now := time.Now()
+		s = &State{Stream: stream, Started: now, Since: now, Stage: StageConnecting, FrameSkip: 1}
+		p.streams[stream] = s
+	}
+	return s
+}
+
+// Started resets the counters for a new run of the stream, the error, the
+// last frame, the restarts and the panics of the previous runs are kept
+func (p *Registry) Started(stream string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	state := &State{Stream: stream, Started: now, Since: now, Stage: StageConnecting, FrameSkip: 1}
+	if s, ok := p.streams[stream]; ok {
+		state.LastError, state.LastFrame, state.Restarts = s.LastError, s.LastFrame, s.Restarts
+		state.Panics, state.Degraded = s.Panics, s.Degraded
+	}
+	p.streams[stream] = state
+}
+
+// Restarting marks the ended pipeline of the stream to be started again
+func (p *Registry) Restarting(stream string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.get(stream)
+	s.Stage, s.Since = StageRestarting, time.Now()
+	s.Restarts++
+}
+
+// LastFrame returns when the stream last had a frame analyzed, over all
+// its runs
+func (p *Registry) LastFrame(stream string) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.streams[stream]; ok {
+		return s.LastFrame
+	}
+	return time.Time{}
+}
+
+// Stage records the stage the stream moves to
+func (p *Registry) Stage(stream, stage string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.get(stream)
+	if s.Stage != stage {
+		s.Stage, s.Since = stage, time.Now()
+	}
+}
+
+// Frame counts an analyzed frame and its detections
+func (p *Registry) Frame(stream string, detections int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.get(stream)
+	s.Frames++
+	s.Detections += int64(detections)
+	s.LastFrame = time.Now()
+	s.Degraded = false
+}
+
+// processed counts a frame handled by the stage, queued is the number of
+// frames still waiting for it
+func (p *Registry) processed(stream, stage string, queued int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stage(stream, stage)
+	s.Processed++
+	s.Queued = queued
+}
+
+// dropped counts a frame that the busy stage did not get
+func (p *Registry) dropped(stream, stage string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stage(stream, stage).Dropped++
+}
+
+// queued records the number of frames waiting for the stage
+func (p *Registry) queued(stream, stage string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stage(stream, stage).Queued = n
+}
+
+// sourceDropped records the frames the source of the stream has dropped
+func (p *Registry) sourceDropped(stream string, n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.get(stream).SourceDropped = n
+}
+
+// gpu records the cuda device of the stream, -1 for none
+func (p *Registry) gpu(stream string, device int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.get(stream)
+	s.GPU = nil
+	if device >= 0 {
+		s.GPU = &device
+	}
+}
+
+// frameSkip records the frame skip of the stream
+func (p *Registry) frameSkip(stream string, skip int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.get(stream).FrameSkip = skip
+}
+
+// ready marks the network of the stream loaded
+func (p *Registry) ready(stream string, took time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.get(stream)
+	s.Ready = true
+	s.LoadSeconds = took.Seconds()
+}
+
+// priority records the priority of the stream
+func (p *Registry) priority(stream string, priority int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.get(stream).Priority = priority
+}
+
+// memoryPaused reports whether the stream has been paused for the memory
+func (p *Registry) memoryPaused(stream string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.streams[stream]
+	return ok && s.MemoryPaused
+}
+
+// pauseLowest pauses the running stream with the lowest priority for the
+// memory and returns it, empty when all are paused
+func (p *Registry) pauseLowest() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var lowest *State
+	for _, s := range p.streams {
+		if s.MemoryPaused || s.Stage == StageStopped || s.Stage == StageRestarting {
+			continue
+		}
+		if lowest == nil || s.Priority < lowest.Priority || s.Priority == lowest.Priority && s.Stream > lowest.Stream {
+			lowest = s
+		}
+	}
+	if lowest == nil {
+		return ""
+	}
+	lowest.MemoryPaused = true
+	return lowest.Stream
+}
+
+// resumeHighest resumes the stream with the highest priority that was
+// paused for the memory and returns it, empty when none is paused
+func (p *Registry) resumeHighest() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var highest *State
+	for _, s := range p.streams {
+		if !s.MemoryPaused {
+			continue
+		}
+		if highest == nil || s.Priority > highest.Priority || s.Priority == highest.Priority && s.Stream < highest.Stream {
+			highest = s
+		}
+	}
+	if highest == nil {
+		return ""
+	}
+	highest.MemoryPaused = false
+	return highest.Stream
+}
+
+// stage returns the counters of the stage, the caller holds the lock
+func (p *Registry) stage(stream, stage string) *StageStats {
+	s := p.get(stream)
+	if s.stages == nil {
+		s.stages = map[string]*StageStats{}
+	}
+	stats, ok := s.stages[stage]
+	if !ok {
+		stats = &StageStats{}
+		s.stages[stage] = stats
+	}
+	return stats
+}
+
+// Event counts a saved detection event
+func (p *Registry) Event(stream string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.get(stream).Events++
+}
+
+// Failed records the error of the stream
+func (p *Registry) Failed(stream, reason string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.get(stream).LastError = reason
+}
+
+// panicked records the panic of a stage of the stream
+func (p *Registry) panicked(stream string, err *PanicError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.get(stream)
+	s.LastError = err.Error()
+	s.Panics++
+	s.Degraded = true
+}
+
+// Reload asks the running stream to read its settings again
+func (p *Registry) Reload(stream string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.streams[stream]; ok {
+		s.reloadRequested = true
+	}
+}
+
+// ReloadRequested reports and clears the reload request of the stream
+func (p *Registry) ReloadRequested(stream string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.streams[stream]
+	if !ok || !s.reloadRequested {
+		return false
+	}
+	s.reloadRequested = false
+	return true
+}
+
+// Stopped marks the pipeline ended with the error returned by Run, it
+// stays on the list
+func (p *Registry) Stopped(stream string, exit error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.streams[stream]; ok {
+		s.Stage, s.Since = StageStopped, time.Now()
+		s.Exit = ""
+		if exit != nil {
+			s.Exit = exit.Error()
+		}
+	}
+}
+
+// List returns copies of the states ordered by the stream
+func (p *Registry) List() []State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	states := []State{}
+	for _, s := range p.streams {
+		state := *s
+		state.StageSeconds = time.Since(s.Since).Seconds()
+		state.stages = nil
+		if len(s.stages) > 0 {
+			state.Stages = map[string]StageStats{}
+			for stage, stats := range s.stages {
+				state.Stages[stage] = *stats
+			}
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Stream < states[j].Stream })
+	return states
+}
+
+// WritePrometheus writes the dropped frames of the sources and the stages
+// in the text format of Prometheus
+func (p *Registry) WritePrometheus(w io.Writer) error {
+	states := p.List()
+	var b strings.Builder
+	b.WriteString("# HELP gocv_source_dropped_frames_total Frames of the stream replaced by newer ones before the capture.\n")
+	b.WriteString("# TYPE gocv_source_dropped_frames_total counter\n")
+	for _, s := range states {
+		fmt.Fprintf(&b, "gocv_source_dropped_frames_total{stream=%q} %d\n", streamLabel(s.Stream), s.SourceDropped)
+	}
+	b.WriteString("# HELP gocv_stage_dropped_frames_total Frames dropped because the stage of the pipeline was busy.\n")
+	b.WriteString("# TYPE gocv_stage_dropped_frames_total counter\n")
+	for _, s := range states {
+		stages := make([]string, 0, len(s.Stages))
+		for stage := range s.Stages {
+			stages = append(stages, stage)
+		}
+		sort.Strings(stages)
+		for _, stage := range stages {
+			fmt.Fprintf(&b, "gocv_stage_dropped_frames_total{stream=%q,stage=%q} %d\n", streamLabel(s.Stream), stage, s.Stages[stage].Dropped)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// streamLabel returns the address of the stream for the labels of the
+// metrics, without the password of the device
+func streamLabel(stream string) string {
+	u, err := url.Parse(stream)
+	if err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	// the user info of an address that does not parse is left out
+	if i := strings.LastIndex(stream, "@"); err != nil && i >= 0 {
+		if scheme, _, ok := strings.Cut(stream, "://"); ok {
+			return scheme + "://" + stream[i+1:]
+		}
+		return stream[i+1:]
+	}
+	return stream
+}
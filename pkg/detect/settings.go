@@ -0,0 +1,57 @@
+package detect
+
+import (
+	"image"
+	"time"
+)
+
+// SettingsRefreshInterval is how often the pipelines reload their settings
+const SettingsRefreshInterval = time.Minute
+
+// Settings controls which detections of a stream are taken into
+// consideration
+type Settings struct {
+	Confidence   float32
+	Intersection float64
+	// detectable class labels, empty means all classes
+	Classes []string
+	// minimum time between two analyzed frames
+	Interval time.Duration
+	// analyzed frames per second at most, independent of the capture, 0 for
+	// no limit
+	MaxFPS float64
+	// part of the frame that is analyzed, empty for the whole frame
+	Crop image.Rectangle
+	// areas of the frame where the detections are ignored
+	Masks []image.Rectangle
+	// false when the stream has been disabled while running
+	Enabled bool
+	// no frames are analyzed while paused
+	Paused bool
+	// the streams with the lowest priority are paused first when the
+	// memory runs low
+	Priority int
+}
+
+// AllowsClass reports whether detections of the class should be kept
+func (s Settings) AllowsClass(label string) bool {
+	if len(s.Classes) == 0 {
+		return true
+	}
+	for _, class := range s.Classes {
+		if class == label {
+			return true
+		}
+	}
+	return false
+}
+
+// Masked reports whether the point is in any of the masks
+func (s Settings) Masked(p image.Point) bool {
+	for _, mask := range s.Masks {
+		if p.In(mask) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package detect
+
+import (
+	"sync"
+	"time"
+)
+
+// skipAdjustInterval is how often the frame skip of a pipeline is
+// adjusted
+const skipAdjustInterval = 2 * time.Second
+
+// frameSkipper adapts the share of the captured frames that are analyzed
+// to the speed of the detection. When a frame takes longer to get through
+// the stages than the capture of skip frames, more frames are skipped;
+// when it takes less than half of that, fewer.
+type frameSkipper struct {
+	stream  string
+	maxSkip int
+
+	mu sync.Mutex
+	// every skip:th frame is analyzed
+	skip    int
+	counter int
+	// moving averages of the time between the captured frames and the
+	// time from the capture to the end of the postprocessing
+	period   time.Duration
+	latency  time.Duration
+	lastRead time.Time
+	adjusted time.Time
+}
+
+func newFrameSkipper(stream string, maxSkip int) *frameSkipper {
+	return &frameSkipper{stream: stream, maxSkip: maxSkip, skip: 1, adjusted: time.Now()}
+}
+
+// average moves the average a bit toward the value
+func average(avg, value time.Duration) time.Duration {
+	if avg == 0 {
+		return value
+	}
+	return avg + (value-avg)/8
+}
+
+// read is called after every captured frame, and reports whether the
+// frame should be analyzed
+func (s *frameSkipper) read() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if !s.lastRead.IsZero() {
+		s.period = average(s.period, now.Sub(s.lastRead))
+	}
+	s.lastRead = now
+	s.counter++
+	// half of the frames when the memory runs low
+	if memoryPressure() >= memoryHigh {
+		return s.counter%(2*s.skip) == 0
+	}
+	return s.counter%s.skip == 0
+}
+
+// processed is called with the time it took from the capture of a frame
+// to the end of its postprocessing
+func (s *frameSkipper) processed(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = average(s.latency, latency)
+	if s.maxSkip <= 1 || time.Since(s.adjusted) < skipAdjustInterval || s.period == 0 {
+		return
+	}
+	s.adjusted = time.Now()
+
+	budget := s.period * time.Duration(s.skip)
+	switch {
+	case s.latency > budget && s.skip < s.maxSkip:
+		s.skip++
+	case s.latency < budget/2 && s.skip > 1:
+		s.skip--
+	default:
+		return
+	}
+	s.counter = 0
+	logger(logPipeline).Debug("Frame skip adjusted", "device", s.stream, "skip", s.skip, "latency", s.latency.Round(time.Millisecond))
+	Pipelines.frameSkip(s.stream, s.skip)
+}
@@ -0,0 +1,51 @@
+package detect
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// SaveSnapshots writes the frame and a tight crop of every detected object
+// to the snapshot directory. The returned path of the frame and the crop
+// paths saved to the objects are relative to the snapshot directory.
+func SaveSnapshots(snapshotDir string, img gocv.Mat, captureId int, captureTime time.Time, detectedObjects []Object) string {
+	if snapshotDir == "" {
+		return ""
+	}
+
+	day := captureTime.Format("2006-01-02")
+	if err := os.MkdirAll(filepath.Join(snapshotDir, day), 0755); err != nil {
+		logger(logPipeline).Error("Error creating snapshot directory", "err", err)
+		return ""
+	}
+	name := fmt.Sprintf("%s-%d", captureTime.Format("150405.000"), captureId)
+
+	snapshot := filepath.Join(day, name+".jpg")
+	if !gocv.IMWrite(filepath.Join(snapshotDir, snapshot), img) {
+		logger(logPipeline).Error("Error writing snapshot", "snapshot", snapshot)
+		return ""
+	}
+
+	bounds := image.Rect(0, 0, img.Cols(), img.Rows())
+	for i, obj := range detectedObjects {
+		rect := image.Rect(obj.Left, obj.Top, obj.Left+obj.Width, obj.Top+obj.Height).Intersect(bounds)
+		if rect.Empty() {
+			continue
+		}
+		region := img.Region(rect)
+		crop := filepath.Join(day, fmt.Sprintf("%s_%d.jpg", name, i))
+		if gocv.IMWrite(filepath.Join(snapshotDir, crop), region) {
+			detectedObjects[i].Crop = crop
+		} else {
+			logger(logPipeline).Error("Error writing crop", "crop", crop)
+		}
+		region.Close()
+	}
+
+	return snapshot
+}
@@ -0,0 +1,125 @@
+package detect
+
+import (
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// stages of a pipeline, each runs in its own goroutine and passes the
+// frames to the next one through a queue
+const (
+	StageCapture     = "capture"
+	StagePreprocess  = "preprocess"
+	StageInference   = "inference"
+	StagePostprocess = "postprocess"
+	StageSink        = "sink"
+)
+
+// DefaultSinkQueue is the number of frames with detections that may wait
+// for the handler before new ones are dropped
+const DefaultSinkQueue = 16
+
+// DropPolicy tells what happens to a frame when the queue of the next
+// stage is full
+type DropPolicy int
+
+const (
+	// Block waits until the next stage has room
+	Block DropPolicy = iota
+	// DropOldest replaces the oldest waiting frame, the next stage always
+	// gets the latest frame
+	DropOldest
+	// DropNewest drops the frame, the waiting frames are kept
+	DropNewest
+)
+
+// frame is a captured frame on its way through the stages. The image is
+// owned by the stage that holds the frame, which returns it to the pool
+// with release when the frame is done or dropped.
+type frame struct {
+	img      gocv.Mat
+	pool     *matPool
+	captured time.Time
+	// index of the next frame of a video file
+	position int64
+	// the settings at the time of the capture
+	settings Settings
+	blob     gocv.Mat
+	prob     []gocv.Mat
+	objects  []Object
+	// nil for a live source
+	checkpoint *checkpoint
+}
+
+// release returns the image of the frame to the pool
+func (f *frame) release() {
+	f.pool.put(f.img)
+}
+
+// drop releases the frame that the busy stage did not get, it is finished
+// for the checkpoint
+func (f *frame) drop() {
+	f.checkpoint.finished(f.position)
+	f.release()
+}
+
+// queue connects two stages of a pipeline
+type queue struct {
+	stream string
+	// the stage reading the queue
+	stage  string
+	policy DropPolicy
+	frames chan *frame
+}
+
+func newQueue(stream, stage string, size int, policy DropPolicy) *queue {
+	return &queue{stream: stream, stage: stage, policy: policy, frames: make(chan *frame, size)}
+}
+
+// put passes the frame to the next stage
+func (q *queue) put(f *frame) {
+	switch q.policy {
+	case DropNewest:
+		// only one frame may wait when the memory runs low
+		if memoryPressure() >= memoryHigh && len(q.frames) > 0 {
+			Pipelines.dropped(q.stream, q.stage)
+			f.drop()
+			break
+		}
+		select {
+		case q.frames <- f:
+		default:
+			Pipelines.dropped(q.stream, q.stage)
+			f.drop()
+		}
+	case DropOldest:
+		for {
+			select {
+			case q.frames <- f:
+				Pipelines.queued(q.stream, q.stage, len(q.frames))
+				return
+			default:
+			}
+			select {
+			case old := <-q.frames:
+				Pipelines.dropped(q.stream, q.stage)
+				old.drop()
+			default:
+			}
+		}
+	default:
+		q.frames <- f
+	}
+	Pipelines.queued(q.stream, q.stage, len(q.frames))
+}
+
+// done counts a frame taken from the queue as processed by the stage
+func (q *queue) done() {
+	Pipelines.processed(q.stream, q.stage, len(q.frames))
+}
+
+// close tells the next stage that no more frames are coming
+func (q *queue) close() {
+	close(q.frames)
+}
@@ -0,0 +1,79 @@
+package detect
+
+import (
+	"testing"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+func TestQueuePut(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy DropPolicy
+		// position of the frame left in the queue of one frame
+		want    int64
+		dropped int64
+	}{
+		{"oldest", DropOldest, 3, 2},
+		{"newest", DropNewest, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := "queue-test-" + tt.name
+			pool := newMatPool(4)
+			defer pool.close()
+			q := newQueue(stream, StageInference, 1, tt.policy)
+			for position := int64(1); position <= 3; position++ {
+				q.put(&frame{img: gocv.NewMat(), pool: pool, position: position})
+			}
+			f := <-q.frames
+			f.release()
+			if f.position != tt.want {
+				t.Errorf("frame %d left in the queue, want %d", f.position, tt.want)
+			}
+			if dropped := droppedFrames(stream, StageInference); dropped != tt.dropped {
+				t.Errorf("%d frames dropped, want %d", dropped, tt.dropped)
+			}
+			// the dropped frames are back in the pool
+			if free := len(pool.free); free != int(tt.dropped)+1 {
+				t.Errorf("%d Mats in the pool, want %d", free, tt.dropped+1)
+			}
+		})
+	}
+}
+
+func TestQueuePutBlocks(t *testing.T) {
+	pool := newMatPool(2)
+	defer pool.close()
+	q := newQueue("queue-test-block", StageInference, 1, Block)
+	q.put(&frame{img: gocv.NewMat(), pool: pool, position: 1})
+	put := make(chan struct{})
+	go func() {
+		defer close(put)
+		q.put(&frame{img: gocv.NewMat(), pool: pool, position: 2})
+	}()
+	select {
+	case <-put:
+		t.Fatal("put to a full queue did not wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	for position := int64(1); position <= 2; position++ {
+		f := <-q.frames
+		f.release()
+		if f.position != position {
+			t.Errorf("frame %d taken, want %d", f.position, position)
+		}
+	}
+	<-put
+	if dropped := droppedFrames("queue-test-block", StageInference); dropped != 0 {
+		t.Errorf("%d frames dropped, want none", dropped)
+	}
+}
+
+// droppedFrames returns the frames the stage of the stream has dropped
+func droppedFrames(stream, stage string) int64 {
+	Pipelines.mu.Lock()
+	defer Pipelines.mu.Unlock()
+	return Pipelines.stage(stream, stage).Dropped
+}
@@ -0,0 +1,33 @@
+package detect
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// processUsage returns the cpu time used by the process and its peak
+// resident memory, which includes the native memory of opencv
+func processUsage() (cpu time.Duration, maxRSS int64) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0
+	}
+	cpu = time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	// in kilobytes on linux
+	return cpu, usage.Maxrss * 1024
+}
+
+// residentMemory returns the current resident memory of the process
+func residentMemory() int64 {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	var size, resident int64
+	if _, err := fmt.Sscan(string(statm), &size, &resident); err != nil {
+		return 0
+	}
+	return resident * int64(os.Getpagesize())
+}
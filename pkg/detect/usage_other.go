@@ -0,0 +1,15 @@
+//go:build !linux
+
+package detect
+
+import "time"
+
+// processUsage is only supported on linux, elsewhere the usage is zero
+func processUsage() (cpu time.Duration, maxRSS int64) {
+	return 0, 0
+}
+
+// residentMemory is only supported on linux
+func residentMemory() int64 {
+	return 0
+}
@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// discordNotifier posts the alerts as embeds to a Discord webhook. The
+// recipient of the subscription is the webhook url.
+type discordNotifier struct {
+	client   *http.Client
+	throttle throttle
+}
+
+func newDiscordNotifier() *discordNotifier {
+	return &discordNotifier{
+		client: &http.Client{Timeout: 30 * time.Second},
+		// webhooks allow about 30 messages per minute
+		throttle: throttle{interval: 2 * time.Second},
+	}
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordEmbed struct {
+	Title  string         `json:"title"`
+	URL    string         `json:"url,omitempty"`
+	Fields []discordField `json:"fields"`
+	Image  *discordImage  `json:"image,omitempty"`
+}
+
+func (d *discordNotifier) Send(ctx context.Context, n Notification, webhook string) error {
+	data := n.Data
+	embed := discordEmbed{Title: n.Subject, URL: data.Link}
+	embed.Fields = []discordField{
+		{"Class", data.Class, true},
+		{"Count", fmt.Sprint(data.Count), true},
+		{"Confidence", fmt.Sprintf("%d%%", data.Confidence), true},
+		{"Stream", data.Stream, false},
+	}
+	if data.Place != "" {
+		embed.Fields = append(embed.Fields, discordField{"Location", data.Place, false})
+	}
+
+	var photo []byte
+	if n.Attachment != "" && SnapshotDir != "" {
+		photo, _ = os.ReadFile(filepath.Join(SnapshotDir, n.Attachment))
+	}
+	var image string
+	if photo != nil {
+		// refers to the file uploaded with the message
+		image = "attachment://" + filepath.Base(n.Attachment)
+	} else {
+		image = SnapshotLink(n.Attachment)
+	}
+	if image != "" {
+		embed.Image = &discordImage{image}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"embeds": []discordEmbed{embed}})
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	contentType := "application/json"
+	if photo == nil {
+		body.Write(payload)
+	} else {
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("payload_json", string(payload))
+		part, err := writer.CreateFormFile("files[0]", filepath.Base(n.Attachment))
+		if err != nil {
+			return err
+		}
+		part.Write(photo)
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		contentType = writer.FormDataContentType()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	d.throttle.wait()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var response struct {
+			RetryAfter float64 `json:"retry_after"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response)
+		d.throttle.pause(time.Duration(response.RetryAfter * float64(time.Second)))
+		return fmt.Errorf("discord: rate limited for %.1fs", response.RetryAfter)
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discord: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
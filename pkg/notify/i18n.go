@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+)
+
+// languages of the notifications. The templates of other languages than
+// English are in templates/<language>/, missing ones fall back to English.
+const (
+	LanguageEnglish = "en"
+	LanguageFinnish = "fi"
+)
+
+// numbers written out in the notifications, larger counts use digits
+var numberWords = map[string]map[int]string{
+	LanguageEnglish: {1: "One", 2: "Two", 3: "Three", 4: "Four", 5: "Five"},
+	LanguageFinnish: {1: "Yksi", 2: "Kaksi", 3: "Kolme", 4: "Neljä", 5: "Viisi"},
+}
+
+// captionFormats are the short chat messages: count, class, stream
+var captionFormats = map[string]string{
+	LanguageEnglish: "%s %s's detected at %s",
+	LanguageFinnish: "%s × %s havaittu: %s",
+}
+
+// burstCaptionFormats are the chat messages of the bursts: events,
+// minutes, stream, peak count, class, peak time
+var burstCaptionFormats = map[string]string{
+	LanguageEnglish: "%d detections in the last %d minutes at %s, peak %s %s's at %s",
+	LanguageFinnish: "%d havaintoa %d minuutin aikana: %s, enimmillään %s × %s klo %s",
+}
+
+// Language returns the language of the observer, or
+// NOTIFY_LANGUAGE when the observer has not chosen one
+func Language(observer string) string {
+	if observer != "" {
+		return observer
+	}
+	if language := os.Getenv("NOTIFY_LANGUAGE"); language != "" {
+		return language
+	}
+	return LanguageEnglish
+}
+
+// CountWord writes out the count in the language
+func CountWord(language string, count int) string {
+	words, ok := numberWords[language]
+	if !ok {
+		words = numberWords[LanguageEnglish]
+	}
+	if word, ok := words[count]; ok {
+		return word
+	}
+	return fmt.Sprint(count)
+}
+
+// captionFormat returns the format of the chat caption in the language
+func captionFormat(language string) string {
+	if format, ok := captionFormats[language]; ok {
+		return format
+	}
+	return captionFormats[LanguageEnglish]
+}
+
+// burstCaptionFormat returns the format of the burst caption in the language
+func burstCaptionFormat(language string) string {
+	if format, ok := burstCaptionFormats[language]; ok {
+		return format
+	}
+	return burstCaptionFormats[LanguageEnglish]
+}
@@ -0,0 +1,42 @@
+package notify
+
+import "testing"
+
+func TestCountWord(t *testing.T) {
+	tests := []struct {
+		language string
+		count    int
+		want     string
+	}{
+		{LanguageEnglish, 1, "One"},
+		{LanguageFinnish, 4, "Neljä"},
+		{LanguageEnglish, 6, "6"},
+		{"sv", 2, "Two"},
+	}
+	for _, tt := range tests {
+		if got := CountWord(tt.language, tt.count); got != tt.want {
+			t.Errorf("CountWord(%q, %d) = %q, want %q", tt.language, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := []struct {
+		name     string
+		observer string
+		env      string
+		want     string
+	}{
+		{"observer", LanguageFinnish, LanguageEnglish, LanguageFinnish},
+		{"environment", "", LanguageFinnish, LanguageFinnish},
+		{"default", "", "", LanguageEnglish},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NOTIFY_LANGUAGE", tt.env)
+			if got := Language(tt.observer); got != tt.want {
+				t.Errorf("Language(%q) = %q, want %q", tt.observer, got, tt.want)
+			}
+		})
+	}
+}
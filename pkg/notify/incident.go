@@ -0,0 +1,232 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The incident notifiers open an incident in PagerDuty or Opsgenie for the
+// detections. The detections of the same class at the same stream belong
+// to one session and fold into one incident until the session has been
+// quiet for INCIDENT_RESOLVE_AFTER, after which the incident is resolved.
+// Reviewing an event acknowledges (confirmed) or resolves (false positive)
+// its incident.
+const (
+	IncidentAcknowledge = "acknowledge"
+	IncidentResolve     = "resolve"
+)
+
+// incidentNotifier is implemented by the notifiers that can also update
+// the incidents they have opened
+type incidentNotifier interface {
+	Notifier
+	update(ctx context.Context, action string, n Notification, recipient string) error
+}
+
+// incidentKey identifies the session of the notification
+func incidentKey(n Notification) string {
+	return "gocv/" + n.Data.Stream + "/" + n.Data.Class
+}
+
+// incidentSessions resolves the incidents of the sessions that have not
+// had detections for a while
+type incidentSessions struct {
+	mu     sync.Mutex
+	after  time.Duration
+	timers map[string]*time.Timer
+}
+
+// touch (re)starts the quiet period of the session
+func (s *incidentSessions) touch(key string, resolve func()) {
+	if s.after <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timers == nil {
+		s.timers = map[string]*time.Timer{}
+	}
+	if timer, ok := s.timers[key]; ok {
+		timer.Stop()
+	}
+	s.timers[key] = time.AfterFunc(s.after, func() {
+		s.mu.Lock()
+		delete(s.timers, key)
+		s.mu.Unlock()
+		resolve()
+	})
+}
+
+// UpdateIncident acknowledges or resolves the incident the notification
+// opened through the channel. Channels without incidents are ignored.
+func UpdateIncident(ctx context.Context, channel, action string, n Notification, recipient string) error {
+	notifiersMu.RLock()
+	notifier, ok := notifiers[channel].(incidentNotifier)
+	notifiersMu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return notifier.update(ctx, action, n, recipient)
+}
+
+// pagerdutyNotifier sends the alerts to the PagerDuty Events API v2. The
+// recipient is the integration (routing) key of the service.
+type pagerdutyNotifier struct {
+	client   *http.Client
+	sessions incidentSessions
+}
+
+func newPagerdutyNotifier(resolveAfter time.Duration) *pagerdutyNotifier {
+	return &pagerdutyNotifier{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		sessions: incidentSessions{after: resolveAfter},
+	}
+}
+
+type pagerdutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerdutyPayload `json:"payload,omitempty"`
+	Images      []pagerdutyLink   `json:"images,omitempty"`
+	Links       []pagerdutyLink   `json:"links,omitempty"`
+}
+
+type pagerdutyPayload struct {
+	Summary       string `json:"summary"`
+	Source        string `json:"source"`
+	Severity      string `json:"severity"`
+	Class         string `json:"class"`
+	CustomDetails Data   `json:"custom_details"`
+}
+
+type pagerdutyLink struct {
+	Src  string `json:"src,omitempty"`
+	Href string `json:"href,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+func (p *pagerdutyNotifier) Send(ctx context.Context, n Notification, routingKey string) error {
+	event := pagerdutyEvent{RoutingKey: routingKey, EventAction: "trigger", DedupKey: incidentKey(n)}
+	event.Payload = &pagerdutyPayload{notificationCaption(n), n.Data.Stream, "critical", n.Data.Class, n.Data}
+	if link := SnapshotLink(n.Attachment); link != "" {
+		event.Images = []pagerdutyLink{{Src: link, Href: link, Text: "Snapshot"}}
+	}
+	if n.Data.Link != "" {
+		event.Links = []pagerdutyLink{{Href: n.Data.Link, Text: n.Data.Stream}}
+	}
+
+	if err := p.post(ctx, event); err != nil {
+		return err
+	}
+	p.sessions.touch(routingKey+"|"+event.DedupKey, func() {
+		// later, not part of the sending
+		if err := p.update(context.Background(), IncidentResolve, n, routingKey); err != nil {
+			logger().Error("Error resolving incident", "event_id", n.Event, "dedup_key", event.DedupKey, "err", err)
+		}
+	})
+	return nil
+}
+
+func (p *pagerdutyNotifier) update(ctx context.Context, action string, n Notification, routingKey string) error {
+	return p.post(ctx, pagerdutyEvent{RoutingKey: routingKey, EventAction: action, DedupKey: incidentKey(n)})
+}
+
+func (p *pagerdutyNotifier) post(ctx context.Context, event pagerdutyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://events.pagerduty.com/v2/enqueue", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := DoRequest(p.client, req); err != nil {
+		return fmt.Errorf("pagerduty: %w", err)
+	}
+	return nil
+}
+
+// opsgenieNotifier creates alerts with the Opsgenie Alert API. The
+// recipient is the name of the responding team.
+type opsgenieNotifier struct {
+	apiKey   string
+	endpoint string
+	client   *http.Client
+	sessions incidentSessions
+}
+
+func newOpsgenieNotifier(apiKey, endpoint string, resolveAfter time.Duration) *opsgenieNotifier {
+	if endpoint == "" {
+		endpoint = "https://api.opsgenie.com"
+	}
+	return &opsgenieNotifier{
+		apiKey:   apiKey,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		sessions: incidentSessions{after: resolveAfter},
+	}
+}
+
+func (o *opsgenieNotifier) Send(ctx context.Context, n Notification, team string) error {
+	alert := map[string]interface{}{
+		"message":     notificationCaption(n),
+		"alias":       incidentKey(n),
+		"description": n.Body,
+		"priority":    "P1",
+		"source":      "gocv-stream-events",
+		"tags":        []string{n.Data.Class},
+		"details": map[string]string{
+			"stream":     n.Data.Stream,
+			"place":      n.Data.Place,
+			"count":      fmt.Sprint(n.Data.Count),
+			"confidence": fmt.Sprint(n.Data.Confidence),
+			"snapshot":   SnapshotLink(n.Attachment),
+		},
+	}
+	if team != "" {
+		alert["responders"] = []map[string]string{{"type": "team", "name": team}}
+	}
+	// an alert with an open alias is deduplicated by opsgenie
+	if err := o.post(ctx, "/v2/alerts", alert); err != nil {
+		return err
+	}
+	o.sessions.touch(incidentKey(n), func() {
+		if err := o.update(context.Background(), IncidentResolve, n, team); err != nil {
+			logger().Error("Error closing alert", "event_id", n.Event, "alias", incidentKey(n), "err", err)
+		}
+	})
+	return nil
+}
+
+func (o *opsgenieNotifier) update(ctx context.Context, action string, n Notification, team string) error {
+	path := "/close"
+	if action == IncidentAcknowledge {
+		path = "/acknowledge"
+	}
+	return o.post(ctx, "/v2/alerts/"+url.PathEscape(incidentKey(n))+path+"?identifierType=alias", map[string]string{"source": "gocv-stream-events"})
+}
+
+func (o *opsgenieNotifier) post(ctx context.Context, path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "GenieKey "+o.apiKey)
+	if err := DoRequest(o.client, req); err != nil {
+		return fmt.Errorf("opsgenie: %w", err)
+	}
+	return nil
+}
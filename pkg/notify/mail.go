@@ -0,0 +1,341 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sendMail sends the notification. The sender, the html body and the extra
+// headers are optional. The attachments are paths of images relative to the
+// snapshot directory and are embedded inline (referenced as cid:image0,
+// cid:image1...).
+func sendMail(ctx context.Context, from string, receiver string, title string, body string, html string, headers map[string]string, attachments ...string) error {
+	if from == "" {
+		from = os.Getenv("EMAIL_ADDR")
+	}
+	// net/smtp has no context, the message is not started after ctx is done
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	switch provider := os.Getenv("EMAIL_PROVIDER"); provider {
+	case "", "smtp":
+		var message []byte
+		message, err = buildMessage(from, receiver, title, body, html, headers, attachments)
+		if err == nil {
+			err = mailer.send(envelopeAddress(from), receiver, message)
+		}
+	case "sendgrid":
+		err = sendgrid.send(ctx, from, receiver, title, body, html, headers, attachments)
+	case "ses":
+		err = ses.send(ctx, from, receiver, title, body, html, headers, attachments)
+	default:
+		err = fmt.Errorf("unknown EMAIL_PROVIDER %q", provider)
+	}
+	if err != nil {
+		return err
+	}
+	logger().Info("Email notification sent", "recipient", receiver)
+	return nil
+}
+
+// envelopeAddress returns the bare address of "Name <address>"
+func envelopeAddress(from string) string {
+	if address, err := mail.ParseAddress(from); err == nil {
+		return address.Address
+	}
+	return from
+}
+
+// readAttachments reads the images from the snapshot directory. Images
+// that cannot be read are skipped since the mail is still worth sending
+// without them.
+func readAttachments(attachments []string) (images [][]byte, names []string) {
+	for _, attachment := range attachments {
+		if attachment == "" || SnapshotDir == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(SnapshotDir, attachment))
+		if err != nil {
+			logger().Error("Error reading attachment", "err", err)
+			continue
+		}
+		images = append(images, data)
+		names = append(names, filepath.Base(attachment))
+	}
+	return images, names
+}
+
+// smtpMailer keeps the SMTP connection open between the messages so that
+// bursts of notifications don't need a new handshake for every mail
+type smtpMailer struct {
+	mu       sync.Mutex
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+var mailer smtpMailer
+
+// the connection is closed if it has not been used for this long
+const smtpIdleTimeout = 30 * time.Second
+
+func (m *smtpMailer) send(from, to string, message []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.client != nil && (time.Since(m.lastUsed) > smtpIdleTimeout || m.client.Reset() != nil) {
+		m.client.Close()
+		m.client = nil
+	}
+	if m.client == nil {
+		client, err := dialSMTP()
+		if err != nil {
+			return err
+		}
+		m.client = client
+	}
+	m.lastUsed = time.Now()
+
+	err := m.deliver(from, to, message)
+	if err != nil {
+		// start with a fresh connection next time
+		m.client.Close()
+		m.client = nil
+	}
+	return err
+}
+
+func (m *smtpMailer) deliver(from, to string, message []byte) error {
+	if err := m.client.Mail(from); err != nil {
+		return err
+	}
+	if err := m.client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := m.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(message); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// dialSMTP connects to SMTP_HOST:SMTP_PORT. SMTP_TLS selects implicit tls
+// ("tls", usually port 465), "starttls" (usually 587) or plain text
+// ("none", default). SMTP_AUTH can be plain, login or cram-md5.
+func dialSMTP() (*smtp.Client, error) {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "25"
+	}
+	address := net.JoinHostPort(host, port)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	mode := os.Getenv("SMTP_TLS")
+	if mode != "" && mode != "none" && mode != "tls" && mode != "starttls" {
+		return nil, fmt.Errorf("unknown SMTP_TLS %q", mode)
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if mode == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if mode == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	user, password := os.Getenv("SMTP_USER"), os.Getenv("SMTP_PASSWORD")
+	var auth smtp.Auth
+	switch os.Getenv("SMTP_AUTH") {
+	case "", "none":
+	case "plain":
+		auth = smtp.PlainAuth("", user, password, host)
+	case "login":
+		auth = &loginAuth{user, password}
+	case "cram-md5":
+		auth = smtp.CRAMMD5Auth(user, password)
+	default:
+		client.Close()
+		return nil, fmt.Errorf("unknown SMTP_AUTH %q", os.Getenv("SMTP_AUTH"))
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// loginAuth implements the LOGIN mechanism which net/smtp doesn't have
+// but e.g. Office 365 requires
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, fmt.Errorf("refusing to send password over unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+	}
+}
+
+// buildMessage creates the MIME message:
+//
+//	multipart/related
+//	  multipart/alternative
+//	    text/plain
+//	    text/html
+//	  image/jpeg...
+//
+// Parts that are not needed (no html, no readable attachments) are left out.
+func buildMessage(from, to, subject, body, html string, headers map[string]string, attachments []string) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\n", from, to, mime.QEncoding.Encode("utf-8", subject))
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, headers[name])
+	}
+
+	images, names := readAttachments(attachments)
+	if len(images) == 0 && html == "" {
+		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n" + body + "\r\n")
+		return msg.Bytes(), nil
+	}
+
+	var content *multipart.Writer
+	if len(images) > 0 {
+		related := multipart.NewWriter(&msg)
+		fmt.Fprintf(&msg, "Content-Type: multipart/related; boundary=%s\r\n\r\n", related.Boundary())
+		content = related
+	}
+
+	if err := writeBody(&msg, content, body, html); err != nil {
+		return nil, err
+	}
+
+	for i, data := range images {
+		part, err := content.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/jpeg"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("inline; filename=%q", names[i])},
+			"Content-Id":                {fmt.Sprintf("<image%d>", i)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(part, data)
+	}
+
+	if content != nil {
+		if err := content.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return msg.Bytes(), nil
+}
+
+// writeBody writes the text (and html) body either as a part of the
+// parent or directly to the message if there is no parent
+func writeBody(msg *bytes.Buffer, parent *multipart.Writer, body, html string) error {
+	if html == "" {
+		part, err := parent.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+		if err != nil {
+			return err
+		}
+		_, err = part.Write([]byte(body + "\r\n"))
+		return err
+	}
+
+	// the boundary is needed in the header before the writer can be created
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	contentType := "multipart/alternative; boundary=" + boundary
+	var w io.Writer = msg
+	if parent != nil {
+		part, err := parent.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+		if err != nil {
+			return err
+		}
+		w = part
+	} else {
+		fmt.Fprintf(msg, "Content-Type: %s\r\n\r\n", contentType)
+	}
+	alternative := multipart.NewWriter(w)
+	if err := alternative.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	for _, alt := range []struct{ contentType, content string }{{"text/plain", body}, {"text/html", html}} {
+		part, err := alternative.CreatePart(textproto.MIMEHeader{"Content-Type": {alt.contentType + "; charset=utf-8"}})
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write([]byte(alt.content + "\r\n")); err != nil {
+			return err
+		}
+	}
+	return alternative.Close()
+}
+
+// writeBase64 writes the data base64 encoded in lines of 76 characters
+// as required by RFC 2045
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
@@ -0,0 +1,157 @@
+// Package notify renders the notifications of the detection events and
+// delivers them through the configured channels (email, chat, sms,
+// incident management...).
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Notification is a rendered notification of a detection event
+type Notification struct {
+	Event int
+	// sender of the mails, empty for the default
+	From    string
+	Subject string
+	Body    string
+	// optional html version of the body
+	HTML string
+	// image relative to the snapshot directory
+	Attachment string
+	// the values the notification was rendered from, for the channels
+	// that format the message themselves
+	Data Data
+}
+
+// notificationJSON is the message body of the channels that deliver the
+// notification as json
+func notificationJSON(n Notification) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"event_id":     n.Event,
+		"subject":      n.Subject,
+		"body":         n.Body,
+		"snapshot_url": SnapshotLink(n.Attachment),
+		"data":         n.Data,
+	})
+}
+
+// Notifier delivers notifications through one channel (email, chat...).
+// The recipient is the channel specific address of the subscription,
+// e.g. an email address or a chat id.
+type Notifier interface {
+	// Send gives up when ctx is done, e.g. when the process shuts down
+	Send(ctx context.Context, n Notification, recipient string) error
+}
+
+var (
+	notifiersMu sync.RWMutex
+	notifiers   = map[string]Notifier{}
+)
+
+// Register makes the notifier available for the subscriptions with the
+// given channel
+func Register(channel string, notifier Notifier) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+	notifiers[channel] = notifier
+}
+
+// Registered reports whether the channel has a notifier
+func Registered(channel string) bool {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+	_, ok := notifiers[channel]
+	return ok
+}
+
+// Channels returns the channels with a notifier in alphabetical order
+func Channels() []string {
+	notifiersMu.RLock()
+	channels := []string{}
+	for channel := range notifiers {
+		channels = append(channels, channel)
+	}
+	notifiersMu.RUnlock()
+	sort.Strings(channels)
+	return channels
+}
+
+// Send sends the notification through the channel. A panic of the
+// notifier is logged with its stack and returned as an error, so that the
+// notification fails like any other delivery instead of the process.
+func Send(ctx context.Context, channel string, n Notification, recipient string) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			logger().Error("Notifier panicked", "channel", channel, "event", n.Event, "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			err = fmt.Errorf("panic in %s notifier: %v", channel, v)
+		}
+	}()
+	notifiersMu.RLock()
+	notifier, ok := notifiers[channel]
+	notifiersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no notifier for channel %q", channel)
+	}
+	return notifier.Send(ctx, n, recipient)
+}
+
+// emailNotifier sends the notifications with sendMail
+type emailNotifier struct{}
+
+func (emailNotifier) Send(ctx context.Context, n Notification, recipient string) error {
+	return sendMail(ctx, n.From, recipient, n.Subject, n.Body, n.HTML, unsubscribeHeaders(n.Data.UnsubscribeURL), n.Attachment)
+}
+
+// Init registers the notifiers that have been configured, an error if
+// the configuration is invalid
+func Init() error {
+	if err := initRateLimits(os.Getenv("NOTIFY_RATE_LIMITS"), os.Getenv("NOTIFY_OVERFLOW")); err != nil {
+		return err
+	}
+	rate, err := envInt("SES_RATE", 0)
+	if err != nil {
+		return err
+	}
+	if rate > 0 {
+		ses.throttle.interval = time.Second / time.Duration(rate)
+	}
+	Register("email", emailNotifier{})
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		Register("telegram", newTelegramNotifier(token))
+	}
+	// webhooks work without the token
+	Register("slack", newSlackNotifier(os.Getenv("SLACK_BOT_TOKEN")))
+	Register("discord", newDiscordNotifier())
+	Register("webhook", NewWebhookClient(os.Getenv("WEBHOOK_SECRET")))
+	resolveAfter, err := envDuration("INCIDENT_RESOLVE_AFTER", 30*time.Minute)
+	if err != nil {
+		return err
+	}
+	Register("pagerduty", newPagerdutyNotifier(resolveAfter))
+	if key := os.Getenv("OPSGENIE_API_KEY"); key != "" {
+		Register("opsgenie", newOpsgenieNotifier(key, os.Getenv("OPSGENIE_URL"), resolveAfter))
+	}
+	// use the AWS_* credentials
+	Register("sns", newSNSNotifier())
+	Register("sqs", newSQSNotifier())
+	switch {
+	case os.Getenv("TWILIO_ACCOUNT_SID") != "":
+		Register("sms", newTwilioNotifier(os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_FROM")))
+	case os.Getenv("SMS_GATEWAY_URL") != "":
+		Register("sms", newGatewayNotifier(os.Getenv("SMS_GATEWAY_URL"), os.Getenv("SMS_GATEWAY_BODY")))
+	}
+	// the plugins may also replace the channels above
+	pluginTimeout, err := envDuration("PLUGIN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return err
+	}
+	registerPlugins(os.Getenv("NOTIFY_PLUGINS"), pluginTimeout)
+	return nil
+}
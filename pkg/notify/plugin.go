@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/plugin"
+)
+
+// pluginNotifier delivers the notifications of a channel with an external
+// program, see package plugin. The notification is sent with the method
+// "notify".
+type pluginNotifier struct {
+	plugin *plugin.Plugin
+}
+
+// Send only checks ctx before the call, the call ends with the timeout of
+// the plugin
+func (p pluginNotifier) Send(ctx context.Context, n Notification, recipient string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.plugin.Call("notify", map[string]interface{}{
+		"recipient":    recipient,
+		"event_id":     n.Event,
+		"subject":      n.Subject,
+		"body":         n.Body,
+		"html":         n.HTML,
+		"snapshot_url": SnapshotLink(n.Attachment),
+		"data":         n.Data,
+	})
+}
+
+// registerPlugins registers the notifiers of the comma separated
+// channel=command list, e.g. "signal=/usr/local/bin/signal-notifier"
+func registerPlugins(config string, timeout time.Duration) {
+	for _, entry := range strings.Split(config, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		channel, command, ok := strings.Cut(entry, "=")
+		if !ok {
+			logger().Error("Invalid notifier plugin, expected channel=command", "plugin", entry)
+			continue
+		}
+		p, err := plugin.New(channel, command, timeout)
+		if err != nil {
+			logger().Error("Notifier plugin disabled", "err", err)
+			continue
+		}
+		Register(channel, pluginNotifier{p})
+	}
+}
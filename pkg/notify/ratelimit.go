@@ -0,0 +1,111 @@
+package notify
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows bursts of up to burst calls, refilled at rate
+// tokens per second
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(count int, per time.Duration) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(count) / per.Seconds(),
+		burst:  float64(count),
+		tokens: float64(count),
+		last:   time.Now(),
+	}
+}
+
+// take uses a token if there is one. Otherwise it returns how long it
+// takes until the next token is available.
+func (b *tokenBucket) take() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// overflow policies for the rate limited notifications: deferred ones are
+// sent as is when the limit allows, collapsed ones are merged into a
+// single summary of everything pending for the same recipient
+const (
+	OverflowDefer    = "defer"
+	OverflowCollapse = "collapse"
+)
+
+var (
+	// rate limits of the channels, the limits are per dispatcher process
+	rateLimits     = map[string]*tokenBucket{}
+	OverflowPolicy = OverflowDefer
+)
+
+// parseRateLimits parses NOTIFY_RATE_LIMITS, e.g. "email=10/1m,sms=5/1h"
+func parseRateLimits(value string) (map[string]*tokenBucket, error) {
+	limits := map[string]*tokenBucket{}
+	for _, limit := range strings.Split(value, ",") {
+		limit = strings.TrimSpace(limit)
+		if limit == "" {
+			continue
+		}
+		channel, rate, ok := strings.Cut(limit, "=")
+		count, per, ok2 := strings.Cut(rate, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid rate limit %q", limit)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid count in rate limit %q", limit)
+		}
+		d, err := time.ParseDuration(per)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid period in rate limit %q", limit)
+		}
+		limits[strings.TrimSpace(channel)] = newTokenBucket(n, d)
+	}
+	return limits, nil
+}
+
+// initRateLimits reads the rate limits of the channels
+func initRateLimits(limits, overflow string) error {
+	parsed, err := parseRateLimits(limits)
+	if err != nil {
+		return fmt.Errorf("NOTIFY_RATE_LIMITS: %v", err)
+	}
+	rateLimits = parsed
+	switch overflow {
+	case "", OverflowDefer:
+	case OverflowCollapse:
+		OverflowPolicy = OverflowCollapse
+	default:
+		return fmt.Errorf("unknown NOTIFY_OVERFLOW %q", overflow)
+	}
+	return nil
+}
+
+// RateLimited returns how long the notification of the channel has to
+// wait, 0 if it can be sent now
+func RateLimited(channel string) time.Duration {
+	bucket, ok := rateLimits[channel]
+	if !ok {
+		return 0
+	}
+	return bucket.take()
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		// the burst of the channels
+		want map[string]float64
+		err  bool
+	}{
+		{"none", "", map[string]float64{}, false},
+		{"channels", "email=10/1m, sms=5/1h", map[string]float64{"email": 10, "sms": 5}, false},
+		{"trailing comma", "email=10/1m,", map[string]float64{"email": 10}, false},
+		{"no count", "email", nil, true},
+		{"no period", "email=10", nil, true},
+		{"zero count", "email=0/1m", nil, true},
+		{"invalid count", "email=ten/1m", nil, true},
+		{"invalid period", "email=10/minute", nil, true},
+		{"negative period", "email=10/-1m", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits, err := parseRateLimits(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("parseRateLimits(%q) error %v, want error %v", tt.value, err, tt.err)
+			}
+			if len(limits) != len(tt.want) {
+				t.Fatalf("parseRateLimits(%q) = %d limits, want %d", tt.value, len(limits), len(tt.want))
+			}
+			for channel, burst := range tt.want {
+				if b, ok := limits[channel]; !ok || b.burst != burst {
+					t.Errorf("parseRateLimits(%q) limit of %s = %v, want a burst of %v", tt.value, channel, b, burst)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(2, time.Minute)
+	for i := 0; i < 2; i++ {
+		if wait := b.take(); wait != 0 {
+			t.Fatalf("take %d of the burst waits %v", i, wait)
+		}
+	}
+	wait := b.take()
+	if wait <= 0 || wait > 30*time.Second {
+		t.Fatalf("take after the burst waits %v, want up to 30s", wait)
+	}
+
+	// half a minute refills one token
+	b.last = b.last.Add(-30 * time.Second)
+	if wait := b.take(); wait != 0 {
+		t.Errorf("take after the refill waits %v", wait)
+	}
+	// the tokens never exceed the burst
+	b.last = b.last.Add(-time.Hour)
+	b.take()
+	if b.tokens > 1 {
+		t.Errorf("%v tokens after an hour, want at most the burst of 2 less one", b.tokens)
+	}
+}
@@ -0,0 +1,143 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sendgridProvider sends the emails with the SendGrid v3 api
+// (EMAIL_PROVIDER=sendgrid, SENDGRID_API_KEY)
+type sendgridProvider struct {
+	client   *http.Client
+	throttle throttle
+}
+
+var sendgrid = &sendgridProvider{
+	client: &http.Client{Timeout: 30 * time.Second},
+	// well below the api limit of 600 requests per minute
+	throttle: throttle{interval: 200 * time.Millisecond},
+}
+
+type sendgridContent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type sendgridAttachment struct {
+	Content     string `json:"content"`
+	Type        string `json:"type"`
+	Filename    string `json:"filename"`
+	Disposition string `json:"disposition"`
+	ContentID   string `json:"content_id"`
+}
+
+type sendgridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+type sendgridPersonalization struct {
+	To []sendgridAddress `json:"to"`
+}
+
+type sendgridMail struct {
+	Personalizations []sendgridPersonalization `json:"personalizations"`
+	From             sendgridAddress           `json:"from"`
+	Subject          string                    `json:"subject"`
+	Content          []sendgridContent         `json:"content"`
+	Attachments      []sendgridAttachment      `json:"attachments,omitempty"`
+	Headers          map[string]string         `json:"headers,omitempty"`
+}
+
+// sendgridFrom splits "Name <address>" for the api
+func sendgridFrom(from string) sendgridAddress {
+	if address, err := mail.ParseAddress(from); err == nil {
+		return sendgridAddress{Email: address.Address, Name: address.Name}
+	}
+	return sendgridAddress{Email: from}
+}
+
+func (s *sendgridProvider) send(ctx context.Context, from, to, subject, body, html string, headers map[string]string, attachments []string) error {
+	mail := sendgridMail{
+		Personalizations: []sendgridPersonalization{{To: []sendgridAddress{{Email: to}}}},
+		From:             sendgridFrom(from),
+		Subject:          subject,
+		Content:          []sendgridContent{{"text/plain", body}},
+		Headers:          headers,
+	}
+	if html != "" {
+		mail.Content = append(mail.Content, sendgridContent{"text/html", html})
+	}
+	images, names := readAttachments(attachments)
+	for i, image := range images {
+		mail.Attachments = append(mail.Attachments, sendgridAttachment{
+			Content:     base64.StdEncoding.EncodeToString(image),
+			Type:        "image/jpeg",
+			Filename:    names[i],
+			Disposition: "inline",
+			ContentID:   fmt.Sprintf("image%d", i),
+		})
+	}
+
+	payload, err := json.Marshal(mail)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.sendgrid.com/v3/mail/send", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SENDGRID_API_KEY"))
+	req.Header.Set("Content-Type", "application/json")
+
+	s.throttle.wait()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		// X-RateLimit-Reset is the unix time when the quota is refilled
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			s.throttle.pause(time.Until(time.Unix(reset, 0)))
+		}
+		return fmt.Errorf("sendgrid: rate limit exceeded")
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid: %s: %s", resp.Status, sendgridErrors(resp.Body))
+	}
+	return nil
+}
+
+// sendgridErrors joins the messages of a sendgrid error response
+func sendgridErrors(body io.Reader) string {
+	var response struct {
+		Errors []struct {
+			Message string `json:"message"`
+			Field   string `json:"field"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, 64*1024)).Decode(&response); err != nil {
+		return "unknown error"
+	}
+	var messages []string
+	for _, e := range response.Errors {
+		if e.Field != "" {
+			messages = append(messages, e.Field+": "+e.Message)
+		} else {
+			messages = append(messages, e.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sesProvider sends the emails with the Amazon SES v2 api
+// (EMAIL_PROVIDER=ses). The message is built the same way as for SMTP
+// and sent as raw content so the inline images work the same.
+type sesProvider struct {
+	client   *http.Client
+	throttle throttle
+}
+
+var ses = &sesProvider{
+	client: &http.Client{Timeout: 30 * time.Second},
+	// the sending rate of a SES sandbox account (SES_RATE overrides)
+	throttle: throttle{interval: time.Second},
+}
+
+func (s *sesProvider) send(ctx context.Context, from, to, subject, body, html string, headers map[string]string, attachments []string) error {
+	message, err := buildMessage(from, to, subject, body, html, headers, attachments)
+	if err != nil {
+		return err
+	}
+
+	var request struct {
+		FromEmailAddress string
+		Destination      struct{ ToAddresses []string }
+		Content          struct{ Raw struct{ Data []byte } }
+	}
+	request.FromEmailAddress = from
+	request.Destination.ToAddresses = []string{to}
+	// []byte is encoded as base64 which is what the api expects
+	request.Content.Raw.Data = message
+
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	region := awsRegion()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://email.%s.amazonaws.com/v2/email/outbound-emails", region), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := signAWS(req, payload, "ses", region); err != nil {
+		return err
+	}
+
+	s.throttle.wait()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var response struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response)
+		// e.g. "TooManyRequestsException:http://internal.amazon.com/..."
+		errorType := strings.SplitN(resp.Header.Get("X-Amzn-ErrorType"), ":", 2)[0]
+		if errorType == "TooManyRequestsException" || errorType == "LimitExceededException" || resp.StatusCode == http.StatusTooManyRequests {
+			s.throttle.pause(time.Minute)
+		}
+		return fmt.Errorf("ses: %s %s: %s", resp.Status, errorType, response.Message)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// slackNotifier posts the alerts to Slack. The recipient is either an
+// incoming webhook url or a channel id which is posted to with the Web
+// API (needs SLACK_BOT_TOKEN). The snapshot is shown if SNAPSHOT_URL
+// makes it publicly available because Slack fetches the image itself.
+type slackNotifier struct {
+	token  string
+	client *http.Client
+}
+
+func newSlackNotifier(token string) *slackNotifier {
+	return &slackNotifier{token: token, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string     `json:"type"`
+	Text     *slackText `json:"text,omitempty"`
+	ImageURL string     `json:"image_url,omitempty"`
+	AltText  string     `json:"alt_text,omitempty"`
+}
+
+type slackMessage struct {
+	Channel string       `json:"channel,omitempty"`
+	Text    string       `json:"text"`
+	Blocks  []slackBlock `json:"blocks"`
+}
+
+func (s *slackNotifier) Send(ctx context.Context, n Notification, recipient string) error {
+	text := notificationCaption(n)
+	if d := n.Data; d.Stream != "" {
+		text = fmt.Sprintf("*%s %s's* detected at *%s*", d.CountWord, d.Class, d.Stream)
+		if d.Time != "" {
+			text += " (" + d.Time + ")"
+		}
+		if d.Place != "" {
+			text += "\n" + d.Place
+		}
+		if d.Link != "" {
+			text += fmt.Sprintf("\n<%s|Check stream>", d.Link)
+		}
+	}
+
+	message := slackMessage{
+		// plain text fallback for notifications
+		Text:   notificationCaption(n),
+		Blocks: []slackBlock{{Type: "section", Text: &slackText{"mrkdwn", text}}},
+	}
+	if image := SnapshotLink(n.Attachment); image != "" {
+		message.Blocks = append(message.Blocks, slackBlock{Type: "image", ImageURL: image, AltText: "snapshot"})
+	}
+
+	endpoint := recipient
+	if !strings.HasPrefix(recipient, "https://") {
+		message.Channel = recipient
+		endpoint = "https://slack.com/api/chat.postMessage"
+	}
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if message.Channel != "" {
+		if s.token == "" {
+			return fmt.Errorf("slack: SLACK_BOT_TOKEN is needed for posting to channel %s", recipient)
+		}
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack: %s: %s", resp.Status, body)
+	}
+
+	// the Web API reports errors in the body with status 200
+	if message.Channel != "" {
+		var response struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(body, &response); err != nil {
+			return err
+		}
+		if !response.OK {
+			return fmt.Errorf("slack: %s", response.Error)
+		}
+	}
+	return nil
+}
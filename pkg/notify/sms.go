@@ -0,0 +1,125 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SMS messages longer than this are split to several parts by the
+// carriers, so the caption is cut to keep the cost of an alert down
+const smsMaxLength = 306
+
+// twilioNotifier sends the alerts as text messages with the Twilio api.
+// The recipient is the phone number in E.164 format.
+type twilioNotifier struct {
+	account string
+	token   string
+	from    string
+	client  *http.Client
+	// twilio queues messages over 1 per second per number
+	throttle throttle
+}
+
+func newTwilioNotifier(account, token, from string) *twilioNotifier {
+	return &twilioNotifier{
+		account:  account,
+		token:    token,
+		from:     from,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		throttle: throttle{interval: time.Second},
+	}
+}
+
+// smsText is the caption of the notification cut to the length of two
+// message parts
+func smsText(n Notification) string {
+	text := []rune(notificationCaption(n))
+	if len(text) > smsMaxLength {
+		text = append(text[:smsMaxLength-1], '…')
+	}
+	return string(text)
+}
+
+func (t *twilioNotifier) Send(ctx context.Context, n Notification, phone string) error {
+	form := url.Values{}
+	form.Set("To", phone)
+	form.Set("From", t.from)
+	form.Set("Body", smsText(n))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.twilio.com/2010-04-01/Accounts/"+t.account+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.account, t.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	t.throttle.wait()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var response struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response) != nil {
+			return fmt.Errorf("twilio: %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			t.throttle.pause(time.Minute)
+		}
+		return fmt.Errorf("twilio: %s (%d)", response.Message, response.Code)
+	}
+	return nil
+}
+
+// gatewayNotifier sends the text messages through a generic http gateway,
+// e.g. the sendsms interface of Kannel in front of an SMPP connection.
+// The {to} and {text} placeholders of the url are replaced with the
+// escaped phone number and message. When a body template is given the
+// request is a POST with the placeholders replaced in the body instead.
+type gatewayNotifier struct {
+	url    string
+	body   string
+	client *http.Client
+}
+
+func newGatewayNotifier(url, body string) *gatewayNotifier {
+	return &gatewayNotifier{url: url, body: body, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (g *gatewayNotifier) Send(ctx context.Context, n Notification, phone string) error {
+	text := smsText(n)
+	address := strings.NewReplacer("{to}", url.QueryEscape(phone), "{text}", url.QueryEscape(text)).Replace(g.url)
+
+	var req *http.Request
+	var err error
+	if g.body == "" {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	} else {
+		// the body is json, so the values are quoted like json strings
+		to, _ := json.Marshal(phone)
+		message, _ := json.Marshal(text)
+		body := strings.NewReplacer(`"{to}"`, string(to), `"{text}"`, string(message)).Replace(g.body)
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, address, strings.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := DoRequest(g.client, req); err != nil {
+		return fmt.Errorf("sms gateway: %w", err)
+	}
+	return nil
+}
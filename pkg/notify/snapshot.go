@@ -0,0 +1,23 @@
+package notify
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotDir is the directory where the frames and crops of the detection
+// events are saved (snapshots are disabled if empty). The attachments of
+// the notifications are relative to it.
+var SnapshotDir string
+
+// SnapshotURL is the public address of the snapshot directory used in
+// notifications and sinks
+var SnapshotURL string
+
+// SnapshotLink returns the public url of a saved snapshot or crop
+func SnapshotLink(path string) string {
+	if path == "" || SnapshotURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(SnapshotURL, "/") + "/" + filepath.ToSlash(path)
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// snsNotifier publishes the notifications to an Amazon SNS topic. The
+// recipient is the topic arn, the region is taken from the arn.
+type snsNotifier struct {
+	client *http.Client
+}
+
+func newSNSNotifier() *snsNotifier {
+	return &snsNotifier{client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *snsNotifier) Send(ctx context.Context, n Notification, topicArn string) error {
+	// arn:aws:sns:<region>:<account>:<name>
+	parts := strings.Split(topicArn, ":")
+	if len(parts) != 6 || parts[2] != "sns" {
+		return fmt.Errorf("sns: invalid topic arn %q", topicArn)
+	}
+	region := parts[3]
+
+	message, err := notificationJSON(n)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("Action", "Publish")
+	form.Set("Version", "2010-03-31")
+	form.Set("TopicArn", topicArn)
+	form.Set("Subject", snsSubject(n.Subject))
+	form.Set("Message", string(message))
+	// the attributes allow filter policies on the subscriptions
+	entry := 0
+	for _, attribute := range [][2]string{{"class", n.Data.Class}, {"stream", n.Data.Stream}} {
+		// empty values are rejected
+		if attribute[1] == "" {
+			continue
+		}
+		entry++
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", entry)
+		form.Set(prefix+"Name", attribute[0])
+		form.Set(prefix+"Value.DataType", "String")
+		form.Set(prefix+"Value.StringValue", attribute[1])
+	}
+	body := []byte(form.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://sns.%s.amazonaws.com/", region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	if err := signAWS(req, body, "sns", region); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var response struct {
+			Error struct {
+				Code    string
+				Message string
+			}
+		}
+		if xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response) != nil {
+			return fmt.Errorf("sns: %s", resp.Status)
+		}
+		return fmt.Errorf("sns: %s %s: %s", resp.Status, response.Error.Code, response.Error.Message)
+	}
+	return nil
+}
+
+// snsSubject makes the subject acceptable for sns: ascii without line
+// breaks, at most 100 characters
+func snsSubject(subject string) string {
+	subject = strings.Map(func(r rune) rune {
+		if r < 32 || r > 126 {
+			return ' '
+		}
+		return r
+	}, subject)
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+	return strings.TrimSpace(subject)
+}
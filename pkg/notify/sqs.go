@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var slugPattern = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// slug turns a stream address into something usable as a message group id
+func slug(s string) string {
+	return slugPattern.ReplaceAllString(s, "_")
+}
+
+// sqsNotifier sends the notifications to an Amazon SQS queue. The
+// recipient is the queue url, the region is taken from the url.
+type sqsNotifier struct {
+	client *http.Client
+}
+
+func newSQSNotifier() *sqsNotifier {
+	return &sqsNotifier{client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *sqsNotifier) Send(ctx context.Context, n Notification, queueURL string) error {
+	// https://sqs.<region>.amazonaws.com/<account>/<name>
+	address, err := url.Parse(queueURL)
+	if err != nil {
+		return fmt.Errorf("sqs: %w", err)
+	}
+	host := strings.Split(address.Host, ".")
+	if len(host) < 3 || host[0] != "sqs" {
+		return fmt.Errorf("sqs: invalid queue url %q", queueURL)
+	}
+	region := host[1]
+
+	message, err := notificationJSON(n)
+	if err != nil {
+		return err
+	}
+	type attribute struct {
+		DataType    string
+		StringValue string
+	}
+	request := struct {
+		QueueUrl          string
+		MessageBody       string
+		MessageAttributes map[string]attribute `json:",omitempty"`
+		// only for fifo queues
+		MessageGroupId         string `json:",omitempty"`
+		MessageDeduplicationId string `json:",omitempty"`
+	}{QueueUrl: queueURL, MessageBody: string(message), MessageAttributes: map[string]attribute{}}
+	if n.Data.Class != "" {
+		request.MessageAttributes["class"] = attribute{"String", n.Data.Class}
+	}
+	if n.Data.Stream != "" {
+		request.MessageAttributes["stream"] = attribute{"String", n.Data.Stream}
+	}
+	if strings.HasSuffix(address.Path, ".fifo") {
+		// the notifications of a stream are kept in order
+		request.MessageGroupId = "gocv-" + slug(n.Data.Stream)
+		request.MessageDeduplicationId = fmt.Sprint(n.Event)
+	}
+	return s.post(ctx, region, request)
+}
+
+func (s *sqsNotifier) post(ctx context.Context, region string, request interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://sqs.%s.amazonaws.com/", region), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "AmazonSQS.SendMessage")
+	if err := signAWS(req, body, "sqs", region); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var response struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response)
+		return fmt.Errorf("sqs: %s %s: %s", resp.Status, response.Type, response.Message)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// telegramNotifier sends the alerts as photo messages with a Telegram
+// bot. The recipient of the subscription is the chat id.
+type telegramNotifier struct {
+	token    string
+	client   *http.Client
+	throttle throttle
+}
+
+func newTelegramNotifier(token string) *telegramNotifier {
+	return &telegramNotifier{
+		token:  token,
+		client: &http.Client{Timeout: 30 * time.Second},
+		// bots may send about 30 messages per second
+		throttle: throttle{interval: 50 * time.Millisecond},
+	}
+}
+
+// notificationCaption is a short summary of the notification for the chat
+// channels
+func notificationCaption(n Notification) string {
+	d := n.Data
+	if d.Stream == "" {
+		return n.Subject
+	}
+	count := d.CountWord
+	if count == "" {
+		count = fmt.Sprint(d.Count)
+	}
+	var caption string
+	if d.Events > 1 {
+		caption = fmt.Sprintf(burstCaptionFormat(d.Language), d.Events, d.WindowMinutes, d.Stream, count, d.Class, d.PeakTime)
+	} else {
+		caption = fmt.Sprintf(captionFormat(d.Language), count, d.Class, d.Stream)
+		if d.Time != "" {
+			caption += " (" + d.Time + ")"
+		}
+	}
+	if d.Link != "" {
+		caption += "\n" + d.Link
+	}
+	return caption
+}
+
+func (t *telegramNotifier) Send(ctx context.Context, n Notification, chatID string) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("chat_id", chatID)
+
+	method := "sendMessage"
+	if n.Attachment != "" && SnapshotDir != "" {
+		photo, err := os.ReadFile(filepath.Join(SnapshotDir, n.Attachment))
+		if err == nil {
+			method = "sendPhoto"
+			writer.WriteField("caption", notificationCaption(n))
+			part, err := writer.CreateFormFile("photo", filepath.Base(n.Attachment))
+			if err != nil {
+				return err
+			}
+			part.Write(photo)
+		}
+	}
+	if method == "sendMessage" {
+		writer.WriteField("text", notificationCaption(n))
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://api.telegram.org/bot%s/%s", t.token, method), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	t.throttle.wait()
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// the error contains the url with the token
+		return fmt.Errorf("telegram: %s", strings.ReplaceAll(err.Error(), t.token, "***"))
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		OK          bool   `json:"ok"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&response); err != nil {
+		return fmt.Errorf("telegram: %s", resp.Status)
+	}
+	if !response.OK {
+		if response.Parameters.RetryAfter > 0 {
+			t.throttle.pause(time.Duration(response.Parameters.RetryAfter) * time.Second)
+		}
+		return fmt.Errorf("telegram: %s", response.Description)
+	}
+	return nil
+}
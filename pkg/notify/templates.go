@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// default notification templates. Each notification type has a text
+// template (with a "subject" block) and optionally a html template. The
+// translations are in subdirectories named by the language. The files can
+// be overridden by placing a file with the same name to TEMPLATE_DIR.
+//
+//go:embed templates
+var defaultTemplates embed.FS
+
+// Data is passed to the notification templates
+type Data struct {
+	Stream    string
+	Link      string
+	Place     string
+	Class     string
+	Count     int
+	CountWord string
+	// highest confidence of the detections (0..100)
+	Confidence int
+	Time       string
+	// image source, either a cid: reference to the attachment or an url
+	// (typed so that html/template does not filter the cid: scheme)
+	Snapshot       htmltemplate.URL
+	UnsubscribeURL string
+	// language of the notification, e.g. en
+	Language string
+	// events aggregated by the burst window of the subscription, the
+	// class and count are the ones of the peak event
+	Events        int
+	WindowMinutes int
+	PeakTime      string
+}
+
+// Branding is the sender and the templates of the notifications of a
+// stream
+type Branding struct {
+	// formatted From address, empty for EMAIL_ADDR
+	Sender string
+	// directory with template overrides in the layout of TEMPLATE_DIR
+	TemplateDir string
+}
+
+// readTemplate returns the template file in the language, falling back to
+// the English one. The branding directory overrides
+// TEMPLATE_DIR, which overrides the embedded defaults.
+func readTemplate(brandingDir, language, name string) (string, error) {
+	if language != "" && language != LanguageEnglish {
+		data, err := readTemplateFile(brandingDir, language+"/"+name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return data, err
+		}
+	}
+	return readTemplateFile(brandingDir, name)
+}
+
+// readTemplateFile returns the overridden template file or the embedded default
+func readTemplateFile(brandingDir, name string) (string, error) {
+	for _, dir := range []string{brandingDir, os.Getenv("TEMPLATE_DIR")} {
+		if dir == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+	}
+	data, err := defaultTemplates.ReadFile("templates/" + name)
+	return string(data), err
+}
+
+// Render executes the templates of the notification type
+// (e.g. "detection") in the language. The html body is empty if the type
+// has no html template.
+func Render(kind, language string, branding Branding, data interface{}) (subject, text, html string, err error) {
+	source, err := readTemplate(branding.TemplateDir, language, kind+".txt")
+	if err != nil {
+		return
+	}
+	tmpl, err := template.New(kind).Parse(source)
+	if err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err = tmpl.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return
+	}
+	subject = strings.TrimSpace(buf.String())
+	buf.Reset()
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return
+	}
+	text = buf.String()
+
+	source, err = readTemplate(branding.TemplateDir, language, kind+".html")
+	if errors.Is(err, fs.ErrNotExist) {
+		return subject, text, "", nil
+	} else if err != nil {
+		return
+	}
+	htmlTmpl, err := htmltemplate.New(kind).Parse(source)
+	if err != nil {
+		return
+	}
+	buf.Reset()
+	if err = htmlTmpl.Execute(&buf, data); err != nil {
+		return
+	}
+	html = buf.String()
+	return
+}
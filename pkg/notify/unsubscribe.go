@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// The unsubscribe links point to UNSUBSCRIBE_URL, which is served by the
+// unsubscribe handler of the server, with the id of the subscription and a
+// token signed with UNSUBSCRIBE_SECRET. Without the secret UNSUBSCRIBE_URL
+// is used as is, e.g. to point to a page with instructions.
+
+// unsubscribeToken signs the id of the subscription
+func unsubscribeToken(subscription int) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("UNSUBSCRIBE_SECRET")))
+	fmt.Fprintf(mac, "unsubscribe:%d", subscription)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// UnsubscribeLink returns the one-click unsubscribe url of the subscription
+func UnsubscribeLink(subscription int) string {
+	link := os.Getenv("UNSUBSCRIBE_URL")
+	if link == "" || os.Getenv("UNSUBSCRIBE_SECRET") == "" {
+		return link
+	}
+	query := url.Values{}
+	query.Set("s", strconv.Itoa(subscription))
+	query.Set("t", unsubscribeToken(subscription))
+	return link + "?" + query.Encode()
+}
+
+// unsubscribeHeaders are the List-Unsubscribe headers of the mails (the
+// one-click POST of RFC 8058 only works with the signed links)
+func unsubscribeHeaders(link string) map[string]string {
+	if link == "" {
+		return nil
+	}
+	headers := map[string]string{"List-Unsubscribe": "<" + link + ">"}
+	if os.Getenv("UNSUBSCRIBE_SECRET") != "" {
+		headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
+	}
+	return headers
+}
+
+// ValidUnsubscribeToken reports whether the token of an unsubscribe link
+// is the one of the subscription
+func ValidUnsubscribeToken(subscription int, token string) bool {
+	return os.Getenv("UNSUBSCRIBE_SECRET") != "" && hmac.Equal([]byte(token), []byte(unsubscribeToken(subscription)))
+}
@@ -0,0 +1,42 @@
+package notify
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestUnsubscribeLink(t *testing.T) {
+	t.Setenv("UNSUBSCRIBE_URL", "https://example.com/unsubscribe")
+	t.Setenv("UNSUBSCRIBE_SECRET", "secret")
+
+	link, err := url.Parse(UnsubscribeLink(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("t")
+	tests := []struct {
+		name         string
+		subscription int
+		token        string
+		want         bool
+	}{
+		{"own token", 42, token, true},
+		{"other subscription", 43, token, false},
+		{"empty token", 42, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidUnsubscribeToken(tt.subscription, tt.token); got != tt.want {
+				t.Errorf("ValidUnsubscribeToken(%d, %q) = %v, want %v", tt.subscription, tt.token, got, tt.want)
+			}
+		})
+	}
+
+	t.Setenv("UNSUBSCRIBE_SECRET", "")
+	if ValidUnsubscribeToken(42, token) {
+		t.Error("token valid without UNSUBSCRIBE_SECRET")
+	}
+	if got := UnsubscribeLink(42); got != "https://example.com/unsubscribe" {
+		t.Errorf("UnsubscribeLink() without secret = %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// envInt returns the integer value of the environment variable or the
+// default if it is not set
+func envInt(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %v", name, err)
+	}
+	return n, nil
+}
+
+// envDuration is like envInt for durations (e.g. 30s, 5m)
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %v", name, err)
+	}
+	return d, nil
+}
+
+// logger returns the logger of the notifications
+func logger() *slog.Logger {
+	return slog.Default().With("module", "notify")
+}
+
+// DoRequest sends the request and turns non 2xx responses into errors
+func DoRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Host, resp.Status, msg)
+	}
+	return nil
+}
+
+// throttle spaces calls evenly to stay within the rate limits of an api
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait blocks until the next call is allowed
+func (t *throttle) wait() {
+	t.mu.Lock()
+	now := time.Now()
+	wait := t.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	t.next = now.Add(wait + t.interval)
+	t.mu.Unlock()
+	time.Sleep(wait)
+}
+
+// pause delays all calls until the time has passed, e.g. when the api
+// tells to retry later
+func (t *throttle) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.next) {
+		t.next = until
+	}
+}
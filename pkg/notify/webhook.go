@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WebhookClient posts json payloads to http endpoints. It is used both
+// as a sink for all events (WEBHOOK_URLS) and as the notifier of the
+// subscriptions with channel 'webhook' (the recipient is the url).
+//
+// The requests are signed with WEBHOOK_SECRET: X-Webhook-Signature is
+// "sha256=" + hex(hmac_sha256(secret, X-Webhook-Timestamp + "." + body)).
+type WebhookClient struct {
+	secret string
+	client *http.Client
+}
+
+// failed requests are retried with exponential backoff starting from this
+const (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+)
+
+func NewWebhookClient(secret string) *WebhookClient {
+	return &WebhookClient{secret: secret, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Send posts the notification once, the outbox retries the failed
+// deliveries with its own backoff
+func (w *WebhookClient) Send(ctx context.Context, n Notification, url string) error {
+	payload, err := notificationJSON(n)
+	if err != nil {
+		return err
+	}
+	return w.post(ctx, url, payload, "application/json")
+}
+
+// PostWithRetry posts the payload to the url, retrying the failed requests
+// until ctx is done. It is used by the event sink, which has no outbox.
+func (w *WebhookClient) PostWithRetry(ctx context.Context, url string, payload []byte, contentType string) error {
+	var err error
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = w.post(ctx, url, payload, contentType); err == nil {
+			return nil
+		}
+		if attempt < webhookAttempts {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", webhookAttempts, err)
+}
+
+func (w *WebhookClient) post(ctx context.Context, url string, payload []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if w.secret != "" {
+		timestamp := fmt.Sprint(time.Now().Unix())
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(payload)
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return DoRequest(w.client, req)
+}
+
+func (w *WebhookClient) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
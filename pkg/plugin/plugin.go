@@ -0,0 +1,220 @@
+// Package plugin runs external programs that receive the events and
+// notifications of the detector, so that custom sinks and notifiers can
+// be written in any language without forking the repository.
+//
+// The plugin is started once and kept running. Every call is a single
+// line of json written to the stdin of the plugin:
+//
+//	{"id":1,"method":"event","params":{...}}
+//
+// and the plugin answers with a single line to its stdout:
+//
+//	{"id":1}                      on success
+//	{"id":1,"error":"message"}    on failure
+//
+// Anything the plugin writes to stderr is logged. The stdin is closed
+// when the detector stops. A plugin that exits or does not answer in time
+// is restarted on the next call.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when the plugin does not answer to a call in time
+var ErrTimeout = errors.New("plugin did not respond in time")
+
+// restartDelay is the minimum time between the starts of a plugin that
+// keeps exiting
+const restartDelay = 5 * time.Second
+
+type request struct {
+	ID     int         `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params"`
+}
+
+type response struct {
+	ID    int    `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// Plugin is an external program receiving calls over its stdin and stdout.
+// Calls are sent one at a time.
+type Plugin struct {
+	Name    string
+	command []string
+	timeout time.Duration
+
+	mu        sync.Mutex
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan response
+	lastID    int
+	started   time.Time
+}
+
+// logger returns the logger of the plugins
+func logger() *slog.Logger {
+	return slog.Default().With("module", "plugin")
+}
+
+// New returns the plugin of the command line, e.g. "/usr/local/bin/sink --verbose".
+// The program is started on the first call.
+func New(name, commandLine string, timeout time.Duration) (*Plugin, error) {
+	command := strings.Fields(commandLine)
+	if len(command) == 0 {
+		return nil, fmt.Errorf("plugin %s: empty command", name)
+	}
+	if name == "" {
+		name = command[0]
+	}
+	return &Plugin{Name: name, command: command, timeout: timeout}, nil
+}
+
+// start runs the program, the caller holds the lock
+func (p *Plugin) start() error {
+	if wait := restartDelay - time.Since(p.started); wait > 0 {
+		return fmt.Errorf("plugin %s: restarting in %v", p.Name, wait.Round(time.Second))
+	}
+	p.started = time.Now()
+
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+	logger().Info("Started plugin", "plugin", p.Name, "pid", cmd.Process.Pid)
+
+	responses := make(chan response)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			var r response
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				logger().Error("Invalid response of plugin", "plugin", p.Name, "response", scanner.Text())
+				continue
+			}
+			responses <- r
+		}
+		close(responses)
+	}()
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger().Info(scanner.Text(), "plugin", p.Name)
+		}
+	}()
+	go func() {
+		err := cmd.Wait()
+		logger().Error("Plugin exited", "plugin", p.Name, "err", err)
+	}()
+
+	p.cmd, p.stdin, p.responses = cmd, stdin, responses
+	return nil
+}
+
+// stop kills the program after a failed call, the caller holds the lock
+func (p *Plugin) stop() {
+	if p.cmd == nil {
+		return
+	}
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	// let the reader of the stdout run until the end
+	go func(responses chan response) {
+		for range responses {
+		}
+	}(p.responses)
+	p.cmd, p.stdin, p.responses = nil, nil, nil
+}
+
+// Call sends the method with the params to the plugin and waits for the
+// answer
+func (p *Plugin) Call(method string, params interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd == nil {
+		if err := p.start(); err != nil {
+			return err
+		}
+	}
+	p.lastID++
+	line, err := json.Marshal(request{ID: p.lastID, Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		p.stop()
+		return fmt.Errorf("plugin %s: %w", p.Name, err)
+	}
+
+	timeout := time.NewTimer(p.timeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case r, ok := <-p.responses:
+			if !ok {
+				p.stop()
+				return fmt.Errorf("plugin %s exited", p.Name)
+			}
+			if r.ID != p.lastID {
+				// late answer to a call that timed out
+				continue
+			}
+			if r.Error != "" {
+				return fmt.Errorf("plugin %s: %s", p.Name, r.Error)
+			}
+			return nil
+		case <-timeout.C:
+			p.stop()
+			return fmt.Errorf("plugin %s: %w", p.Name, ErrTimeout)
+		}
+	}
+}
+
+// Close closes the stdin of the plugin and gives it a moment to finish
+// before it is killed
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return nil
+	}
+	p.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		// the responses are closed when the plugin closes its stdout
+		for range p.responses {
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+	}
+	p.cmd, p.stdin, p.responses = nil, nil, nil
+	return nil
+}
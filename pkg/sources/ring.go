@@ -0,0 +1,142 @@
+package sources
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// ringSize is the number of the latest frames kept of a stream
+const ringSize = 3
+
+// a stream that has no new frame in this time is handled as closed, so
+// that a stalled camera is connected again
+const streamReadTimeout = 30 * time.Second
+
+// close waits this long for the reading of a stalled stream to end, the
+// reading then releases the stream by itself if it ever returns
+const ringCloseTimeout = 5 * time.Second
+
+// frameRing reads a stream continuously in its own goroutine, so that the
+// buffers of the decoder never fill up and the newest frame is always at
+// hand. The frames that are overwritten or skipped before anyone takes
+// them are counted as dropped.
+type frameRing struct {
+	webcam *gocv.VideoCapture
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	frames [ringSize]gocv.Mat
+	// time it took to read and decode each frame
+	decoded [ringSize]time.Duration
+	// index of the newest frame
+	newest int
+	// frames written since the last take
+	unread  int
+	dropped int64
+	// the stream has ended or the ring has been closed
+	closed   bool
+	stopping bool
+	done     chan struct{}
+}
+
+func newFrameRing(webcam *gocv.VideoCapture) *frameRing {
+	r := &frameRing{webcam: webcam, done: make(chan struct{})}
+	r.cond = sync.NewCond(&r.mu)
+	for i := range r.frames {
+		r.frames[i] = gocv.NewMat()
+	}
+	go r.run()
+	return r
+}
+
+// run reads the frames until the stream ends or the ring is closed
+func (r *frameRing) run() {
+	defer close(r.done)
+	spare := gocv.NewMat()
+	for {
+		r.mu.Lock()
+		stopping := r.stopping
+		r.mu.Unlock()
+		started := time.Now()
+		if stopping || !r.webcam.Read(&spare) || spare.Empty() {
+			break
+		}
+		decoded := time.Since(started)
+
+		r.mu.Lock()
+		r.newest = (r.newest + 1) % ringSize
+		r.frames[r.newest], spare = spare, r.frames[r.newest]
+		r.decoded[r.newest] = decoded
+		r.unread++
+		r.mu.Unlock()
+		r.cond.Signal()
+	}
+
+	r.mu.Lock()
+	r.closed = true
+	r.unread = 0
+	for i := range r.frames {
+		r.frames[i].Close()
+	}
+	r.mu.Unlock()
+	r.cond.Broadcast()
+	spare.Close()
+	r.webcam.Close()
+}
+
+// latest swaps the newest frame with img, waiting for a frame that has not
+// been taken yet. The frame is not copied: the Mat of img takes the place
+// of the frame in the ring and is written over later. Returns the time it
+// took to decode the frame, and false when the stream has ended, the ring
+// is closing, ctx is done or no frame has come in streamReadTimeout.
+func (r *frameRing) latest(ctx context.Context, img *gocv.Mat) (time.Duration, bool) {
+	ctx, cancel := context.WithTimeout(ctx, streamReadTimeout)
+	defer cancel()
+	// the lock makes sure that the wait below is not missed
+	wake := context.AfterFunc(ctx, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.cond.Broadcast()
+	})
+	defer wake()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.unread == 0 && !r.closed && !r.stopping && ctx.Err() == nil {
+		r.cond.Wait()
+	}
+	if r.unread == 0 {
+		return 0, false
+	}
+	r.frames[r.newest], *img = *img, r.frames[r.newest]
+	r.dropped += int64(r.unread - 1)
+	r.unread = 0
+	return r.decoded[r.newest], true
+}
+
+// droppedFrames returns the number of frames that were never taken
+func (r *frameRing) droppedFrames() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// close stops the reading after the current frame, which releases the
+// stream. A stream stalled in the read is left to be released when the
+// read returns.
+func (r *frameRing) close() error {
+	r.mu.Lock()
+	r.stopping = true
+	r.mu.Unlock()
+	r.cond.Broadcast()
+
+	select {
+	case <-r.done:
+	case <-time.After(ringCloseTimeout):
+		logger().Warn("Stream is stalled in a read, releasing it in the background", "timeout", ringCloseTimeout)
+	}
+	return nil
+}
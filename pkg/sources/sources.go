@@ -0,0 +1,229 @@
+// Package sources opens the video sources the detection reads its frames
+// from: images, video files, webcams and rtsp streams.
+package sources
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+//go:generate go run golang.org/x/tools/cmd/stringer -type=DeviceSource
+type DeviceSource int
+
+const (
+	IMAGE DeviceSource = iota
+	VIDEO
+	STREAM
+)
+
+// DeviceType returns the type of the device, or -1 if it is not recognized
+func DeviceType(deviceID string) DeviceSource {
+	if strings.HasSuffix(deviceID, ".jpg") || strings.HasSuffix(deviceID, ".png") {
+		return IMAGE
+	} else if strings.HasSuffix(deviceID, ".mp4") || deviceID == "0" {
+		return VIDEO
+	} else if strings.HasPrefix(deviceID, "rtsp") {
+		return STREAM
+	}
+	return -1
+}
+
+// how long opening a stream may take
+const streamOpenTimeout = 5 * time.Second
+
+// ErrTimeout is returned when the stream does not answer in time
+var ErrTimeout = fmt.Errorf("connection timeout")
+
+// Capture is an opened source
+type Capture struct {
+	Type   DeviceSource
+	device string
+	webcam *gocv.VideoCapture
+	// the image of an IMAGE source
+	image gocv.Mat
+	// the latest frames of a STREAM source
+	ring *frameRing
+	// time it took to read and decode the last frame
+	decoded time.Duration
+	closed  bool
+}
+
+// the open captures by the device
+var (
+	capturesMu sync.Mutex
+	captures   = map[string]int{}
+)
+
+// OpenCaptures returns the number of the captures that have been opened
+// and not closed by the device. More than one for a device is a leak.
+func OpenCaptures() map[string]int {
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+	open := make(map[string]int, len(captures))
+	for device, n := range captures {
+		open[device] = n
+	}
+	return open
+}
+
+// counted adds n to the open captures of the device
+func counted(device string, n int) {
+	capturesMu.Lock()
+	defer capturesMu.Unlock()
+	if captures[device] += n; captures[device] <= 0 {
+		delete(captures, device)
+	}
+}
+
+// Open opens the device
+func Open(deviceID string) (*Capture, error) {
+	c := &Capture{Type: DeviceType(deviceID), device: deviceID}
+	switch c.Type {
+	case IMAGE:
+		c.image = gocv.IMRead(deviceID, gocv.IMReadColor)
+		if c.image.Empty() {
+			return nil, fmt.Errorf("cannot read image %s", deviceID)
+		}
+	case VIDEO:
+		// read from local video or webcam
+		webcam, err := gocv.OpenVideoCapture(deviceID)
+		if err != nil {
+			return nil, err
+		}
+		c.webcam = webcam
+	case STREAM:
+		// open capture device (with ffmpeg)
+		webcam, err := openStream(deviceID)
+		if err != nil {
+			return nil, err
+		}
+		c.ring = newFrameRing(webcam)
+	default:
+		return nil, fmt.Errorf("unrecognized device: %s", deviceID)
+	}
+	counted(deviceID, 1)
+	logger().Debug("Opened capture", "device", deviceID, "type", c.Type)
+	return c, nil
+}
+
+// logger returns the logger of the captures
+func logger() *slog.Logger {
+	return slog.Default().With("module", "capture")
+}
+
+// openStream gives up on streams that do not answer within
+// streamOpenTimeout
+func openStream(deviceID string) (*gocv.VideoCapture, error) {
+	ctxTimeout, cancel := context.WithTimeout(context.Background(), streamOpenTimeout)
+	defer cancel()
+
+	c1 := make(chan *gocv.VideoCapture, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		wc, err := gocv.OpenVideoCaptureWithAPI(deviceID, 1900)
+		if err != nil {
+			errs <- err
+			return
+		}
+		c1 <- wc
+	}()
+
+	select {
+	case webcam := <-c1:
+		logger().Info("Connected", "device", deviceID)
+		return webcam, nil
+	case err := <-errs:
+		return nil, err
+	case <-ctxTimeout.Done():
+		logger().Error("Connection timed out", "device", deviceID)
+		return nil, ErrTimeout
+	}
+}
+
+// Read captures the next frame to img. The frame of a stream is the newest
+// one that has not been read yet, and it replaces the Mat of img instead of
+// being copied to it. Returns false when the device has been closed.
+func (c *Capture) Read(img *gocv.Mat) bool {
+	return c.ReadContext(context.Background(), img)
+}
+
+// ReadContext is Read that stops waiting for the frame of a stream when
+// ctx is done
+func (c *Capture) ReadContext(ctx context.Context, img *gocv.Mat) bool {
+	started := time.Now()
+	switch c.Type {
+	case IMAGE:
+		c.image.CopyTo(img)
+		c.decoded = time.Since(started)
+		return true
+	case STREAM:
+		var ok bool
+		c.decoded, ok = c.ring.latest(ctx, img)
+		return ok
+	case VIDEO:
+		c.webcam.Grab(25)
+	}
+	ok := c.webcam.Read(img)
+	c.decoded = time.Since(started)
+	return ok
+}
+
+// Decoded returns the time it took to read and decode the last frame. The
+// frames of a stream are decoded in the background, so the time spent
+// waiting for the frame is not included.
+func (c *Capture) Decoded() time.Duration {
+	return c.decoded
+}
+
+// Live tells whether the source is a camera or a stream, which should not
+// end, unlike the files
+func (c *Capture) Live() bool {
+	return c.Type == STREAM || c.Type == VIDEO && c.device == "0"
+}
+
+// Position returns the index of the next frame of a video file, 0 for the
+// other sources
+func (c *Capture) Position() int64 {
+	if c.Live() || c.webcam == nil {
+		return 0
+	}
+	return int64(c.webcam.Get(gocv.VideoCapturePosFrames))
+}
+
+// SeekFrame moves a video file to the frame, the other sources ignore it
+func (c *Capture) SeekFrame(frame int64) {
+	if c.Live() || c.webcam == nil {
+		return
+	}
+	c.webcam.Set(gocv.VideoCapturePosFrames, float64(frame))
+}
+
+// Dropped returns the number of frames of a stream that were replaced by
+// newer ones before they were read
+func (c *Capture) Dropped() int64 {
+	if c.ring == nil {
+		return 0
+	}
+	return c.ring.droppedFrames()
+}
+
+func (c *Capture) Close() error {
+	if !c.closed {
+		c.closed = true
+		counted(c.device, -1)
+	}
+	if c.ring != nil {
+		return c.ring.close()
+	}
+	if c.webcam != nil {
+		return c.webcam.Close()
+	}
+	return c.image.Close()
+}
@@ -0,0 +1,25 @@
+package sources
+
+import "testing"
+
+func TestDeviceType(t *testing.T) {
+	tests := []struct {
+		device string
+		want   DeviceSource
+	}{
+		{"snapshot.jpg", IMAGE},
+		{"snapshot.png", IMAGE},
+		{"sample.mp4", VIDEO},
+		{"0", VIDEO},
+		{"rtsp://camera.local/stream", STREAM},
+		{"http://camera.local/stream", -1},
+		{"", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.device, func(t *testing.T) {
+			if got := DeviceType(tt.device); got != tt.want {
+				t.Errorf("DeviceType(%q) = %v, want %v", tt.device, got, tt.want)
+			}
+		})
+	}
+}
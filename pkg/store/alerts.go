@@ -0,0 +1,55 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AlertRecord is a notification of an event to a subscription and the
+// outcome of its delivery
+type AlertRecord struct {
+	ID           int
+	Event        int
+	Subscription int
+	Observer     string
+	Channel      string
+	// queued/delivered/retrying/failed
+	Status  string
+	Error   string
+	Created time.Time
+	// zero if not delivered
+	DeliveredAt time.Time
+}
+
+const alertColumns = "SELECT a.id, a.detection_event_id, a.subscription_id, o.email, a.channel, a.status, COALESCE(a.error, ''), a.created, a.delivered_at " +
+	"FROM alert a JOIN subscription sub ON sub.id=a.subscription_id JOIN observer o ON o.id=sub.observer_id"
+
+// AlertsForEvent answers who was notified about the event and when
+func (db Database) AlertsForEvent(event int) ([]AlertRecord, error) {
+	return db.queryAlerts(alertColumns+" WHERE a.detection_event_id=$1 AND ($2=0 OR sub.org_id=$2) ORDER BY a.id", event, db.org)
+}
+
+// AlertHistory returns the latest alerts of the subscription
+func (db Database) AlertHistory(subscription int, limit int) ([]AlertRecord, error) {
+	return db.queryAlerts(alertColumns+" WHERE a.subscription_id=$1 AND ($2=0 OR sub.org_id=$2) ORDER BY a.id DESC LIMIT $3", subscription, db.org, limit)
+}
+
+func (db Database) queryAlerts(query string, args ...interface{}) ([]AlertRecord, error) {
+	rows, err := db.reader().QueryContext(db.context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []AlertRecord
+	for rows.Next() {
+		var a AlertRecord
+		var delivered sql.NullTime
+		if err := rows.Scan(&a.ID, &a.Event, &a.Subscription, &a.Observer, &a.Channel, &a.Status, &a.Error, &a.Created, &delivered); err != nil {
+			return nil, err
+		}
+		a.DeliveredAt = delivered.Time
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
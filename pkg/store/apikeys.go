@@ -0,0 +1,134 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The rest api, the grpc api, /ws and /preview require an api key. Only
+// the sha256 digest of a key is stored, the key itself is shown once when
+// it is created with -create-api-key. A read key can only get, a write key
+// can also change the streams, observers and subscriptions.
+
+// scopes of the api keys
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// ErrReadOnlyKey is returned for changes made with a read key
+var ErrReadOnlyKey = errors.New("api key is read-only")
+
+// APIKey is an api key without the key itself
+type APIKey struct {
+	ID   int
+	Name string
+	// zero for the keys of all organizations
+	Org     int
+	Scope   string
+	Created time.Time
+	Revoked *time.Time
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ForAPIKey returns the database scoped to the organization of the api
+// key. Returns sql.ErrNoRows for missing, unknown and revoked keys and
+// ErrReadOnlyKey if write access is needed.
+func (db Database) ForAPIKey(key string, write bool) (Database, error) {
+	if key == "" {
+		return Database{}, sql.ErrNoRows
+	}
+	var org int
+	var scope string
+	err := db.pool.QueryRowContext(db.context(), "SELECT COALESCE(org_id, 0), scope FROM api_key WHERE key_hash=$1 AND revoked_at IS NULL", hashAPIKey(key)).Scan(&org, &scope)
+	if err != nil {
+		return Database{}, err
+	}
+	if write && scope != ScopeWrite {
+		return Database{}, ErrReadOnlyKey
+	}
+	return db.ForOrg(org), nil
+}
+
+// CreateAPIKey adds a key for the organization of the database and
+// returns it. The key cannot be read back later.
+func (db Database) CreateAPIKey(name, scope string) (string, error) {
+	if scope != ScopeRead && scope != ScopeWrite {
+		return "", fmt.Errorf("unknown scope %q", scope)
+	}
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(random)
+
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("INSERT INTO api_key (name, org_id, key_hash, scope) VALUES (NULLIF($1, ''), NULLIF($2, 0), $3, $4) RETURNING id",
+		name, db.org, hashAPIKey(key), scope).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO audit_log (action, subject, details) VALUES ('create_api_key', $1, $2)", strconv.Itoa(id), scope)
+	if err != nil {
+		return "", err
+	}
+	return key, tx.Commit()
+}
+
+// ListAPIKeys returns the keys of the organization
+func (db Database) ListAPIKeys() ([]APIKey, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT id, COALESCE(name, ''), COALESCE(org_id, 0), scope, created, revoked_at FROM api_key WHERE $1=0 OR org_id=$1 ORDER BY id", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Org, &k.Scope, &k.Created, &k.Revoked); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey stops the key from working. The key stays in the list.
+func (db Database) RevokeAPIKey(id int) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE api_key SET revoked_at=NOW() WHERE id=$1 AND revoked_at IS NULL AND ($2=0 OR org_id=$2)", id, db.org)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO audit_log (action, subject) VALUES ('revoke_api_key', $1)", strconv.Itoa(id))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,32 @@
+package store
+
+import (
+	"database/sql"
+	"net/mail"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// A shared deployment can brand the notifications per organization or per
+// stream in the notification_branding table, the settings of the stream
+// override the ones of the organization.
+
+// streamBranding returns the branding of the stream
+func streamBranding(tx *sql.Tx, stream Stream) (notify.Branding, error) {
+	var b notify.Branding
+	var fromName, fromAddress, templateDir sql.NullString
+	err := tx.QueryRow("SELECT b.from_name, b.from_address, b.template_dir FROM notification_branding b "+
+		"WHERE b.stream_id=$1 OR (b.stream_id IS NULL AND b.org_id=(SELECT org_id FROM stream WHERE id=$1)) ORDER BY b.stream_id NULLS LAST LIMIT 1", stream.ID).
+		Scan(&fromName, &fromAddress, &templateDir)
+	if err == sql.ErrNoRows {
+		return b, nil
+	}
+	if err != nil {
+		return b, err
+	}
+	if fromAddress.String != "" {
+		b.Sender = (&mail.Address{Name: fromName.String, Address: fromAddress.String}).String()
+	}
+	b.TemplateDir = templateDir.String
+	return b, nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// A subscription with a burst window aggregates its alerts: the first
+// event opens a notification that is held for the window, and the events
+// detected before it is sent are folded into it. A single event is sent
+// as the normal detection, several as a summary with the peak count, so
+// a busy stream sends one notification per window instead of one per
+// event. The alert interval only applies between the bursts.
+
+// burstWindow parses the burst window of the subscription (e.g. 5m), zero
+// when the alerts are not aggregated
+func burstWindow(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		return 0
+	}
+	return window
+}
+
+// foldBurst folds the event into the open burst of the subscription.
+// Returns false when the subscription has no burst open.
+func foldBurst(tx *sql.Tx, subscription int, data notify.Data, snapshot string, branding notify.Branding) (bool, error) {
+	var id int
+	var payload []byte
+	var attachment string
+	// a burst that is due belongs to the dispatcher already
+	err := tx.QueryRow("SELECT id, COALESCE(payload, '{}'), COALESCE(attachment, '') FROM outbox WHERE subscription_id=$1 AND burst AND sent_at IS NULL AND not_before > NOW() ORDER BY id DESC LIMIT 1 FOR UPDATE", subscription).
+		Scan(&id, &payload, &attachment)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var burst notify.Data
+	if err := json.Unmarshal(payload, &burst); err != nil {
+		return false, fmt.Errorf("outbox %d: %w", id, err)
+	}
+	burst.Events++
+	if data.Confidence > burst.Confidence {
+		burst.Confidence = data.Confidence
+	}
+	// the summary shows the event with the most detections
+	if data.Count > burst.Count {
+		burst.Class, burst.Count, burst.CountWord, burst.PeakTime = data.Class, data.Count, data.CountWord, data.PeakTime
+		if snapshot != "" {
+			attachment = snapshot
+			burst.Snapshot = "cid:image0"
+		}
+	}
+
+	subject, body, html, err := notify.Render("burst", burst.Language, branding, burst)
+	if err != nil {
+		return false, err
+	}
+	if payload, err = json.Marshal(burst); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec("UPDATE outbox SET subject=$2, body=$3, html=NULLIF($4, ''), attachment=NULLIF($5, ''), payload=$6 WHERE id=$1",
+		id, subject, body, html, attachment, payload)
+	return err == nil, err
+}
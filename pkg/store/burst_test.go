@@ -0,0 +1,26 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBurstWindow(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"5m", 5 * time.Minute},
+		{"90s", 90 * time.Second},
+		{"-5m", 0},
+		{"five minutes", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := burstWindow(tt.value); got != tt.want {
+				t.Errorf("burstWindow(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package store
+
+import "database/sql"
+
+// VideoCheckpoint returns the frame of the video file to resume from, 0
+// for the start
+func (db Database) VideoCheckpoint(source string) (int64, error) {
+	var frame int64
+	err := db.pool.QueryRowContext(db.context(), "SELECT frame FROM video_checkpoint WHERE source=$1", source).Scan(&frame)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return frame, err
+}
+
+// SaveVideoCheckpoint saves the frame of the video file to resume from,
+// 0 starts the file over
+func (db Database) SaveVideoCheckpoint(source string, frame int64) error {
+	if frame == 0 {
+		_, err := db.pool.ExecContext(db.context(), "DELETE FROM video_checkpoint WHERE source=$1", source)
+		return err
+	}
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO video_checkpoint (source, frame) VALUES ($1, $2) "+
+		"ON CONFLICT (source) DO UPDATE SET frame=EXCLUDED.frame, updated=NOW()", source, frame)
+	return err
+}
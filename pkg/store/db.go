@@ -0,0 +1,462 @@
+// Package store keeps the streams, the detection events and the
+// notification subscriptions in PostgreSQL.
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+type Database struct {
+	pool *sql.DB
+	// optional read replica for the reporting queries
+	replica *sql.DB
+	// false when the detections are stored only to an external sink
+	storeDetections bool
+	// when set, the queries only see the rows of this organization
+	org int
+	// cancels the queries, nil for none
+	ctx context.Context
+}
+
+// Classes are the labels of the model by the class id - 1, the same as in
+// the classes table
+var Classes []string
+
+// Hooks are the names of the commands that the streams can run on their
+// events (StreamSettings.OnDetect)
+var Hooks []string
+
+// StreamHosts are the hosts that the organizations may point their streams
+// to, e.g. the cameras and a restreaming proxy; empty allows any host
+var StreamHosts []string
+
+// PoolConfig tunes the connection pool. Zero values keep the defaults
+// of database/sql.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	// how often the connection is pinged (0 disables the health check)
+	HealthCheckInterval time.Duration
+}
+
+func NewDatabaseConnection(connString string, config PoolConfig) (*Database, error) {
+
+	pool, err := sql.Open("postgres", connString)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pool.SetMaxOpenConns(config.MaxOpenConns)
+	if config.MaxIdleConns != 0 {
+		pool.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	pool.SetConnMaxLifetime(config.ConnMaxLifetime)
+	pool.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	err = pool.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	db := &Database{pool: pool, storeDetections: true}
+	if config.HealthCheckInterval > 0 {
+		go db.healthCheck(config.HealthCheckInterval)
+	}
+	return db, nil
+}
+
+// ConnectReplica opens a connection to a read replica. After this the
+// reporting queries (ListEvents etc.) are run against the replica while
+// the writes still go to the primary.
+func (db *Database) ConnectReplica(connString string, config PoolConfig) error {
+	replica, err := NewDatabaseConnection(connString, config)
+	if err != nil {
+		return err
+	}
+	db.replica = replica.pool
+	return nil
+}
+
+// reader returns the connection pool for read only queries
+func (db Database) reader() *sql.DB {
+	if db.replica != nil {
+		return db.replica
+	}
+	return db.pool
+}
+
+// healthCheck pings the database periodically so that broken connections
+// (e.g. after a network outage or pgbouncer restart) are noticed and
+// replaced before the stream workers need them
+func (db Database) healthCheck(interval time.Duration) {
+	healthy := true
+	for range time.Tick(interval) {
+		err := db.pool.Ping()
+		if err != nil && healthy {
+			logger().Error("Database health check failed", "err", err)
+		} else if err == nil && !healthy {
+			stats := db.pool.Stats()
+			logger().Info("Database connection restored", "open", stats.OpenConnections, "in_use", stats.InUse)
+		}
+		healthy = err == nil
+	}
+}
+
+// logger returns the logger of the database
+func logger() *slog.Logger {
+	return slog.Default().With("module", "store")
+}
+
+// sleep waits for d, false if ctx was done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ForOrg returns a copy of the database handle that is scoped to the
+// given organization
+func (db Database) ForOrg(org int) Database {
+	db.org = org
+	return db
+}
+
+// WithContext returns a copy of the database handle whose queries and
+// transactions are cancelled when ctx is done
+func (db Database) WithContext(ctx context.Context) Database {
+	db.ctx = ctx
+	return db
+}
+
+// context returns the context of the queries
+func (db Database) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// Org returns the organization the handle is scoped to, 0 for all
+func (db Database) Org() int {
+	return db.org
+}
+
+// SetStoreDetections chooses whether the single detections of the events
+// are saved, they can be left to an external sink
+func (db *Database) SetStoreDetections(store bool) {
+	db.storeDetections = store
+}
+
+// Close closes the connections
+func (db Database) Close() error {
+	if db.replica != nil {
+		db.replica.Close()
+	}
+	return db.pool.Close()
+}
+
+// ClassID returns the id of the class label in the classes table
+func (db Database) ClassID(label string) (int, error) {
+	var class_id int
+	err := db.pool.QueryRowContext(db.context(), "SELECT class_id FROM classes WHERE label=$1", label).Scan(&class_id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("no class with label %q", label)
+	}
+	return class_id, err
+}
+
+// InsertDetections saves the event with its detections and queues the
+// notifications of the event in the same transaction
+func (db Database) InsertDetections(deviceID string, detectedObjects []Detection, classId int, captureTime string, snapshot string) (int, error) {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	event, err := db.insertEvent(tx, deviceID, detectedObjects, classId, captureTime, snapshot)
+	if err != nil {
+		return 0, err
+	}
+	return event, tx.Commit()
+}
+
+// insertEvent saves the event and queues its notifications in the
+// transaction. Returns 0 for a duplicate event.
+func (db Database) insertEvent(tx *sql.Tx, deviceID string, detectedObjects []Detection, classId int, captureTime string, snapshot string) (int, error) {
+	var confidence int
+	for _, obj := range detectedObjects {
+		if obj.Confidence > confidence {
+			confidence = obj.Confidence
+		}
+	}
+
+	// a replayed event gets the same key and is not inserted again
+	var lastInsertId int
+	err := tx.QueryRow("INSERT INTO detection_event(class, count, confidence, stream_id, created, snapshot, dedup_key) values($1, $2, $3, (SELECT id FROM stream WHERE address=$4), $5, NULLIF($6, ''), $7) "+
+		"ON CONFLICT (dedup_key) DO NOTHING RETURNING id",
+		classId, len(detectedObjects), confidence, deviceID, captureTime, snapshot, dedupKey(deviceID, classId, captureTime, detectedObjects)).Scan(&lastInsertId)
+	if err == sql.ErrNoRows {
+		logger().Debug("Skipping duplicate event", "device", deviceID, "captured", captureTime)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if db.storeDetections {
+		for _, obj := range detectedObjects {
+			_, err := tx.Exec("INSERT INTO detection(confidence, location_top, location_left, width, height, crop, event) VALUES($1,$2,$3,$4,$5,NULLIF($6, ''),$7)",
+				obj.Confidence, obj.Top, obj.Left, obj.Width, obj.Height, obj.Crop, lastInsertId)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if err := db.queueNotifications(tx, deviceID, lastInsertId, classId, detectedObjects, confidence, snapshot); err != nil {
+		return 0, err
+	}
+	return lastInsertId, nil
+}
+
+// dedupKey identifies an event by the stream, class, capture time rounded
+// to the second and the bounding boxes, so that the same frame produces
+// the same key when a pipeline is retried or replayed
+func dedupKey(deviceID string, classId int, captureTime string, detectedObjects []Detection) string {
+	if t, err := time.Parse(time.RFC3339, captureTime); err == nil {
+		captureTime = t.UTC().Truncate(time.Second).Format(time.RFC3339)
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s|%d|%s", deviceID, classId, captureTime)
+	for _, obj := range detectedObjects {
+		fmt.Fprintf(hash, "|%d,%d,%d,%d", obj.Left, obj.Top, obj.Width, obj.Height)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// hasBeenAlerted checks if the subscription has already been alerted
+// within its alert interval. If not, the alert is recorded. loc is the
+// timezone of the stream, in which the alert times are saved.
+func (db Database) hasBeenAlerted(tx *sql.Tx, subscriptionId int, alertInterval string, event int, channel string, loc *time.Location) (bool, error) {
+	var intervalType string
+	var intervalLength int
+	fmt.Sscanf(alertInterval, "%d%s", &intervalLength, &intervalType)
+
+	captureTime := time.Now().In(loc)
+
+	var lastCapture sql.NullTime
+	err := tx.QueryRow("SELECT created FROM alert WHERE subscription_id=$1 ORDER BY created DESC LIMIT 1", subscriptionId).Scan(&lastCapture)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+
+	if lastCapture.Valid {
+		// the timestamps are saved without zone in the local time
+		t := lastCapture.Time
+		lastCaptureTime := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+
+		switch {
+		case intervalType == "m":
+			if lastCaptureTime.After(captureTime.Add(-(time.Minute * time.Duration(intervalLength)))) {
+				return true, nil
+			}
+		case intervalType == "h":
+			if lastCaptureTime.After(captureTime.Add(-(time.Hour * time.Duration(intervalLength)))) {
+				return true, nil
+			}
+		case intervalType == "d":
+			if lastCaptureTime.After(captureTime.AddDate(0, 0, -intervalLength)) {
+				return true, nil
+			}
+		default:
+			return true, nil
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO alert (detection_event_id, subscription_id, created, channel) VALUES ($1,$2,$3,$4)", event, subscriptionId, captureTime, channel)
+	return false, err
+}
+
+// queueNotifications writes a notification to the outbox for every
+// subscription of the stream that is interested in the event and has not
+// been alerted recently. The outbox is delivered by DispatchNotifications.
+func (db Database) queueNotifications(tx *sql.Tx, deviceID string, event int, classId int, detectedObjects []Detection, confidence int, snapshot string) error {
+	count := len(detectedObjects)
+
+	type recipient struct {
+		subscription int
+		channel      string
+		// channel specific address, the email of the observer by default
+		address  string
+		interval string
+		quiet    quietHours
+		filter   subscriptionFilter
+		language string
+		burst    time.Duration
+	}
+
+	// subscriptions of other organizations are never notified even if they point to the stream
+	rows, err := tx.Query("SELECT sub.id, sub.channel, COALESCE(sub.recipient, o.email), COALESCE(sub.alert_interval, ''), "+
+		"COALESCE(sub.quiet_start::text, ''), COALESCE(sub.quiet_end::text, ''), sub.quiet_action, COALESCE(o.timezone, ''), sub.classes, sub.zones, COALESCE(o.language, ''), COALESCE(sub.burst_window, '') FROM subscription sub JOIN observer o ON o.id=sub.observer_id JOIN stream s ON s.id=sub.stream_id "+
+		"WHERE s.address=$1 AND sub.alert=TRUE AND sub.mode=$2 AND sub.org_id IS NOT DISTINCT FROM s.org_id", deviceID, SubscriptionEvent)
+	if err != nil {
+		return err
+	}
+	// the rows must be read before running other queries in the transaction
+	var recipients []recipient
+	for rows.Next() {
+		var r recipient
+		var burst string
+		if err := rows.Scan(&r.subscription, &r.channel, &r.address, &r.interval, &r.quiet.start, &r.quiet.end, &r.quiet.action, &r.quiet.timezone, pq.Array(&r.filter.classes), pq.Array(&r.filter.zones), &r.language, &burst); err != nil {
+			rows.Close()
+			return err
+		}
+		r.burst = burstWindow(burst)
+		recipients = append(recipients, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(recipients) == 0 {
+		return nil
+	}
+
+	zones, err := streamZones(tx, deviceID)
+	if err != nil {
+		return err
+	}
+	eventZones := zonesOf(zones, detectedObjects)
+
+	label := Classes[classId-1]
+	stream, err := db.StreamByAddress(deviceID)
+	if err != nil {
+		return err
+	}
+	branding, err := streamBranding(tx, stream)
+	if err != nil {
+		return err
+	}
+	now := time.Now().In(stream.Location())
+	data := notify.Data{
+		Stream:     stream.Name,
+		Link:       stream.Link,
+		Place:      stream.Place(),
+		Count:      count,
+		Confidence: confidence,
+		Time:       now.Format("2.1.2006 15:04"),
+		Events:     1,
+		PeakTime:   now.Format("15:04"),
+	}
+	if snapshot != "" {
+		// the snapshot is embedded as the first attachment
+		data.Snapshot = "cid:image0"
+	}
+
+	for _, r := range recipients {
+		if !r.filter.matches(label, eventZones) {
+			continue
+		}
+		// suppressed alerts do not count in the alert interval
+		until, quiet := r.quiet.until(time.Now(), stream.Location())
+		if quiet && r.quiet.action != QuietDefer {
+			continue
+		}
+
+		// rendered per subscription for the language and the unsubscribe link
+		data.Language = notify.Language(r.language)
+		data.Class, err = translateClass(tx, label, data.Language)
+		if err != nil {
+			return err
+		}
+		data.CountWord = notify.CountWord(data.Language, count)
+		data.UnsubscribeURL = notify.UnsubscribeLink(r.subscription)
+		data.WindowMinutes = int(r.burst.Round(time.Minute) / time.Minute)
+
+		if r.burst > 0 {
+			folded, err := foldBurst(tx, r.subscription, data, snapshot, branding)
+			if err != nil {
+				return err
+			}
+			if folded {
+				continue
+			}
+		}
+		alerted, err := db.hasBeenAlerted(tx, r.subscription, r.interval, event, r.channel, now.Location())
+		if err != nil {
+			return err
+		}
+		if alerted {
+			continue
+		}
+		var notBefore *time.Time
+		if quiet {
+			notBefore = &until
+		}
+		if r.burst > 0 {
+			// held open for the events of the window
+			end := time.Now().Add(r.burst)
+			if notBefore == nil || end.After(*notBefore) {
+				notBefore = &end
+			}
+		}
+
+		subject, body, html, err := notify.Render("detection", data.Language, branding, data)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO outbox (event_id, subscription_id, channel, recipient, sender, subject, body, html, attachment, payload, not_before, burst) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12)",
+			event, r.subscription, r.channel, r.address, branding.Sender, subject, body, html, snapshot, payload, notBefore, r.burst > 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamAddresses returns the addresses of the enabled streams
+func (db Database) StreamAddresses() ([]string, error) {
+	var streams []string
+	var addr string
+	rows, err := db.pool.QueryContext(db.context(), "SELECT address FROM stream WHERE enabled AND ($1=0 OR org_id=$1)", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := rows.Scan(&addr); err != nil {
+			return nil, err
+		}
+
+		if addr != "" {
+			streams = append(streams, addr)
+		}
+
+	}
+	return streams, rows.Err()
+}
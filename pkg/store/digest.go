@@ -0,0 +1,230 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	htmltemplate "html/template"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// subscription modes. The event subscriptions are alerted of every event
+// (within the alert interval), the digest subscriptions get one summary
+// of the previous day or week.
+const (
+	SubscriptionEvent  = "event"
+	SubscriptionDaily  = "daily"
+	SubscriptionWeekly = "weekly"
+)
+
+// number of the best snapshots shown in a digest
+const digestSnapshots = 3
+
+// digestData is passed to the digest templates
+type digestData struct {
+	Stream string
+	Link   string
+	Place  string
+	// daily or weekly
+	Period string
+	From   string
+	To     string
+	// number of events
+	Total   int
+	Classes []digestClass
+	// image sources of the best events, the first one is attached
+	Snapshots      []htmltemplate.URL
+	UnsubscribeURL string
+	Language       string
+}
+
+// digestSubscription is a subscription in one of the digest modes
+type digestSubscription struct {
+	id        int
+	mode      string
+	channel   string
+	recipient string
+	address   string
+	until     sql.NullTime
+	language  string
+}
+
+type digestClass struct {
+	Class      string
+	Events     int
+	Detections int
+}
+
+// digestPeriod returns the period that ended last before now, i.e. the
+// previous day or the previous week starting on Monday
+func digestPeriod(mode string, now time.Time) (start, end time.Time) {
+	end = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if mode == SubscriptionWeekly {
+		weekday := (int(end.Weekday()) + 6) % 7
+		end = end.AddDate(0, 0, -weekday)
+		return end.AddDate(0, 0, -7), end
+	}
+	return end.AddDate(0, 0, -1), end
+}
+
+// SendDigests queues the digests periodically until ctx is done
+func (db Database) SendDigests(ctx context.Context, interval time.Duration) {
+	for {
+		if err := db.QueueDigests(); err != nil {
+			logger().Error("Error queueing digests", "err", err)
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// QueueDigests writes the digest of every digest subscription whose period
+// has ended to the outbox. The end of the summarized period is saved to
+// the subscription, so a digest that was missed (e.g. during a restart)
+// covers everything since the previous one.
+func (db Database) QueueDigests() error {
+	rows, err := db.pool.QueryContext(db.context(), "SELECT sub.id, sub.mode, sub.channel, COALESCE(sub.recipient, o.email), s.address, sub.digest_until, COALESCE(o.language, '') FROM subscription sub JOIN observer o ON o.id=sub.observer_id JOIN stream s ON s.id=sub.stream_id "+
+		"WHERE sub.alert=TRUE AND sub.mode IN ($1, $2) AND sub.org_id IS NOT DISTINCT FROM s.org_id AND ($3=0 OR s.org_id=$3)", SubscriptionDaily, SubscriptionWeekly, db.org)
+	if err != nil {
+		return err
+	}
+	var subscriptions []digestSubscription
+	for rows.Next() {
+		var d digestSubscription
+		if err := rows.Scan(&d.id, &d.mode, &d.channel, &d.recipient, &d.address, &d.until, &d.language); err != nil {
+			rows.Close()
+			return err
+		}
+		subscriptions = append(subscriptions, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range subscriptions {
+		stream, err := db.StreamByAddress(d.address)
+		if err != nil {
+			return err
+		}
+		start, end := digestPeriod(d.mode, time.Now().In(stream.Location()))
+		if d.until.Valid {
+			if !d.until.Time.Before(end) {
+				continue
+			}
+			start = d.until.Time.In(stream.Location())
+		}
+		if err := db.queueDigest(d, stream, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queueDigest summarizes the events of the stream between start and end
+// for the subscription. Nothing is sent if there were no events.
+func (db Database) queueDigest(d digestSubscription, stream Stream, start, end time.Time) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the events are saved in the local time of the stream
+	const wallClock = "2006-01-02 15:04:05"
+	from, to := start.Format(wallClock), end.Format(wallClock)
+	data := digestData{
+		Stream:         stream.Name,
+		Link:           stream.Link,
+		Place:          stream.Place(),
+		Period:         d.mode,
+		From:           start.Format("2.1.2006"),
+		To:             end.Add(-time.Second).Format("2.1.2006"),
+		UnsubscribeURL: notify.UnsubscribeLink(d.id),
+		Language:       notify.Language(d.language),
+	}
+
+	rows, err := tx.Query("SELECT c.label, COUNT(*), SUM(e.count) FROM detection_event e JOIN classes c ON c.id=e.class "+
+		"WHERE e.stream_id=$1 AND e.created >= $2 AND e.created < $3 AND e.deleted_at IS NULL AND e.status <> $4 GROUP BY c.label ORDER BY 2 DESC, 1",
+		stream.ID, from, to, EventFalsePositive)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var c digestClass
+		if err := rows.Scan(&c.Class, &c.Events, &c.Detections); err != nil {
+			rows.Close()
+			return err
+		}
+		data.Classes = append(data.Classes, c)
+		data.Total += c.Events
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for i, c := range data.Classes {
+		if data.Classes[i].Class, err = translateClass(tx, c.Class, data.Language); err != nil {
+			return err
+		}
+	}
+
+	if data.Total > 0 {
+		branding, err := streamBranding(tx, stream)
+		if err != nil {
+			return err
+		}
+		var snapshots []string
+		rows, err = tx.Query("SELECT snapshot FROM detection_event WHERE stream_id=$1 AND created >= $2 AND created < $3 AND deleted_at IS NULL AND status <> $4 AND snapshot IS NOT NULL "+
+			"ORDER BY confidence DESC NULLS LAST, created DESC LIMIT $5", stream.ID, from, to, EventFalsePositive, digestSnapshots)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var snapshot string
+			if err := rows.Scan(&snapshot); err != nil {
+				rows.Close()
+				return err
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// only the best snapshot is attached, the others are linked
+		var attachment string
+		for i, snapshot := range snapshots {
+			if i == 0 {
+				attachment = snapshot
+				data.Snapshots = append(data.Snapshots, "cid:image0")
+			} else if link := notify.SnapshotLink(snapshot); link != "" {
+				data.Snapshots = append(data.Snapshots, htmltemplate.URL(link))
+			}
+		}
+
+		subject, body, html, err := notify.Render("digest", data.Language, branding, data)
+		if err != nil {
+			return err
+		}
+		// the chat channels show only the subject of the digest
+		payload, err := json.Marshal(notify.Data{UnsubscribeURL: data.UnsubscribeURL, Language: data.Language})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO outbox (subscription_id, channel, recipient, sender, subject, body, html, attachment, payload) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9)",
+			d.id, d.channel, d.recipient, branding.Sender, subject, body, html, attachment, payload)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE subscription SET digest_until=$1 WHERE id=$2", end, d.id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,335 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Event is a saved detection event with the stream and class resolved
+type Event struct {
+	ID            int    `json:"id"`
+	StreamName    string `json:"stream_name"`
+	StreamAddress string `json:"stream_address"`
+	Class         string `json:"class"`
+	Count         int    `json:"count"`
+	// highest confidence (0..100) of the detections in the event
+	Confidence int       `json:"confidence"`
+	Created    time.Time `json:"created"`
+	// frame image relative to the snapshot directory
+	Snapshot   string `json:"snapshot"`
+	Status     string `json:"status"`
+	ReviewedBy string `json:"reviewed_by"`
+	// zero if the event has not been reviewed
+	ReviewedAt time.Time `json:"reviewed_at"`
+	Tags       []string  `json:"tags"`
+	// only populated by GetEvent
+	Detections []Detection `json:"detections,omitempty"`
+}
+
+// Detection is a single bounding box of an event
+type Detection struct {
+	Confidence int `json:"confidence"`
+	Top        int `json:"top"`
+	Left       int `json:"left"`
+	Width      int `json:"width"`
+	Height     int `json:"height"`
+	// cropped image relative to the snapshot directory
+	Crop string `json:"crop"`
+}
+
+// EventCursor points to the last event of the previous page. Pass it in
+// EventFilter.After to get the next page.
+type EventCursor struct {
+	ID         int       `json:"id"`
+	Created    time.Time `json:"created"`
+	Confidence int       `json:"confidence"`
+}
+
+// EventFilter restricts the events returned by ListEvents. Zero values
+// are ignored.
+type EventFilter struct {
+	Stream string // stream address
+	Class  string // class label
+	Status string
+	// events having all of these tags
+	Tags []string
+	// include soft deleted events
+	Deleted       bool
+	MinConfidence int
+	From, To      time.Time
+	// "created" (default) or "confidence"
+	OrderBy   string
+	Ascending bool
+	After     *EventCursor
+	// defaults to 50
+	Limit int
+}
+
+// Cursor returns the cursor for fetching the events after this one
+func (e Event) Cursor() *EventCursor {
+	return &EventCursor{e.ID, e.Created, e.Confidence}
+}
+
+// review statuses of an event
+const (
+	EventNew           = "new"
+	EventReviewed      = "reviewed"
+	EventConfirmed     = "confirmed"
+	EventFalsePositive = "false_positive"
+)
+
+const eventColumns = "SELECT e.id, COALESCE(s.name, ''), COALESCE(s.address, ''), c.label, e.count, COALESCE(e.confidence, 0), e.created, " +
+	"COALESCE(e.snapshot, ''), e.status, COALESCE(e.reviewed_by, ''), e.reviewed_at, ARRAY(SELECT tag FROM event_tag WHERE event_id=e.id ORDER BY tag) FROM detection_event e JOIN classes c ON c.id=e.class LEFT JOIN stream s ON s.id=e.stream_id"
+
+// ListEvents returns the events matching the filter. Pagination is done
+// with keyset (the last event's cursor) instead of offsets so that the
+// pages stay stable while new events are inserted.
+func (db Database) ListEvents(filter EventFilter) ([]Event, error) {
+	var where []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if db.org != 0 {
+		where = append(where, "s.org_id="+arg(db.org))
+	}
+	if filter.Stream != "" {
+		where = append(where, "s.address="+arg(filter.Stream))
+	}
+	if filter.Class != "" {
+		where = append(where, "c.label="+arg(filter.Class))
+	}
+	if filter.Status != "" {
+		where = append(where, "e.status="+arg(filter.Status))
+	}
+	for _, tag := range filter.Tags {
+		where = append(where, "EXISTS (SELECT 1 FROM event_tag t WHERE t.event_id=e.id AND t.tag="+arg(normalizeTag(tag))+")")
+	}
+	if !filter.Deleted {
+		where = append(where, "e.deleted_at IS NULL")
+	}
+	if filter.MinConfidence > 0 {
+		where = append(where, "e.confidence>="+arg(filter.MinConfidence))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "e.created>="+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "e.created<"+arg(filter.To))
+	}
+
+	column := "e.created"
+	if filter.OrderBy == "confidence" {
+		column = "COALESCE(e.confidence, 0)"
+	} else if filter.OrderBy != "" && filter.OrderBy != "created" {
+		return nil, fmt.Errorf("cannot order events by %q", filter.OrderBy)
+	}
+	direction, comparison := "DESC", "<"
+	if filter.Ascending {
+		direction, comparison = "ASC", ">"
+	}
+
+	if filter.After != nil {
+		var value interface{} = filter.After.Created
+		if filter.OrderBy == "confidence" {
+			value = filter.After.Confidence
+		}
+		where = append(where, fmt.Sprintf("(%s, e.id) %s (%s, %s)", column, comparison, arg(value), arg(filter.After.ID)))
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	query := eventColumns
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, e.id %s LIMIT %s", column, direction, direction, arg(limit))
+
+	rows, err := db.reader().QueryContext(db.context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// GetEvent returns a single event with its detections. Returns
+// sql.ErrNoRows if there is no such event.
+func (db Database) GetEvent(id int) (Event, error) {
+	e, err := scanEvent(db.reader().QueryRowContext(db.context(), eventColumns+" WHERE e.id=$1 AND ($2=0 OR s.org_id=$2)", id, db.org))
+	if err != nil {
+		return e, err
+	}
+
+	rows, err := db.reader().QueryContext(db.context(), "SELECT confidence, location_top, location_left, width, height, COALESCE(crop, '') FROM detection WHERE event=$1 ORDER BY id", id)
+	if err != nil {
+		return e, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d Detection
+		if err := rows.Scan(&d.Confidence, &d.Top, &d.Left, &d.Width, &d.Height, &d.Crop); err != nil {
+			return e, err
+		}
+		e.Detections = append(e.Detections, d)
+	}
+	return e, rows.Err()
+}
+
+// the crops of a snapshot are saved next to it as <snapshot>_<i>.jpg
+var cropSuffix = regexp.MustCompile(`_[0-9]+\.jpg$`)
+
+// SnapshotVisible tells whether the snapshot or crop at the path relative
+// to SNAPSHOT_DIR belongs to an event of a stream of the organization
+func (db Database) SnapshotVisible(path string) (bool, error) {
+	snapshot := cropSuffix.ReplaceAllString(path, ".jpg")
+	var visible bool
+	err := db.reader().QueryRowContext(db.context(), "SELECT EXISTS (SELECT 1 FROM detection_event e LEFT JOIN stream s ON s.id=e.stream_id WHERE e.snapshot=$1 AND ($2=0 OR s.org_id=$2))", snapshot, db.org).Scan(&visible)
+	return visible, err
+}
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (Event, error) {
+	var e Event
+	var reviewedAt sql.NullTime
+	err := row.Scan(&e.ID, &e.StreamName, &e.StreamAddress, &e.Class, &e.Count, &e.Confidence, &e.Created, &e.Snapshot, &e.Status, &e.ReviewedBy, &reviewedAt, pq.Array(&e.Tags))
+	e.ReviewedAt = reviewedAt.Time
+	return e, err
+}
+
+// SetEventStatus marks the event reviewed by the given user
+func (db Database) SetEventStatus(id int, status, reviewer string) error {
+	switch status {
+	case EventNew, EventReviewed, EventConfirmed, EventFalsePositive:
+	default:
+		return fmt.Errorf("unknown event status %q", status)
+	}
+
+	res, err := db.pool.ExecContext(db.context(), "UPDATE detection_event SET status=$1, reviewed_by=$2, reviewed_at=NOW() WHERE id=$3 AND ($4=0 OR stream_id IN (SELECT id FROM stream WHERE org_id=$4))", status, reviewer, id, db.org)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	db.updateIncidents(id, status)
+	return nil
+}
+
+// DeleteEvent hides the event from the listings without removing the rows
+func (db Database) DeleteEvent(id int) error {
+	res, err := db.pool.ExecContext(db.context(), "UPDATE detection_event SET deleted_at=NOW() WHERE id=$1 AND deleted_at IS NULL AND ($2=0 OR stream_id IN (SELECT id FROM stream WHERE org_id=$2))", id, db.org)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// RestoreEvent undoes DeleteEvent
+func (db Database) RestoreEvent(id int) error {
+	res, err := db.pool.ExecContext(db.context(), "UPDATE detection_event SET deleted_at=NULL WHERE id=$1 AND ($2=0 OR stream_id IN (SELECT id FROM stream WHERE org_id=$2))", id, db.org)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// expectRow returns sql.ErrNoRows if the statement didn't affect any rows
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// tags are case insensitive
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// TagEvent adds a free-form tag (e.g. "nesting") to the event
+func (db Database) TagEvent(id int, tag string) error {
+	tag = normalizeTag(tag)
+	if tag == "" {
+		return fmt.Errorf("empty tag")
+	}
+	if _, err := db.GetEvent(id); err != nil {
+		return err
+	}
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO event_tag (event_id, tag) VALUES ($1, $2) ON CONFLICT DO NOTHING", id, tag)
+	return err
+}
+
+// UntagEvent removes the tag from the event
+func (db Database) UntagEvent(id int, tag string) error {
+	if _, err := db.GetEvent(id); err != nil {
+		return err
+	}
+	res, err := db.pool.ExecContext(db.context(), "DELETE FROM event_tag WHERE event_id=$1 AND tag=$2", id, normalizeTag(tag))
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// RenameTag changes the tag in all events
+func (db Database) RenameTag(from, to string) error {
+	to = normalizeTag(to)
+	if to == "" {
+		return fmt.Errorf("empty tag")
+	}
+	// only the events of the organization are renamed
+	const scope = "($3=0 OR event_id IN (SELECT e.id FROM detection_event e JOIN stream s ON s.id=e.stream_id WHERE s.org_id=$3))"
+
+	// events that already have the new tag would conflict
+	_, err := db.pool.ExecContext(db.context(), "DELETE FROM event_tag WHERE tag=$1 AND event_id IN (SELECT event_id FROM event_tag WHERE tag=$2) AND "+scope, normalizeTag(from), to, db.org)
+	if err != nil {
+		return err
+	}
+	_, err = db.pool.ExecContext(db.context(), "UPDATE event_tag SET tag=$2 WHERE tag=$1 AND "+scope, normalizeTag(from), to, db.org)
+	return err
+}
+
+// ListTags returns all tags in use and how many events have them
+func (db Database) ListTags() (map[string]int, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT t.tag, COUNT(*) FROM event_tag t JOIN detection_event e ON e.id=t.event_id LEFT JOIN stream s ON s.id=e.stream_id "+
+		"WHERE $1=0 OR s.org_id=$1 GROUP BY t.tag", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := map[string]int{}
+	for rows.Next() {
+		var tag string
+		var count int
+		if err := rows.Scan(&tag, &count); err != nil {
+			return nil, err
+		}
+		tags[tag] = count
+	}
+	return tags, rows.Err()
+}
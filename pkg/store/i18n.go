@@ -0,0 +1,14 @@
+package store
+
+import "database/sql"
+
+// translateClass returns the name of the class label in the language from
+// the class_translation table, or the label itself
+func translateClass(tx *sql.Tx, label, language string) (string, error) {
+	var name string
+	err := tx.QueryRow("SELECT name FROM class_translation WHERE label=$1 AND language=$2", label, language).Scan(&name)
+	if err == sql.ErrNoRows {
+		return label, nil
+	}
+	return name, err
+}
@@ -0,0 +1,44 @@
+package store
+
+import (
+	"encoding/json"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// incidentActions maps the review status of an event to the incident action
+var incidentActions = map[string]string{
+	EventConfirmed:     notify.IncidentAcknowledge,
+	EventFalsePositive: notify.IncidentResolve,
+}
+
+// updateIncidents acknowledges or resolves the incidents opened for the
+// event after it has been reviewed. Errors are only logged as the review
+// itself has succeeded.
+func (db Database) updateIncidents(event int, status string) {
+	action, ok := incidentActions[status]
+	if !ok {
+		return
+	}
+
+	rows, err := db.pool.QueryContext(db.context(), "SELECT channel, recipient, COALESCE(payload, '{}') FROM outbox WHERE event_id=$1 AND sent_at IS NOT NULL AND channel IN ('pagerduty', 'opsgenie')", event)
+	if err != nil {
+		logger().Error("Error updating incidents", "event_id", event, "err", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var channel, recipient string
+		var payload []byte
+		n := notify.Notification{Event: event}
+		if err := rows.Scan(&channel, &recipient, &payload); err != nil {
+			logger().Error("Error updating incidents", "event_id", event, "err", err)
+			return
+		}
+		json.Unmarshal(payload, &n.Data)
+
+		if err := notify.UpdateIncident(db.context(), channel, action, n, recipient); err != nil {
+			logger().Error("Error updating incident", "event_id", event, "channel", channel, "err", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Worker is an instance of the detector that shares the streams of the
+// database with the other instances. A worker reads a stream while it
+// holds the lease of the stream, and renews its leases with every
+// heartbeat. When a worker stops, its leases expire and the other workers
+// claim the streams.
+type Worker struct {
+	ID   string `json:"id"`
+	Host string `json:"host"`
+	// streams at most, 0 for no limit
+	Capacity  int       `json:"capacity"`
+	Started   time.Time `json:"started"`
+	Heartbeat time.Time `json:"heartbeat"`
+	Streams   []string  `json:"streams"`
+}
+
+// WorkerHeartbeat registers the worker or tells that it is still alive
+func (db Database) WorkerHeartbeat(id, host string, capacity int) error {
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO worker (id, host, org_id, capacity, heartbeat) VALUES ($1, $2, $3, $4, NOW()) "+
+		"ON CONFLICT (id) DO UPDATE SET host=EXCLUDED.host, org_id=EXCLUDED.org_id, capacity=EXCLUDED.capacity, heartbeat=NOW()", id, host, db.org, capacity)
+	return err
+}
+
+// StreamShare returns the number of the streams each worker should read
+// for the streams to be spread evenly on the workers whose heartbeat is
+// younger than the ttl
+func (db Database) StreamShare(ttl time.Duration) (int, error) {
+	var streams, workers int
+	err := db.pool.QueryRowContext(db.context(), "SELECT (SELECT COUNT(*) FROM stream WHERE enabled AND COALESCE(address, '')<>'' AND ($1=0 OR org_id=$1)), "+
+		"(SELECT COUNT(*) FROM worker WHERE org_id=$1 AND heartbeat > NOW() - $2::float8 * INTERVAL '1 second')", db.org, ttl.Seconds()).Scan(&streams, &workers)
+	if err != nil {
+		return 0, err
+	}
+	if workers == 0 {
+		return streams, nil
+	}
+	return (streams + workers - 1) / workers, nil
+}
+
+// RenewLeases extends the leases of the worker that have not expired and
+// returns the addresses of their streams. A stream is missing from the
+// addresses when its lease has expired and another worker may have
+// claimed it.
+func (db Database) RenewLeases(worker string, ttl time.Duration) ([]string, error) {
+	rows, err := db.pool.QueryContext(db.context(), "WITH renewed AS (UPDATE stream_lease SET expires=NOW() + $2::float8 * INTERVAL '1 second' WHERE worker=$1 AND expires > NOW() RETURNING stream_id) "+
+		"SELECT s.address FROM renewed r JOIN stream s ON s.id=r.stream_id", worker, ttl.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	return scanAddresses(rows)
+}
+
+// ClaimStreams leases at most n enabled streams that have no lease or
+// whose lease has expired to the worker, and returns their addresses. A
+// lease that another worker renewed in the meantime is not taken over.
+func (db Database) ClaimStreams(worker string, n int, ttl time.Duration) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	rows, err := db.pool.QueryContext(db.context(), "WITH claimed AS (INSERT INTO stream_lease (stream_id, worker, expires) "+
+		"SELECT s.id, $1::text, NOW() + $2::float8 * INTERVAL '1 second' FROM stream s LEFT JOIN stream_lease l ON l.stream_id=s.id "+
+		"WHERE s.enabled AND COALESCE(s.address, '')<>'' AND ($3=0 OR s.org_id=$3) AND (l.stream_id IS NULL OR l.expires <= NOW()) ORDER BY s.id LIMIT $4 "+
+		"ON CONFLICT (stream_id) DO UPDATE SET worker=EXCLUDED.worker, acquired=NOW(), expires=EXCLUDED.expires WHERE stream_lease.expires <= NOW() "+
+		"RETURNING stream_id) SELECT s.address FROM claimed c JOIN stream s ON s.id=c.stream_id", worker, ttl.Seconds(), db.org, n)
+	if err != nil {
+		return nil, err
+	}
+	return scanAddresses(rows)
+}
+
+// ReleaseLease gives up the lease of the worker on the stream
+func (db Database) ReleaseLease(worker, address string) error {
+	_, err := db.pool.ExecContext(db.context(), "DELETE FROM stream_lease WHERE worker=$1 AND stream_id IN (SELECT id FROM stream WHERE address=$2)", worker, address)
+	return err
+}
+
+// LeadingWorker returns the id of the oldest worker whose heartbeat is
+// younger than the ttl. The leading worker runs the jobs that only one
+// instance should run, e.g. the digests. Returns sql.ErrNoRows if there
+// are no live workers.
+func (db Database) LeadingWorker(ttl time.Duration) (string, error) {
+	var id string
+	err := db.pool.QueryRowContext(db.context(), "SELECT id FROM worker WHERE org_id=$1 AND heartbeat > NOW() - $2::float8 * INTERVAL '1 second' ORDER BY started, id LIMIT 1", db.org, ttl.Seconds()).Scan(&id)
+	return id, err
+}
+
+// Workers returns the workers with the streams they hold
+func (db Database) Workers() ([]Worker, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT w.id, w.host, w.capacity, w.started, w.heartbeat, COALESCE(s.address, '') FROM worker w "+
+		"LEFT JOIN stream_lease l ON l.worker=w.id AND l.expires > NOW() LEFT JOIN stream s ON s.id=l.stream_id "+
+		"WHERE w.org_id=$1 ORDER BY w.started, w.id, s.id", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workers []Worker
+	for rows.Next() {
+		var w Worker
+		var address string
+		if err := rows.Scan(&w.ID, &w.Host, &w.Capacity, &w.Started, &w.Heartbeat, &address); err != nil {
+			return nil, err
+		}
+		if len(workers) == 0 || workers[len(workers)-1].ID != w.ID {
+			w.Streams = []string{}
+			workers = append(workers, w)
+		}
+		if address != "" {
+			last := &workers[len(workers)-1]
+			last.Streams = append(last.Streams, address)
+		}
+	}
+	return workers, rows.Err()
+}
+
+// scanAddresses reads the stream addresses of the rows
+func scanAddresses(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	var addresses []string
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// testSnapshot is the sample image of the test notifications, relative to
+// the snapshot directory
+const testSnapshot = "notify-test.jpg"
+
+// SendTestNotification sends a synthetic detection straight through a
+// notifier, bypassing the outbox, so that the configuration of a channel
+// can be verified. The target is either the id of a subscription or
+// "channel:recipient", e.g. "email:me@example.com".
+func (db Database) SendTestNotification(target string) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	// nothing is written, the transaction is only for the lookups
+	defer tx.Rollback()
+
+	var channel, recipient, language, address string
+	subscription, err := strconv.Atoi(target)
+	if err == nil {
+		err = tx.QueryRow("SELECT sub.channel, COALESCE(sub.recipient, o.email), COALESCE(o.language, ''), s.address FROM subscription sub "+
+			"JOIN observer o ON o.id=sub.observer_id JOIN stream s ON s.id=sub.stream_id WHERE sub.id=$1 AND ($2=0 OR s.org_id=$2)", subscription, db.org).
+			Scan(&channel, &recipient, &language, &address)
+		if err != nil {
+			return fmt.Errorf("subscription %d: %w", subscription, err)
+		}
+	} else {
+		var ok bool
+		channel, recipient, ok = strings.Cut(target, ":")
+		if !ok || channel == "" || recipient == "" {
+			return fmt.Errorf("invalid target %q, expected a subscription id or channel:recipient", target)
+		}
+	}
+
+	stream := Stream{Name: "Test stream"}
+	if address != "" {
+		if stream, err = db.StreamByAddress(address); err != nil {
+			return err
+		}
+	}
+	branding, err := streamBranding(tx, stream)
+	if err != nil {
+		return err
+	}
+
+	label := "bird"
+	if len(Classes) > 0 {
+		label = Classes[0]
+	}
+	data := notify.Data{
+		Stream:     stream.Name,
+		Link:       stream.Link,
+		Place:      stream.Place(),
+		Count:      1,
+		Confidence: 99,
+		Time:       time.Now().In(stream.Location()).Format("2.1.2006 15:04"),
+		Language:   notify.Language(language),
+	}
+	if data.Class, err = translateClass(tx, label, data.Language); err != nil {
+		return err
+	}
+	data.CountWord = notify.CountWord(data.Language, data.Count)
+	if subscription != 0 {
+		data.UnsubscribeURL = notify.UnsubscribeLink(subscription)
+	}
+
+	n := notify.Notification{From: branding.Sender}
+	if notify.SnapshotDir != "" {
+		if err := writeTestSnapshot(filepath.Join(notify.SnapshotDir, testSnapshot)); err != nil {
+			return err
+		}
+		n.Attachment = testSnapshot
+		data.Snapshot = "cid:image0"
+	}
+	n.Data = data
+	if n.Subject, n.Body, n.HTML, err = notify.Render("detection", data.Language, branding, data); err != nil {
+		return err
+	}
+	n.Subject = "[TEST] " + n.Subject
+	return notify.Send(db.context(), channel, n, recipient)
+}
+
+// writeTestSnapshot writes a gray sample image with a bounding box
+func writeTestSnapshot(path string) error {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 360))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{96, 96, 96, 255}}, image.Point{}, draw.Src)
+	box := image.Rect(240, 120, 400, 260)
+	green := &image.Uniform{color.RGBA{0, 255, 0, 255}}
+	for _, edge := range []image.Rectangle{
+		{box.Min, image.Pt(box.Max.X, box.Min.Y+2)},
+		{image.Pt(box.Min.X, box.Max.Y-2), box.Max},
+		{box.Min, image.Pt(box.Min.X+2, box.Max.Y)},
+		{image.Pt(box.Max.X-2, box.Min.Y), box.Max},
+	} {
+		draw.Draw(img, edge, green, image.Point{}, draw.Src)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
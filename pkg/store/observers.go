@@ -0,0 +1,178 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// Observer is a person receiving the notifications of their subscriptions
+type Observer struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	// IANA name for the quiet hours, empty = timezone of the stream
+	Timezone string `json:"timezone"`
+	// language of the notifications, empty = NOTIFY_LANGUAGE
+	Language string `json:"language"`
+}
+
+// Validate checks the fields that are given by the users
+func (o Observer) Validate() error {
+	if _, err := mail.ParseAddress(o.Email); err != nil {
+		return fmt.Errorf("invalid email %q", o.Email)
+	}
+	if o.Timezone != "" {
+		if _, err := time.LoadLocation(o.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", o.Timezone)
+		}
+	}
+	switch o.Language {
+	case "", notify.LanguageEnglish, notify.LanguageFinnish:
+	default:
+		return fmt.Errorf("unsupported language %q", o.Language)
+	}
+	return nil
+}
+
+const observerColumns = "SELECT id, COALESCE(name, ''), email, COALESCE(timezone, ''), COALESCE(language, '') FROM observer"
+
+// ListObservers returns the observers of the organization
+func (db Database) ListObservers() ([]Observer, error) {
+	rows, err := db.reader().QueryContext(db.context(), observerColumns+" WHERE $1=0 OR org_id=$1 ORDER BY id", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var observers []Observer
+	for rows.Next() {
+		var o Observer
+		if err := rows.Scan(&o.ID, &o.Name, &o.Email, &o.Timezone, &o.Language); err != nil {
+			return nil, err
+		}
+		observers = append(observers, o)
+	}
+	return observers, rows.Err()
+}
+
+// GetObserver returns the observer by id. Returns sql.ErrNoRows if there
+// is no such observer.
+func (db Database) GetObserver(id int) (Observer, error) {
+	var o Observer
+	err := db.reader().QueryRowContext(db.context(), observerColumns+" WHERE id=$1 AND ($2=0 OR org_id=$2)", id, db.org).
+		Scan(&o.ID, &o.Name, &o.Email, &o.Timezone, &o.Language)
+	return o, err
+}
+
+// CreateObserver adds the observer to the organization and returns its id
+func (db Database) CreateObserver(o Observer) (int, error) {
+	var id int
+	err := db.pool.QueryRowContext(db.context(), "INSERT INTO observer (name, email, timezone, language, org_id) VALUES (NULLIF($1, ''), $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, 0)) RETURNING id",
+		o.Name, o.Email, o.Timezone, o.Language, db.org).Scan(&id)
+	return id, err
+}
+
+// UpdateObserver replaces the fields of the observer with the given id
+func (db Database) UpdateObserver(o Observer) error {
+	res, err := db.pool.ExecContext(db.context(), "UPDATE observer SET name=NULLIF($2, ''), email=$3, timezone=NULLIF($4, ''), language=NULLIF($5, '') WHERE id=$1 AND ($6=0 OR org_id=$6)",
+		o.ID, o.Name, o.Email, o.Timezone, o.Language, db.org)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// DeleteObserver purges the observer with the given id, see PurgeObserver
+func (db Database) DeleteObserver(id int) error {
+	o, err := db.GetObserver(id)
+	if err != nil {
+		return err
+	}
+	return db.PurgeObserver(o.Email)
+}
+
+// PurgeObserver removes the observer with all their subscriptions, alerts
+// and queued or sent notifications in a single transaction. The purge is
+// recorded to the audit log with a hash of the email instead of the
+// address itself.
+func (db Database) PurgeObserver(email string) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var observer int
+	err = tx.QueryRow("SELECT id FROM observer WHERE email=$1 AND ($2=0 OR org_id=$2) FOR UPDATE", email, db.org).Scan(&observer)
+	if err != nil {
+		return err
+	}
+
+	const subscriptions = "(SELECT id FROM subscription WHERE observer_id=$1)"
+	var alerts, notifications, subs int64
+	res, err := tx.Exec("DELETE FROM alert WHERE subscription_id IN "+subscriptions, observer)
+	if err != nil {
+		return err
+	}
+	alerts, _ = res.RowsAffected()
+
+	res, err = tx.Exec("DELETE FROM outbox WHERE subscription_id IN "+subscriptions+" OR recipient=$2", observer, email)
+	if err != nil {
+		return err
+	}
+	notifications, _ = res.RowsAffected()
+
+	if _, err = tx.Exec("DELETE FROM dead_letter WHERE subscription_id IN "+subscriptions, observer); err != nil {
+		return err
+	}
+
+	res, err = tx.Exec("DELETE FROM subscription WHERE observer_id=$1", observer)
+	if err != nil {
+		return err
+	}
+	subs, _ = res.RowsAffected()
+
+	if _, err = tx.Exec("DELETE FROM observer WHERE id=$1", observer); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(email))
+	details := fmt.Sprintf("observer %d: %d subscriptions, %d alerts, %d notifications", observer, subs, alerts, notifications)
+	_, err = tx.Exec("INSERT INTO audit_log (action, subject, details) VALUES ('purge_observer', $1, $2)", hex.EncodeToString(sum[:]), details)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ObserversByEmail returns the ids of the observers with the address, the
+// same person can be an observer in several organizations
+func (db Database) ObserversByEmail(email string) ([]int, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT id FROM observer WHERE lower(email)=lower($1) ORDER BY id", email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ObserverOrg returns the organization of the observer, 0 for none
+func (db Database) ObserverOrg(observer int) (int, error) {
+	var org int
+	err := db.reader().QueryRowContext(db.context(), "SELECT COALESCE(org_id, 0) FROM observer WHERE id=$1", observer).Scan(&org)
+	return org, err
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// OfflineAlerts are the recipients of the alerts of the streams that have
+// stopped delivering frames
+type OfflineAlerts struct {
+	// a stream without frames for this long is offline
+	Threshold time.Duration
+	// alert the event subscriptions of the stream
+	Observers bool
+	// the contact alerted of every stream (e.g. slack and the url of the
+	// hook), no one if the channel is empty
+	Channel   string
+	Recipient string
+}
+
+// offlineData is passed to the offline and online templates
+type offlineData struct {
+	Stream string
+	Link   string
+	Place  string
+	// the last frame before the outage, or the first one after it
+	Time string
+	// the reason of the last failed connection, if any
+	Error          string
+	UnsubscribeURL string
+	Language       string
+}
+
+// offlineStream is a stream whose state has changed since the last check
+type offlineStream struct {
+	address string
+	// the last frame, or the last status if it has never had one
+	seen      time.Time
+	lastError string
+}
+
+// AlertOfflineStreams checks the streams periodically until ctx is done
+func (db Database) AlertOfflineStreams(ctx context.Context, interval time.Duration, alerts OfflineAlerts) {
+	for {
+		if err := db.CheckOfflineStreams(alerts); err != nil {
+			logger().Error("Error checking offline streams", "err", err)
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// CheckOfflineStreams queues an alert of every enabled stream that has had
+// no frames within the threshold, whether its worker is failing to
+// connect or has stopped reporting, and a notice of every alerted stream
+// that has had frames since. A stream is alerted once per outage, the
+// alerted ones are kept in offline_alert.
+func (db Database) CheckOfflineStreams(alerts OfflineAlerts) error {
+	offline, err := db.changedStreams("SELECT st.address, COALESCE(st.last_frame, st.updated), COALESCE(st.last_error, '') FROM stream_status st JOIN stream s ON s.address=st.address "+
+		"WHERE s.enabled AND NOT s.paused AND ($1=0 OR s.org_id=$1) AND COALESCE(st.last_frame, st.updated) < NOW() - make_interval(secs => $2) "+
+		"AND NOT EXISTS (SELECT 1 FROM offline_alert oa WHERE oa.address=st.address)", db.org, alerts.Threshold.Seconds())
+	if err != nil {
+		return err
+	}
+	online, err := db.changedStreams("SELECT st.address, st.last_frame, '' FROM offline_alert oa JOIN stream_status st ON st.address=oa.address JOIN stream s ON s.address=oa.address "+
+		"WHERE ($1=0 OR s.org_id=$1) AND st.last_frame > oa.alerted", db.org)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range offline {
+		if err := db.queueOfflineNotice("offline", o, alerts); err != nil {
+			return err
+		}
+	}
+	for _, o := range online {
+		if err := db.queueOfflineNotice("online", o, alerts); err != nil {
+			return err
+		}
+	}
+
+	// the outages of the streams that were disabled or removed meanwhile
+	// end without a notice
+	_, err = db.pool.ExecContext(db.context(), "DELETE FROM offline_alert oa WHERE NOT EXISTS (SELECT 1 FROM stream s WHERE s.address=oa.address AND s.enabled AND NOT s.paused)")
+	return err
+}
+
+// changedStreams returns the streams of the query
+func (db Database) changedStreams(query string, args ...interface{}) ([]offlineStream, error) {
+	rows, err := db.pool.QueryContext(db.context(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var streams []offlineStream
+	for rows.Next() {
+		var o offlineStream
+		if err := rows.Scan(&o.address, &o.seen, &o.lastError); err != nil {
+			return nil, err
+		}
+		streams = append(streams, o)
+	}
+	return streams, rows.Err()
+}
+
+// queueOfflineNotice writes the offline alert or the online notice of the
+// stream to the outbox and records the outage. Nothing is queued if
+// another instance has already done it.
+func (db Database) queueOfflineNotice(kind string, o offlineStream, alerts OfflineAlerts) error {
+	stream, err := db.StreamByAddress(o.address)
+	if err != nil {
+		return err
+	}
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if kind == "offline" {
+		result, err = tx.Exec("INSERT INTO offline_alert (address, alerted) VALUES ($1, NOW()) ON CONFLICT (address) DO NOTHING", o.address)
+	} else {
+		result, err = tx.Exec("DELETE FROM offline_alert WHERE address=$1", o.address)
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+
+	type recipient struct {
+		subscription sql.NullInt64
+		channel      string
+		address      string
+		language     string
+	}
+	var recipients []recipient
+	if alerts.Channel != "" {
+		recipients = append(recipients, recipient{channel: alerts.Channel, address: alerts.Recipient})
+	}
+	if alerts.Observers {
+		rows, err := tx.Query("SELECT sub.id, sub.channel, COALESCE(sub.recipient, o.email), COALESCE(o.language, '') FROM subscription sub JOIN observer o ON o.id=sub.observer_id JOIN stream s ON s.id=sub.stream_id "+
+			"WHERE s.id=$1 AND sub.alert=TRUE AND sub.mode=$2 AND sub.org_id IS NOT DISTINCT FROM s.org_id", stream.ID, SubscriptionEvent)
+		if err != nil {
+			return err
+		}
+		// the rows must be read before running other queries in the transaction
+		for rows.Next() {
+			var r recipient
+			if err := rows.Scan(&r.subscription, &r.channel, &r.address, &r.language); err != nil {
+				rows.Close()
+				return err
+			}
+			recipients = append(recipients, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	branding, err := streamBranding(tx, stream)
+	if err != nil {
+		return err
+	}
+	for _, r := range recipients {
+		data := offlineData{
+			Stream:   stream.Name,
+			Link:     stream.Link,
+			Place:    stream.Place(),
+			Time:     o.seen.In(stream.Location()).Format("2.1.2006 15:04"),
+			Error:    o.lastError,
+			Language: notify.Language(r.language),
+		}
+		if r.subscription.Valid {
+			data.UnsubscribeURL = notify.UnsubscribeLink(int(r.subscription.Int64))
+		}
+		subject, body, html, err := notify.Render(kind, data.Language, branding, data)
+		if err != nil {
+			return err
+		}
+		// the chat channels show only the subject
+		payload, err := json.Marshal(notify.Data{UnsubscribeURL: data.UnsubscribeURL, Language: data.Language})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO outbox (subscription_id, channel, recipient, sender, subject, body, html, payload) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), $8)",
+			r.subscription, r.channel, r.address, branding.Sender, subject, body, html, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,374 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// notifications that fail this many times are moved to the dead letters
+const maxDeliveryAttempts = 5
+
+// delay before the first retry, doubled after every failed attempt
+const retryBackoff = time.Minute
+
+// the notifications claimed by a dispatcher that has not saved their
+// result in this time (e.g. it crashed) are sent again
+const outboxClaimLease = 10 * time.Minute
+
+// the condition of the rows of the outbox that are not claimed, the lease
+// in seconds is the first parameter of the query
+const unclaimed = "(claimed_at IS NULL OR claimed_at < NOW() - make_interval(secs => $1))"
+
+// DeadLetter is a notification whose delivery failed permanently
+type DeadLetter struct {
+	ID        int
+	Event     int
+	Channel   string
+	Recipient string
+	Subject   string
+	Attempts  int
+	LastError string
+	FailedAt  time.Time
+}
+
+// DispatchNotifications delivers the notifications queued to the outbox
+// with at most workers of them being sent at the same time, so that a
+// slow mail server delays the other notifications of the batch less.
+// The rows are marked sent only after the delivery succeeded, so a crash
+// in between causes a duplicate mail, once the claim of the rows has
+// expired, instead of a lost one. Returns when
+// ctx is done, after the batch being sent.
+func (db Database) DispatchNotifications(ctx context.Context, interval time.Duration, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	// the batch is sent with the context of the database, which outlives
+	// ctx during a shutdown
+	d := newDispatcher(db.context(), workers)
+	defer d.close()
+	// enough rows for every worker and the next ones in line
+	limit := 10
+	if workers*2 > limit {
+		limit = workers * 2
+	}
+	for {
+		delivered, err := db.deliverOutbox(d, limit)
+		if err != nil {
+			logger().Error("Error delivering notifications", "err", err)
+		}
+		if delivered == 0 {
+			sleep(ctx, interval)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// delivery is a notification handed to the workers of the dispatcher
+type delivery struct {
+	channel      string
+	recipient    string
+	notification notify.Notification
+	// the result of the sending
+	err  error
+	done *sync.WaitGroup
+}
+
+// dispatcher sends the notifications of the outbox with a fixed number of
+// goroutines fed by a channel
+type dispatcher struct {
+	ctx        context.Context
+	deliveries chan *delivery
+}
+
+func newDispatcher(ctx context.Context, workers int) *dispatcher {
+	d := &dispatcher{ctx: ctx, deliveries: make(chan *delivery)}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+func (d *dispatcher) work() {
+	for del := range d.deliveries {
+		del.err = notify.Send(d.ctx, del.channel, del.notification, del.recipient)
+		del.done.Done()
+	}
+}
+
+// close stops the workers
+func (d *dispatcher) close() {
+	close(d.deliveries)
+}
+
+// send delivers the notifications and waits until all have been sent or
+// have failed
+func (d *dispatcher) send(deliveries []*delivery) {
+	var wg sync.WaitGroup
+	wg.Add(len(deliveries))
+	for _, del := range deliveries {
+		del.done = &wg
+		d.deliveries <- del
+	}
+	wg.Wait()
+}
+
+// pendingNotification is a row of the outbox claimed for sending
+type pendingNotification struct {
+	id, subscription int
+	channel          string
+	recipient        string
+	attempts         int
+	notify.Notification
+	// rows merged to this one, marked sent with it
+	collapsed []int
+	delivery  *delivery
+}
+
+// deliverOutbox sends a batch of pending notifications and returns how
+// many of them were handled. The rows are claimed in a short transaction
+// so that several dispatchers can share the same outbox, sent by the
+// workers of the dispatcher outside of any transaction, and the result of
+// every notification is saved on its own.
+func (db Database) deliverOutbox(d *dispatcher, limit int) (int, error) {
+	handled, sending, err := db.claimOutbox(limit)
+	if err != nil {
+		return 0, err
+	}
+
+	deliveries := make([]*delivery, len(sending))
+	for i, n := range sending {
+		deliveries[i] = n.delivery
+	}
+	d.send(deliveries)
+
+	for _, n := range sending {
+		if err := db.finishDelivery(n); err != nil {
+			// the row is sent again after its claim expires
+			logger().Error("Error saving notification result", "notification", n.id, "event_id", n.Event, "err", err)
+		}
+	}
+	return handled, nil
+}
+
+// claimOutbox claims a batch of the pending notifications for
+// outboxClaimLease and returns how many rows were handled and the
+// notifications to send. The rate limited rows are deferred, and with the
+// collapse policy the collapsed rows are merged to the notifications.
+func (db Database) claimOutbox(limit int) (int, []*pendingNotification, error) {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, COALESCE(event_id, 0), COALESCE(subscription_id, 0), channel, recipient, COALESCE(sender, ''), subject, body, COALESCE(html, ''), COALESCE(attachment, ''), COALESCE(payload, '{}'), attempts "+
+		"FROM outbox WHERE sent_at IS NULL AND (not_before IS NULL OR not_before <= NOW()) AND "+unclaimed+" ORDER BY id LIMIT $2 FOR UPDATE SKIP LOCKED", outboxClaimLease.Seconds(), limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	var pending []*pendingNotification
+	for rows.Next() {
+		n := &pendingNotification{}
+		var payload []byte
+		if err := rows.Scan(&n.id, &n.Event, &n.subscription, &n.channel, &n.recipient, &n.From, &n.Subject, &n.Body, &n.HTML, &n.Attachment, &payload, &n.attempts); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		if err := json.Unmarshal(payload, &n.Data); err != nil {
+			logger().Error("Invalid payload in notification", "notification", n.id, "event_id", n.Event, "err", err)
+		}
+		pending = append(pending, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	// the rows already merged to a notification of the batch are sent with it
+	merged := map[int]bool{}
+	var ids, claimed []int
+	var sending []*pendingNotification
+	for _, n := range pending {
+		if merged[n.id] {
+			continue
+		}
+		if wait := notify.RateLimited(n.channel); wait > 0 {
+			_, err = tx.Exec("UPDATE outbox SET not_before=$1, collapsed=$2 WHERE id=$3", time.Now().Add(wait), notify.OverflowPolicy == notify.OverflowCollapse, n.id)
+			if err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		if notify.OverflowPolicy == notify.OverflowCollapse {
+			n.Notification, n.collapsed, err = collapseNotifications(tx, append(ids, n.id), n.channel, n.recipient, n.Notification)
+			if err != nil {
+				return 0, nil, err
+			}
+			for _, id := range n.collapsed {
+				merged[id] = true
+			}
+		}
+		n.delivery = &delivery{channel: n.channel, recipient: n.recipient, notification: n.Notification}
+		ids = append(ids, n.id)
+		claimed = append(append(claimed, n.id), n.collapsed...)
+		sending = append(sending, n)
+	}
+	if len(claimed) > 0 {
+		if _, err := tx.Exec("UPDATE outbox SET claimed_at=NOW() WHERE id=ANY($1)", pq.Array(claimed)); err != nil {
+			return 0, nil, err
+		}
+	}
+	return len(pending), sending, tx.Commit()
+}
+
+// finishDelivery saves the result of the sent notification: marks it and
+// the rows collapsed to it sent, or releases their claims with a backoff
+// before the next attempt, or moves them to the dead letters
+func (db Database) finishDelivery(n *pendingNotification) error {
+	ctx := db.context()
+	if sendErr := n.delivery.err; sendErr != nil {
+		logger().Error("Error sending notification", "notification", n.id, "event_id", n.Event, "channel", n.channel, "err", sendErr)
+		ids := append([]int{n.id}, n.collapsed...)
+		status := "retrying"
+		if n.attempts+1 >= maxDeliveryAttempts {
+			status = "failed"
+		}
+		// the alerts are found through the outbox, before the rows are
+		// moved to the dead letters
+		_, err := db.pool.ExecContext(ctx, "UPDATE alert a SET status=$1, error=$2 FROM outbox o WHERE o.id=ANY($3) AND a.detection_event_id=o.event_id AND a.subscription_id=o.subscription_id", status, sendErr.Error(), pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		if status == "failed" {
+			return db.deadLetter(ids, sendErr)
+		}
+		backoff := retryBackoff << n.attempts
+		_, err = db.pool.ExecContext(ctx, "UPDATE outbox SET attempts=attempts+1, last_error=$1, not_before=$2, claimed_at=NULL WHERE id=ANY($3)", sendErr.Error(), time.Now().Add(backoff), pq.Array(ids))
+		return err
+	}
+
+	if _, err := db.pool.ExecContext(ctx, "UPDATE outbox SET attempts=attempts+1, sent_at=NOW() WHERE id=$1", n.id); err != nil {
+		return err
+	}
+	if _, err := db.pool.ExecContext(ctx, "UPDATE alert SET status='delivered', error=NULL, delivered_at=NOW() WHERE detection_event_id=$1 AND subscription_id=$2", n.Event, n.subscription); err != nil {
+		return err
+	}
+	if len(n.collapsed) == 0 {
+		return nil
+	}
+	if _, err := db.pool.ExecContext(ctx, "UPDATE outbox SET sent_at=NOW() WHERE id=ANY($1)", pq.Array(n.collapsed)); err != nil {
+		return err
+	}
+	_, err := db.pool.ExecContext(ctx, "UPDATE alert a SET status='delivered', error=NULL, delivered_at=NOW() FROM outbox o WHERE o.id=ANY($1) AND a.detection_event_id=o.event_id AND a.subscription_id=o.subscription_id", pq.Array(n.collapsed))
+	return err
+}
+
+// deadLetter moves the failed notifications from the outbox to the dead
+// letters
+func (db Database) deadLetter(ids []int, sendErr error) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("INSERT INTO dead_letter (outbox_id, event_id, subscription_id, channel, recipient, subject, body, html, payload, attachment, attempts, last_error, created) "+
+		"SELECT id, event_id, subscription_id, channel, recipient, subject, body, html, payload, attachment, attempts+1, $1, created FROM outbox WHERE id=ANY($2)", sendErr.Error(), pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM outbox WHERE id=ANY($1)", pq.Array(ids)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeadLetters returns the permanently failed notifications, newest first
+func (db Database) DeadLetters(limit int) ([]DeadLetter, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT d.id, COALESCE(d.event_id, 0), d.channel, d.recipient, d.subject, d.attempts, COALESCE(d.last_error, ''), d.failed_at FROM dead_letter d "+
+		"LEFT JOIN subscription sub ON sub.id=d.subscription_id WHERE $1=0 OR sub.org_id=$1 ORDER BY d.id DESC LIMIT $2", db.org, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var letters []DeadLetter
+	for rows.Next() {
+		var d DeadLetter
+		if err := rows.Scan(&d.ID, &d.Event, &d.Channel, &d.Recipient, &d.Subject, &d.Attempts, &d.LastError, &d.FailedAt); err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+// RequeueDeadLetter moves the dead letter back to the outbox with the
+// attempts reset, e.g. after the configuration of the channel was fixed
+func (db Database) RequeueDeadLetter(id int) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO outbox (event_id, subscription_id, channel, recipient, subject, body, html, payload, attachment, last_error, created) "+
+		"SELECT d.event_id, d.subscription_id, d.channel, d.recipient, d.subject, d.body, d.html, d.payload, d.attachment, d.last_error, d.created FROM dead_letter d "+
+		"LEFT JOIN subscription sub ON sub.id=d.subscription_id WHERE d.id=$1 AND ($2=0 OR sub.org_id=$2)", id, db.org)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM dead_letter WHERE id=$1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// collapseNotifications merges the notifications collapsed by the rate
+// limit for the same recipient to the notification, except the ones of
+// the sending rows. Returns the ids of the merged rows, which are to be
+// marked sent with the notification.
+func collapseNotifications(tx *sql.Tx, sending []int, channel, recipient string, n notify.Notification) (notify.Notification, []int, error) {
+	rows, err := tx.Query("SELECT id, subject FROM outbox WHERE "+unclaimed+" AND channel=$2 AND recipient=$3 AND collapsed AND sent_at IS NULL AND NOT id=ANY($4) ORDER BY id FOR UPDATE SKIP LOCKED", outboxClaimLease.Seconds(), channel, recipient, pq.Array(sending))
+	if err != nil {
+		return n, nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	var subjects []string
+	for rows.Next() {
+		var merged int
+		var subject string
+		if err := rows.Scan(&merged, &subject); err != nil {
+			return n, nil, err
+		}
+		ids = append(ids, merged)
+		subjects = append(subjects, subject)
+	}
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return n, nil, err
+	}
+
+	summary := n
+	summary.Subject = fmt.Sprintf("%s (+%d more)", n.Subject, len(ids))
+	summary.Body = n.Body + fmt.Sprintf("\n\n%d more notifications were collapsed because of the rate limit:\n", len(ids))
+	for _, subject := range subjects {
+		summary.Body += "- " + subject + "\n"
+	}
+	// the html version would lack the collapsed ones
+	summary.HTML = ""
+	// the chat channels show the subject of the summary
+	summary.Data = notify.Data{UnsubscribeURL: n.Data.UnsubscribeURL}
+	return summary, ids, nil
+}
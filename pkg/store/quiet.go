@@ -0,0 +1,79 @@
+package store
+
+import (
+	"time"
+)
+
+// actions for the alerts during the quiet hours
+const (
+	QuietSuppress = "suppress"
+	QuietDefer    = "defer"
+)
+
+// quietHours is the daily window of a subscription during which the
+// observer does not want to be alerted
+type quietHours struct {
+	// "15:04:05", empty when the subscription has no quiet hours
+	start, end string
+	action     string
+	// timezone of the observer, empty for the timezone of the stream
+	timezone string
+}
+
+// until returns whether now is within the quiet hours and when the quiet
+// hours end. The window wraps midnight when the end is before the start,
+// e.g. from 22:00 to 07:00.
+func (q quietHours) until(now time.Time, streamLocation *time.Location) (time.Time, bool) {
+	if q.start == "" || q.end == "" {
+		return time.Time{}, false
+	}
+	start, err := time.Parse("15:04:05", q.start)
+	if err == nil {
+		var end time.Time
+		end, err = time.Parse("15:04:05", q.end)
+		if err == nil {
+			return quietWindow(now.In(q.location(streamLocation)), start, end)
+		}
+	}
+	logger().Error("Invalid quiet hours", "start", q.start, "end", q.end, "err", err)
+	return time.Time{}, false
+}
+
+func (q quietHours) location(streamLocation *time.Location) *time.Location {
+	if q.timezone == "" {
+		return streamLocation
+	}
+	loc, err := time.LoadLocation(q.timezone)
+	if err != nil {
+		logger().Error("Invalid timezone of observer", "timezone", q.timezone, "err", err)
+		return streamLocation
+	}
+	return loc
+}
+
+// quietWindow checks the window with the clock times of start and end
+// against the local time now
+func quietWindow(now, start, end time.Time) (time.Time, bool) {
+	clock := func(day time.Time, t time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), 0, day.Location())
+	}
+	todayStart, todayEnd := clock(now, start), clock(now, end)
+
+	if todayEnd.Equal(todayStart) {
+		return time.Time{}, false
+	}
+	if todayEnd.Before(todayStart) {
+		// wraps midnight: quiet after the start today or before the end today
+		switch {
+		case !now.Before(todayStart):
+			return clock(now.AddDate(0, 0, 1), end), true
+		case now.Before(todayEnd):
+			return todayEnd, true
+		}
+		return time.Time{}, false
+	}
+	if !now.Before(todayStart) && now.Before(todayEnd) {
+		return todayEnd, true
+	}
+	return time.Time{}, false
+}
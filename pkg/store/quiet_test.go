@@ -0,0 +1,42 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursUntil(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Skip("no timezone data:", err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.March, day, hour, minute, 0, 0, helsinki)
+	}
+	tests := []struct {
+		name       string
+		hours      quietHours
+		now        time.Time
+		quietUntil time.Time
+		quiet      bool
+	}{
+		{"no quiet hours", quietHours{}, at(1, 12, 0), time.Time{}, false},
+		{"within", quietHours{start: "09:00:00", end: "17:00:00"}, at(1, 12, 0), at(1, 17, 0), true},
+		{"at the start", quietHours{start: "09:00:00", end: "17:00:00"}, at(1, 9, 0), at(1, 17, 0), true},
+		{"at the end", quietHours{start: "09:00:00", end: "17:00:00"}, at(1, 17, 0), time.Time{}, false},
+		{"before midnight", quietHours{start: "22:00:00", end: "07:00:00"}, at(1, 23, 0), at(2, 7, 0), true},
+		{"after midnight", quietHours{start: "22:00:00", end: "07:00:00"}, at(2, 6, 30), at(2, 7, 0), true},
+		{"outside of the night", quietHours{start: "22:00:00", end: "07:00:00"}, at(1, 12, 0), time.Time{}, false},
+		{"empty window", quietHours{start: "12:00:00", end: "12:00:00"}, at(1, 12, 0), time.Time{}, false},
+		{"invalid", quietHours{start: "noon", end: "17:00:00"}, at(1, 12, 0), time.Time{}, false},
+		{"timezone of the observer", quietHours{start: "09:00:00", end: "17:00:00", timezone: "UTC"}, at(1, 18, 0), at(1, 17, 0).Add(2 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, quiet := tt.hours.until(tt.now, helsinki)
+			if quiet != tt.quiet || !until.Equal(tt.quietUntil) {
+				t.Errorf("until(%v) = %v, %v, want %v, %v", tt.now, until, quiet, tt.quietUntil, tt.quiet)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+
+	"github.com/lib/pq"
+)
+
+// DetectionConfig is the state of a stream in the database that controls
+// its detection: the overrides of the detection settings and the masks
+type DetectionConfig struct {
+	// false when the stream has been disabled
+	Enabled bool
+	// no frames are analyzed while paused
+	Paused bool
+	StreamSettings
+	// areas of the frame where the detections are ignored
+	Masks []image.Rectangle
+}
+
+// DetectionConfig returns the configuration of the stream by the address.
+// Streams that are not in the database are enabled without overrides.
+func (db Database) DetectionConfig(address string) (DetectionConfig, error) {
+	config := DetectionConfig{Enabled: true}
+
+	var id int
+	var confidence, intersection, fps sql.NullFloat64
+	var interval, gpu, priority sql.NullInt64
+	var hook sql.NullString
+	err := db.pool.QueryRowContext(db.context(), "SELECT s.id, s.enabled, s.paused, st.confidence, st.iou, st.classes, st.sample_interval_ms, st.max_fps, st.gpu, st.priority, st.crop, st.on_detect FROM stream s LEFT JOIN stream_settings st ON st.stream_id=s.id WHERE s.address=$1", address).
+		Scan(&id, &config.Enabled, &config.Paused, &confidence, &intersection, pq.Array(&config.Classes), &interval, &fps, &gpu, &priority, pq.Array(&config.Crop), &hook)
+	if err == sql.ErrNoRows {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+
+	if confidence.Valid {
+		config.Confidence = &confidence.Float64
+	}
+	if intersection.Valid {
+		config.IOU = &intersection.Float64
+	}
+	if interval.Valid {
+		ms := int(interval.Int64)
+		config.SampleIntervalMs = &ms
+	}
+	if fps.Valid {
+		config.MaxFPS = &fps.Float64
+	}
+	if gpu.Valid {
+		device := int(gpu.Int64)
+		config.GPU = &device
+	}
+	config.Priority = int(priority.Int64)
+	config.OnDetect = hook.String
+
+	zones, err := db.StreamZones(id)
+	if err != nil {
+		return config, err
+	}
+	for _, z := range zones {
+		if z.Mask {
+			config.Masks = append(config.Masks, image.Rect(z.X, z.Y, z.X+z.Width, z.Y+z.Height))
+		}
+	}
+	return config, nil
+}
+
+// StreamSettings are the overrides of the detection settings of a stream,
+// nil (or empty) values use the defaults of the command line
+type StreamSettings struct {
+	Confidence       *float64 `json:"confidence"`
+	IOU              *float64 `json:"iou"`
+	Classes          []string `json:"classes"`
+	SampleIntervalMs *int     `json:"sample_interval_ms"`
+	// analyzed frames per second at most, whatever the frame rate of the
+	// stream
+	MaxFPS *float64 `json:"max_fps"`
+	// cuda device of the stream, nil to let the detector choose. A change
+	// takes effect when the stream is restarted.
+	GPU *int `json:"gpu"`
+	// streams with a lower priority are paused first when the memory runs
+	// low
+	Priority int `json:"priority"`
+	// x, y, width and height of the part of the frame that is analyzed,
+	// empty for the whole frame
+	Crop []int64 `json:"crop"`
+	// name of the hook run on every event of the stream, empty for none
+	OnDetect string `json:"on_detect"`
+}
+
+// Validate checks the fields that are given by the users
+func (s StreamSettings) Validate() error {
+	if s.Confidence != nil && (*s.Confidence <= 0 || *s.Confidence > 1) {
+		return fmt.Errorf("confidence must be between 0 and 1")
+	}
+	if s.IOU != nil && (*s.IOU <= 0 || *s.IOU > 1) {
+		return fmt.Errorf("iou must be between 0 and 1")
+	}
+	if s.SampleIntervalMs != nil && *s.SampleIntervalMs < 0 {
+		return fmt.Errorf("sample interval cannot be negative")
+	}
+	if s.MaxFPS != nil && *s.MaxFPS <= 0 {
+		return fmt.Errorf("max fps must be positive")
+	}
+	if s.GPU != nil && *s.GPU < 0 {
+		return fmt.Errorf("gpu cannot be negative")
+	}
+	if len(s.Crop) != 0 && (len(s.Crop) != 4 || s.Crop[0] < 0 || s.Crop[1] < 0 || s.Crop[2] <= 0 || s.Crop[3] <= 0) {
+		return fmt.Errorf("crop must be x, y, width and height")
+	}
+	if s.OnDetect != "" && !knownHook(s.OnDetect) {
+		return fmt.Errorf("unknown hook %q", s.OnDetect)
+	}
+	for _, class := range s.Classes {
+		if !knownClass(class) {
+			return fmt.Errorf("unknown class %q", class)
+		}
+	}
+	return nil
+}
+
+// GetStreamSettings returns the overrides of the stream
+func (db Database) GetStreamSettings(stream int) (StreamSettings, error) {
+	var s StreamSettings
+	var confidence, intersection, fps sql.NullFloat64
+	var interval, gpu, priority sql.NullInt64
+	var hook sql.NullString
+	err := db.reader().QueryRowContext(db.context(), "SELECT st.confidence, st.iou, st.classes, st.sample_interval_ms, st.max_fps, st.gpu, st.priority, st.crop, st.on_detect FROM stream s LEFT JOIN stream_settings st ON st.stream_id=s.id "+
+		"WHERE s.id=$1 AND ($2=0 OR s.org_id=$2)", stream, db.org).
+		Scan(&confidence, &intersection, pq.Array(&s.Classes), &interval, &fps, &gpu, &priority, pq.Array(&s.Crop), &hook)
+	if confidence.Valid {
+		s.Confidence = &confidence.Float64
+	}
+	if intersection.Valid {
+		s.IOU = &intersection.Float64
+	}
+	if interval.Valid {
+		ms := int(interval.Int64)
+		s.SampleIntervalMs = &ms
+	}
+	if fps.Valid {
+		s.MaxFPS = &fps.Float64
+	}
+	if gpu.Valid {
+		device := int(gpu.Int64)
+		s.GPU = &device
+	}
+	s.Priority = int(priority.Int64)
+	s.OnDetect = hook.String
+	return s, err
+}
+
+// SetStreamSettings replaces the overrides of the stream. The running
+// stream picks them up within a minute.
+func (db Database) SetStreamSettings(stream int, s StreamSettings) error {
+	if _, err := db.GetStream(stream); err != nil {
+		return err
+	}
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO stream_settings (stream_id, confidence, iou, classes, sample_interval_ms, max_fps, gpu, priority, crop, on_detect) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, '')) "+
+		"ON CONFLICT (stream_id) DO UPDATE SET confidence=EXCLUDED.confidence, iou=EXCLUDED.iou, classes=EXCLUDED.classes, sample_interval_ms=EXCLUDED.sample_interval_ms, max_fps=EXCLUDED.max_fps, gpu=EXCLUDED.gpu, priority=EXCLUDED.priority, crop=EXCLUDED.crop, on_detect=EXCLUDED.on_detect",
+		stream, s.Confidence, s.IOU, pq.Array(s.Classes), s.SampleIntervalMs, s.MaxFPS, s.GPU, s.Priority, pq.Array(s.Crop), s.OnDetect)
+	return err
+}
+
+// knownClass reports whether the label is one of the Classes
+func knownClass(label string) bool {
+	for _, class := range Classes {
+		if class == label {
+			return true
+		}
+	}
+	return false
+}
+
+// knownHook reports whether the name is one of the Hooks
+func knownHook(name string) bool {
+	for _, hook := range Hooks {
+		if hook == name {
+			return true
+		}
+	}
+	return false
+}
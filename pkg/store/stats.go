@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DailyStat is the summary of one class in one stream for a day
+type DailyStat struct {
+	Day        time.Time
+	Stream     string
+	Class      string
+	Events     int
+	Detections int
+	// average of the highest confidences of the events (0..100)
+	AvgConfidence float64
+}
+
+// AlertStat is the number of alerts sent to a subscription
+type AlertStat struct {
+	Subscription int
+	Observer     string
+	Stream       string
+	Alerts       int
+	LastAlert    time.Time
+}
+
+// RefreshStatisticsEvery updates the summary tables periodically until ctx
+// is done
+func (db Database) RefreshStatisticsEvery(ctx context.Context, interval time.Duration) {
+	for {
+		if err := db.RefreshStatistics(); err != nil {
+			logger().Error("Error refreshing statistics", "err", err)
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// RefreshStatistics adds the events and alerts created after the previous
+// refresh to the summary tables. The processed ids are tracked in
+// stats_watermark so that each row is counted only once.
+func (db Database) RefreshStatistics() error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// serializes concurrent refreshes
+	var lastEvent, lastAlert int
+	err = tx.QueryRow("SELECT last_id FROM stats_watermark WHERE name='detection_event' FOR UPDATE").Scan(&lastEvent)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	err = tx.QueryRow("SELECT last_id FROM stats_watermark WHERE name='alert' FOR UPDATE").Scan(&lastAlert)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var maxEvent, maxAlert int
+	err = tx.QueryRow("SELECT COALESCE((SELECT MAX(id) FROM detection_event), 0), COALESCE((SELECT MAX(id) FROM alert), 0)").Scan(&maxEvent, &maxAlert)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO stats_daily (day, stream_id, class, events, detections, confidence_sum) "+
+		"SELECT created::date, COALESCE(stream_id, 0), class, COUNT(*), SUM(count), SUM(COALESCE(confidence, 0)) "+
+		"FROM detection_event WHERE id>$1 AND id<=$2 GROUP BY 1, 2, 3 "+
+		"ON CONFLICT (day, stream_id, class) DO UPDATE SET events=stats_daily.events+EXCLUDED.events, "+
+		"detections=stats_daily.detections+EXCLUDED.detections, confidence_sum=stats_daily.confidence_sum+EXCLUDED.confidence_sum",
+		lastEvent, maxEvent)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO stats_alert (subscription_id, alerts, last_alert) "+
+		"SELECT subscription_id, COUNT(*), MAX(created) FROM alert WHERE id>$1 AND id<=$2 GROUP BY 1 "+
+		"ON CONFLICT (subscription_id) DO UPDATE SET alerts=stats_alert.alerts+EXCLUDED.alerts, "+
+		"last_alert=GREATEST(stats_alert.last_alert, EXCLUDED.last_alert)",
+		lastAlert, maxAlert)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO stats_watermark (name, last_id) VALUES ('detection_event', $1), ('alert', $2) "+
+		"ON CONFLICT (name) DO UPDATE SET last_id=EXCLUDED.last_id", maxEvent, maxAlert)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DailyStats returns the daily summaries between the dates. The stream
+// (address) is optional.
+func (db Database) DailyStats(from, to time.Time, stream string) ([]DailyStat, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT d.day, COALESCE(s.address, ''), c.label, d.events, d.detections, d.confidence_sum::float/d.events "+
+		"FROM stats_daily d JOIN classes c ON c.id=d.class LEFT JOIN stream s ON s.id=d.stream_id "+
+		"WHERE d.day>=$1 AND d.day<=$2 AND ($3='' OR s.address=$3) AND ($4=0 OR s.org_id=$4) ORDER BY d.day, s.address, c.label",
+		from, to, stream, db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []DailyStat
+	for rows.Next() {
+		var stat DailyStat
+		if err := rows.Scan(&stat.Day, &stat.Stream, &stat.Class, &stat.Events, &stat.Detections, &stat.AvgConfidence); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// AlertStats returns the alert counts of all subscriptions
+func (db Database) AlertStats() ([]AlertStat, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT a.subscription_id, o.email, COALESCE(s.name, ''), a.alerts, a.last_alert "+
+		"FROM stats_alert a JOIN subscription sub ON sub.id=a.subscription_id JOIN observer o ON o.id=sub.observer_id "+
+		"LEFT JOIN stream s ON s.id=sub.stream_id WHERE $1=0 OR sub.org_id=$1 ORDER BY a.subscription_id", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []AlertStat
+	for rows.Next() {
+		var stat AlertStat
+		if err := rows.Scan(&stat.Subscription, &stat.Observer, &stat.Stream, &stat.Alerts, &stat.LastAlert); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// StreamStatus is the health of a stream as reported by its worker
+type StreamStatus struct {
+	Address    string    `json:"address"`
+	Online     bool      `json:"online"`
+	LastFrame  time.Time `json:"last_frame"`
+	FPS        float64   `json:"fps"`
+	LastError  string    `json:"last_error"`
+	Reconnects int       `json:"reconnects"`
+	Updated    time.Time `json:"updated"`
+}
+
+// StreamConnected marks the stream online after the capture has been
+// opened. Every connection after the first one counts as a reconnect.
+func (db Database) StreamConnected(address string) {
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO stream_status (address, online, updated) VALUES ($1, TRUE, NOW()) "+
+		"ON CONFLICT (address) DO UPDATE SET online=TRUE, reconnects=stream_status.reconnects+1, updated=NOW()", address)
+	if err != nil {
+		logger().Error("Error updating stream status", "device", address, "err", err)
+	}
+}
+
+// StreamFailed marks the stream offline with the reason
+func (db Database) StreamFailed(address string, reason string) {
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO stream_status (address, online, last_error, updated) VALUES ($1, FALSE, $2, NOW()) "+
+		"ON CONFLICT (address) DO UPDATE SET online=FALSE, last_error=EXCLUDED.last_error, updated=NOW()", address, reason)
+	if err != nil {
+		logger().Error("Error updating stream status", "device", address, "err", err)
+	}
+}
+
+// StreamRunning is called periodically by the worker with the frame rate
+// of the last period
+func (db Database) StreamRunning(address string, lastFrame time.Time, fps float64) {
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO stream_status (address, online, last_frame, fps, updated) VALUES ($1, TRUE, $2, $3, NOW()) "+
+		"ON CONFLICT (address) DO UPDATE SET online=TRUE, last_frame=EXCLUDED.last_frame, fps=EXCLUDED.fps, updated=NOW()", address, lastFrame, fps)
+	if err != nil {
+		logger().Error("Error updating stream status", "device", address, "err", err)
+	}
+}
+
+// StreamStatuses returns the status of every stream that has had a worker
+func (db Database) StreamStatuses() ([]StreamStatus, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT st.address, st.online, st.last_frame, COALESCE(st.fps, 0), COALESCE(st.last_error, ''), st.reconnects, st.updated "+
+		"FROM stream_status st LEFT JOIN stream s ON s.address=st.address WHERE $1=0 OR s.org_id=$1 ORDER BY st.address", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []StreamStatus
+	for rows.Next() {
+		var status StreamStatus
+		var lastFrame sql.NullTime
+		if err := rows.Scan(&status.Address, &status.Online, &lastFrame, &status.FPS, &status.LastError, &status.Reconnects, &status.Updated); err != nil {
+			return nil, err
+		}
+		status.LastFrame = lastFrame.Time
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// used when the stream has no timezone or the device is not in the database
+const defaultTimezone = "Europe/Helsinki"
+
+// Stream is a camera stream stored in the database
+type Stream struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Link        string `json:"link"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+	Timezone    string `json:"timezone"`
+	// only valid if HasCoordinates is true
+	Latitude       float64 `json:"latitude"`
+	Longitude      float64 `json:"longitude"`
+	HasCoordinates bool    `json:"has_coordinates"`
+	Enabled        bool    `json:"enabled"`
+	// set with PauseStream, not with UpdateStream
+	Paused bool `json:"paused"`
+	// zero if the stream does not belong to an organization
+	Org int `json:"-"`
+}
+
+// Location returns the timezone of the stream
+func (s Stream) Location() *time.Location {
+	tz := s.Timezone
+	if tz == "" {
+		tz = defaultTimezone
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		logger().Error("Invalid timezone of stream", "stream_id", s.ID, "timezone", tz, "err", err)
+		loc, _ = time.LoadLocation(defaultTimezone)
+	}
+	return loc
+}
+
+// Place describes where the stream is, e.g. "Nest island (60.2345, 22.1234)"
+func (s Stream) Place() string {
+	if !s.HasCoordinates {
+		return s.Description
+	}
+	coordinates := fmt.Sprintf("(%.4f, %.4f)", s.Latitude, s.Longitude)
+	if s.Description == "" {
+		return coordinates
+	}
+	return s.Description + " " + coordinates
+}
+
+// StreamByAddress returns the stream with the given address. Returns
+// sql.ErrNoRows for devices that are not in the database.
+func (db Database) StreamByAddress(address string) (Stream, error) {
+	return scanStream(db.pool.QueryRowContext(db.context(), streamColumns+" WHERE address=$1", address))
+}
+
+const streamColumns = "SELECT id, name, link, address, description, timezone, latitude, longitude, enabled, paused, COALESCE(org_id, 0) FROM stream"
+
+func scanStream(row interface{ Scan(...interface{}) error }) (Stream, error) {
+	var s Stream
+	var name, link, description, timezone sql.NullString
+	var latitude, longitude sql.NullFloat64
+	err := row.Scan(&s.ID, &name, &link, &s.Address, &description, &timezone, &latitude, &longitude, &s.Enabled, &s.Paused, &s.Org)
+	s.Name, s.Link, s.Description, s.Timezone = name.String, link.String, description.String, timezone.String
+	s.Latitude, s.Longitude = latitude.Float64, longitude.Float64
+	s.HasCoordinates = latitude.Valid && longitude.Valid
+	return s, err
+}
+
+// the schemes of the addresses that the organizations may use
+var streamSchemes = map[string]bool{"rtsp": true, "rtsps": true, "http": true, "https": true}
+
+// Validate checks the fields that are given by the users. org is the
+// organization of the user: only the operator (0) may use the other
+// sources, e.g. the files of the host.
+func (s Stream) Validate(org int) error {
+	if s.Address == "" {
+		return fmt.Errorf("stream address is required")
+	}
+	if org != 0 {
+		if err := validateAddress(s.Address); err != nil {
+			return err
+		}
+	}
+	if s.Timezone != "" {
+		if _, err := time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q", s.Timezone)
+		}
+	}
+	if s.HasCoordinates && (s.Latitude < -90 || s.Latitude > 90 || s.Longitude < -180 || s.Longitude > 180) {
+		return fmt.Errorf("invalid coordinates")
+	}
+	return nil
+}
+
+// validateAddress checks that the address is a network stream on one of
+// the StreamHosts, so that an organization cannot read the files of the
+// host nor reach its internal services
+func validateAddress(address string) error {
+	u, err := url.Parse(address)
+	if err != nil || !streamSchemes[strings.ToLower(u.Scheme)] || u.Hostname() == "" {
+		return fmt.Errorf("stream address must be an rtsp, rtsps, http or https url")
+	}
+	if len(StreamHosts) == 0 {
+		return nil
+	}
+	for _, host := range StreamHosts {
+		if strings.EqualFold(u.Hostname(), host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("stream host %q is not allowed", u.Hostname())
+}
+
+// ListStreams returns the streams of the organization
+func (db Database) ListStreams() ([]Stream, error) {
+	rows, err := db.reader().QueryContext(db.context(), streamColumns+" WHERE $1=0 OR org_id=$1 ORDER BY id", db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var streams []Stream
+	for rows.Next() {
+		s, err := scanStream(rows)
+		if err != nil {
+			return nil, err
+		}
+		streams = append(streams, s)
+	}
+	return streams, rows.Err()
+}
+
+// GetStream returns the stream by id. Returns sql.ErrNoRows if there is
+// no such stream.
+func (db Database) GetStream(id int) (Stream, error) {
+	return scanStream(db.reader().QueryRowContext(db.context(), streamColumns+" WHERE id=$1 AND ($2=0 OR org_id=$2)", id, db.org))
+}
+
+// coordinates returns the coordinates for the nullable columns
+func (s Stream) coordinates() (latitude, longitude interface{}) {
+	if !s.HasCoordinates {
+		return nil, nil
+	}
+	return s.Latitude, s.Longitude
+}
+
+// CreateStream adds the stream to the organization and returns its id.
+// The detector picks it up on the next start.
+func (db Database) CreateStream(s Stream) (int, error) {
+	latitude, longitude := s.coordinates()
+	var id int
+	err := db.pool.QueryRowContext(db.context(), "INSERT INTO stream (name, link, address, description, timezone, latitude, longitude, enabled, org_id) "+
+		"VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7, $8, NULLIF($9, 0)) RETURNING id",
+		s.Name, s.Link, s.Address, s.Description, s.Timezone, latitude, longitude, s.Enabled, db.org).Scan(&id)
+	return id, err
+}
+
+// UpdateStream replaces the fields of the stream with the given id. A
+// running stream stops when it notices that it has been disabled.
+func (db Database) UpdateStream(s Stream) error {
+	latitude, longitude := s.coordinates()
+	res, err := db.pool.ExecContext(db.context(), "UPDATE stream SET name=NULLIF($2, ''), link=NULLIF($3, ''), address=$4, description=NULLIF($5, ''), timezone=NULLIF($6, ''), latitude=$7, longitude=$8, enabled=$9 "+
+		"WHERE id=$1 AND ($10=0 OR org_id=$10)", s.ID, s.Name, s.Link, s.Address, s.Description, s.Timezone, latitude, longitude, s.Enabled, db.org)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// PauseStream pauses or resumes the detection of the stream. Returns the
+// address of the stream, whose running pipeline should be told to reload
+// its settings for the change to be immediate.
+func (db Database) PauseStream(id int, paused bool) (string, error) {
+	var address string
+	err := db.pool.QueryRowContext(db.context(), "UPDATE stream SET paused=$2 WHERE id=$1 AND ($3=0 OR org_id=$3) RETURNING address", id, paused, db.org).Scan(&address)
+	return address, err
+}
+
+// DeleteStream removes the stream with its settings. Streams that have
+// events or subscriptions cannot be removed.
+func (db Database) DeleteStream(id int) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var stream int
+	err = tx.QueryRow("SELECT id FROM stream WHERE id=$1 AND ($2=0 OR org_id=$2) FOR UPDATE", id, db.org).Scan(&stream)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"stream_settings", "stream_zone", "notification_branding", "stream_lease"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE stream_id=$1", stream); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM stream WHERE id=$1", stream); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/osmundi/gocv-stream-events/pkg/notify"
+)
+
+// Subscription is an observer's interest in the events of a stream
+type Subscription struct {
+	ID       int  `json:"id"`
+	Observer int  `json:"observer_id"`
+	Stream   int  `json:"stream_id"`
+	Alert    bool `json:"alert"`
+	// minimum time between the alerts, e.g. 15m, 2h or 1d
+	AlertInterval string  `json:"alert_interval"`
+	Confidence    float64 `json:"confidence"`
+	Channel       string  `json:"channel"`
+	// channel specific address, empty = email of the observer
+	Recipient string `json:"recipient"`
+	// event, daily or weekly
+	Mode string `json:"mode"`
+	// HH:MM, empty = no quiet hours
+	QuietStart  string   `json:"quiet_start"`
+	QuietEnd    string   `json:"quiet_end"`
+	QuietAction string   `json:"quiet_action"`
+	Classes     []string `json:"classes"`
+	Zones       []string `json:"zones"`
+	// e.g. 5m, empty = every event is alerted separately
+	BurstWindow string `json:"burst_window"`
+}
+
+// Validate checks the fields that are given by the users
+func (s Subscription) Validate() error {
+	if s.Observer == 0 || s.Stream == 0 {
+		return fmt.Errorf("observer_id and stream_id are required")
+	}
+	if !notify.Registered(s.Channel) {
+		return fmt.Errorf("unknown channel %q", s.Channel)
+	}
+	switch s.Mode {
+	case SubscriptionEvent, SubscriptionDaily, SubscriptionWeekly:
+	default:
+		return fmt.Errorf("unknown mode %q", s.Mode)
+	}
+	switch s.QuietAction {
+	case QuietSuppress, QuietDefer:
+	default:
+		return fmt.Errorf("unknown quiet action %q", s.QuietAction)
+	}
+	for _, t := range []string{s.QuietStart, s.QuietEnd} {
+		if _, err := time.Parse("15:04", t); t != "" && err != nil {
+			return fmt.Errorf("invalid quiet hours %q", t)
+		}
+	}
+	if s.BurstWindow != "" && burstWindow(s.BurstWindow) == 0 {
+		return fmt.Errorf("invalid burst window %q", s.BurstWindow)
+	}
+	if s.Confidence < 0 || s.Confidence > 1 {
+		return fmt.Errorf("confidence must be between 0 and 1")
+	}
+	return nil
+}
+
+const subscriptionColumns = "SELECT id, COALESCE(observer_id, 0), COALESCE(stream_id, 0), COALESCE(alert, FALSE), COALESCE(alert_interval, ''), COALESCE(confidence, 0), channel, " +
+	"COALESCE(recipient, ''), mode, COALESCE(to_char(quiet_start, 'HH24:MI'), ''), COALESCE(to_char(quiet_end, 'HH24:MI'), ''), quiet_action, classes, zones, COALESCE(burst_window, '') FROM subscription"
+
+func scanSubscription(row interface{ Scan(...interface{}) error }) (Subscription, error) {
+	var s Subscription
+	err := row.Scan(&s.ID, &s.Observer, &s.Stream, &s.Alert, &s.AlertInterval, &s.Confidence, &s.Channel,
+		&s.Recipient, &s.Mode, &s.QuietStart, &s.QuietEnd, &s.QuietAction, pq.Array(&s.Classes), pq.Array(&s.Zones), &s.BurstWindow)
+	return s, err
+}
+
+// ListSubscriptions returns the subscriptions of the organization, or of
+// the observer if it is given
+func (db Database) ListSubscriptions(observer int) ([]Subscription, error) {
+	rows, err := db.reader().QueryContext(db.context(), subscriptionColumns+" WHERE ($1=0 OR org_id=$1) AND ($2=0 OR observer_id=$2) ORDER BY id", db.org, observer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		s, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// GetSubscription returns the subscription by id. Returns sql.ErrNoRows if
+// there is no such subscription.
+func (db Database) GetSubscription(id int) (Subscription, error) {
+	return scanSubscription(db.reader().QueryRowContext(db.context(), subscriptionColumns+" WHERE id=$1 AND ($2=0 OR org_id=$2)", id, db.org))
+}
+
+// checkSubscriptionOwners returns sql.ErrNoRows unless both the observer
+// and the stream belong to the organization
+func checkSubscriptionOwners(tx *sql.Tx, s Subscription, org int) error {
+	var n int
+	err := tx.QueryRow("SELECT (SELECT COUNT(*) FROM observer WHERE id=$1 AND ($3=0 OR org_id=$3)) + (SELECT COUNT(*) FROM stream WHERE id=$2 AND ($3=0 OR org_id=$3))",
+		s.Observer, s.Stream, org).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n != 2 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateSubscription adds the subscription and returns its id. The
+// subscription belongs to the organization of the stream.
+func (db Database) CreateSubscription(s Subscription) (int, error) {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := checkSubscriptionOwners(tx, s, db.org); err != nil {
+		return 0, err
+	}
+	var id int
+	err = tx.QueryRow("INSERT INTO subscription (observer_id, stream_id, alert, alert_interval, confidence, channel, recipient, mode, quiet_start, quiet_end, quiet_action, classes, zones, burst_window, org_id) "+
+		"VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), $8, NULLIF($9, '')::time, NULLIF($10, '')::time, $11, $12, $13, NULLIF($14, ''), (SELECT org_id FROM stream WHERE id=$2)) RETURNING id",
+		s.Observer, s.Stream, s.Alert, s.AlertInterval, s.Confidence, s.Channel, s.Recipient, s.Mode, s.QuietStart, s.QuietEnd, s.QuietAction, pq.Array(s.Classes), pq.Array(s.Zones), s.BurstWindow).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// UpdateSubscription replaces the fields of the subscription with the given id
+func (db Database) UpdateSubscription(s Subscription) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkSubscriptionOwners(tx, s, db.org); err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE subscription SET observer_id=$2, stream_id=$3, alert=$4, alert_interval=NULLIF($5, ''), confidence=$6, channel=$7, recipient=NULLIF($8, ''), mode=$9, "+
+		"quiet_start=NULLIF($10, '')::time, quiet_end=NULLIF($11, '')::time, quiet_action=$12, classes=$13, zones=$14, burst_window=NULLIF($15, ''), org_id=(SELECT org_id FROM stream WHERE id=$3) "+
+		"WHERE id=$1 AND ($16=0 OR org_id=$16)",
+		s.ID, s.Observer, s.Stream, s.Alert, s.AlertInterval, s.Confidence, s.Channel, s.Recipient, s.Mode, s.QuietStart, s.QuietEnd, s.QuietAction, pq.Array(s.Classes), pq.Array(s.Zones), s.BurstWindow, db.org)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteSubscription removes the subscription with its alerts and queued
+// notifications
+func (db Database) DeleteSubscription(id int) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var subscription int
+	err = tx.QueryRow("SELECT id FROM subscription WHERE id=$1 AND ($2=0 OR org_id=$2) FOR UPDATE", id, db.org).Scan(&subscription)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"alert", "outbox", "dead_letter"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE subscription_id=$1", subscription); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM subscription WHERE id=$1", subscription); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Unsubscribe disables the alerts of the subscription
+func (db Database) Unsubscribe(subscription int) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE subscription SET alert=FALSE WHERE id=$1 AND ($2=0 OR org_id=$2)", subscription, db.org)
+	if err != nil {
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO audit_log (action, subject) VALUES ('unsubscribe', $1)", strconv.Itoa(subscription))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
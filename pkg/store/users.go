@@ -0,0 +1,128 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// DashboardUser is a person signing in with OIDC
+type DashboardUser struct {
+	ID    int    `json:"id"`
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	// zero for the users of all organizations
+	Org   int    `json:"org"`
+	Scope string `json:"scope"`
+}
+
+// AddDashboardUser lets the person with the email sign in to the
+// organization of the database
+func (db Database) AddDashboardUser(email, scope string) error {
+	if scope != ScopeRead && scope != ScopeWrite {
+		return fmt.Errorf("unknown scope %q", scope)
+	}
+	_, err := db.pool.ExecContext(db.context(), "INSERT INTO dashboard_user (email, org_id, scope) VALUES ($1, NULLIF($2, 0), $3)", email, db.org, scope)
+	return err
+}
+
+// RemoveDashboardUser removes the users with the email from the
+// organization of the database, which also ends their sessions
+func (db Database) RemoveDashboardUser(email string) error {
+	res, err := db.pool.ExecContext(db.context(), "DELETE FROM dashboard_user WHERE lower(email)=lower($1) AND ($2=0 OR org_id=$2)", email, db.org)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+const dashboardUserColumns = "SELECT id, COALESCE(email, ''), COALESCE(name, ''), COALESCE(org_id, 0), scope FROM dashboard_user"
+
+func scanDashboardUser(row interface{ Scan(...interface{}) error }) (DashboardUser, error) {
+	var u DashboardUser
+	err := row.Scan(&u.ID, &u.Email, &u.Name, &u.Org, &u.Scope)
+	return u, err
+}
+
+// DashboardUserByID returns the user of a session
+func (db Database) DashboardUserByID(id int) (DashboardUser, error) {
+	return scanDashboardUser(db.pool.QueryRowContext(db.context(), dashboardUserColumns+" WHERE id=$1", id))
+}
+
+// DashboardUserBySubject returns the user of an id token
+func (db Database) DashboardUserBySubject(issuer, subject string) (DashboardUser, error) {
+	return scanDashboardUser(db.pool.QueryRowContext(db.context(), dashboardUserColumns+" WHERE issuer=$1 AND subject=$2", issuer, subject))
+}
+
+// ErrUnknownUser is returned for the accounts that are not mapped to users
+var ErrUnknownUser = errors.New("user is not allowed to sign in")
+
+// DashboardLogin are the claims of a verified id token
+type DashboardLogin struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified *bool
+	Name          string
+	// name of the organization from the organization claim, nil when the
+	// claim is not used
+	Organization *string
+	// scope from the roles claim, empty when the claim is not used
+	Scope string
+}
+
+// SignIn returns the local user of the login. The user is bound to the
+// row added with AddDashboardUser for the email on the first sign in, or
+// created when the organization of the login exists. The organization
+// and the scope are updated from the login when they are given.
+func (db Database) SignIn(login DashboardLogin) (DashboardUser, error) {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return DashboardUser{}, err
+	}
+	defer tx.Rollback()
+
+	org, scope := -1, login.Scope
+	if login.Organization != nil {
+		err := tx.QueryRow("SELECT id FROM organization WHERE name=$1", *login.Organization).Scan(&org)
+		if err == sql.ErrNoRows {
+			return DashboardUser{}, ErrUnknownUser
+		}
+		if err != nil {
+			return DashboardUser{}, err
+		}
+	}
+
+	user, err := scanDashboardUser(tx.QueryRow(dashboardUserColumns+" WHERE issuer=$1 AND subject=$2 FOR UPDATE", login.Issuer, login.Subject))
+	if err == sql.ErrNoRows && login.Email != "" && (login.EmailVerified == nil || *login.EmailVerified) {
+		// the first sign in of a user added with -add-user
+		user, err = scanDashboardUser(tx.QueryRow(dashboardUserColumns+" WHERE subject IS NULL AND lower(email)=lower($1) ORDER BY id LIMIT 1 FOR UPDATE", login.Email))
+	}
+	if err == sql.ErrNoRows {
+		if org < 0 {
+			return DashboardUser{}, ErrUnknownUser
+		}
+		if scope == "" {
+			scope = ScopeRead
+		}
+		err = tx.QueryRow("INSERT INTO dashboard_user (issuer, subject, org_id, scope) VALUES ($1, $2, NULLIF($3, 0), $4) RETURNING id",
+			login.Issuer, login.Subject, org, scope).Scan(&user.ID)
+	}
+	if err != nil {
+		return DashboardUser{}, err
+	}
+
+	if org >= 0 {
+		user.Org = org
+	}
+	if scope != "" {
+		user.Scope = scope
+	}
+	user.Email, user.Name = login.Email, login.Name
+	_, err = tx.Exec("UPDATE dashboard_user SET issuer=$2, subject=$3, email=NULLIF($4, ''), name=NULLIF($5, ''), org_id=NULLIF($6, 0), scope=$7, last_login=NOW() WHERE id=$1",
+		user.ID, login.Issuer, login.Subject, user.Email, user.Name, user.Org, user.Scope)
+	if err != nil {
+		return DashboardUser{}, err
+	}
+	return user, tx.Commit()
+}
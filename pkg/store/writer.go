@@ -0,0 +1,164 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// what Writer.Write does when the queue of the writer is full
+const (
+	// OverflowBlock waits for room in the queue
+	OverflowBlock = "block"
+	// OverflowDrop drops the event and returns ErrWriterFull
+	OverflowDrop = "drop"
+)
+
+var (
+	ErrWriterFull   = errors.New("write queue is full")
+	ErrWriterClosed = errors.New("writer is closed")
+)
+
+// EventWrite is a detection event waiting in the queue of a Writer
+type EventWrite struct {
+	Stream     string
+	Detections []Detection
+	Class      int
+	Captured   string
+	Snapshot   string
+	// called after the event has been saved with its id, 0 for a
+	// duplicate. It runs in the goroutine of the writer, so the next batch
+	// waits for it: slow work must be handed to another goroutine.
+	Done func(event int, err error)
+}
+
+// Writer saves the detection events in the background, so that the
+// pipelines do not wait for the database. The queued events are saved in
+// batches of one transaction, every interval or when a batch is full.
+type Writer struct {
+	db       Database
+	writes   chan EventWrite
+	batch    int
+	interval time.Duration
+	overflow string
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewWriter starts a writer with a queue of size events
+func (db Database) NewWriter(size, batch int, interval time.Duration, overflow string) (*Writer, error) {
+	switch overflow {
+	case "":
+		overflow = OverflowBlock
+	case OverflowBlock, OverflowDrop:
+	default:
+		return nil, errors.New("unknown write overflow " + overflow)
+	}
+	if batch < 1 {
+		batch = 1
+	}
+	w := &Writer{db: db, writes: make(chan EventWrite, size), batch: batch, interval: interval, overflow: overflow, done: make(chan struct{})}
+	go w.run()
+	return w, nil
+}
+
+// Write queues the event
+func (w *Writer) Write(e EventWrite) error {
+	// the read lock keeps the queue open until the event is in it
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+	if w.overflow == OverflowDrop {
+		select {
+		case w.writes <- e:
+			return nil
+		default:
+			return ErrWriterFull
+		}
+	}
+	w.writes <- e
+	return nil
+}
+
+// Close saves the queued events and stops the writer
+func (w *Writer) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.writes)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+// run collects the batches until the queue is closed
+func (w *Writer) run() {
+	defer close(w.done)
+	for e := range w.writes {
+		batch := []EventWrite{e}
+		deadline := time.NewTimer(w.interval)
+	collect:
+		for len(batch) < w.batch {
+			select {
+			case e, ok := <-w.writes:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, e)
+			case <-deadline.C:
+				break collect
+			}
+		}
+		deadline.Stop()
+		w.flush(batch)
+	}
+}
+
+// flush saves the batch in one transaction. If the transaction fails, the
+// events are saved one by one so that one bad event does not lose the
+// others.
+func (w *Writer) flush(batch []EventWrite) {
+	events, err := w.db.insertBatch(batch)
+	if err != nil {
+		if len(batch) > 1 {
+			logger().Error("Error saving events, saving them one by one", "events", len(batch), "err", err)
+		}
+		for i, e := range batch {
+			if len(batch) > 1 {
+				events[i], err = w.db.InsertDetections(e.Stream, e.Detections, e.Class, e.Captured, e.Snapshot)
+			}
+			if e.Done != nil {
+				e.Done(events[i], err)
+			}
+		}
+		return
+	}
+	for i, e := range batch {
+		if e.Done != nil {
+			e.Done(events[i], nil)
+		}
+	}
+}
+
+// insertBatch saves the events in one transaction and returns their ids
+func (db Database) insertBatch(batch []EventWrite) ([]int, error) {
+	events := make([]int, len(batch))
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return events, err
+	}
+	defer tx.Rollback()
+	for i, e := range batch {
+		if events[i], err = db.insertEvent(tx, e.Stream, e.Detections, e.Class, e.Captured, e.Snapshot); err != nil {
+			return make([]int, len(batch)), err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return make([]int, len(batch)), err
+	}
+	return events, nil
+}
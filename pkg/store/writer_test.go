@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the stream of the events the fake database fails to insert
+const brokenStream = "broken"
+
+// fakeEvents is a database/sql driver that saves only the detection
+// events, with the streams having no subscriptions. It keeps the number of
+// events of every committed transaction.
+type fakeEvents struct {
+	mu      sync.Mutex
+	id      int64
+	commits []int
+}
+
+// openFakeEvents returns a database on a new fake driver
+func openFakeEvents(t *testing.T) (Database, *fakeEvents) {
+	d := &fakeEvents{}
+	pool := sql.OpenDB(d)
+	t.Cleanup(func() { pool.Close() })
+	return Database{pool: pool}, d
+}
+
+func (d *fakeEvents) Open(string) (driver.Conn, error) { return &fakeConn{events: d}, nil }
+
+// Connect and Driver let sql.OpenDB use the driver as a connector
+func (d *fakeEvents) Connect(context.Context) (driver.Conn, error) { return d.Open("") }
+func (d *fakeEvents) Driver() driver.Driver                        { return d }
+
+type fakeConn struct {
+	events *fakeEvents
+	// events inserted in the open transaction
+	inserted int
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inserted = 0
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+	c.events.commits = append(c.events.commits, c.inserted)
+	return nil
+}
+
+func (c *fakeConn) Rollback() error { return nil }
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("unexpected exec: " + s.query)
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	switch {
+	case strings.HasPrefix(s.query, "INSERT INTO detection_event"):
+		if args[3] == brokenStream {
+			return nil, errors.New("no such stream")
+		}
+		s.conn.inserted++
+		s.conn.events.mu.Lock()
+		defer s.conn.events.mu.Unlock()
+		s.conn.events.id++
+		return &fakeRows{columns: []string{"id"}, rows: [][]driver.Value{{s.conn.events.id}}}, nil
+	case strings.HasPrefix(s.query, "SELECT sub.id"):
+		return &fakeRows{columns: make([]string, 12)}, nil
+	}
+	return nil, errors.New("unexpected query: " + s.query)
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// saved collects the results of the events passed to the writer
+type saved struct {
+	mu     sync.Mutex
+	events map[string]int
+	errs   map[string]error
+}
+
+func (s *saved) write(stream string) EventWrite {
+	return EventWrite{Stream: stream, Class: 1, Captured: time.Now().Format(time.RFC3339), Done: func(event int, err error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.events[stream], s.errs[stream] = event, err
+	}}
+}
+
+func TestWriterBatches(t *testing.T) {
+	db, fake := openFakeEvents(t)
+	w, err := db.NewWriter(10, 3, time.Hour, OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &saved{events: map[string]int{}, errs: map[string]error{}}
+	// a full batch is saved without waiting for the interval, the rest
+	// when the writer is closed
+	for _, stream := range []string{"a", "b", "c", "d"} {
+		if err := w.Write(s.write(stream)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	if len(fake.commits) != 2 || fake.commits[0] != 3 || fake.commits[1] != 1 {
+		t.Errorf("committed %v events, want [3 1]", fake.commits)
+	}
+	for _, stream := range []string{"a", "b", "c", "d"} {
+		if s.events[stream] == 0 || s.errs[stream] != nil {
+			t.Errorf("event of %s saved as %d, %v", stream, s.events[stream], s.errs[stream])
+		}
+	}
+	if err := w.Write(s.write("e")); err != ErrWriterClosed {
+		t.Errorf("Write after Close = %v, want %v", err, ErrWriterClosed)
+	}
+}
+
+func TestWriterFallback(t *testing.T) {
+	db, fake := openFakeEvents(t)
+	w, err := db.NewWriter(10, 3, time.Hour, OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &saved{events: map[string]int{}, errs: map[string]error{}}
+	// the broken event fails the batch, the others are saved one by one
+	for _, stream := range []string{"a", brokenStream, "c"} {
+		if err := w.Write(s.write(stream)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	if len(fake.commits) != 2 || fake.commits[0] != 1 || fake.commits[1] != 1 {
+		t.Errorf("committed %v events, want [1 1]", fake.commits)
+	}
+	for _, stream := range []string{"a", "c"} {
+		if s.events[stream] == 0 || s.errs[stream] != nil {
+			t.Errorf("event of %s saved as %d, %v", stream, s.events[stream], s.errs[stream])
+		}
+	}
+	if s.events[brokenStream] != 0 || s.errs[brokenStream] == nil {
+		t.Errorf("broken event saved as %d, %v, want an error", s.events[brokenStream], s.errs[brokenStream])
+	}
+}
+
+func TestWriterDrop(t *testing.T) {
+	db, _ := openFakeEvents(t)
+	w, err := db.NewWriter(0, 1, time.Hour, OverflowDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	s := &saved{events: map[string]int{}, errs: map[string]error{}}
+	// the writer takes the first event, the unbuffered queue is then full
+	// until the event has been saved
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	first := s.write("a")
+	first.Done = func(int, error) {
+		close(blocked)
+		<-release
+	}
+	for w.Write(first) == ErrWriterFull {
+		time.Sleep(time.Millisecond)
+	}
+	<-blocked
+	if err := w.Write(s.write("b")); err != ErrWriterFull {
+		t.Errorf("Write to a full queue = %v, want %v", err, ErrWriterFull)
+	}
+	close(release)
+
+	if _, err := db.NewWriter(1, 1, time.Hour, "wait"); err == nil {
+		t.Error("NewWriter accepted an unknown overflow")
+	}
+}
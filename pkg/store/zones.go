@@ -0,0 +1,149 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"image"
+)
+
+// zone is a named area of the frame of a stream, e.g. "nest box"
+type zone struct {
+	name string
+	rect image.Rectangle
+}
+
+// streamZones returns the zones of the stream, the masks are not zones
+func streamZones(tx *sql.Tx, deviceID string) ([]zone, error) {
+	rows, err := tx.Query("SELECT z.name, z.x, z.y, z.width, z.height FROM stream_zone z JOIN stream s ON s.id=z.stream_id WHERE s.address=$1 AND NOT z.mask", deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var zones []zone
+	for rows.Next() {
+		var z zone
+		var x, y, width, height int
+		if err := rows.Scan(&z.name, &x, &y, &width, &height); err != nil {
+			return nil, err
+		}
+		z.rect = image.Rect(x, y, x+width, y+height)
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+// zonesOf returns the names of the zones that contain the center of any
+// of the detected objects
+func zonesOf(zones []zone, detectedObjects []Detection) map[string]bool {
+	hit := map[string]bool{}
+	for _, obj := range detectedObjects {
+		center := image.Pt(obj.Left+obj.Width/2, obj.Top+obj.Height/2)
+		for _, z := range zones {
+			if center.In(z.rect) {
+				hit[z.name] = true
+			}
+		}
+	}
+	return hit
+}
+
+// subscriptionFilter limits the events a subscription is alerted of.
+// Empty lists match everything.
+type subscriptionFilter struct {
+	classes []string
+	zones   []string
+}
+
+// matches reports whether an event of the class with detections in the
+// given zones passes the filter
+func (f subscriptionFilter) matches(class string, zones map[string]bool) bool {
+	if len(f.classes) > 0 {
+		found := false
+		for _, c := range f.classes {
+			if c == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.zones) > 0 {
+		for _, z := range f.zones {
+			if zones[z] {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// StreamZone is a zone or a mask of a stream in the pixel coordinates of
+// its frames
+type StreamZone struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Mask   bool   `json:"mask"`
+}
+
+// Validate checks the fields that are given by the users
+func (z StreamZone) Validate() error {
+	if z.Name == "" {
+		return fmt.Errorf("zone name is required")
+	}
+	if z.X < 0 || z.Y < 0 || z.Width <= 0 || z.Height <= 0 {
+		return fmt.Errorf("invalid area for zone %q", z.Name)
+	}
+	return nil
+}
+
+// StreamZones returns the zones and masks of the stream
+func (db Database) StreamZones(stream int) ([]StreamZone, error) {
+	rows, err := db.reader().QueryContext(db.context(), "SELECT z.name, z.x, z.y, z.width, z.height, z.mask FROM stream_zone z JOIN stream s ON s.id=z.stream_id "+
+		"WHERE s.id=$1 AND ($2=0 OR s.org_id=$2) ORDER BY z.id", stream, db.org)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var zones []StreamZone
+	for rows.Next() {
+		var z StreamZone
+		if err := rows.Scan(&z.Name, &z.X, &z.Y, &z.Width, &z.Height, &z.Mask); err != nil {
+			return nil, err
+		}
+		zones = append(zones, z)
+	}
+	return zones, rows.Err()
+}
+
+// SetStreamZones replaces the zones and masks of the stream. The running
+// stream picks up the masks within a minute.
+func (db Database) SetStreamZones(stream int, zones []StreamZone) error {
+	tx, err := db.pool.BeginTx(db.context(), nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("SELECT id FROM stream WHERE id=$1 AND ($2=0 OR org_id=$2) FOR UPDATE", stream, db.org).Scan(&id)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM stream_zone WHERE stream_id=$1", id); err != nil {
+		return err
+	}
+	for _, z := range zones {
+		_, err := tx.Exec("INSERT INTO stream_zone (stream_id, name, x, y, width, height, mask) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			id, z.Name, z.X, z.Y, z.Width, z.Height, z.Mask)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}